@@ -4,10 +4,18 @@ import (
 	"log"
 	"net/http"
 	"net/http/httputil"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/spf13/viper"
 	"gitlab.com/cake/go-project-template/gpt"
+	"gitlab.com/cake/go-project-template/ingest"
+	"gitlab.com/cake/go-project-template/inspector"
+	appmetric "gitlab.com/cake/go-project-template/metric"
+	"gitlab.com/cake/go-project-template/report"
+	"gitlab.com/cake/go-project-template/slo"
+	"gitlab.com/cake/go-project-template/tracing"
+	"gitlab.com/cake/go-project-template/upstream"
 	"gitlab.com/cake/goctx"
 	"gitlab.com/cake/golibs/intercom"
 	"gitlab.com/cake/golibs/metric"
@@ -18,14 +26,22 @@ import (
 	m800trace "gitlab.com/cake/golibs/trace"
 
 	// new_err "gitlab.com/cake/go-project-template/examples/err"
-	// "gitlab.com/cake/go-project-template/examples/metric_api"
 	"gitlab.com/cake/go-project-template/examples/trace"
 )
 
 var (
 	metricSystem = "gin"
+
+	// redactedSettings are the secrets of the settings served by /config, the database section is removed as a whole
+	redactedSettings = []string{
+		"paging.secret",
+		"otel.traces.headers",
+		"metric.export.headers",
+	}
 )
 
+const redacted = "[REDACTED]"
+
 func InitGinServer(ctx goctx.Context) (*http.Server, error) {
 	m800log.Infof(ctx, "[api server] init gin")
 
@@ -57,14 +73,18 @@ func GinRouter() (*gin.Engine, error) {
 	router := gin.New()
 	router.Use(intercom.M800Recovery(gpt.CodeInternalServerError))
 
+	router.Use(tracing.SamplingMiddleware())
 	router.Use(m800trace.Middleware(gopkg.GetAppName()))
-	p, err := metric.NewPrometheus(metricSystem,
-		metric.HistogramMetrics(metricSystem, metric.DefaultDurationBucket, metric.DefaultSizeBucket),
-		metric.HistogramHandleFunc())
+	router.Use(tracing.LogMiddleware())
+	router.Use(inspector.Middleware())
+	router.Use(slo.Middleware())
+	p, err := metric.NewPrometheus(metricSystem, httpMetrics(), appmetric.HistogramHandleFunc())
 	if err != nil {
 		return nil, err
 	}
-	p.Use(router)
+	// serve the declared metrics of the dedicated registry together with the global one
+	router.Use(p.GetGinHandlerFunc())
+	router.GET(p.MetricsPath, appmetric.Handler())
 	router.NoRoute(intercom.NoRouteHandler(gpt.CodeRouteNotFound))
 
 	// Init root router group
@@ -76,11 +96,14 @@ func GinRouter() (*gin.Engine, error) {
 	rootGroup.GET("/ready", ready)
 	rootGroup.GET("/mongo", mongo)
 	rootGroup.GET("/version", version)
+	rootGroup.GET("/slo", slo.StatusHandler)
+	inspector.AddInspectorEndpoint(rootGroup)
 
 	// Add application API
 	// new_err.AddErrorEndpoint(rootGroup)
-	// metric_api.AddMetricEndpoint(rootGroup)
 	trace.AddMetricEndpoint(rootGroup)
+	report.AddReportEndpoint(rootGroup)
+	ingest.AddIngestEndpoint(rootGroup)
 
 	// for testing purpose
 	rootGroup.Any("/echo/*any", echo)
@@ -91,9 +114,27 @@ func GinRouter() (*gin.Engine, error) {
 func appConfig(c *gin.Context) {
 	settings := viper.AllSettings()
 	delete(settings, "database")
+	for _, key := range redactedSettings {
+		redact(settings, strings.Split(key, "."))
+	}
 	c.JSON(http.StatusOK, settings)
 }
 
+// redact replaces the value at the path of nested settings
+func redact(settings map[string]interface{}, path []string) {
+	v, ok := settings[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		settings[path[0]] = redacted
+		return
+	}
+	if sub, ok := v.(map[string]interface{}); ok {
+		redact(sub, path[1:])
+	}
+}
+
 func health(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{})
 }
@@ -114,6 +155,13 @@ func ready(c *gin.Context) {
 			result["local mongo"] = false
 		}
 	}
+	if r := upstream.Default(); r != nil {
+		result["upstreams"] = r.Status()
+		if !r.Ready() {
+			m800log.Errorf(ctx, "[ready] critical upstream circuit open")
+			code = http.StatusServiceUnavailable
+		}
+	}
 
 	response := gin.H{}
 	response["code"] = 0
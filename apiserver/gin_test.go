@@ -0,0 +1,51 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+func TestAppConfig(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	viper.Set("paging.secret", "key")
+	viper.Set("paging.max_limit", 100)
+	viper.Set("otel.traces.headers", map[string]string{"authorization": "Bearer token"})
+	viper.Set("metric.export.headers", map[string]string{"authorization": "Bearer token"})
+	viper.Set("database.mgo.password", "secret")
+	defer viper.Reset()
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodGet, "/config", nil)
+	appConfig(c)
+
+	settings := map[string]interface{}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &settings); err != nil {
+		t.Fatal(err)
+	}
+	get := func(key string) interface{} {
+		var v interface{} = settings
+		for _, k := range strings.Split(key, ".") {
+			m, _ := v.(map[string]interface{})
+			v = m[k]
+		}
+		return v
+	}
+	if get("database") != nil {
+		t.Errorf("database should be removed: %s", rec.Body)
+	}
+	for _, key := range redactedSettings {
+		if get(key) != redacted {
+			t.Errorf("%s should be redacted: %s", key, rec.Body)
+		}
+	}
+	if get("paging.max_limit") != float64(100) {
+		t.Errorf("the other settings should be kept: %s", rec.Body)
+	}
+}
@@ -0,0 +1,50 @@
+package apiserver
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gitlab.com/cake/golibs/metric"
+
+	appmetric "gitlab.com/cake/go-project-template/metric"
+)
+
+// httpMetrics declares the gin HTTP metrics
+func httpMetrics() func(*metric.GinPrometheus) {
+	return metric.HistogramMetrics(metricSystem, metric.DefaultDurationBucket, metric.DefaultSizeBucket)
+}
+
+// HTTPMetricDefinitions describes the gin HTTP metrics with fully qualified names
+func HTTPMetricDefinitions() []appmetric.Definition {
+	p := &metric.GinPrometheus{MetricsList: map[string]*metric.Metrics{}}
+	httpMetrics()(p)
+
+	ret := []appmetric.Definition{}
+	for _, m := range p.MetricsList {
+		d := appmetric.Definition{Labels: m.Args}
+		switch opts := m.Opts.(type) {
+		case prometheus.CounterOpts:
+			d.Type, d.Help = appmetric.TypeCounter, opts.Help
+			d.Name = prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name)
+		case prometheus.GaugeOpts:
+			d.Type, d.Help = appmetric.TypeGauge, opts.Help
+			d.Name = prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name)
+		case prometheus.HistogramOpts:
+			d.Type, d.Help, d.Buckets = appmetric.TypeHistogram, opts.Help, opts.Buckets
+			d.Name = prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name)
+		case prometheus.SummaryOpts:
+			d.Type, d.Help = appmetric.TypeSummary, opts.Help
+			d.Name = prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name)
+			d.Objectives = map[string]float64{}
+			for q, e := range opts.Objectives {
+				d.Objectives[strconv.FormatFloat(q, 'g', -1, 64)] = e
+			}
+		default:
+			continue
+		}
+		ret = append(ret, d)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
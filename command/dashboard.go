@@ -0,0 +1,63 @@
+package command
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gitlab.com/cake/go-project-template/apiserver"
+	"gitlab.com/cake/go-project-template/dashboard"
+	"gitlab.com/cake/go-project-template/metric"
+	"gitlab.com/cake/gopkg"
+)
+
+func NewDashboardCmd() *cobra.Command {
+	var configFile, output string
+	opts := dashboard.Options{}
+	c := &cobra.Command{
+		Use:   "dashboard",
+		Short: "Print the grafana dashboard of the registered metrics",
+		Long:  `Generate a grafana dashboard JSON from the gin HTTP metrics and the metrics declared in the config file`,
+		Run: func(cmd *cobra.Command, args []string) {
+			viper.SetConfigFile(configFile)
+			if err := viper.ReadInConfig(); err != nil {
+				panic(err)
+			}
+			if err := initMetric(); err != nil {
+				panic(err)
+			}
+			if opts.Title == "" {
+				opts.Title = gopkg.GetAppName()
+			}
+			d := dashboard.Generate(opts,
+				dashboard.Section{Title: "HTTP", Definitions: apiserver.HTTPMetricDefinitions()},
+				dashboard.RegistrySection("Metrics", metric.Default()),
+			)
+
+			var w io.Writer = os.Stdout
+			if output != "" {
+				f, err := os.Create(output)
+				if err != nil {
+					panic(err)
+				}
+				defer f.Close()
+				w = f
+			}
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(d); err != nil {
+				panic(err)
+			}
+		},
+	}
+	c.Flags().StringVarP(&configFile, "config", "c", "./local.toml", "Path to Config File")
+	c.Flags().StringVarP(&output, "output", "o", "", "Path to the dashboard JSON, stdout if empty")
+	c.Flags().StringVar(&opts.Title, "title", "", "Dashboard title, defaults to the app name")
+	c.Flags().StringVar(&opts.UID, "uid", "", "Dashboard uid, empty lets grafana assign one")
+	c.Flags().StringVar(&opts.Datasource, "datasource", "Prometheus", "Default prometheus datasource")
+	c.Flags().StringVar(&opts.Refresh, "refresh", "30s", "Dashboard refresh interval")
+	c.Flags().StringSliceVar(&opts.Variables, "var", []string{"service", "type"}, "Labels templated as dashboard variables")
+	return c
+}
@@ -0,0 +1,67 @@
+package command
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cobra"
+	"gitlab.com/cake/go-project-template/mock"
+)
+
+func NewMockCmd() *cobra.Command {
+	var dir, addr, record string
+	var maxRecords int
+	c := &cobra.Command{
+		Use:   "mock",
+		Short: "Serve canned upstream responses from fixture files",
+		Long: `Run an http server answering the upstream calls with the fixtures of a directory,
+point the [upstream.<name>] base_url to it to run the server offline.
+The fixtures and the recorded requests are served under ` + mock.AdminPath,
+		Run: func(cmd *cobra.Command, args []string) {
+			var w io.Writer
+			if record != "" {
+				f, err := os.OpenFile(record, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+				if err != nil {
+					panic(err)
+				}
+				defer f.Close()
+				w = f
+			}
+			s, err := mock.NewServer(dir, mock.NewRecorder(maxRecords, w))
+			if err != nil {
+				panic("load fixtures error:" + err.Error())
+			}
+			log.Printf("mock server loaded %d fixtures from %s", len(s.Fixtures()), dir)
+
+			gin.SetMode(gin.ReleaseMode)
+			httpServer := &http.Server{Addr: addr, Handler: s.Router()}
+			go func() {
+				log.Printf("mock server is listening %s", addr)
+				if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					log.Fatalf("listen: %s\n", err)
+				}
+			}()
+
+			quit := make(chan os.Signal, 1)
+			signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+			<-quit
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := httpServer.Shutdown(ctx); err != nil {
+				log.Printf("mock server shutdown error:%v\n", err)
+			}
+		},
+	}
+	c.Flags().StringVarP(&dir, "dir", "d", "./mock/fixtures", "Directory of the *.json fixture files")
+	c.Flags().StringVarP(&addr, "addr", "a", ":8999", "Listen address")
+	c.Flags().StringVarP(&record, "record", "r", "", "Path to append the requests as JSON lines, disabled if empty")
+	c.Flags().IntVar(&maxRecords, "max-records", 1000, "Requests kept in memory for "+mock.AdminPath+"/requests")
+	return c
+}
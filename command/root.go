@@ -27,5 +27,8 @@ func Execute() error {
 	rootCmd.AddCommand(NewVersionCmd())
 	rootCmd.AddCommand(NewAPICmd())
 	rootCmd.AddCommand(NewServerCmd())
+	rootCmd.AddCommand(NewSLOCmd())
+	rootCmd.AddCommand(NewDashboardCmd())
+	rootCmd.AddCommand(NewMockCmd())
 	return rootCmd.Execute()
 }
@@ -11,7 +11,7 @@ import (
 	"syscall"
 	"time"
 
-	m800trace "gitlab.com/cake/golibs/trace"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -21,7 +21,17 @@ import (
 	"gitlab.com/cake/redispool"
 
 	"gitlab.com/cake/go-project-template/apiserver"
+	"gitlab.com/cake/go-project-template/dbmetric"
 	"gitlab.com/cake/go-project-template/gpt"
+	"gitlab.com/cake/go-project-template/inspector"
+	"gitlab.com/cake/go-project-template/metric"
+	"gitlab.com/cake/go-project-template/mongohook"
+	"gitlab.com/cake/go-project-template/paging"
+	"gitlab.com/cake/go-project-template/report"
+	"gitlab.com/cake/go-project-template/slo"
+	"gitlab.com/cake/go-project-template/tracing"
+	"gitlab.com/cake/go-project-template/upstream"
+	"gitlab.com/cake/go-project-template/watcher"
 )
 
 var (
@@ -47,15 +57,59 @@ func NewServerCmd() *cobra.Command {
 			}
 			m800log.Infof(systemCtx, "[go-project-template] init config: %+v", viper.AllSettings())
 
-			tp, err := m800trace.InitTracer(gopkg.GetAppName(), gpt.GetNamespace(), viper.GetFloat64("otel.traces.sampler_arg"))
+			tp, err := initTracer()
 			if err != nil {
-				panic(err)
+				panic("init tracer error:" + err.Error())
+			}
+			if tp != nil {
+				defer func() {
+					if err := tp.Shutdown(context.Background()); err != nil {
+						m800log.Panicf(systemCtx, "Error shutting down tracer provider: %v", err)
+					}
+				}()
 			}
-			defer func() {
-				if err := tp.Shutdown(context.Background()); err != nil {
-					m800log.Panicf(systemCtx, "Error shutting down tracer provider: %v", err)
+
+			if err := initBaggagePolicy(); err != nil {
+				panic("init baggage policy error:" + err.Error())
+			}
+
+			// Init declared metrics before the handlers look them up
+			if err := initMetric(); err != nil {
+				panic("init metric error:" + err.Error())
+			}
+			if err := initSLO(); err != nil {
+				panic("init slo error:" + err.Error())
+			}
+			if err := initUpstream(); err != nil {
+				panic("init upstream error:" + err.Error())
+			}
+			if c := tracing.TailCollector(); c != nil {
+				if err := metric.Default().Namespaced().Register(c); err != nil {
+					panic("init trace tail metric error:" + err.Error())
 				}
-			}()
+			}
+			exporter, err := initMetricExporter()
+			if err != nil {
+				panic("init metric exporter error:" + err.Error())
+			}
+			if exporter != nil {
+				defer func() {
+					if err := exporter.Shutdown(context.Background()); err != nil {
+						m800log.Errorf(systemCtx, "metric exporter shutdown error: %v", err)
+					}
+				}()
+			}
+			pusher, err := initMetricPusher()
+			if err != nil {
+				panic("init metric pusher error:" + err.Error())
+			}
+			if pusher != nil {
+				defer func() {
+					if err := pusher.Shutdown(context.Background()); err != nil {
+						m800log.Errorf(systemCtx, "metric pusher shutdown error: %v", err)
+					}
+				}()
+			}
 
 			if viper.GetBool("app.prof") {
 				ActivateProfile()
@@ -86,14 +140,39 @@ func NewServerCmd() *cobra.Command {
 			// if err != nil {
 			// 	panic("init redis rate limit db error:" + err.Error())
 			// }
+			// if err := dbmetric.InstrumentRedis("rate_limit", redisPool, metric.Default().Namespaced()); err != nil {
+			// 	panic("init redis metric error:" + err.Error())
+			// }
+			// traced commands with context, e.g. redisClient.Set(ctx, key, value)
+			// redisClient := redistrace.New(redisPool)
 
 			// Init local mongo
-			err = mgopool.Initialize(getLocalMongoDBInfo())
+			mongoPool, err := mgopool.NewSessionPool(getLocalMongoDBInfo())
 			if err != nil {
 				m800log.Errorf(systemCtx, "local mongo connect error: %v, config: %+v", err, getLocalMongoDBInfo())
 				panic(err)
 			}
+			instrumentedPool, err := dbmetric.InstrumentMongo("local", mongoPool, metric.Default().Namespaced())
+			if err != nil {
+				panic("init mongo metric error:" + err.Error())
+			}
+			hookedPool := mongohook.Wrap(instrumentedPool, tracing.MongoHook())
+			mgopool.SetExportedPool(hookedPool)
 			defer mgopool.Close()
+			mongoClient, err := mongoPool.GetMongoClient()
+			if err != nil {
+				panic(err)
+			}
+			report.SetMongoClient(mongoClient, hookedPool)
+
+			// Init mongo change stream watcher
+			if viper.GetBool("watcher.enabled") {
+				w, err := initWatcher(mongoPool)
+				if err != nil {
+					panic("init watcher error:" + err.Error())
+				}
+				defer w.Close()
+			}
 
 			defer func(httpServer *http.Server) {
 				log.Println("shutdown api server ...")
@@ -132,6 +211,10 @@ func initInfra(config string) (closer io.Closer, err error) {
 
 	m800log.SetM800JSONFormatter(viper.GetString("log.timestamp_format"), gopkg.GetAppName(), gopkg.GetVersion().Version, gpt.GetPhaseEnv(), gpt.GetNamespace())
 	_ = m800log.SetAccessLevel(viper.GetString("log.access_level"))
+	tracing.SetLogCorrelation(viper.GetBool("log.span_events"))
+
+	// cursors issued by one replica must be accepted by the others
+	paging.SetSecret(viper.GetString("paging.secret"))
 
 	return
 }
@@ -155,6 +238,101 @@ func initInfra(config string) (closer io.Closer, err error) {
 // 	}
 // }
 
+// initTracer exports the traces by [otel.traces] exporter, the spans are kept by the inspector too when
+// [inspector] is enabled, nil provider is returned when both are disabled
+func initTracer() (*tracesdk.TracerProvider, error) {
+	conf, err := tracing.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	inspectorConf, err := inspector.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	opts := []tracesdk.TracerProviderOption{}
+	if i := inspector.Init(inspectorConf); i != nil {
+		opts = append(opts, tracesdk.WithSpanProcessor(i))
+	}
+	return tracing.Init(conf, gopkg.GetAppName(), gpt.GetNamespace(), opts...)
+}
+
+// initBaggagePolicy enforces [otel.baggage] on the inbound and outbound baggage, the baggage is propagated as is without the section
+func initBaggagePolicy() error {
+	if !viper.IsSet("otel.baggage") {
+		return nil
+	}
+	conf, err := tracing.LoadBaggageConfig()
+	if err != nil {
+		return err
+	}
+	tracing.SetBaggagePolicy(tracing.NewBaggagePolicy(conf))
+	return nil
+}
+
+func initMetric() error {
+	conf, err := metric.LoadConfig()
+	if err != nil {
+		return err
+	}
+	return metric.Init(conf)
+}
+
+// initSLO registers the slo metrics to the default metric registry
+func initSLO() error {
+	conf, err := slo.LoadConfig()
+	if err != nil {
+		return err
+	}
+	return slo.Init(conf, metric.Default().Registerer())
+}
+
+// initUpstream builds the clients of the [upstream] section and registers their metrics to the default metric registry with its namespace
+func initUpstream() error {
+	confs, err := upstream.LoadConfig()
+	if err != nil {
+		return err
+	}
+	return upstream.Init(confs, metric.Default().Namespaced())
+}
+
+// initMetricExporter pushes the same metrics as /metrics when [metric.export] exporter is set
+func initMetricExporter() (*metric.PeriodicExporter, error) {
+	conf, err := metric.LoadExportConfig()
+	if err != nil {
+		return nil, err
+	}
+	return metric.StartExporter(conf, metric.Gatherer(), metric.NewResource())
+}
+
+// initMetricPusher pushes the same metrics as /metrics to [metric.push] url when it's set,
+// the last push on Shutdown keeps the metrics of a run exiting before being scraped
+func initMetricPusher() (*metric.Pusher, error) {
+	conf, err := metric.LoadPushConfig()
+	if err != nil {
+		return nil, err
+	}
+	return metric.StartPusher(conf, metric.Gatherer())
+}
+
+func initWatcher(pool *mgopool.Pool) (*watcher.Manager, error) {
+	conf, err := watcher.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if conf.DB == "" {
+		conf.DB = viper.GetString("database.mgo.name")
+	}
+	client, err := pool.GetMongoClient()
+	if err != nil {
+		return nil, err
+	}
+	w := watcher.NewManager(client, conf)
+	if err := metric.Default().Namespaced().Register(w); err != nil {
+		return nil, err
+	}
+	return w, w.Start(systemCtx)
+}
+
 func getLocalMongoDBInfo() *mgopool.DBInfo {
 	name := viper.GetString("database.mgo.name")
 	mgoUser := viper.GetString("database.mgo.user")
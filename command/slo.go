@@ -0,0 +1,54 @@
+package command
+
+import (
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gitlab.com/cake/go-project-template/slo"
+)
+
+func NewSLOCmd() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "slo",
+		Short: "Service level objective tools",
+	}
+	c.AddCommand(newSLORulesCmd())
+	return c
+}
+
+func newSLORulesCmd() *cobra.Command {
+	var configFile, output string
+	c := &cobra.Command{
+		Use:   "rules",
+		Short: "Print the prometheus alert rules of the [slo] objectives",
+		Long:  `Generate the error ratio recording rules and the multi-window burn rate alerts of the objectives declared in the config file`,
+		Run: func(cmd *cobra.Command, args []string) {
+			viper.SetConfigFile(configFile)
+			if err := viper.ReadInConfig(); err != nil {
+				panic(err)
+			}
+			conf, err := slo.LoadConfig()
+			if err != nil {
+				panic(err)
+			}
+
+			var w io.Writer = os.Stdout
+			if output != "" {
+				f, err := os.Create(output)
+				if err != nil {
+					panic(err)
+				}
+				defer f.Close()
+				w = f
+			}
+			if err := slo.WriteRules(w, conf); err != nil {
+				panic(err)
+			}
+		},
+	}
+	c.Flags().StringVarP(&configFile, "config", "c", "./local.toml", "Path to Config File")
+	c.Flags().StringVarP(&output, "output", "o", "", "Path to the rule file, stdout if empty")
+	return c
+}
@@ -0,0 +1,241 @@
+package dashboard
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gitlab.com/cake/go-project-template/metric"
+)
+
+const (
+	schemaVersion = 27
+
+	// datasourceVar is the templated prometheus datasource used by every panel
+	datasourceVar = "datasource"
+
+	panelWidth  = 12
+	panelHeight = 8
+	gridWidth   = 24
+)
+
+// Options of the generated dashboard
+type Options struct {
+	Title string
+	UID   string
+	// Datasource is the default prometheus datasource of the datasource variable
+	Datasource string
+	// Variables are label names templated as dashboard variables, e.g. service and type
+	Variables []string
+	Refresh   string
+}
+
+// Section is a row of panels, the definition names must be fully qualified
+type Section struct {
+	Title       string
+	Definitions []metric.Definition
+}
+
+// RegistrySection returns the definitions of r with their fully qualified names
+func RegistrySection(title string, r *metric.Registry) Section {
+	defs := r.Definitions()
+	for i := range defs {
+		defs[i].Name = r.FQName(defs[i].Name)
+	}
+	return Section{Title: title, Definitions: defs}
+}
+
+type Dashboard struct {
+	UID           string     `json:"uid,omitempty"`
+	Title         string     `json:"title"`
+	Tags          []string   `json:"tags"`
+	Timezone      string     `json:"timezone"`
+	Editable      bool       `json:"editable"`
+	Refresh       string     `json:"refresh,omitempty"`
+	SchemaVersion int        `json:"schemaVersion"`
+	Time          TimeRange  `json:"time"`
+	Templating    Templating `json:"templating"`
+	Panels        []Panel    `json:"panels"`
+}
+
+type TimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type Templating struct {
+	List []Variable `json:"list"`
+}
+
+type Variable struct {
+	Name       string      `json:"name"`
+	Label      string      `json:"label,omitempty"`
+	Type       string      `json:"type"`
+	Datasource string      `json:"datasource,omitempty"`
+	Query      string      `json:"query"`
+	Definition string      `json:"definition,omitempty"`
+	Refresh    int         `json:"refresh"`
+	IncludeAll bool        `json:"includeAll"`
+	Multi      bool        `json:"multi"`
+	AllValue   string      `json:"allValue,omitempty"`
+	Sort       int         `json:"sort"`
+	Current    interface{} `json:"current"`
+}
+
+type Panel struct {
+	ID          int      `json:"id"`
+	Type        string   `json:"type"`
+	Title       string   `json:"title"`
+	Description string   `json:"description,omitempty"`
+	Datasource  string   `json:"datasource,omitempty"`
+	GridPos     GridPos  `json:"gridPos"`
+	Targets     []Target `json:"targets,omitempty"`
+	// Panels of a collapsed row
+	Panels    []Panel `json:"panels,omitempty"`
+	Collapsed bool    `json:"collapsed,omitempty"`
+	// YAxes of graph panel, YAxis and DataFormat of heatmap panel
+	YAxes      []Axis `json:"yaxes,omitempty"`
+	YAxis      *Axis  `json:"yAxis,omitempty"`
+	DataFormat string `json:"dataFormat,omitempty"`
+}
+
+type GridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type Target struct {
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat,omitempty"`
+	RefID        string `json:"refId"`
+	Format       string `json:"format,omitempty"`
+}
+
+type Axis struct {
+	Format string `json:"format"`
+	Show   bool   `json:"show"`
+}
+
+// Generate builds one row per section and the panels of every definition in name order
+func Generate(opts Options, sections ...Section) *Dashboard {
+	d := &Dashboard{
+		UID:           opts.UID,
+		Title:         opts.Title,
+		Tags:          []string{"generated"},
+		Timezone:      "browser",
+		Editable:      true,
+		Refresh:       opts.Refresh,
+		SchemaVersion: schemaVersion,
+		Time:          TimeRange{From: "now-1h", To: "now"},
+		Templating:    Templating{List: []Variable{datasourceVariable(opts.Datasource)}},
+		Panels:        []Panel{},
+	}
+	all := []metric.Definition{}
+	for _, s := range sections {
+		all = append(all, s.Definitions...)
+	}
+	d.Templating.List = append(d.Templating.List, labelVariables(opts.Variables, all)...)
+
+	l := &layout{}
+	for _, s := range sections {
+		defs := append([]metric.Definition{}, s.Definitions...)
+		sort.Slice(defs, func(i, j int) bool { return defs[i].Name < defs[j].Name })
+		d.Panels = append(d.Panels, l.row(s.Title))
+		for _, def := range defs {
+			for _, p := range panels(def, selector(opts.Variables, def)) {
+				d.Panels = append(d.Panels, l.place(p))
+			}
+		}
+	}
+	return d
+}
+
+func datasourceVariable(current string) Variable {
+	v := Variable{Name: datasourceVar, Label: "Datasource", Type: "datasource", Query: "prometheus", Refresh: 1}
+	if current != "" {
+		v.Current = map[string]string{"text": current, "value": current}
+	}
+	return v
+}
+
+// labelVariables templates the labels used by at least one definition
+func labelVariables(labels []string, defs []metric.Definition) []Variable {
+	ret := []Variable{}
+	for _, label := range labels {
+		for _, def := range defs {
+			if !def.HasLabel(label) {
+				continue
+			}
+			query := fmt.Sprintf("label_values(%s, %s)", seriesName(def), label)
+			ret = append(ret, Variable{
+				Name:       label,
+				Label:      strings.Title(label),
+				Type:       "query",
+				Datasource: "${" + datasourceVar + "}",
+				Query:      query,
+				Definition: query,
+				Refresh:    2,
+				IncludeAll: true,
+				Multi:      true,
+				AllValue:   ".*",
+				Sort:       1,
+				Current:    map[string]interface{}{"text": "All", "value": []string{"$__all"}},
+			})
+			break
+		}
+	}
+	return ret
+}
+
+// seriesName is a series which always exists for the metric
+func seriesName(def metric.Definition) string {
+	switch def.Type {
+	case metric.TypeHistogram, metric.TypeSummary:
+		return def.Name + "_count"
+	default:
+		return def.Name
+	}
+}
+
+// selector filters the templated labels of the metric
+func selector(variables []string, def metric.Definition) string {
+	matchers := []string{}
+	for _, v := range variables {
+		if def.HasLabel(v) {
+			matchers = append(matchers, fmt.Sprintf(`%s=~"$%s"`, v, v))
+		}
+	}
+	if len(matchers) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(matchers, ", ") + "}"
+}
+
+// layout places the panels two per line below the previous ones
+type layout struct {
+	id, x, y int
+}
+
+func (l *layout) row(title string) Panel {
+	if l.x > 0 {
+		l.x, l.y = 0, l.y+panelHeight
+	}
+	l.id++
+	p := Panel{ID: l.id, Type: "row", Title: title, GridPos: GridPos{H: 1, W: gridWidth, Y: l.y}}
+	l.y++
+	return p
+}
+
+func (l *layout) place(p Panel) Panel {
+	l.id++
+	p.ID = l.id
+	p.Datasource = "${" + datasourceVar + "}"
+	p.GridPos = GridPos{H: panelHeight, W: panelWidth, X: l.x, Y: l.y}
+	l.x += panelWidth
+	if l.x >= gridWidth {
+		l.x, l.y = 0, l.y+panelHeight
+	}
+	return p
+}
@@ -0,0 +1,53 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"gitlab.com/cake/go-project-template/metric"
+)
+
+func TestGenerate(t *testing.T) {
+	d := Generate(Options{Title: "test", Variables: []string{"service", "missing"}},
+		Section{Title: "A", Definitions: []metric.Definition{
+			{Type: metric.TypeHistogram, Name: "app_latency_seconds", Labels: []string{"service", "type"}},
+			{Type: metric.TypeCounter, Name: "app_jobs_total"},
+		}},
+		Section{Title: "B", Definitions: []metric.Definition{
+			{Type: metric.TypeSummary, Name: "app_size_bytes"},
+		}},
+	)
+
+	// datasource and service, the missing label is not used by any metric
+	if len(d.Templating.List) != 2 || d.Templating.List[1].Query != "label_values(app_latency_seconds_count, service)" {
+		t.Errorf("unexpected variables: %+v", d.Templating.List)
+	}
+
+	titles := []string{}
+	ids := map[int]bool{}
+	for _, p := range d.Panels {
+		titles = append(titles, p.Title)
+		if ids[p.ID] {
+			t.Errorf("duplicated panel id %d", p.ID)
+		}
+		ids[p.ID] = true
+	}
+	want := "A,app_jobs_total rate,app_latency_seconds quantiles,app_latency_seconds heatmap,app_latency_seconds rate," +
+		"app_latency_seconds p99 by service,app_latency_seconds p99 by type,B,app_size_bytes quantiles,app_size_bytes rate"
+	if got := strings.Join(titles, ","); got != want {
+		t.Errorf("panels = %s", got)
+	}
+	// row B starts a new line after the odd panel
+	if b := d.Panels[7]; b.GridPos.X != 0 || b.GridPos.Y != 1+3*panelHeight {
+		t.Errorf("unexpected row position: %+v", b.GridPos)
+	}
+
+	heatmap := d.Panels[3]
+	if heatmap.Targets[0].Expr != `sum by (le) (increase(app_latency_seconds_bucket{service=~"$service"}[$__rate_interval]))` || heatmap.YAxis.Format != "s" {
+		t.Errorf("unexpected heatmap: %+v", heatmap)
+	}
+	if _, err := json.Marshal(d); err != nil {
+		t.Fatal(err)
+	}
+}
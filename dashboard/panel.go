@@ -0,0 +1,121 @@
+package dashboard
+
+import (
+	"fmt"
+	"strings"
+
+	"gitlab.com/cake/go-project-template/metric"
+)
+
+const rateInterval = "[$__rate_interval]"
+
+var quantiles = []struct{ q, legend string }{{"0.5", "p50"}, {"0.9", "p90"}, {"0.99", "p99"}}
+
+// panels charts one metric: rates of counters, values of gauges, quantiles and heatmaps of histograms,
+// quantiles of summaries, and a breakdown by every label
+func panels(def metric.Definition, sel string) []Panel {
+	name, unit := def.Name, unitOf(def.Name)
+	switch def.Type {
+	case metric.TypeCounter:
+		ret := []Panel{graph(name+" rate", def.Help, "short",
+			target(fmt.Sprintf("sum(rate(%s%s%s))", name, sel, rateInterval), "rate"))}
+		for _, l := range def.Labels {
+			ret = append(ret, graph(fmt.Sprintf("%s rate by %s", name, l), def.Help, "short",
+				target(fmt.Sprintf("sum by (%s) (rate(%s%s%s))", l, name, sel, rateInterval), legend(l))))
+		}
+		return ret
+	case metric.TypeGauge:
+		ret := []Panel{graph(name, def.Help, unit, target(name+sel, legend(def.Labels...)))}
+		for _, l := range def.Labels {
+			ret = append(ret, graph(fmt.Sprintf("%s max by %s", name, l), def.Help, unit,
+				target(fmt.Sprintf("max by (%s) (%s%s)", l, name, sel), legend(l))))
+		}
+		return ret
+	case metric.TypeHistogram:
+		bucket := fmt.Sprintf("rate(%s_bucket%s%s)", name, sel, rateInterval)
+		quantile := graph(name+" quantiles", def.Help, unit)
+		for _, q := range quantiles {
+			quantile.Targets = append(quantile.Targets,
+				target(fmt.Sprintf("histogram_quantile(%s, sum by (le) (%s))", q.q, bucket), q.legend))
+		}
+		heatmap := Panel{
+			Type:        "heatmap",
+			Title:       name + " heatmap",
+			Description: def.Help,
+			Targets: []Target{{
+				Expr:         fmt.Sprintf("sum by (le) (increase(%s_bucket%s%s))", name, sel, rateInterval),
+				LegendFormat: "{{le}}",
+				Format:       "heatmap",
+			}},
+			DataFormat: "tsbuckets",
+			YAxis:      &Axis{Format: unit, Show: true},
+		}
+		ret := []Panel{quantile, heatmap, countRate(def, sel)}
+		for _, l := range def.Labels {
+			ret = append(ret, graph(fmt.Sprintf("%s p99 by %s", name, l), def.Help, unit,
+				target(fmt.Sprintf("histogram_quantile(0.99, sum by (le, %s) (%s))", l, bucket), legend(l))))
+		}
+		return withRefIDs(ret)
+	case metric.TypeSummary:
+		ret := []Panel{
+			graph(name+" quantiles", def.Help, unit, target(fmt.Sprintf("max by (quantile) (%s%s)", name, sel), "q{{quantile}}")),
+			countRate(def, sel),
+		}
+		for _, l := range def.Labels {
+			ret = append(ret, graph(fmt.Sprintf("%s average by %s", name, l), def.Help, unit,
+				target(fmt.Sprintf("sum by (%s) (rate(%s_sum%s%s)) / sum by (%s) (rate(%s_count%s%s))",
+					l, name, sel, rateInterval, l, name, sel, rateInterval), legend(l))))
+		}
+		return withRefIDs(ret)
+	}
+	return nil
+}
+
+func countRate(def metric.Definition, sel string) Panel {
+	return graph(def.Name+" rate", def.Help, "short",
+		target(fmt.Sprintf("sum(rate(%s_count%s%s))", def.Name, sel, rateInterval), "rate"))
+}
+
+func graph(title, description, unit string, targets ...Target) Panel {
+	return withRefIDs([]Panel{{
+		Type:        "graph",
+		Title:       title,
+		Description: description,
+		Targets:     targets,
+		YAxes:       []Axis{{Format: unit, Show: true}, {Format: "short", Show: false}},
+	}})[0]
+}
+
+func target(expr, legendFormat string) Target {
+	return Target{Expr: expr, LegendFormat: legendFormat}
+}
+
+// withRefIDs names the targets A, B, C...
+func withRefIDs(ps []Panel) []Panel {
+	for i := range ps {
+		for j := range ps[i].Targets {
+			ps[i].Targets[j].RefID = string(rune('A' + j))
+		}
+	}
+	return ps
+}
+
+func legend(labels ...string) string {
+	parts := make([]string, 0, len(labels))
+	for _, l := range labels {
+		parts = append(parts, "{{"+l+"}}")
+	}
+	return strings.Join(parts, " ")
+}
+
+// unitOf follows the base unit suffix of the naming convention
+func unitOf(name string) string {
+	switch {
+	case strings.HasSuffix(name, "_seconds"):
+		return "s"
+	case strings.HasSuffix(name, "_bytes"):
+		return "bytes"
+	default:
+		return "short"
+	}
+}
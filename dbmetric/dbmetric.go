@@ -0,0 +1,36 @@
+package dbmetric
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	labelPool = "pool"
+
+	// commandBuckets are 1ms to about 8s
+	commandBucketStart  = 0.001
+	commandBucketFactor = 2
+	commandBucketCount  = 14
+)
+
+// register returns the existing collector if an equal one is registered, so the command histograms
+// can be shared by pools
+func register(reg prometheus.Registerer, c prometheus.Collector) (prometheus.Collector, error) {
+	if err := reg.Register(c); err != nil {
+		are := prometheus.AlreadyRegisteredError{}
+		if errors.As(err, &are) {
+			return are.ExistingCollector, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,140 @@
+package dbmetric
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"gitlab.com/cake/goctx"
+	"gitlab.com/cake/gopkg"
+	"gitlab.com/cake/mgopool/v3"
+)
+
+type fakeMongoPool struct {
+	mgopool.MongoPool
+	err gopkg.CodeError
+}
+
+func (p *fakeMongoPool) Ping(ctx goctx.Context) gopkg.CodeError { return p.err }
+func (p *fakeMongoPool) Len() int                               { return 3 }
+func (p *fakeMongoPool) Cap() int                               { return 10 }
+func (p *fakeMongoPool) IsAvailable() bool                      { return true }
+func (p *fakeMongoPool) LiveServers() []string                  { return []string{"a", "b"} }
+
+type fakeRedisConn struct {
+	redis.Conn
+}
+
+func (c *fakeRedisConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	switch cmd {
+	case "get":
+		return nil, redis.Error("WRONGTYPE")
+	case "set":
+		return nil, errors.New("broken pipe")
+	}
+	return "OK", nil
+}
+func (c *fakeRedisConn) Err() error   { return nil }
+func (c *fakeRedisConn) Close() error { return nil }
+
+func gather(t *testing.T, reg *prometheus.Registry) map[string]*dto.MetricFamily {
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := map[string]*dto.MetricFamily{}
+	for _, mf := range mfs {
+		ret[mf.GetName()] = mf
+	}
+	return ret
+}
+
+func labelValue(m *dto.Metric, name string) string {
+	for _, l := range m.GetLabel() {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
+
+func TestInstrumentMongo(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	fake := &fakeMongoPool{}
+	pool, err := InstrumentMongo("local", fake, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := goctx.Background()
+	_ = pool.Ping(ctx)
+	fake.err = gopkg.NewCodeError(42, "failed")
+	_ = pool.Ping(ctx)
+
+	mfs := gather(t, reg)
+	if v := mfs["mongo_pool_size"].GetMetric()[0].GetGauge().GetValue(); v != 3 {
+		t.Errorf("mongo_pool_size = %v, want 3", v)
+	}
+	if v := mfs["mongo_pool_live_servers"].GetMetric()[0].GetGauge().GetValue(); v != 2 {
+		t.Errorf("mongo_pool_live_servers = %v, want 2", v)
+	}
+	codes := map[string]uint64{}
+	for _, m := range mfs["mongo_command_duration_seconds"].GetMetric() {
+		if labelValue(m, "operation") != "Ping" || labelValue(m, labelPool) != "local" {
+			t.Errorf("unexpected labels %v", m.GetLabel())
+		}
+		codes[labelValue(m, "code")] = m.GetHistogram().GetSampleCount()
+	}
+	if codes["0"] != 1 || codes["42"] != 1 {
+		t.Errorf("unexpected codes %v", codes)
+	}
+
+	// the command histogram is shared by pools, also behind the namespace prefix of metric.Registry.Namespaced
+	if _, err := InstrumentMongo("remote", &fakeMongoPool{}, reg); err != nil {
+		t.Fatal(err)
+	}
+	prefixed := prometheus.NewRegistry()
+	for _, name := range []string{"local", "remote"} {
+		pool, err := InstrumentMongo(name, &fakeMongoPool{}, prometheus.WrapRegistererWithPrefix("app_", prefixed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = pool.Ping(ctx)
+	}
+	if n := len(gather(t, prefixed)["app_mongo_command_duration_seconds"].GetMetric()); n != 2 {
+		t.Errorf("prefixed command histogram has %d series, want 2", n)
+	}
+}
+
+func TestInstrumentRedisPool(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	pool := &redis.Pool{
+		MaxActive: 5,
+		MaxIdle:   2,
+		Dial:      func() (redis.Conn, error) { return &fakeRedisConn{}, nil },
+	}
+	if err := InstrumentRedisPool("cache", pool, reg); err != nil {
+		t.Fatal(err)
+	}
+	conn := pool.Get()
+	for _, cmd := range []string{"ping", "get", "set"} {
+		_, _ = conn.Do(cmd)
+	}
+	conn.Close()
+
+	mfs := gather(t, reg)
+	results := map[string]string{}
+	for _, m := range mfs["redis_command_duration_seconds"].GetMetric() {
+		results[labelValue(m, "command")] = labelValue(m, "result")
+	}
+	if results["PING"] != ResultOK || results["GET"] != ResultError || results["SET"] != ResultFailed {
+		t.Errorf("unexpected results %v", results)
+	}
+	if v := mfs["redis_pool_max_active_connections"].GetMetric()[0].GetGauge().GetValue(); v != 5 {
+		t.Errorf("redis_pool_max_active_connections = %v, want 5", v)
+	}
+	if v := mfs["redis_pool_idle_connections"].GetMetric()[0].GetGauge().GetValue(); v != 1 {
+		t.Errorf("redis_pool_idle_connections = %v, want 1", v)
+	}
+}
@@ -0,0 +1,80 @@
+package dbmetric
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gitlab.com/cake/go-project-template/mongohook"
+	"gitlab.com/cake/goctx"
+	"gitlab.com/cake/mgopool/v3"
+)
+
+// MongoCollector reads the pool status on every scrape
+type MongoCollector struct {
+	pool        mgopool.MongoPool
+	size        *prometheus.Desc
+	capacity    *prometheus.Desc
+	available   *prometheus.Desc
+	liveServers *prometheus.Desc
+}
+
+func NewMongoCollector(name string, pool mgopool.MongoPool) *MongoCollector {
+	labels := prometheus.Labels{labelPool: name}
+	return &MongoCollector{
+		pool:        pool,
+		size:        prometheus.NewDesc("mongo_pool_size", "Available connections of the mongo pool.", nil, labels),
+		capacity:    prometheus.NewDesc("mongo_pool_capacity", "Capacity of the mongo pool.", nil, labels),
+		available:   prometheus.NewDesc("mongo_pool_available", "Whether the mongo pool is available.", nil, labels),
+		liveServers: prometheus.NewDesc("mongo_pool_live_servers", "Live servers of the mongo pool.", nil, labels),
+	}
+}
+
+func (c *MongoCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.size
+	ch <- c.capacity
+	ch <- c.available
+	ch <- c.liveServers
+}
+
+func (c *MongoCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(c.size, prometheus.GaugeValue, float64(c.pool.Len()))
+	ch <- prometheus.MustNewConstMetric(c.capacity, prometheus.GaugeValue, float64(c.pool.Cap()))
+	ch <- prometheus.MustNewConstMetric(c.available, prometheus.GaugeValue, boolValue(c.pool.IsAvailable()))
+	ch <- prometheus.MustNewConstMetric(c.liveServers, prometheus.GaugeValue, float64(len(c.pool.LiveServers())))
+}
+
+func newMongoDuration() *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mongo_command_duration_seconds",
+		Help:    "Latency of the mongo pool operations by operation and error code, 0 is success.",
+		Buckets: prometheus.ExponentialBuckets(commandBucketStart, commandBucketFactor, commandBucketCount),
+	}, []string{labelPool, "operation", "code"})
+}
+
+// MongoHook observes the latency of every operation
+func MongoHook(name string, duration *prometheus.HistogramVec) mongohook.Hook {
+	return func(ctx goctx.Context, op mongohook.Operation) (goctx.Context, func(res mongohook.Result)) {
+		start := time.Now()
+		return ctx, func(res mongohook.Result) {
+			code := 0
+			if res.Err != nil {
+				code = res.Err.ErrorCode()
+			}
+			duration.WithLabelValues(name, op.Name, strconv.Itoa(code)).Observe(time.Since(start).Seconds())
+		}
+	}
+}
+
+// InstrumentMongo registers the collector of pool to reg and returns pool with the latency hook,
+// set the returned pool by mgopool.SetExportedPool to instrument the package level functions
+func InstrumentMongo(name string, pool mgopool.MongoPool, reg prometheus.Registerer) (*mongohook.Pool, error) {
+	if err := reg.Register(NewMongoCollector(name, pool)); err != nil {
+		return nil, err
+	}
+	c, err := register(reg, newMongoDuration())
+	if err != nil {
+		return nil, err
+	}
+	return mongohook.Wrap(pool, MongoHook(name, c.(*prometheus.HistogramVec))), nil
+}
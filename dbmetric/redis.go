@@ -0,0 +1,134 @@
+package dbmetric
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/prometheus/client_golang/prometheus"
+	"gitlab.com/cake/redispool"
+)
+
+// Results of redis commands
+const (
+	ResultOK = "ok"
+	// ResultError is an error reply of redis
+	ResultError = "error"
+	// ResultFailed is a connection or protocol error
+	ResultFailed = "failed"
+)
+
+// RedisCollector reads redis.Pool.Stats on every scrape
+type RedisCollector struct {
+	pool         *redis.Pool
+	active       *prometheus.Desc
+	idle         *prometheus.Desc
+	maxActive    *prometheus.Desc
+	waitCount    *prometheus.Desc
+	waitDuration *prometheus.Desc
+}
+
+func NewRedisCollector(name string, pool *redis.Pool) *RedisCollector {
+	labels := prometheus.Labels{labelPool: name}
+	return &RedisCollector{
+		pool:         pool,
+		active:       prometheus.NewDesc("redis_pool_active_connections", "Connections of the redis pool including the idle ones.", nil, labels),
+		idle:         prometheus.NewDesc("redis_pool_idle_connections", "Idle connections of the redis pool.", nil, labels),
+		maxActive:    prometheus.NewDesc("redis_pool_max_active_connections", "Maximum connections of the redis pool, 0 is unlimited.", nil, labels),
+		waitCount:    prometheus.NewDesc("redis_pool_wait_total", "Total connections waited for.", nil, labels),
+		waitDuration: prometheus.NewDesc("redis_pool_wait_duration_seconds_total", "Total time blocked waiting for a connection.", nil, labels),
+	}
+}
+
+func (c *RedisCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.active
+	ch <- c.idle
+	ch <- c.maxActive
+	ch <- c.waitCount
+	ch <- c.waitDuration
+}
+
+func (c *RedisCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.pool.Stats()
+	ch <- prometheus.MustNewConstMetric(c.active, prometheus.GaugeValue, float64(stats.ActiveCount))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stats.IdleCount))
+	ch <- prometheus.MustNewConstMetric(c.maxActive, prometheus.GaugeValue, float64(c.pool.MaxActive))
+	ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds())
+}
+
+func newRedisDuration() *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "redis_command_duration_seconds",
+		Help:    "Latency of the redis commands by command and result.",
+		Buckets: prometheus.ExponentialBuckets(commandBucketStart, commandBucketFactor, commandBucketCount),
+	}, []string{labelPool, "command", "result"})
+}
+
+// InstrumentRedis instruments the redigo pool of p, see InstrumentRedisPool
+func InstrumentRedis(name string, p *redispool.Pool, reg prometheus.Registerer) error {
+	return InstrumentRedisPool(name, p.GetPool(), reg)
+}
+
+// InstrumentRedisPool registers the collector of pool to reg and wraps pool.Dial to observe the command latency,
+// it must be called before the pool dials the first connection
+func InstrumentRedisPool(name string, pool *redis.Pool, reg prometheus.Registerer) error {
+	if err := reg.Register(NewRedisCollector(name, pool)); err != nil {
+		return err
+	}
+	c, err := register(reg, newRedisDuration())
+	if err != nil {
+		return err
+	}
+	duration := c.(*prometheus.HistogramVec)
+
+	dial := pool.Dial
+	pool.Dial = func() (redis.Conn, error) {
+		conn, err := dial()
+		if err != nil {
+			return nil, err
+		}
+		return &redisConn{Conn: conn, name: name, duration: duration}, nil
+	}
+	return nil
+}
+
+// redisConn observes Do, pipelined Send and Receive are not observed
+type redisConn struct {
+	redis.Conn
+	name     string
+	duration *prometheus.HistogramVec
+}
+
+func (c *redisConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	start := time.Now()
+	reply, err := c.Conn.Do(cmd, args...)
+	c.observe(cmd, start, err)
+	return reply, err
+}
+
+func (c *redisConn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	start := time.Now()
+	reply, err := redis.DoWithTimeout(c.Conn, timeout, cmd, args...)
+	c.observe(cmd, start, err)
+	return reply, err
+}
+
+func (c *redisConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return redis.ReceiveWithTimeout(c.Conn, timeout)
+}
+
+func (c *redisConn) observe(cmd string, start time.Time, err error) {
+	// empty command flushes the pipeline
+	if cmd == "" {
+		return
+	}
+	result := ResultOK
+	if err != nil {
+		result = ResultFailed
+		if _, ok := err.(redis.Error); ok {
+			result = ResultError
+		}
+	}
+	c.duration.WithLabelValues(c.name, strings.ToUpper(cmd), result).Observe(time.Since(start).Seconds())
+}
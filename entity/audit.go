@@ -0,0 +1,97 @@
+package entity
+
+import (
+	"time"
+
+	"gitlab.com/cake/goctx"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	FieldCreatedAt  = "createdAt"
+	FieldCreatedBy  = "createdBy"
+	FieldCreatedCID = "createdCid"
+	FieldUpdatedAt  = "updatedAt"
+	FieldUpdatedBy  = "updatedBy"
+	FieldUpdatedCID = "updatedCid"
+	FieldDeletedAt  = "deletedAt"
+	FieldDeletedBy  = "deletedBy"
+)
+
+var (
+	// ActorKeys are the goctx keys looked up in order to identify who made the change
+	ActorKeys = []string{goctx.LogKeyEID, goctx.LogKeyService, goctx.LogKeyInternalCaller}
+
+	auditFields = map[string]bool{
+		FieldCreatedAt:  true,
+		FieldCreatedBy:  true,
+		FieldCreatedCID: true,
+		FieldUpdatedAt:  true,
+		FieldUpdatedBy:  true,
+		FieldUpdatedCID: true,
+		FieldDeletedAt:  true,
+		FieldDeletedBy:  true,
+	}
+)
+
+// Audit is embedded (with `bson:",inline"`) into entities managed by Repository
+type Audit struct {
+	CreatedAt  time.Time  `bson:"createdAt" json:"createdAt"`
+	CreatedBy  string     `bson:"createdBy" json:"createdBy"`
+	CreatedCID string     `bson:"createdCid" json:"createdCid"`
+	UpdatedAt  time.Time  `bson:"updatedAt" json:"updatedAt"`
+	UpdatedBy  string     `bson:"updatedBy" json:"updatedBy"`
+	UpdatedCID string     `bson:"updatedCid" json:"updatedCid"`
+	DeletedAt  *time.Time `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+	DeletedBy  string     `bson:"deletedBy,omitempty" json:"deletedBy,omitempty"`
+}
+
+func (a Audit) IsDeleted() bool {
+	return a.DeletedAt != nil
+}
+
+// Actor returns the identity of the caller from the context
+func Actor(ctx goctx.Context) string {
+	for _, key := range ActorKeys {
+		if v, ok := ctx.GetString(key); ok && v != "" {
+			return v
+		}
+	}
+	return "unknown"
+}
+
+// CID returns the correlation id of the context
+func CID(ctx goctx.Context) string {
+	cid, _ := ctx.GetString(goctx.LogKeyCID)
+	return cid
+}
+
+func createdFields(ctx goctx.Context, now time.Time) bson.M {
+	actor, cid := Actor(ctx), CID(ctx)
+	return bson.M{
+		FieldCreatedAt:  now,
+		FieldCreatedBy:  actor,
+		FieldCreatedCID: cid,
+		FieldUpdatedAt:  now,
+		FieldUpdatedBy:  actor,
+		FieldUpdatedCID: cid,
+	}
+}
+
+func updatedFields(ctx goctx.Context, now time.Time) bson.M {
+	return bson.M{
+		FieldUpdatedAt:  now,
+		FieldUpdatedBy:  Actor(ctx),
+		FieldUpdatedCID: CID(ctx),
+	}
+}
+
+// NotDeleted adds the soft delete filter to the selector
+func NotDeleted(selector bson.M) bson.M {
+	ret := bson.M{}
+	for k, v := range selector {
+		ret[k] = v
+	}
+	ret[FieldDeletedAt] = nil
+	return ret
+}
@@ -0,0 +1,65 @@
+package entity
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/cake/go-project-template/gpt"
+	"gitlab.com/cake/golibs/intercom"
+	"gitlab.com/cake/gopkg"
+)
+
+const (
+	defaultHistoryCount = 20
+	maxHistoryCount     = 100
+)
+
+// HistoryHandler serves the history of the entity identified by the path parameter idParam,
+// paging with the "offset" and "count" query parameters, a count above the maximum is capped
+func HistoryHandler(r *Repository, idParam string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := intercom.GetContextFromGin(c)
+
+		offset, err := queryInt(c, "offset", 0)
+		if err != nil {
+			intercom.GinError(c, err)
+			return
+		}
+		count, err := queryInt(c, "count", defaultHistoryCount)
+		if err != nil {
+			intercom.GinError(c, err)
+			return
+		}
+		if count == 0 {
+			intercom.GinError(c, gopkg.NewCodeError(gpt.CodeBadRequest, "invalid count: 0"))
+			return
+		}
+		if count > maxHistoryCount {
+			count = maxHistoryCount
+		}
+		id, err := r.ParseID(c.Param(idParam))
+		if err != nil {
+			intercom.GinError(c, err)
+			return
+		}
+
+		records, total, err := r.History(ctx, id, offset, count)
+		if err != nil {
+			intercom.GinError(c, err)
+			return
+		}
+		intercom.GinOKListResponse(c, records, total, offset, len(records))
+	}
+}
+
+func queryInt(c *gin.Context, key string, defaultValue int) (int, gopkg.CodeError) {
+	s := c.Query(key)
+	if s == "" {
+		return defaultValue, nil
+	}
+	v, errConv := strconv.Atoi(s)
+	if errConv != nil || v < 0 {
+		return 0, gopkg.NewCodeError(gpt.CodeBadRequest, "invalid "+key+": "+s)
+	}
+	return v, nil
+}
@@ -0,0 +1,66 @@
+package entity
+
+import (
+	"reflect"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionRestore = "restore"
+
+	historySuffix = "_history"
+
+	fieldEntityID = "entityId"
+	fieldTime     = "time"
+)
+
+// Change is the before/after value of one top level field
+type Change struct {
+	Field  string      `bson:"field" json:"field"`
+	Before interface{} `bson:"before,omitempty" json:"before,omitempty"`
+	After  interface{} `bson:"after,omitempty" json:"after,omitempty"`
+}
+
+// HistoryRecord is one entry of the history collection
+type HistoryRecord struct {
+	ID       primitive.ObjectID `bson:"_id" json:"id"`
+	EntityID interface{}        `bson:"entityId" json:"entityId"`
+	Action   string             `bson:"action" json:"action"`
+	Changes  []Change           `bson:"changes,omitempty" json:"changes,omitempty"`
+	Actor    string             `bson:"actor" json:"actor"`
+	CID      string             `bson:"cid" json:"cid"`
+	Time     time.Time          `bson:"time" json:"time"`
+}
+
+// Diff returns the changed top level fields between two documents, audit fields are ignored
+func Diff(before, after bson.M) []Change {
+	keys := map[string]bool{}
+	for k := range before {
+		keys[k] = true
+	}
+	for k := range after {
+		keys[k] = true
+	}
+
+	changes := []Change{}
+	for k := range keys {
+		if auditFields[k] {
+			continue
+		}
+		b, bok := before[k]
+		a, aok := after[k]
+		if bok == aok && reflect.DeepEqual(b, a) {
+			continue
+		}
+		changes = append(changes, Change{Field: k, Before: b, After: a})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
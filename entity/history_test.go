@@ -0,0 +1,76 @@
+package entity
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/cake/goctx"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDiff(t *testing.T) {
+	before := bson.M{"_id": 1, "name": "a", "tags": []string{"x"}, "gone": true, FieldUpdatedAt: time.Unix(1, 0)}
+	after := bson.M{"_id": 1, "name": "b", "tags": []string{"x"}, "new": 3, FieldUpdatedAt: time.Unix(2, 0)}
+
+	changes := Diff(before, after)
+	if len(changes) != 3 {
+		t.Fatalf("expected 3 changes, got %+v", changes)
+	}
+	expected := []string{"gone", "name", "new"}
+	for i, c := range changes {
+		if c.Field != expected[i] {
+			t.Errorf("change %d field = %s, want %s", i, c.Field, expected[i])
+		}
+	}
+	if changes[1].Before != "a" || changes[1].After != "b" {
+		t.Errorf("unexpected name change: %+v", changes[1])
+	}
+}
+
+func TestActor(t *testing.T) {
+	ctx := goctx.Background()
+	if a := Actor(ctx); a != "unknown" {
+		t.Errorf("empty context actor = %s", a)
+	}
+	ctx.Set(goctx.LogKeyService, "svc")
+	ctx.Set(goctx.LogKeyEID, "user1")
+	if a := Actor(ctx); a != "user1" {
+		t.Errorf("actor = %s, want user1", a)
+	}
+}
+
+func TestNotDeleted(t *testing.T) {
+	selector := bson.M{"name": "a"}
+	scoped := NotDeleted(selector)
+	if _, ok := selector[FieldDeletedAt]; ok {
+		t.Error("selector should not be modified")
+	}
+	if v, ok := scoped[FieldDeletedAt]; !ok || v != nil {
+		t.Errorf("unexpected scoped selector: %v", scoped)
+	}
+}
+
+func TestHistoryHandlerBadRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/objects/:id/history", HistoryHandler(NewRepository("db", "objects"), "id"))
+	router.GET("/names/:id/history", HistoryHandler(NewRepository("db", "names").WithIDParser(StringIDParser), "id"))
+	for _, path := range []string{
+		"/objects/abc/history",
+		"/objects/5f0c9a6e1c9d440000a1b2c3/history?count=0",
+		"/objects/5f0c9a6e1c9d440000a1b2c3/history?count=-1",
+		"/names/abc/history?count=0",
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("GET %s = %d, want 400", path, w.Code)
+		}
+	}
+	if id, err := NewRepository("db", "names").WithIDParser(StringIDParser).ParseID("5f0c9a6e1c9d440000a1b2c3"); err != nil || id != "5f0c9a6e1c9d440000a1b2c3" {
+		t.Errorf("string ids should be kept as is, got %#v %v", id, err)
+	}
+}
@@ -0,0 +1,229 @@
+package entity
+
+import (
+	"time"
+
+	"gitlab.com/cake/go-project-template/gpt"
+	"gitlab.com/cake/go-project-template/internal/document"
+	"gitlab.com/cake/goctx"
+	"gitlab.com/cake/gopkg"
+	"gitlab.com/cake/m800log"
+	"gitlab.com/cake/mgopool/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Repository manages the lifecycle of entities in one collection:
+// audit fields are filled from goctx, deletes are soft, and every change is written to the history collection.
+type Repository struct {
+	dbName            string
+	collection        string
+	historyCollection string
+	parseID           IDParser
+}
+
+// IDParser converts an id from a request, e.g. a path parameter, to the _id value of the collection
+type IDParser func(s string) (interface{}, error)
+
+// ObjectIDParser parses the hex of the ObjectIDs generated by Create, the default of NewRepository
+func ObjectIDParser(s string) (interface{}, error) {
+	return primitive.ObjectIDFromHex(s)
+}
+
+// StringIDParser uses the id as is, for collections whose documents have string ids
+func StringIDParser(s string) (interface{}, error) {
+	return s, nil
+}
+
+// NewRepository returns the repository of collection, history is kept in "<collection>_history"
+func NewRepository(dbName, collection string) *Repository {
+	return &Repository{
+		dbName:            dbName,
+		collection:        collection,
+		historyCollection: collection + historySuffix,
+		parseID:           ObjectIDParser,
+	}
+}
+
+// WithIDParser changes how the ids of requests are converted, the collection declares its id type
+func (r *Repository) WithIDParser(parse IDParser) *Repository {
+	r.parseID = parse
+	return r
+}
+
+// ParseID converts s to the _id value of the collection, a malformed id is a bad request
+func (r *Repository) ParseID(s string) (interface{}, gopkg.CodeError) {
+	id, errParse := r.parseID(s)
+	if errParse != nil {
+		return nil, gopkg.NewCodeError(gpt.CodeBadRequest, "invalid id: "+s)
+	}
+	return id, nil
+}
+
+func (r *Repository) Collection() string {
+	return r.collection
+}
+
+func (r *Repository) HistoryCollection() string {
+	return r.historyCollection
+}
+
+// EnsureIndexes creates the indexes used by soft delete filtering and history query,
+// the deletedAt index isn't sparse since a sparse index can't serve the deletedAt: null filter
+func (r *Repository) EnsureIndexes(ctx goctx.Context) (err gopkg.CodeError) {
+	if err = mgopool.CreateIndex(ctx, r.dbName, r.collection, []string{FieldDeletedAt}, false, false, ""); err != nil {
+		return
+	}
+	return mgopool.CreateIndex(ctx, r.dbName, r.historyCollection, []string{fieldEntityID, "-" + fieldTime}, false, false, "")
+}
+
+// Create inserts doc with audit fields, an ObjectID is generated when doc has no _id
+func (r *Repository) Create(ctx goctx.Context, doc interface{}) (id interface{}, err gopkg.CodeError) {
+	m, err := document.ToM(doc)
+	if err != nil {
+		return
+	}
+	if _, ok := m[mgopool.ObjId]; !ok {
+		m[mgopool.ObjId] = primitive.NewObjectID()
+	}
+	id = m[mgopool.ObjId]
+	now := time.Now().UTC()
+	for k, v := range createdFields(ctx, now) {
+		m[k] = v
+	}
+	delete(m, FieldDeletedAt)
+	delete(m, FieldDeletedBy)
+
+	if err = mgopool.Insert(ctx, r.dbName, r.collection, m); err != nil {
+		return
+	}
+	r.writeHistory(ctx, id, ActionCreate, Diff(bson.M{}, m), now)
+	return
+}
+
+// Get loads a not deleted entity
+func (r *Repository) Get(ctx goctx.Context, id interface{}, result interface{}) gopkg.CodeError {
+	return mgopool.QueryOne(ctx, r.dbName, r.collection, result, NotDeleted(bson.M{mgopool.ObjId: id}), nil, 0)
+}
+
+// GetWithDeleted loads an entity regardless of its deleted state
+func (r *Repository) GetWithDeleted(ctx goctx.Context, id interface{}, result interface{}) gopkg.CodeError {
+	return mgopool.QueryOne(ctx, r.dbName, r.collection, result, bson.M{mgopool.ObjId: id}, nil, 0)
+}
+
+// List queries not deleted entities
+func (r *Repository) List(ctx goctx.Context, selector bson.M, result interface{}, skip, limit int, sort ...string) gopkg.CodeError {
+	return mgopool.QueryAll(ctx, r.dbName, r.collection, result, NotDeleted(selector), nil, skip, limit, sort...)
+}
+
+// Count counts not deleted entities
+func (r *Repository) Count(ctx goctx.Context, selector bson.M) (int, gopkg.CodeError) {
+	return mgopool.QueryCount(ctx, r.dbName, r.collection, NotDeleted(selector))
+}
+
+// Update sets the fields of a not deleted entity and records the diff.
+// The update is a plain document of fields to set, audit fields and _id cannot be changed.
+func (r *Repository) Update(ctx goctx.Context, id interface{}, update interface{}, result interface{}) (err gopkg.CodeError) {
+	set, err := document.ToM(update)
+	if err != nil {
+		return
+	}
+	delete(set, mgopool.ObjId)
+	for k := range auditFields {
+		delete(set, k)
+	}
+	now := time.Now().UTC()
+	for k, v := range updatedFields(ctx, now) {
+		set[k] = v
+	}
+
+	before := bson.M{}
+	err = mgopool.FindAndModify(ctx, r.dbName, r.collection, &before, NotDeleted(bson.M{mgopool.ObjId: id}), bson.M{mgopool.OpSet: set}, nil, false, false)
+	if err != nil {
+		return
+	}
+	after := bson.M{}
+	for k, v := range before {
+		after[k] = v
+	}
+	for k, v := range set {
+		after[k] = v
+	}
+	r.writeHistory(ctx, id, ActionUpdate, Diff(before, after), now)
+
+	if result != nil {
+		err = fromDocument(after, result)
+	}
+	return
+}
+
+// SoftDelete marks the entity as deleted
+func (r *Repository) SoftDelete(ctx goctx.Context, id interface{}) (err gopkg.CodeError) {
+	now := time.Now().UTC()
+	set := updatedFields(ctx, now)
+	set[FieldDeletedAt] = now
+	set[FieldDeletedBy] = Actor(ctx)
+
+	err = mgopool.FindAndModify(ctx, r.dbName, r.collection, nil, NotDeleted(bson.M{mgopool.ObjId: id}), bson.M{mgopool.OpSet: set}, nil, false, false)
+	if err != nil {
+		return
+	}
+	r.writeHistory(ctx, id, ActionDelete, nil, now)
+	return
+}
+
+// Restore reverts a soft delete
+func (r *Repository) Restore(ctx goctx.Context, id interface{}) (err gopkg.CodeError) {
+	now := time.Now().UTC()
+	update := bson.M{
+		mgopool.OpSet:   updatedFields(ctx, now),
+		mgopool.OpUnset: bson.M{FieldDeletedAt: "", FieldDeletedBy: ""},
+	}
+	selector := bson.M{mgopool.ObjId: id, FieldDeletedAt: bson.M{mgopool.OpNotEqual: nil}}
+
+	err = mgopool.FindAndModify(ctx, r.dbName, r.collection, nil, selector, update, nil, false, false)
+	if err != nil {
+		return
+	}
+	r.writeHistory(ctx, id, ActionRestore, nil, now)
+	return
+}
+
+// History returns the change records of the entity, newest first
+func (r *Repository) History(ctx goctx.Context, id interface{}, skip, limit int) (records []HistoryRecord, total int, err gopkg.CodeError) {
+	selector := bson.M{fieldEntityID: id}
+	total, err = mgopool.QueryCount(ctx, r.dbName, r.historyCollection, selector)
+	if err != nil {
+		return
+	}
+	records = []HistoryRecord{}
+	err = mgopool.QueryAll(ctx, r.dbName, r.historyCollection, &records, selector, nil, skip, limit, "-"+fieldTime)
+	return
+}
+
+// writeHistory doesn't fail the entity operation, which is already applied
+func (r *Repository) writeHistory(ctx goctx.Context, id interface{}, action string, changes []Change, now time.Time) {
+	record := HistoryRecord{
+		ID:       primitive.NewObjectID(),
+		EntityID: id,
+		Action:   action,
+		Changes:  changes,
+		Actor:    Actor(ctx),
+		CID:      CID(ctx),
+		Time:     now,
+	}
+	if err := mgopool.Insert(ctx, r.dbName, r.historyCollection, record); err != nil {
+		m800log.Errorf(ctx, "[entity] write %s history of %s/%v error: %v", action, r.collection, id, err)
+	}
+}
+
+func fromDocument(m bson.M, result interface{}) gopkg.CodeError {
+	data, errMarshal := mgopool.Marshal(m)
+	if errMarshal != nil {
+		return gopkg.NewCodeError(gpt.CodeInternalServerError, errMarshal.Error())
+	}
+	if errUnmarshal := mgopool.Unmarshal(data, result); errUnmarshal != nil {
+		return gopkg.NewCodeError(gpt.CodeInternalServerError, errUnmarshal.Error())
+	}
+	return nil
+}
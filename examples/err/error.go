@@ -8,12 +8,15 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"gitlab.com/cake/go-project-template/gpt"
+	"gitlab.com/cake/go-project-template/upstream"
 	"gitlab.com/cake/goctx"
 	"gitlab.com/cake/golibs/intercom"
 	"gitlab.com/cake/gopkg"
 	"gitlab.com/cake/m800log"
 )
 
+const selfUpstream = "self"
+
 func randomErr(c *gin.Context) {
 	ctx := intercom.GetContextFromGin(c)
 	handlerName := c.HandlerName()
@@ -54,10 +57,20 @@ func upstreamErr(c *gin.Context) {
 	intercom.GinOKResponse(c, resp)
 }
 
+// upstreamToRandomError calls the error endpoint through the [upstream.self] client when it's configured,
+// so the upstream errors are retried and counted by its circuit breaker
 func upstreamToRandomError(ctx goctx.Context) (result *intercom.JsonResponse, err gopkg.CodeError) {
 	var req *http.Request
 
 	uri := gpt.APIErrorPath
+	if client := upstream.Get(selfUpstream); client != nil {
+		req, err = client.NewRequest(ctx, http.MethodGet, uri, nil)
+		if err != nil {
+			return
+		}
+		return client.M800Do(ctx, req)
+	}
+
 	req, err = intercom.HTTPNewRequest(
 		ctx, http.MethodGet,
 		fmt.Sprintf("http://localhost:8999%s", uri),
@@ -1,6 +0,0 @@
-package metric_api
-
-var (
-	ServiceList = []string{"service1", "service2", "service3"}
-	TypeList    = []string{"type1", "type2"}
-)
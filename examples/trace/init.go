@@ -8,7 +8,6 @@ import (
 	"gitlab.com/cake/m800log"
 	"gitlab.com/cake/mgopool/v3"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/codes"
 )
 
 func AddMetricEndpoint(rootGroup *gin.RouterGroup) {
@@ -30,13 +29,16 @@ func nameHandler(c *gin.Context) {
 	span := ctx.GetSpan()
 	bag := ctx.GetBaggage()
 
+	// the span of the insert is a child of span and records the error
 	err := mgopool.Insert(ctx, "testDB", "testCollection", sample{"1", test})
 	if err != nil {
-		span.SetStatus(codes.Error, err.Error())
 		m800log.Error(ctx, "==================== error:", err.Error())
 	}
 
-	span.SetAttributes(attribute.KeyValue{Key: "baggage", Value: attribute.StringValue(bag.String())})
+	// the baggage is filtered by [otel.baggage] before it reaches the handler
+	for _, m := range bag.Members() {
+		span.SetAttributes(attribute.String("baggage."+m.Key(), m.Value()))
+	}
 	span.SetAttributes(attribute.KeyValue{Key: "name", Value: attribute.StringValue(test)})
 
 	c.JSON(404, gin.H{
@@ -4,10 +4,15 @@ const (
 	// examples/err
 	APIErrorPath = "/v1/error"
 
-	// examples/metric_api
-	APIMetricPath        = "/v1/metric"
-	APILabeledMetricPath = "/v1/labeled-metric/"
+	// ingest
+	APIMetricPath = "/v1/metric"
 
 	// examples/trace
 	APITracePath = "/v1/trace"
+
+	// report
+	APIReportPath = "/v1/report"
+
+	// inspector
+	APIInspectorPath = "/debug/inspector"
 )
@@ -12,8 +12,15 @@ const (
 
 	CodeBadRequest = 9990001
 	CodeForbidden  = 9990002
+	CodeConflict   = 9990003
+	CodeTimeout    = 9990004
+	CodeNotFound   = 9990005
+	// CodePreconditionFailed is returned when the If-Match header doesn't match the current version
+	CodePreconditionFailed = 9990006
 
 	CodeUpstreamSpecific = 9990100
+	// CodeUpstreamUnavailable is returned without sending the request when the circuit is open or the bulkhead is full
+	CodeUpstreamUnavailable = 9990101
 )
 
 func init() {
@@ -23,6 +30,21 @@ func init() {
 	// Status Forbidden Error 403
 	_ = intercom.ErrorHttpStatusMapping.Set(CodeForbidden, http.StatusForbidden)
 
+	// Status Not Found Error 404
+	_ = intercom.ErrorHttpStatusMapping.Set(CodeNotFound, http.StatusNotFound)
+
+	// Status Conflict Error 409
+	_ = intercom.ErrorHttpStatusMapping.Set(CodeConflict, http.StatusConflict)
+
+	// Status Precondition Failed 412
+	_ = intercom.ErrorHttpStatusMapping.Set(CodePreconditionFailed, http.StatusPreconditionFailed)
+
+	// Status Gateway Timeout 504
+	_ = intercom.ErrorHttpStatusMapping.Set(CodeTimeout, http.StatusGatewayTimeout)
+
+	// Status Service Unavailable 503
+	_ = intercom.ErrorHttpStatusMapping.Set(CodeUpstreamUnavailable, http.StatusServiceUnavailable)
+
 	// Status Internal Server Error 500
 	_ = intercom.ErrorHttpStatusMapping.Set(CodeInternalServerError, http.StatusInternalServerError)
 	_ = intercom.ErrorHttpStatusMapping.Set(CodeUpstreamSpecific, http.StatusInternalServerError)
@@ -1,6 +1,6 @@
 #!/bin/bash
 
-VERSION="7.1.1"
+VERSION="7.5.17"
 
 docker run -ti --rm -p 3000:3000 --name grafana \
   grafana/grafana:$VERSION
@@ -0,0 +1,106 @@
+package ingest
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"gitlab.com/cake/go-project-template/gpt"
+	"gitlab.com/cake/go-project-template/metric"
+	"gitlab.com/cake/golibs/intercom"
+	"gitlab.com/cake/gopkg"
+	"gitlab.com/cake/m800log"
+)
+
+// Config is the [metric.ingest] section
+type Config struct {
+	// Metrics lists the writable metrics, empty denies writing any metric
+	Metrics []string `mapstructure:"metrics"`
+	// MaxBatch is the max observations of one request, defaults to 1000
+	MaxBatch int `mapstructure:"max_batch"`
+}
+
+// Schema describes a writable metric to clients
+type Schema struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Help   string   `json:"help,omitempty"`
+	Labels []string `json:"labels"`
+	Ops    []string `json:"ops"`
+}
+
+var settings = Config{MaxBatch: 1000}
+
+// AddIngestEndpoint serves the ingestion API of the default metric registry
+func AddIngestEndpoint(rootGroup *gin.RouterGroup) {
+	// settings
+	apiTimeout := viper.GetDuration("http.api_timeout")
+	if err := viper.UnmarshalKey("metric.ingest", &settings); err != nil {
+		panic("invalid metric.ingest config:" + err.Error())
+	}
+	if settings.MaxBatch <= 0 {
+		settings.MaxBatch = 1000
+	}
+
+	metricGroup := rootGroup.Group(gpt.APIMetricPath,
+		intercom.AccessMiddleware(apiTimeout, gpt.GetNamespace()),
+	)
+	{
+		metricGroup.GET("", schemaHandler)
+		metricGroup.POST("", ingestHandler)
+	}
+}
+
+// ingester is built per request because metric.Init may replace the default registry
+func ingester() *Ingester {
+	return NewIngester(metric.Default(), settings.Metrics...)
+}
+
+func schemaHandler(c *gin.Context) {
+	ret := []Schema{}
+	for _, d := range ingester().Schemas() {
+		labels := d.Labels
+		if labels == nil {
+			labels = []string{}
+		}
+		ret = append(ret, Schema{Name: d.Name, Type: d.Type, Help: d.Help, Labels: labels, Ops: ops(d.Type)})
+	}
+	intercom.GinOKResponse(c, ret)
+}
+
+func ops(typ string) []string {
+	switch typ {
+	case metric.TypeCounter:
+		return []string{OpAdd, OpInc}
+	case metric.TypeGauge:
+		return []string{OpSet, OpAdd, OpSub, OpInc, OpDec}
+	default:
+		return []string{OpObserve}
+	}
+}
+
+func ingestHandler(c *gin.Context) {
+	ctx := intercom.GetContextFromGin(c)
+
+	var batch Batch
+	if err := intercom.ParseJSONGin(ctx, c, &batch); err != nil {
+		m800log.Debugf(ctx, "[metric ingest] invalid request: %v", err)
+		intercom.GinError(c, gopkg.NewCodeError(gpt.CodeBadRequest, err.Error()))
+		return
+	}
+	if len(batch.Observations) == 0 {
+		intercom.GinError(c, gopkg.NewCodeError(gpt.CodeBadRequest, "observations are required"))
+		return
+	}
+	if len(batch.Observations) > settings.MaxBatch {
+		intercom.GinError(c, gopkg.NewCodeError(gpt.CodeBadRequest,
+			fmt.Sprintf("too many observations: %d, max %d", len(batch.Observations), settings.MaxBatch)))
+		return
+	}
+
+	result := ingester().Ingest(ctx, batch.Observations)
+	if result.Rejected > 0 {
+		m800log.Debugf(ctx, "[metric ingest] %d of %d observations rejected: %+v", result.Rejected, len(batch.Observations), result.Errors)
+	}
+	intercom.GinOKResponse(c, result)
+}
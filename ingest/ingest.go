@@ -0,0 +1,254 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"gitlab.com/cake/go-project-template/gpt"
+	"gitlab.com/cake/go-project-template/metric"
+	"gitlab.com/cake/goctx"
+	"gitlab.com/cake/gopkg"
+)
+
+// Operations of an observation, the default is add for counter, set for gauge and observe for histogram and summary
+const (
+	OpAdd     = "add"
+	OpInc     = "inc"
+	OpSet     = "set"
+	OpSub     = "sub"
+	OpDec     = "dec"
+	OpObserve = "observe"
+)
+
+// Observation is one change of a declared metric, Value is ignored by inc and dec
+type Observation struct {
+	Metric string            `json:"metric"`
+	Op     string            `json:"op,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+}
+
+// Batch is the request body of the ingestion API
+type Batch struct {
+	Observations []Observation `json:"observations"`
+}
+
+// ItemError is the error of the observation at Index, the other observations are still applied
+type ItemError struct {
+	Index   int    `json:"index"`
+	Metric  string `json:"metric"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Result of a batch
+type Result struct {
+	Accepted int         `json:"accepted"`
+	Rejected int         `json:"rejected"`
+	Errors   []ItemError `json:"errors"`
+}
+
+// Ingester applies observations to the declared metrics of a registry
+type Ingester struct {
+	registry *metric.Registry
+	// allow lists the writable metrics
+	allow map[string]bool
+}
+
+// NewIngester allows the metrics of names only, no metric is writable without names
+func NewIngester(r *metric.Registry, names ...string) *Ingester {
+	in := &Ingester{registry: r, allow: map[string]bool{}}
+	for _, n := range names {
+		in.allow[n] = true
+	}
+	return in
+}
+
+// Schemas returns the writable definitions in name order
+func (in *Ingester) Schemas() []metric.Definition {
+	ret := []metric.Definition{}
+	for _, d := range in.registry.Definitions() {
+		if in.allowed(d.Name) {
+			ret = append(ret, d)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+func (in *Ingester) allowed(name string) bool {
+	return in.allow[name]
+}
+
+// Ingest validates and applies every observation, an invalid one doesn't stop the others
+func (in *Ingester) Ingest(ctx goctx.Context, obs []Observation) Result {
+	ret := Result{Errors: []ItemError{}}
+	for i, o := range obs {
+		if err := in.apply(ctx, o); err != nil {
+			ret.Rejected++
+			ret.Errors = append(ret.Errors, ItemError{Index: i, Metric: o.Metric, Code: err.ErrorCode(), Message: err.Error()})
+			continue
+		}
+		ret.Accepted++
+	}
+	return ret
+}
+
+func (in *Ingester) apply(ctx goctx.Context, o Observation) gopkg.CodeError {
+	def, err := in.registry.Definition(o.Metric)
+	if err != nil || !in.allowed(o.Metric) {
+		return gopkg.NewCodeError(gpt.CodeNotFound, fmt.Sprintf("metric %s is not declared", o.Metric))
+	}
+	op, value, err := normalize(def.Type, o.Op, o.Value)
+	if err != nil {
+		return gopkg.NewCodeError(gpt.CodeBadRequest, err.Error())
+	}
+	values, err := labelValues(def, o.Labels)
+	if err != nil {
+		return gopkg.NewCodeError(gpt.CodeBadRequest, err.Error())
+	}
+	if err := in.write(ctx, def, op, value, values); err != nil {
+		if errors.Is(err, metric.ErrNotFound) || errors.Is(err, metric.ErrTypeMismatch) {
+			return gopkg.NewCodeError(gpt.CodeNotFound, err.Error())
+		}
+		return gopkg.NewCodeError(gpt.CodeInternalServerError, err.Error())
+	}
+	return nil
+}
+
+// normalize resolves the default operation and turns inc, dec and sub into add
+func normalize(typ, op string, value float64) (string, float64, error) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return "", 0, fmt.Errorf("value must be finite")
+	}
+	if op == "" {
+		switch typ {
+		case metric.TypeCounter:
+			op = OpAdd
+		case metric.TypeGauge:
+			op = OpSet
+		default:
+			op = OpObserve
+		}
+	}
+	switch {
+	case op == OpInc && (typ == metric.TypeCounter || typ == metric.TypeGauge):
+		return OpAdd, 1, nil
+	case op == OpAdd && typ == metric.TypeCounter:
+		if value < 0 {
+			return "", 0, fmt.Errorf("counter can't decrease, value %v", value)
+		}
+		return OpAdd, value, nil
+	case op == OpAdd && typ == metric.TypeGauge:
+		return OpAdd, value, nil
+	case op == OpSub && typ == metric.TypeGauge:
+		return OpAdd, -value, nil
+	case op == OpDec && typ == metric.TypeGauge:
+		return OpAdd, -1, nil
+	case op == OpSet && typ == metric.TypeGauge:
+		return OpSet, value, nil
+	case op == OpObserve && (typ == metric.TypeHistogram || typ == metric.TypeSummary):
+		return OpObserve, value, nil
+	}
+	return "", 0, fmt.Errorf("operation %q is not supported by %s", op, typ)
+}
+
+// labelValues orders the values as declared, missing and undeclared labels are rejected
+func labelValues(def metric.Definition, labels map[string]string) ([]string, error) {
+	values := make([]string, 0, len(def.Labels))
+	for _, l := range def.Labels {
+		v, ok := labels[l]
+		if !ok {
+			return nil, fmt.Errorf("label %s is required", l)
+		}
+		values = append(values, v)
+	}
+	if len(labels) != len(def.Labels) {
+		extra := []string{}
+		for l := range labels {
+			if !def.HasLabel(l) {
+				extra = append(extra, l)
+			}
+		}
+		sort.Strings(extra)
+		return nil, fmt.Errorf("labels %s are not declared", strings.Join(extra, ", "))
+	}
+	return values, nil
+}
+
+// write goes through the cardinality guard of the labeled metrics
+func (in *Ingester) write(ctx goctx.Context, def metric.Definition, op string, value float64, values []string) error {
+	r := in.registry
+	labeled := len(values) > 0
+	switch def.Type {
+	case metric.TypeCounter:
+		if labeled {
+			vec, err := r.CounterVec(def.Name)
+			if err != nil {
+				return err
+			}
+			vec.WithLabelValues(values...).Add(value)
+			return nil
+		}
+		c, err := r.Counter(def.Name)
+		if err != nil {
+			return err
+		}
+		c.Add(value)
+	case metric.TypeGauge:
+		var g interface {
+			Set(float64)
+			Add(float64)
+		}
+		if labeled {
+			vec, err := r.GaugeVec(def.Name)
+			if err != nil {
+				return err
+			}
+			g = vec.WithLabelValues(values...)
+		} else {
+			gauge, err := r.Gauge(def.Name)
+			if err != nil {
+				return err
+			}
+			g = gauge
+		}
+		if op == OpSet {
+			g.Set(value)
+		} else {
+			g.Add(value)
+		}
+	case metric.TypeHistogram:
+		if labeled {
+			vec, err := r.HistogramVec(def.Name)
+			if err != nil {
+				return err
+			}
+			metric.Observe(ctx, vec.WithLabelValues(values...), value)
+			return nil
+		}
+		h, err := r.Histogram(def.Name)
+		if err != nil {
+			return err
+		}
+		metric.Observe(ctx, h, value)
+	case metric.TypeSummary:
+		if labeled {
+			vec, err := r.SummaryVec(def.Name)
+			if err != nil {
+				return err
+			}
+			vec.WithLabelValues(values...).Observe(value)
+			return nil
+		}
+		s, err := r.Summary(def.Name)
+		if err != nil {
+			return err
+		}
+		s.Observe(value)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package ingest
+
+import (
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"gitlab.com/cake/go-project-template/gpt"
+	"gitlab.com/cake/go-project-template/metric"
+	"gitlab.com/cake/goctx"
+)
+
+func testRegistry(t *testing.T) *metric.Registry {
+	r := metric.NewRegistry("test", "")
+	err := r.RegisterAll([]metric.Definition{
+		{Type: metric.TypeCounter, Name: "jobs_total"},
+		{Type: metric.TypeGauge, Name: "queue_size", Labels: []string{"queue"}},
+		{Type: metric.TypeHistogram, Name: "latency_seconds", Labels: []string{"queue"}, Buckets: []float64{1}},
+		{Type: metric.TypeSummary, Name: "size_bytes"},
+		{Type: metric.TypeCounter, Name: "internal_total"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func value(t *testing.T, r *metric.Registry, name string) *dto.Metric {
+	mfs, err := r.Gatherer().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == "test_"+name {
+			return mf.GetMetric()[0]
+		}
+	}
+	t.Fatalf("metric %s not found", name)
+	return nil
+}
+
+func TestIngest(t *testing.T) {
+	r := testRegistry(t)
+	in := NewIngester(r, "jobs_total", "queue_size", "latency_seconds", "size_bytes")
+	q := map[string]string{"queue": "a"}
+	result := in.Ingest(goctx.Background(), []Observation{
+		{Metric: "jobs_total", Value: 2},
+		{Metric: "jobs_total", Op: OpInc},
+		{Metric: "queue_size", Labels: q, Value: 10},
+		{Metric: "queue_size", Labels: q, Op: OpSub, Value: 3},
+		{Metric: "queue_size", Labels: q, Op: OpDec},
+		{Metric: "latency_seconds", Labels: q, Value: 0.5},
+		{Metric: "size_bytes", Op: OpObserve, Value: 100},
+		// rejected
+		{Metric: "jobs_total", Value: -1},
+		{Metric: "jobs_total", Op: OpSet, Value: 1},
+		{Metric: "queue_size", Value: 1},
+		{Metric: "queue_size", Labels: map[string]string{"queue": "a", "host": "b"}, Value: 1},
+		{Metric: "internal_total", Value: 1},
+		{Metric: "unknown", Value: 1},
+	})
+
+	if result.Accepted != 7 || result.Rejected != 6 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	wantCodes := []int{gpt.CodeBadRequest, gpt.CodeBadRequest, gpt.CodeBadRequest, gpt.CodeBadRequest, gpt.CodeNotFound, gpt.CodeNotFound}
+	for i, e := range result.Errors {
+		if e.Index != 7+i || e.Code != wantCodes[i] {
+			t.Errorf("unexpected error %d: %+v", i, e)
+		}
+	}
+
+	if v := value(t, r, "jobs_total").GetCounter().GetValue(); v != 3 {
+		t.Errorf("jobs_total = %v, want 3", v)
+	}
+	if v := value(t, r, "queue_size").GetGauge().GetValue(); v != 6 {
+		t.Errorf("queue_size = %v, want 6", v)
+	}
+	if v := value(t, r, "latency_seconds").GetHistogram().GetSampleCount(); v != 1 {
+		t.Errorf("latency_seconds count = %v, want 1", v)
+	}
+	if len(in.Schemas()) != 4 {
+		t.Errorf("internal_total should not be writable: %+v", in.Schemas())
+	}
+}
+
+func TestIngestDenyByDefault(t *testing.T) {
+	in := NewIngester(testRegistry(t))
+	if len(in.Schemas()) != 0 {
+		t.Errorf("no metric should be writable without an allowlist: %+v", in.Schemas())
+	}
+	if result := in.Ingest(goctx.Background(), []Observation{{Metric: "jobs_total", Value: 1}}); len(result.Errors) != 1 {
+		t.Errorf("observation should be rejected: %+v", result)
+	}
+}
@@ -0,0 +1,103 @@
+package inspector
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Query parameters of Filter
+const (
+	ParamRoute       = "route"
+	ParamStatus      = "status"
+	ParamMinDuration = "min_duration"
+	ParamMaxDuration = "max_duration"
+	ParamCID         = "cid"
+	ParamLimit       = "limit"
+)
+
+// Filter of the requests and traces, zero values match all
+type Filter struct {
+	// Route is the gin route or a path.Match pattern of it, e.g. /v1/report/*
+	Route string
+	// Status is a status code, e.g. 404, or a class, e.g. 5xx
+	Status      string
+	MinDuration time.Duration
+	MaxDuration time.Duration
+	CID         string
+	Limit       int
+}
+
+// ParseFilter reads the filter from the query parameters
+func ParseFilter(q url.Values) (f Filter, err error) {
+	f.Route = q.Get(ParamRoute)
+	if _, err = path.Match(f.Route, "/"); err != nil {
+		return f, fmt.Errorf("invalid %s: %s", ParamRoute, f.Route)
+	}
+	f.Status = strings.ToLower(q.Get(ParamStatus))
+	if f.Status != "" && !validStatus(f.Status) {
+		return f, fmt.Errorf("invalid %s: %s", ParamStatus, f.Status)
+	}
+	for name, d := range map[string]*time.Duration{ParamMinDuration: &f.MinDuration, ParamMaxDuration: &f.MaxDuration} {
+		if v := q.Get(name); v != "" {
+			if *d, err = time.ParseDuration(v); err != nil {
+				return f, fmt.Errorf("invalid %s: %s", name, v)
+			}
+		}
+	}
+	f.CID = q.Get(ParamCID)
+	if v := q.Get(ParamLimit); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 0 {
+			return f, fmt.Errorf("invalid %s: %s", ParamLimit, v)
+		}
+	}
+	return f, nil
+}
+
+func validStatus(s string) bool {
+	if len(s) == 3 && s[1:] == "xx" {
+		return s[0] >= '1' && s[0] <= '5'
+	}
+	n, err := strconv.Atoi(s)
+	return err == nil && n >= 100 && n < 600
+}
+
+func (f Filter) matchStatus(status int) bool {
+	if f.Status == "" {
+		return true
+	}
+	if strings.HasSuffix(f.Status, "xx") {
+		return strconv.Itoa(status/100) == f.Status[:1]
+	}
+	return strconv.Itoa(status) == f.Status
+}
+
+func (f Filter) matchRoute(route string) bool {
+	if f.Route == "" || f.Route == route {
+		return true
+	}
+	ok, _ := path.Match(f.Route, route)
+	return ok
+}
+
+func (f Filter) matchDuration(d time.Duration) bool {
+	return d >= f.MinDuration && (f.MaxDuration <= 0 || d <= f.MaxDuration)
+}
+
+func (f Filter) matchRequest(r Request) bool {
+	return f.matchRoute(r.Route) && f.matchStatus(r.Status) && f.matchDuration(r.Duration) && (f.CID == "" || f.CID == r.CID)
+}
+
+// matchTrace filters by the duration of the trace, the traces without a request only match the duration
+func (f Filter) matchTrace(t TraceSummary) bool {
+	if !f.matchDuration(t.Duration) {
+		return false
+	}
+	if f.Route == "" && f.Status == "" && f.CID == "" {
+		return true
+	}
+	return t.Request != nil && f.matchRoute(t.Request.Route) && f.matchStatus(t.Request.Status) && (f.CID == "" || f.CID == t.Request.CID)
+}
@@ -0,0 +1,148 @@
+package inspector
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/cake/go-project-template/gpt"
+	"gitlab.com/cake/goctx"
+	"gitlab.com/cake/golibs/intercom"
+	"gitlab.com/cake/gopkg"
+)
+
+const (
+	// ParamFormat is json or html, defaults to the Accept header
+	ParamFormat = "format"
+	FormatJSON  = "json"
+	FormatHTML  = "html"
+)
+
+// Middleware records the requests to the default inspector, it must be used after the trace middleware
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		i := Default()
+		if i == nil || strings.HasPrefix(c.Request.URL.Path, gpt.APIInspectorPath) {
+			c.Next()
+			return
+		}
+		start := time.Now()
+		// a panic not recovered yet is recorded as a 500
+		defer func() {
+			status := c.Writer.Status()
+			p := recover()
+			if p != nil {
+				status = http.StatusInternalServerError
+			}
+			ctx := intercom.GetContextFromGin(c)
+			r := Request{
+				Time:     start,
+				Method:   c.Request.Method,
+				Path:     c.Request.URL.Path,
+				Route:    c.FullPath(),
+				Status:   status,
+				Code:     c.GetInt(goctx.LogKeyErrorCode),
+				Duration: time.Since(start),
+			}
+			r.CID, _ = ctx.GetString(goctx.LogKeyCID)
+			if sc := ctx.GetSpan().SpanContext(); sc.IsValid() {
+				r.TraceID = sc.TraceID().String()
+			}
+			i.Record(r)
+			if p != nil {
+				panic(p)
+			}
+		}()
+		c.Next()
+	}
+}
+
+// AddInspectorEndpoint serves the recent requests and traces when the inspector is enabled
+func AddInspectorEndpoint(rootGroup *gin.RouterGroup) {
+	if Default() == nil {
+		return
+	}
+	inspectorGroup := rootGroup.Group(gpt.APIInspectorPath)
+	{
+		inspectorGroup.GET("/requests", requestsHandler)
+		inspectorGroup.GET("/traces", tracesHandler)
+		inspectorGroup.GET("/traces/:traceID", traceHandler)
+	}
+}
+
+func badRequest(msg string) gopkg.CodeError {
+	return gopkg.NewCodeError(gpt.CodeBadRequest, msg)
+}
+
+// format returns the format parameter, or html when the client prefers it, e.g. a browser
+func format(c *gin.Context) (string, bool) {
+	switch f := c.Query(ParamFormat); f {
+	case FormatJSON, FormatHTML:
+		return f, true
+	case "":
+		if c.NegotiateFormat(gin.MIMEJSON, gin.MIMEHTML) == gin.MIMEHTML {
+			return FormatHTML, true
+		}
+		return FormatJSON, true
+	}
+	return "", false
+}
+
+func parse(c *gin.Context) (f Filter, outFormat string, ok bool) {
+	outFormat, ok = format(c)
+	if !ok {
+		intercom.GinError(c, badRequest("invalid "+ParamFormat+": "+c.Query(ParamFormat)))
+		return
+	}
+	f, err := ParseFilter(c.Request.URL.Query())
+	if err != nil {
+		intercom.GinError(c, badRequest(err.Error()))
+		return f, outFormat, false
+	}
+	return f, outFormat, true
+}
+
+func requestsHandler(c *gin.Context) {
+	f, outFormat, ok := parse(c)
+	if !ok {
+		return
+	}
+	ret := Default().Requests(f)
+	if outFormat == FormatHTML {
+		render(c, requestsTemplate, ret)
+		return
+	}
+	intercom.GinOKResponse(c, ret)
+}
+
+func tracesHandler(c *gin.Context) {
+	f, outFormat, ok := parse(c)
+	if !ok {
+		return
+	}
+	ret := Default().Traces(f)
+	if outFormat == FormatHTML {
+		render(c, tracesTemplate, ret)
+		return
+	}
+	intercom.GinOKResponse(c, ret)
+}
+
+func traceHandler(c *gin.Context) {
+	outFormat, ok := format(c)
+	if !ok {
+		intercom.GinError(c, badRequest("invalid "+ParamFormat+": "+c.Query(ParamFormat)))
+		return
+	}
+	t, ok := Default().Trace(c.Param("traceID"))
+	if !ok {
+		intercom.GinError(c, gopkg.NewCodeError(gpt.CodeNotFound, "trace not found: "+c.Param("traceID")))
+		return
+	}
+	if outFormat == FormatHTML {
+		render(c, traceTemplate, newWaterfall(t))
+		return
+	}
+	intercom.GinOKResponse(c, t)
+}
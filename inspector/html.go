@@ -0,0 +1,102 @@
+package inspector
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/cake/golibs/intercom"
+	"gitlab.com/cake/m800log"
+)
+
+const style = `<style>
+body { font-family: sans-serif; font-size: 13px; }
+table { border-collapse: collapse; width: 100%; }
+td, th { border-bottom: 1px solid #ddd; padding: 2px 6px; text-align: left; white-space: nowrap; }
+.error { color: #c00; }
+.bar { position: relative; height: 14px; background: #f4f4f4; min-width: 400px; }
+.bar div { position: absolute; height: 14px; background: #4a90d9; min-width: 1px; }
+.bar div.error { background: #d9534f; }
+</style>`
+
+var (
+	requestsTemplate = template.Must(template.New("requests").Parse(`<!DOCTYPE html><html><head><title>Requests</title>` + style + `</head><body>
+<h3>Requests</h3><p><a href="traces?format=html">traces</a></p>
+<table><tr><th>time</th><th>method</th><th>path</th><th>route</th><th>status</th><th>code</th><th>duration</th><th>cid</th><th>trace</th></tr>
+{{range .}}<tr{{if ge .Status 500}} class="error"{{end}}><td>{{.Time.Format "15:04:05.000"}}</td><td>{{.Method}}</td><td>{{.Path}}</td><td>{{.Route}}</td><td>{{.Status}}</td><td>{{if .Code}}{{.Code}}{{end}}</td><td>{{.Duration}}</td><td>{{.CID}}</td>
+<td>{{if .TraceID}}<a href="traces/{{.TraceID}}?format=html">{{.TraceID}}</a>{{end}}</td></tr>
+{{end}}</table></body></html>`))
+
+	tracesTemplate = template.Must(template.New("traces").Parse(`<!DOCTYPE html><html><head><title>Traces</title>` + style + `</head><body>
+<h3>Traces</h3><p><a href="requests?format=html">requests</a></p>
+<table><tr><th>start</th><th>trace</th><th>name</th><th>spans</th><th>duration</th><th>status</th><th>cid</th></tr>
+{{range .}}<tr{{if .Error}} class="error"{{end}}><td>{{.Start.Format "15:04:05.000"}}</td><td><a href="traces/{{.TraceID}}?format=html">{{.TraceID}}</a></td><td>{{.Name}}</td><td>{{.SpanSize}}</td><td>{{.Duration}}</td>
+<td>{{with .Request}}{{.Status}}{{end}}</td><td>{{with .Request}}{{.CID}}{{end}}</td></tr>
+{{end}}</table></body></html>`))
+
+	traceTemplate = template.Must(template.New("trace").Parse(`<!DOCTYPE html><html><head><title>Trace {{.TraceID}}</title>` + style + `</head><body>
+<h3>{{.Name}}</h3><p>trace {{.TraceID}}, {{.SpanSize}} spans, {{.Duration}}{{with .Request}}, {{.Method}} {{.Path}} {{.Status}} cid {{.CID}}{{end}}</p>
+<p><a href="../traces?format=html">traces</a></p>
+<table><tr><th>span</th><th>kind</th><th>duration</th><th></th></tr>
+{{range .Rows}}<tr{{if .Error}} class="error"{{end}}><td title="{{.Attributes}}" style="padding-left: {{.Indent}}px">{{.Name}}</td><td>{{.Kind}}</td><td>{{.Duration}}</td>
+<td class="bar"><div{{if .Error}} class="error"{{end}} style="left: {{.Offset}}%; width: {{.Width}}%"></div></td></tr>
+{{end}}</table></body></html>`))
+)
+
+type waterfall struct {
+	Trace
+	Rows []row
+}
+
+type row struct {
+	Span
+	Indent int
+	Error  bool
+	// Offset and Width are percentages of the trace duration
+	Offset float64
+	Width  float64
+}
+
+// newWaterfall orders the spans depth first under their parents, the spans whose parent isn't in the trace are roots
+func newWaterfall(t Trace) waterfall {
+	w := waterfall{Trace: t}
+	children := map[string][]Span{}
+	ids := map[string]bool{}
+	for _, s := range t.Spans {
+		ids[s.SpanID] = true
+	}
+	roots := []Span{}
+	for _, s := range t.Spans {
+		if s.ParentSpanID == "" || !ids[s.ParentSpanID] {
+			roots = append(roots, s)
+			continue
+		}
+		children[s.ParentSpanID] = append(children[s.ParentSpanID], s)
+	}
+
+	total := float64(t.Duration)
+	var walk func(s Span, depth int)
+	walk = func(s Span, depth int) {
+		r := row{Span: s, Indent: depth * 16, Error: s.Status == "Error", Width: 100}
+		if total > 0 {
+			r.Offset = float64(s.Start.Sub(t.Start)) / total * 100
+			r.Width = float64(s.Duration) / total * 100
+		}
+		w.Rows = append(w.Rows, r)
+		for _, c := range children[s.SpanID] {
+			walk(c, depth+1)
+		}
+	}
+	for _, s := range roots {
+		walk(s, 0)
+	}
+	return w
+}
+
+func render(c *gin.Context, t *template.Template, data interface{}) {
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	if err := t.Execute(c.Writer, data); err != nil {
+		m800log.Errorf(intercom.GetContextFromGin(c), "[inspector] render %s failed: %v", t.Name(), err)
+	}
+}
@@ -0,0 +1,326 @@
+package inspector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+)
+
+var (
+	defaultInspector   *Inspector
+	defaultInspectorMu sync.RWMutex
+)
+
+// Config is the [inspector] section, the inspector is meant for local debugging and disabled by default
+type Config struct {
+	Enabled bool `mapstructure:"enabled"`
+	// MaxTraces kept in the ring buffer, defaults to 100
+	MaxTraces int `mapstructure:"max_traces"`
+	// MaxSpans of one trace, the later spans are dropped, defaults to 500
+	MaxSpans int `mapstructure:"max_spans"`
+	// MaxRequests kept in the ring buffer, defaults to 500
+	MaxRequests int `mapstructure:"max_requests"`
+}
+
+// LoadConfig reads the [inspector] section
+func LoadConfig() (conf Config, err error) {
+	if err = viper.UnmarshalKey("inspector", &conf); err != nil {
+		return
+	}
+	conf.setDefault()
+	err = conf.validate()
+	return
+}
+
+func (c *Config) setDefault() {
+	if c.MaxTraces == 0 {
+		c.MaxTraces = 100
+	}
+	if c.MaxSpans == 0 {
+		c.MaxSpans = 500
+	}
+	if c.MaxRequests == 0 {
+		c.MaxRequests = 500
+	}
+}
+
+func (c *Config) validate() error {
+	if c.MaxTraces < 0 || c.MaxSpans < 0 || c.MaxRequests < 0 {
+		return fmt.Errorf("max_traces, max_spans and max_requests must not be negative")
+	}
+	return nil
+}
+
+// Span is an ended span
+type Span struct {
+	TraceID       string                 `json:"traceID"`
+	SpanID        string                 `json:"spanID"`
+	ParentSpanID  string                 `json:"parentSpanID,omitempty"`
+	Name          string                 `json:"name"`
+	Kind          string                 `json:"kind"`
+	Start         time.Time              `json:"start"`
+	End           time.Time              `json:"end"`
+	Duration      time.Duration          `json:"duration"`
+	Status        string                 `json:"status"`
+	StatusMessage string                 `json:"statusMessage,omitempty"`
+	Sampled       bool                   `json:"sampled"`
+	Attributes    map[string]interface{} `json:"attributes,omitempty"`
+	Events        []Event                `json:"events,omitempty"`
+}
+
+type Event struct {
+	Name       string                 `json:"name"`
+	Time       time.Time              `json:"time"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// Trace is the spans of one trace ended in this process ordered by start time
+type Trace struct {
+	TraceSummary
+	Spans []Span `json:"spans"`
+}
+
+// TraceSummary describes a trace by its first span and the request recorded with the trace ID
+type TraceSummary struct {
+	TraceID  string        `json:"traceID"`
+	Name     string        `json:"name"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	SpanSize int           `json:"spanSize"`
+	Error    bool          `json:"error"`
+	Request  *Request      `json:"request,omitempty"`
+}
+
+// Request is one request recorded by Middleware
+type Request struct {
+	Time     time.Time     `json:"time"`
+	Method   string        `json:"method"`
+	Path     string        `json:"path"`
+	Route    string        `json:"route"`
+	Status   int           `json:"status"`
+	Code     int           `json:"code,omitempty"`
+	Duration time.Duration `json:"duration"`
+	CID      string        `json:"cid,omitempty"`
+	TraceID  string        `json:"traceID,omitempty"`
+}
+
+// Inspector keeps the recent traces as a span processor and the recent requests in ring buffers
+type Inspector struct {
+	conf Config
+
+	mu       sync.RWMutex
+	traces   map[trace.TraceID]*Trace
+	order    []trace.TraceID
+	next     int
+	requests []Request
+	nextReq  int
+}
+
+var _ tracesdk.SpanProcessor = (*Inspector)(nil)
+
+func New(conf Config) *Inspector {
+	conf.setDefault()
+	return &Inspector{
+		conf:     conf,
+		traces:   map[trace.TraceID]*Trace{},
+		order:    make([]trace.TraceID, 0, conf.MaxTraces),
+		requests: make([]Request, 0, conf.MaxRequests),
+	}
+}
+
+// Init replaces the default inspector used by Middleware and the endpoints, nil is returned when disabled
+func Init(conf Config) *Inspector {
+	var i *Inspector
+	if conf.Enabled {
+		i = New(conf)
+	}
+	defaultInspectorMu.Lock()
+	defer defaultInspectorMu.Unlock()
+	defaultInspector = i
+	return i
+}
+
+// Default returns the inspector built by Init, nil when disabled
+func Default() *Inspector {
+	defaultInspectorMu.RLock()
+	defer defaultInspectorMu.RUnlock()
+	return defaultInspector
+}
+
+func (i *Inspector) OnStart(parent context.Context, s tracesdk.ReadWriteSpan) {}
+
+// OnEnd records every ended span including the ones recorded but not sampled
+func (i *Inspector) OnEnd(s tracesdk.ReadOnlySpan) {
+	span := newSpan(s)
+	id := s.SpanContext().TraceID()
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	t, ok := i.traces[id]
+	if !ok {
+		if i.conf.MaxTraces == 0 {
+			return
+		}
+		t = &Trace{TraceSummary: TraceSummary{TraceID: span.TraceID}}
+		if len(i.order) < i.conf.MaxTraces {
+			i.order = append(i.order, id)
+		} else {
+			delete(i.traces, i.order[i.next])
+			i.order[i.next] = id
+		}
+		i.next = (i.next + 1) % i.conf.MaxTraces
+		i.traces[id] = t
+	}
+	if len(t.Spans) >= i.conf.MaxSpans {
+		return
+	}
+	t.Spans = append(t.Spans, span)
+	t.update()
+}
+
+func (i *Inspector) Shutdown(context.Context) error   { return nil }
+func (i *Inspector) ForceFlush(context.Context) error { return nil }
+
+func newSpan(s tracesdk.ReadOnlySpan) Span {
+	sc := s.SpanContext()
+	ret := Span{
+		TraceID:       sc.TraceID().String(),
+		SpanID:        sc.SpanID().String(),
+		Name:          s.Name(),
+		Kind:          s.SpanKind().String(),
+		Start:         s.StartTime(),
+		End:           s.EndTime(),
+		Duration:      s.EndTime().Sub(s.StartTime()),
+		Status:        s.Status().Code.String(),
+		StatusMessage: s.Status().Description,
+		Sampled:       sc.IsSampled(),
+	}
+	if p := s.Parent(); p.IsValid() {
+		ret.ParentSpanID = p.SpanID().String()
+	}
+	if attrs := s.Attributes(); len(attrs) > 0 {
+		ret.Attributes = make(map[string]interface{}, len(attrs))
+		for _, kv := range attrs {
+			ret.Attributes[string(kv.Key)] = kv.Value.AsInterface()
+		}
+	}
+	for _, e := range s.Events() {
+		ev := Event{Name: e.Name, Time: e.Time}
+		if len(e.Attributes) > 0 {
+			ev.Attributes = make(map[string]interface{}, len(e.Attributes))
+			for _, kv := range e.Attributes {
+				ev.Attributes[string(kv.Key)] = kv.Value.AsInterface()
+			}
+		}
+		ret.Events = append(ret.Events, ev)
+	}
+	return ret
+}
+
+// update sorts the spans and summarizes them, the name is of the earliest span, usually the root
+func (t *Trace) update() {
+	sort.SliceStable(t.Spans, func(a, b int) bool { return t.Spans[a].Start.Before(t.Spans[b].Start) })
+	t.Name = t.Spans[0].Name
+	t.Start = t.Spans[0].Start
+	t.SpanSize = len(t.Spans)
+	end := t.Start
+	t.Error = false
+	for _, s := range t.Spans {
+		if s.End.After(end) {
+			end = s.End
+		}
+		if s.Status == codes.Error.String() {
+			t.Error = true
+		}
+	}
+	t.Duration = end.Sub(t.Start)
+}
+
+// Record adds the request to the ring buffer
+func (i *Inspector) Record(r Request) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.conf.MaxRequests == 0 {
+		return
+	}
+	if len(i.requests) < i.conf.MaxRequests {
+		i.requests = append(i.requests, r)
+	} else {
+		i.requests[i.nextReq] = r
+	}
+	i.nextReq = (i.nextReq + 1) % i.conf.MaxRequests
+}
+
+// Requests returns the recorded requests matching f, the latest first
+func (i *Inspector) Requests(f Filter) []Request {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	ret := []Request{}
+	for n := 1; n <= len(i.requests); n++ {
+		r := i.requests[(i.nextReq-n+len(i.requests))%len(i.requests)]
+		if f.matchRequest(r) {
+			ret = append(ret, r)
+			if f.Limit > 0 && len(ret) >= f.Limit {
+				break
+			}
+		}
+	}
+	return ret
+}
+
+// Traces returns the summaries of the traces matching f, the latest first,
+// the request of a trace is looked up by trace ID to filter by route, status and CID
+func (i *Inspector) Traces(f Filter) []TraceSummary {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	byTrace := i.requestsByTrace()
+	ret := []TraceSummary{}
+	for _, t := range i.traces {
+		s := t.TraceSummary
+		s.Request = byTrace[s.TraceID]
+		if f.matchTrace(s) {
+			ret = append(ret, s)
+		}
+	}
+	sort.Slice(ret, func(a, b int) bool { return ret[a].Start.After(ret[b].Start) })
+	if f.Limit > 0 && len(ret) > f.Limit {
+		ret = ret[:f.Limit]
+	}
+	return ret
+}
+
+// Trace returns a copy of the trace of the hex trace ID
+func (i *Inspector) Trace(traceID string) (Trace, bool) {
+	id, err := trace.TraceIDFromHex(traceID)
+	if err != nil {
+		return Trace{}, false
+	}
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	t, ok := i.traces[id]
+	if !ok {
+		return Trace{}, false
+	}
+	ret := Trace{TraceSummary: t.TraceSummary, Spans: append([]Span{}, t.Spans...)}
+	ret.Request = i.requestsByTrace()[traceID]
+	return ret, true
+}
+
+func (i *Inspector) requestsByTrace() map[string]*Request {
+	ret := make(map[string]*Request, len(i.requests))
+	for n := range i.requests {
+		r := i.requests[n]
+		if r.TraceID != "" {
+			ret[r.TraceID] = &r
+		}
+	}
+	return ret
+}
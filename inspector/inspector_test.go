@@ -0,0 +1,134 @@
+package inspector
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/cake/golibs/intercom"
+	"go.opentelemetry.io/otel/codes"
+
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestInspector(t *testing.T) {
+	i := New(Config{MaxTraces: 2, MaxRequests: 2})
+	tracer := tracesdk.NewTracerProvider(tracesdk.WithSpanProcessor(i)).Tracer("inspector-test")
+
+	ids := []string{}
+	for n := 0; n < 3; n++ {
+		ctx, root := tracer.Start(context.Background(), "GET /v1/report/:name")
+		_, child := tracer.Start(ctx, "QueryAll db.report")
+		if n == 2 {
+			child.SetStatus(codes.Error, "timeout")
+		}
+		child.End()
+		root.End()
+		id := root.SpanContext().TraceID().String()
+		ids = append(ids, id)
+		i.Record(Request{Time: time.Now(), Route: "/v1/report/:name", Status: 200 + 300*(n/2), Duration: time.Duration(n) * time.Second, CID: "c" + id[:4], TraceID: id})
+	}
+
+	if _, ok := i.Trace(ids[0]); ok {
+		t.Error("the oldest trace should be evicted")
+	}
+	tr, ok := i.Trace(ids[2])
+	if !ok || tr.SpanSize != 2 || !tr.Error || tr.Name != "GET /v1/report/:name" || tr.Request == nil {
+		t.Fatalf("unexpected trace: %+v", tr)
+	}
+	w := newWaterfall(tr)
+	if len(w.Rows) != 2 || w.Rows[1].Indent == 0 || !w.Rows[1].Error {
+		t.Errorf("child should be indented under the root: %+v", w.Rows)
+	}
+
+	if reqs := i.Requests(Filter{}); len(reqs) != 2 || reqs[0].TraceID != ids[2] {
+		t.Errorf("the latest 2 requests should be kept, latest first: %+v", reqs)
+	}
+	for _, c := range []struct {
+		query string
+		want  int
+	}{
+		{"status=5xx", 1},
+		{"status=200", 1},
+		{"route=/v1/report/*", 2},
+		{"route=/v1/metric", 0},
+		{"cid=c" + ids[1][:4], 1},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/?"+c.query, nil)
+		f, err := ParseFilter(req.URL.Query())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(i.Traces(f)); got != c.want {
+			t.Errorf("%s: got %d traces, want %d", c.query, got, c.want)
+		}
+	}
+	if reqs := i.Requests(Filter{MinDuration: 1500 * time.Millisecond}); len(reqs) != 1 {
+		t.Errorf("only the slow request should match: %+v", reqs)
+	}
+	if _, err := ParseFilter(map[string][]string{ParamStatus: {"6xx"}}); err == nil {
+		t.Error("invalid status should be rejected")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	i := Init(Config{Enabled: true})
+	defer Init(Config{})
+	tracer := tracesdk.NewTracerProvider(tracesdk.WithSpanProcessor(i)).Tracer("inspector-test")
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		ctx, span := tracer.Start(c.Request.Context(), c.FullPath())
+		intercom.GetContextFromGin(c).SetSpan(span)
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+		span.End()
+	}, Middleware())
+	router.GET("/v1/report/:name", func(c *gin.Context) { c.Status(http.StatusNotFound) })
+	router.GET("/v1/panic", func(c *gin.Context) { panic("boom") })
+	AddInspectorEndpoint(router.Group(""))
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/report/a", nil))
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/inspector/requests?status=4xx", nil))
+	var resp struct {
+		Result []Request `json:"result"`
+	}
+	err := json.Unmarshal(rec.Body.Bytes(), &resp)
+	if reqs := resp.Result; err != nil || len(reqs) != 1 || reqs[0].TraceID == "" {
+		t.Fatalf("unexpected requests %s: %v", rec.Body, err)
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/debug/inspector/traces/"+resp.Result[0].TraceID, nil)
+	req.Header.Set("Accept", "text/html")
+	router.ServeHTTP(rec, req)
+	if !strings.Contains(rec.Header().Get("Content-Type"), "text/html") || !strings.Contains(rec.Body.String(), "/v1/report/:name") {
+		t.Errorf("unexpected waterfall %s", rec.Body)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("the panic should be passed on")
+			}
+		}()
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/panic", nil))
+	}()
+	if reqs := i.Requests(Filter{Status: "5xx"}); len(reqs) != 1 || reqs[0].Route != "/v1/panic" {
+		t.Errorf("the panicking request should be recorded as a 500: %+v", reqs)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/inspector/traces?min_duration=x", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid filter should be rejected, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,30 @@
+// Package document converts the documents written by the entity and versioned stores
+package document
+
+import (
+	"gitlab.com/cake/go-project-template/gpt"
+	"gitlab.com/cake/gopkg"
+	"gitlab.com/cake/mgopool/v3"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ToM returns doc as a bson.M the caller can modify, a bson.M is copied and
+// any other value is marshaled with its bson tags
+func ToM(doc interface{}) (bson.M, gopkg.CodeError) {
+	if m, ok := doc.(bson.M); ok {
+		ret := bson.M{}
+		for k, v := range m {
+			ret[k] = v
+		}
+		return ret, nil
+	}
+	data, errMarshal := mgopool.Marshal(doc)
+	if errMarshal != nil {
+		return nil, gopkg.NewCodeError(gpt.CodeBadRequest, errMarshal.Error())
+	}
+	m := bson.M{}
+	if errUnmarshal := mgopool.Unmarshal(data, &m); errUnmarshal != nil {
+		return nil, gopkg.NewCodeError(gpt.CodeBadRequest, errUnmarshal.Error())
+	}
+	return m, nil
+}
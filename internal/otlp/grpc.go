@@ -0,0 +1,141 @@
+package otlp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// Unary Export methods of the OTLP/gRPC collector services
+const (
+	MetricsExportMethod = "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"
+	TraceExportMethod   = "/opentelemetry.proto.collector.trace.v1.TraceService/Export"
+
+	grpcStatusOK = "0"
+)
+
+// GRPCClient calls the Export methods over HTTP/2 with the gRPC framing, the endpoint is host:port
+// or a URL whose http scheme means cleartext HTTP/2 and https means TLS
+type GRPCClient struct {
+	base      string
+	headers   map[string]string
+	gzip      bool
+	transport *http2.Transport
+	client    *http.Client
+}
+
+// NewGRPCClient validates the endpoint, timeout limits the dial and the whole call and 0 means no limit
+func NewGRPCClient(endpoint string, headers map[string]string, gzip bool, timeout time.Duration) (*GRPCClient, error) {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid otlp grpc endpoint: %s", endpoint)
+	}
+
+	tr := &http2.Transport{}
+	if u.Scheme == "http" {
+		dialer := &net.Dialer{Timeout: timeout}
+		tr.AllowHTTP = true
+		tr.DialTLS = func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dialer.Dial(network, addr)
+		}
+	}
+	return &GRPCClient{
+		base:      u.Scheme + "://" + u.Host,
+		headers:   headers,
+		gzip:      gzip,
+		transport: tr,
+		client:    &http.Client{Transport: tr, Timeout: timeout},
+	}, nil
+}
+
+// Export sends the encoded request message, a non OK grpc-status is returned as error
+func (c *GRPCClient) Export(ctx context.Context, method string, msg []byte) error {
+	var flag byte
+	if c.gzip {
+		buf := &bytes.Buffer{}
+		zw := gzip.NewWriter(buf)
+		if _, err := zw.Write(msg); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		msg, flag = buf.Bytes(), 1
+	}
+	// length-prefixed message: compressed flag and big endian length
+	body := make([]byte, 5, 5+len(msg))
+	body[0] = flag
+	binary.BigEndian.PutUint32(body[1:], uint32(len(msg)))
+	body = append(body, msg...)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.base+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	if c.gzip {
+		req.Header.Set("Grpc-Encoding", "gzip")
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set("Grpc-Timeout", grpcTimeout(time.Until(deadline)))
+	}
+	for k, v := range c.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// the trailers are only read after the body
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("otlp grpc endpoint responded %d", resp.StatusCode)
+	}
+
+	status, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		// trailers-only response
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if status != grpcStatusOK {
+		if m, errUnescape := url.PathUnescape(message); errUnescape == nil {
+			message = m
+		}
+		return fmt.Errorf("otlp grpc endpoint responded status %s: %s", status, message)
+	}
+	return nil
+}
+
+func (c *GRPCClient) Close() {
+	c.transport.CloseIdleConnections()
+}
+
+// grpcTimeout formats d in milliseconds, or seconds beyond the 8 digits limit
+func grpcTimeout(d time.Duration) string {
+	ms := d.Milliseconds()
+	if ms <= 0 {
+		ms = 1
+	}
+	if ms > 99999999 {
+		return strconv.FormatInt(ms/1000, 10) + "S"
+	}
+	return strconv.FormatInt(ms, 10) + "m"
+}
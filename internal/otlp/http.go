@@ -0,0 +1,65 @@
+package otlp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// HTTPClient posts OTLP/HTTP requests with JSON encoding
+type HTTPClient struct {
+	Endpoint string
+	Headers  map[string]string
+	Gzip     bool
+	Client   *http.Client
+}
+
+// Post encodes and sends req, non 2xx responses are returned as error
+func (c *HTTPClient) Post(ctx context.Context, req interface{}) error {
+	body := &bytes.Buffer{}
+	var w io.Writer = body
+	var zw *gzip.Writer
+	if c.Gzip {
+		zw = gzip.NewWriter(body)
+		w = zw
+	}
+	if err := json.NewEncoder(w).Encode(req); err != nil {
+		return err
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return err
+		}
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Endpoint, body)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.Gzip {
+		httpReq.Header.Set("Content-Encoding", "gzip")
+	}
+	for k, v := range c.Headers {
+		httpReq.Header.Set(k, v)
+	}
+	resp, err := c.Client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("otlp endpoint responded %d: %s", resp.StatusCode, msg)
+	}
+	return nil
+}
+
+func (c *HTTPClient) Close() {
+	c.Client.CloseIdleConnections()
+}
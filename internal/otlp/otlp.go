@@ -0,0 +1,80 @@
+// Package otlp holds the OTLP encoding and the transports shared by the metric and trace exporters,
+// the model follows the JSON mapping of opentelemetry-proto,
+// see https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/common/v1/common.proto
+package otlp
+
+import (
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+
+	m800schema "gitlab.com/cake/golibs/intercom/schemas/v1.0.0"
+)
+
+type Resource struct {
+	Attributes []KeyValue `json:"attributes"`
+}
+
+// Scope is the instrumentation scope, formerly instrumentation library
+type Scope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type KeyValue struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+// AnyValue has one of the values set, 64 bits integers are strings in JSON
+type AnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// NewResource returns the same resource attributes as m800trace.InitTracer
+func NewResource(serviceName, namespace string) *resource.Resource {
+	return resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceNameKey.String(serviceName),
+		m800schema.M800NamespaceKey.String(namespace),
+	)
+}
+
+// Attributes converts the attributes, slices are emitted as strings
+func Attributes(kvs []attribute.KeyValue) []KeyValue {
+	ret := make([]KeyValue, 0, len(kvs))
+	for _, kv := range kvs {
+		v := AnyValue{}
+		switch kv.Value.Type() {
+		case attribute.BOOL:
+			b := kv.Value.AsBool()
+			v.BoolValue = &b
+		case attribute.INT64:
+			i := strconv.FormatInt(kv.Value.AsInt64(), 10)
+			v.IntValue = &i
+		case attribute.FLOAT64:
+			f := kv.Value.AsFloat64()
+			v.DoubleValue = &f
+		default:
+			s := kv.Value.Emit()
+			v.StringValue = &s
+		}
+		ret = append(ret, KeyValue{Key: string(kv.Key), Value: v})
+	}
+	return ret
+}
+
+// StringKeyValue returns the string attribute of key
+func StringKeyValue(key, value string) KeyValue {
+	return KeyValue{Key: key, Value: AnyValue{StringValue: &value}}
+}
+
+func UnixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
@@ -0,0 +1,114 @@
+// Package otlptest serves the OTLP/gRPC Export methods in the tests of the exporters
+package otlptest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Request is one Export call received by the server
+type Request struct {
+	Method string
+	Header http.Header
+	// Message is the protobuf request message, decompressed
+	Message []byte
+}
+
+// GRPCServer answers every call over cleartext HTTP/2 with Status
+type GRPCServer struct {
+	Endpoint string
+	// Status is the grpc-status of the responses, 0 by default
+	Status string
+
+	listener net.Listener
+	mu       sync.Mutex
+	requests []Request
+}
+
+// NewGRPCServer listens on a local port until the test ends
+func NewGRPCServer(t *testing.T) *GRPCServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &GRPCServer{Endpoint: "http://" + l.Addr().String(), Status: "0", listener: l}
+	h2 := &http2.Server{}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go h2.ServeConn(conn, &http2.ServeConnOpts{Handler: http.HandlerFunc(s.serve)})
+		}
+	}()
+	t.Cleanup(func() { _ = l.Close() })
+	return s
+}
+
+// Requests returns the received calls in order
+func (s *GRPCServer) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request{}, s.requests...)
+}
+
+func (s *GRPCServer) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	if r.Header.Get("Content-Type") != "application/grpc" || len(body) < 5 || int(binary.BigEndian.Uint32(body[1:5])) != len(body)-5 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	msg := body[5:]
+	if body[0] == 1 {
+		zr, err := gzip.NewReader(bytes.NewReader(msg))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		msg, _ = ioutil.ReadAll(zr)
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Method: r.URL.Path, Header: r.Header.Clone(), Message: msg})
+	status := s.Status
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.WriteHeader(http.StatusOK)
+	// an empty ExportServiceResponse
+	_, _ = w.Write([]byte{0, 0, 0, 0, 0})
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", status)
+	if status != "0" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", "export%20failed")
+	}
+}
+
+// Fields returns the length-delimited values of the field num of the message b
+func Fields(b []byte, num protowire.Number) ([][]byte, error) {
+	var ret [][]byte
+	for len(b) > 0 {
+		n, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			return nil, protowire.ParseError(l)
+		}
+		m := protowire.ConsumeFieldValue(n, typ, b[l:])
+		if m < 0 {
+			return nil, protowire.ParseError(m)
+		}
+		if n == num && typ == protowire.BytesType {
+			v, _ := protowire.ConsumeBytes(b[l:])
+			ret = append(ret, v)
+		}
+		b = b[l+m:]
+	}
+	return ret, nil
+}
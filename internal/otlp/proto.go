@@ -0,0 +1,146 @@
+package otlp
+
+import (
+	"encoding/hex"
+	"math"
+	"strconv"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Protobuf encoding of the model for OTLP/gRPC, the field numbers are the ones of opentelemetry-proto.
+// The 64 bits integers and the IDs, which the JSON mapping keeps as decimal and hex strings, are converted back.
+
+// AppendMessage appends the embedded message built by f, an empty message is still written
+func AppendMessage(b []byte, num protowire.Number, f func(b []byte) []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, f(nil))
+}
+
+// AppendString omits the empty string
+func AppendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+// AppendBool omits false
+func AppendBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, 1)
+}
+
+// AppendVarint omits zero, it also encodes the enums and the unsigned 32 bits counts
+func AppendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// AppendDouble always writes v, the doubles of OTLP are either oneof or optional fields
+func AppendDouble(b []byte, num protowire.Number, v float64) []byte {
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+// AppendFixed64 writes the decimal string of a fixed64 field, the empty string is omitted
+func AppendFixed64(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, parseUint(v))
+}
+
+// AppendPackedFixed64 writes the decimal strings of a repeated fixed64 field
+func AppendPackedFixed64(b []byte, num protowire.Number, vs []string) []byte {
+	if len(vs) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	b = protowire.AppendVarint(b, uint64(8*len(vs)))
+	for _, v := range vs {
+		b = protowire.AppendFixed64(b, parseUint(v))
+	}
+	return b
+}
+
+// AppendPackedDouble writes a repeated double field
+func AppendPackedDouble(b []byte, num protowire.Number, vs []float64) []byte {
+	if len(vs) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	b = protowire.AppendVarint(b, uint64(8*len(vs)))
+	for _, v := range vs {
+		b = protowire.AppendFixed64(b, math.Float64bits(v))
+	}
+	return b
+}
+
+// AppendID writes the hex trace or span ID as bytes, the empty string is omitted
+func AppendID(b []byte, num protowire.Number, id string) []byte {
+	if id == "" {
+		return b
+	}
+	raw, _ := hex.DecodeString(id)
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, raw)
+}
+
+// AppendKeyValues writes the repeated KeyValue field
+func AppendKeyValues(b []byte, num protowire.Number, kvs []KeyValue) []byte {
+	for _, kv := range kvs {
+		kv := kv
+		b = AppendMessage(b, num, func(b []byte) []byte {
+			b = AppendString(b, 1, kv.Key)
+			return AppendMessage(b, 2, kv.Value.appendProto)
+		})
+	}
+	return b
+}
+
+func (v AnyValue) appendProto(b []byte) []byte {
+	switch {
+	case v.StringValue != nil:
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		return protowire.AppendString(b, *v.StringValue)
+	case v.BoolValue != nil:
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		return protowire.AppendVarint(b, protowire.EncodeBool(*v.BoolValue))
+	case v.IntValue != nil:
+		i, _ := strconv.ParseInt(*v.IntValue, 10, 64)
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		return protowire.AppendVarint(b, uint64(i))
+	case v.DoubleValue != nil:
+		return AppendDouble(b, 4, *v.DoubleValue)
+	}
+	return b
+}
+
+// AppendResource writes the Resource message
+func AppendResource(b []byte, num protowire.Number, r Resource) []byte {
+	return AppendMessage(b, num, func(b []byte) []byte {
+		return AppendKeyValues(b, 1, r.Attributes)
+	})
+}
+
+// AppendScope writes the InstrumentationScope message
+func AppendScope(b []byte, num protowire.Number, s Scope) []byte {
+	return AppendMessage(b, num, func(b []byte) []byte {
+		b = AppendString(b, 1, s.Name)
+		return AppendString(b, 2, s.Version)
+	})
+}
+
+func parseUint(s string) uint64 {
+	v, _ := strconv.ParseUint(s, 10, 64)
+	return v
+}
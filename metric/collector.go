@@ -0,0 +1,92 @@
+package metric
+
+import (
+	"github.com/povilasv/prommod"
+	"github.com/prometheus/client_golang/prometheus"
+	"gitlab.com/cake/gopkg"
+)
+
+const (
+	buildInfoName      = "build_info"
+	defaultModFilePath = "./go.mod"
+)
+
+// CollectorConfig is the [metric.collectors] section, every collector is enabled unless set to false
+type CollectorConfig struct {
+	// BuildInfo is the version, commit, branch and build date of gopkg.GetVersion
+	BuildInfo *bool `mapstructure:"build_info"`
+	// ModFile reads the dependency versions of go.mod at ModFilePath, defaults to ./go.mod
+	ModFile     *bool  `mapstructure:"mod_file"`
+	ModFilePath string `mapstructure:"mod_file_path"`
+	// Modules are the dependency versions embedded in the binary
+	Modules   *bool `mapstructure:"modules"`
+	GoRuntime *bool `mapstructure:"go_runtime"`
+	Process   *bool `mapstructure:"process"`
+}
+
+func enabled(b *bool) bool {
+	return b == nil || *b
+}
+
+// globalCollectors are registered to the global registry by the init of client_golang and gopkg,
+// they are moved to the dedicated registry so each of them can be turned off
+func globalCollectors() []prometheus.Collector {
+	app := gopkg.GetAppName()
+	return []prometheus.Collector{
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		gopkg.NewBuildInfoCollector(),
+		prommod.NewCollector(app),
+		gopkg.NewModFileCollector(app, defaultModFilePath),
+	}
+}
+
+// NewBuildInfoCollector exports the constant 1 labeled by gopkg.GetVersion, e.g. go_project_template_build_info
+func NewBuildInfoCollector(namespace string) prometheus.Collector {
+	v := gopkg.GetVersion()
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      buildInfoName,
+		Help:      "A metric with a constant '1' value labeled by version, revision, branch, build date and Go version.",
+	}, []string{"version", "revision", "branch", "build_date", "goversion", "goos", "goarch"})
+	gauge.WithLabelValues(v.Version, v.GitCommit, v.GitBranch, v.BuildDate, v.GoVer, v.GoOs, v.GoArch).Set(1)
+	return gauge
+}
+
+// collectors returns the enabled collectors of conf
+func (conf CollectorConfig) collectors(namespace string) []prometheus.Collector {
+	ret := []prometheus.Collector{}
+	if enabled(conf.BuildInfo) {
+		ret = append(ret, NewBuildInfoCollector(namespace))
+	}
+	if enabled(conf.ModFile) {
+		path := conf.ModFilePath
+		if path == "" {
+			path = defaultModFilePath
+		}
+		ret = append(ret, gopkg.NewModFileCollector(gopkg.GetAppName(), path))
+	}
+	if enabled(conf.Modules) {
+		ret = append(ret, prommod.NewCollector(gopkg.GetAppName()))
+	}
+	if enabled(conf.GoRuntime) {
+		ret = append(ret, prometheus.NewGoCollector())
+	}
+	if enabled(conf.Process) {
+		ret = append(ret, prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	}
+	return ret
+}
+
+// registerCollectors moves the runtime, process and build collectors from the global registry to r
+func (r *Registry) registerCollectors(conf CollectorConfig) error {
+	for _, c := range globalCollectors() {
+		prometheus.Unregister(c)
+	}
+	for _, c := range conf.collectors(r.namespace) {
+		if err := r.reg.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
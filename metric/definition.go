@@ -0,0 +1,219 @@
+package metric
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+	"gitlab.com/cake/gopkg"
+)
+
+// Please check naming best practice in official document: https://prometheus.io/docs/practices/naming/
+
+// Metric types, a definition with labels is registered as the vector of the type
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+	TypeSummary   = "summary"
+)
+
+var (
+	// 2 4 6 8 10
+	DefaultBucket = prometheus.LinearBuckets(2, 2, 5)
+
+	DefaultObjectives = map[float64]float64{0.5: 0.05, 0.8: 0.05}
+
+	invalidNameChar = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+// Definition declares one metric
+type Definition struct {
+	Type   string   `mapstructure:"type"`
+	Name   string   `mapstructure:"name"`
+	Help   string   `mapstructure:"help"`
+	Labels []string `mapstructure:"labels"`
+	// ConstLabels keys are lower-cased by viper
+	ConstLabels map[string]string `mapstructure:"const_labels"`
+	// Buckets of histogram, defaults to DefaultBucket
+	Buckets []float64 `mapstructure:"buckets"`
+	// Objectives of summary keyed by quantile string, e.g. "0.5" = 0.05, defaults to DefaultObjectives
+	Objectives map[string]float64 `mapstructure:"objectives"`
+	// MaxAge of summary, defaults to prometheus.DefMaxAge
+	MaxAge time.Duration `mapstructure:"max_age"`
+
+	// MaxSeries and Allow guard the label cardinality of a vector, see GuardOpts
+	MaxSeries int                 `mapstructure:"max_series"`
+	Allow     map[string][]string `mapstructure:"allow"`
+	// Preregister creates all combinations of the allowlists at registration
+	Preregister bool `mapstructure:"preregister"`
+}
+
+// Config is the [metric] section
+type Config struct {
+	// Namespace defaults to the app name, Subsystem is optional
+	Namespace string `mapstructure:"namespace"`
+	Subsystem string `mapstructure:"subsystem"`
+	// File is an optional TOML or YAML file with more definitions
+	File        string       `mapstructure:"file"`
+	Definitions []Definition `mapstructure:"definitions"`
+	// ExemplarSampleRate is the ratio of histogram observations carrying the trace ID, defaults to 1
+	ExemplarSampleRate *float64 `mapstructure:"exemplar_sample_rate"`
+	// Collectors toggles the runtime, process and build info collectors
+	Collectors CollectorConfig `mapstructure:"collectors"`
+}
+
+// LoadConfig reads the [metric] section and the definitions of the referenced file
+func LoadConfig() (conf Config, err error) {
+	if err = viper.UnmarshalKey("metric", &conf); err != nil {
+		return
+	}
+	if conf.File != "" {
+		var defs []Definition
+		if defs, err = LoadFile(conf.File); err != nil {
+			return
+		}
+		conf.Definitions = append(conf.Definitions, defs...)
+	}
+	return
+}
+
+// LoadFile reads the top level "definitions" list of a TOML or YAML file
+func LoadFile(path string) ([]Definition, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml", ".yaml", ".yml":
+	default:
+		return nil, fmt.Errorf("unsupported metric definition file: %s", path)
+	}
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	var defs []Definition
+	if err := v.UnmarshalKey("definitions", &defs); err != nil {
+		return nil, err
+	}
+	return defs, nil
+}
+
+// AppNamespace converts the app name into a valid metric namespace, e.g. go-project-template to go_project_template
+func AppNamespace() string {
+	ns := invalidNameChar.ReplaceAllString(gopkg.GetAppName(), "_")
+	if ns != "" && ns[0] >= '0' && ns[0] <= '9' {
+		ns = "_" + ns
+	}
+	return ns
+}
+
+// HasLabel reports whether name is one of the declared labels
+func (d Definition) HasLabel(name string) bool {
+	for _, l := range d.Labels {
+		if l == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (d Definition) validate() error {
+	if d.Name == "" {
+		return fmt.Errorf("metric name is required")
+	}
+	switch d.Type {
+	case TypeCounter, TypeGauge, TypeHistogram, TypeSummary:
+	default:
+		return fmt.Errorf("metric %s: unknown type %s", d.Name, d.Type)
+	}
+	if len(d.Buckets) > 0 && d.Type != TypeHistogram {
+		return fmt.Errorf("metric %s: buckets only apply to histogram", d.Name)
+	}
+	if len(d.Objectives) > 0 && d.Type != TypeSummary {
+		return fmt.Errorf("metric %s: objectives only apply to summary", d.Name)
+	}
+	if (d.MaxSeries > 0 || len(d.Allow) > 0 || d.Preregister) && len(d.Labels) == 0 {
+		return fmt.Errorf("metric %s: cardinality guard requires labels", d.Name)
+	}
+	if d.Preregister && len(d.Allow) != len(d.Labels) {
+		return fmt.Errorf("metric %s: preregister requires allowlists of all labels", d.Name)
+	}
+	// the cases below panic in client_golang instead of returning error
+	for i := 1; i < len(d.Buckets); i++ {
+		if d.Buckets[i] <= d.Buckets[i-1] {
+			return fmt.Errorf("metric %s: buckets must be in increasing order", d.Name)
+		}
+	}
+	for _, l := range d.Labels {
+		if (l == "le" && d.Type == TypeHistogram) || (l == "quantile" && d.Type == TypeSummary) {
+			return fmt.Errorf("metric %s: label %s is reserved", d.Name, l)
+		}
+	}
+	return nil
+}
+
+func (d Definition) objectives() (map[float64]float64, error) {
+	if len(d.Objectives) == 0 {
+		return DefaultObjectives, nil
+	}
+	ret := make(map[float64]float64, len(d.Objectives))
+	for k, v := range d.Objectives {
+		q, err := strconv.ParseFloat(k, 64)
+		if err != nil || q <= 0 || q >= 1 {
+			return nil, fmt.Errorf("metric %s: invalid quantile %s", d.Name, k)
+		}
+		ret[q] = v
+	}
+	return ret, nil
+}
+
+// collector creates the metric without registering it
+func (d Definition) collector(namespace, subsystem string) (prometheus.Collector, error) {
+	if err := d.validate(); err != nil {
+		return nil, err
+	}
+	help := d.Help
+	if help == "" {
+		help = d.Name + "."
+	}
+	labels := prometheus.Labels(d.ConstLabels)
+
+	switch d.Type {
+	case TypeCounter:
+		opts := prometheus.CounterOpts{Namespace: namespace, Subsystem: subsystem, Name: d.Name, Help: help, ConstLabels: labels}
+		if len(d.Labels) > 0 {
+			return prometheus.NewCounterVec(opts, d.Labels), nil
+		}
+		return prometheus.NewCounter(opts), nil
+	case TypeGauge:
+		opts := prometheus.GaugeOpts{Namespace: namespace, Subsystem: subsystem, Name: d.Name, Help: help, ConstLabels: labels}
+		if len(d.Labels) > 0 {
+			return prometheus.NewGaugeVec(opts, d.Labels), nil
+		}
+		return prometheus.NewGauge(opts), nil
+	case TypeHistogram:
+		buckets := d.Buckets
+		if len(buckets) == 0 {
+			buckets = DefaultBucket
+		}
+		opts := prometheus.HistogramOpts{Namespace: namespace, Subsystem: subsystem, Name: d.Name, Help: help, ConstLabels: labels, Buckets: buckets}
+		if len(d.Labels) > 0 {
+			return prometheus.NewHistogramVec(opts, d.Labels), nil
+		}
+		return prometheus.NewHistogram(opts), nil
+	default:
+		objectives, err := d.objectives()
+		if err != nil {
+			return nil, err
+		}
+		opts := prometheus.SummaryOpts{Namespace: namespace, Subsystem: subsystem, Name: d.Name, Help: help, ConstLabels: labels, Objectives: objectives, MaxAge: d.MaxAge}
+		if len(d.Labels) > 0 {
+			return prometheus.NewSummaryVec(opts, d.Labels), nil
+		}
+		return prometheus.NewSummary(opts), nil
+	}
+}
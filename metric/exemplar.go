@@ -0,0 +1,101 @@
+package metric
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"gitlab.com/cake/goctx"
+	"go.opentelemetry.io/otel/trace"
+
+	ginmetric "gitlab.com/cake/golibs/metric"
+)
+
+// exemplarRate holds the float64 bits of the sample rate, 1 by default
+var exemplarRate = math.Float64bits(1)
+
+// SetExemplarSampleRate sets the ratio of observations carrying the trace ID, clamped to [0, 1]
+func SetExemplarSampleRate(rate float64) {
+	rate = math.Max(0, math.Min(1, rate))
+	atomic.StoreUint64(&exemplarRate, math.Float64bits(rate))
+}
+
+// ExemplarSampleRate returns the current sample rate
+func ExemplarSampleRate() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&exemplarRate))
+}
+
+// exemplarLabels returns the trace ID label of a sampled span, the label name is shared with golibs/metric
+func exemplarLabels(sc trace.SpanContext) (prometheus.Labels, bool) {
+	if !sc.IsValid() || !sc.IsSampled() {
+		return nil, false
+	}
+	rate := ExemplarSampleRate()
+	if rate <= 0 || (rate < 1 && rand.Float64() >= rate) {
+		return nil, false
+	}
+	return prometheus.Labels{ginmetric.KeyTraceID: sc.TraceID().String()}, true
+}
+
+// Observe records v and attaches the trace ID of ctx as exemplar when the observer supports it
+func Observe(ctx goctx.Context, o prometheus.Observer, v float64) {
+	var sc trace.SpanContext
+	if ctx != nil {
+		if span := ctx.GetSpan(); span != nil {
+			sc = span.SpanContext()
+		}
+	}
+	observe(o, v, sc)
+}
+
+func observe(o prometheus.Observer, v float64, sc trace.SpanContext) {
+	if eo, ok := o.(prometheus.ExemplarObserver); ok {
+		if labels, ok := exemplarLabels(sc); ok {
+			eo.ObserveWithExemplar(v, labels)
+			return
+		}
+	}
+	o.Observe(v)
+}
+
+// HistogramHandleFunc records the request metrics of golibs/metric.HistogramMetrics like
+// golibs/metric.HistogramHandleFunc, the duration carries the trace ID as exemplar by the sample rate
+func HistogramHandleFunc() func(p *ginmetric.GinPrometheus) {
+	return func(p *ginmetric.GinPrometheus) {
+		p.SetHandlerFunc(func(c *gin.Context) {
+			if c.Request.URL.String() == p.MetricsPath {
+				c.Next()
+				return
+			}
+			start := time.Now()
+			sc := trace.SpanContextFromContext(c.Request.Context())
+			reqSz := ginmetric.ComputeApproximateRequestSize(c.Request)
+
+			c.Next()
+
+			dur := time.Since(start).Seconds()
+			status, method, handler := statusClass(c.Writer.Status()), c.Request.Method, c.HandlerName()
+			observe(p.HistogramVec[ginmetric.KeyReqDur].WithLabelValues(status, method, handler), dur, sc)
+			p.Summary[ginmetric.KeyReqDurAll].Observe(dur)
+			p.CntVec[ginmetric.KeyReqCnt].WithLabelValues(status, method, handler).Inc()
+			p.HistogramVec[ginmetric.KeyReqSz].WithLabelValues(status, method, handler).Observe(float64(reqSz))
+			p.HistogramVec[ginmetric.KeyResSz].WithLabelValues(status, method, handler).Observe(float64(c.Writer.Size()))
+		})
+	}
+}
+
+// statusClass is the code label of golibs/metric, the class of the status or the informational status itself
+func statusClass(code int) string {
+	switch {
+	case code >= http.StatusInternalServerError:
+		return "5xx"
+	case code < http.StatusOK:
+		return strconv.Itoa(code)
+	}
+	return strconv.Itoa(code/100) + "xx"
+}
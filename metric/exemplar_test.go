@@ -0,0 +1,109 @@
+package metric
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+
+	dto "github.com/prometheus/client_model/go"
+	ginmetric "gitlab.com/cake/golibs/metric"
+)
+
+func TestExemplar(t *testing.T) {
+	defer SetExemplarSampleRate(ExemplarSampleRate())
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{1},
+		TraceFlags: trace.FlagsSampled,
+	})
+	exemplarTraceID := func(rate float64, sc trace.SpanContext) string {
+		SetExemplarSampleRate(rate)
+		h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "h", Buckets: []float64{1}})
+		observe(h, 0.5, sc)
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(h)
+		mfs, _ := reg.Gather()
+		for _, l := range mfs[0].GetMetric()[0].GetHistogram().GetBucket()[0].GetExemplar().GetLabel() {
+			if l.GetName() == ginmetric.KeyTraceID {
+				return l.GetValue()
+			}
+		}
+		return ""
+	}
+
+	if got := exemplarTraceID(1, sc); got != sc.TraceID().String() {
+		t.Errorf("exemplar trace id = %q", got)
+	}
+	if got := exemplarTraceID(0, sc); got != "" {
+		t.Errorf("rate 0 should not attach exemplar, got %q", got)
+	}
+	if got := exemplarTraceID(1, sc.WithTraceFlags(0)); got != "" {
+		t.Errorf("unsampled span should not attach exemplar, got %q", got)
+	}
+	if SetExemplarSampleRate(2); ExemplarSampleRate() != 1 {
+		t.Errorf("rate should be clamped, got %v", ExemplarSampleRate())
+	}
+}
+
+func TestHistogramHandleFunc(t *testing.T) {
+	defer SetExemplarSampleRate(ExemplarSampleRate())
+	gin.SetMode(gin.TestMode)
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{1},
+		TraceFlags: trace.FlagsSampled,
+	})
+	serve := func(rate float64) (*dto.Exemplar, bool) {
+		SetExemplarSampleRate(rate)
+		labels := []string{"code", "method", "handler"}
+		dur := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "dur", Buckets: []float64{10}}, labels)
+		cnt := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "cnt"}, labels)
+		p := &ginmetric.GinPrometheus{
+			CntVec: map[string]*prometheus.CounterVec{ginmetric.KeyReqCnt: cnt},
+			HistogramVec: map[string]*prometheus.HistogramVec{
+				ginmetric.KeyReqDur: dur,
+				ginmetric.KeyReqSz:  prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "req"}, labels),
+				ginmetric.KeyResSz:  prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "res"}, labels),
+			},
+			Summary: map[string]prometheus.Summary{ginmetric.KeyReqDurAll: prometheus.NewSummary(prometheus.SummaryOpts{Name: "all"})},
+		}
+		HistogramHandleFunc()(p)
+
+		var traced bool
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Request = c.Request.WithContext(trace.ContextWithSpanContext(c.Request.Context(), sc))
+		}, p.GetGinHandlerFunc())
+		router.GET("/", func(c *gin.Context) {
+			traced = trace.SpanContextFromContext(c.Request.Context()).Equal(sc)
+		})
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(dur, cnt)
+		mfs, _ := reg.Gather()
+		if m := mfs[0].GetMetric(); len(m) != 1 || m[0].GetLabel()[0].GetValue() != "2xx" || m[0].GetCounter().GetValue() != 1 {
+			t.Errorf("unexpected request counter %v", m)
+		}
+		mfs = mfs[1:]
+		for _, b := range mfs[0].GetMetric()[0].GetHistogram().GetBucket() {
+			if b.GetExemplar() != nil {
+				return b.GetExemplar(), traced
+			}
+		}
+		return nil, traced
+	}
+
+	if e, traced := serve(1); e == nil || !traced {
+		t.Errorf("rate 1 should attach the exemplar and keep the span, got %v %v", e, traced)
+	}
+	if e, traced := serve(0); e != nil || !traced {
+		t.Errorf("rate 0 should drop the exemplar and keep the span, got %v %v", e, traced)
+	}
+}
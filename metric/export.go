@@ -0,0 +1,239 @@
+package metric
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/spf13/viper"
+	"gitlab.com/cake/go-project-template/gpt"
+	"gitlab.com/cake/go-project-template/internal/otlp"
+	"gitlab.com/cake/goctx"
+	"gitlab.com/cake/gopkg"
+	"gitlab.com/cake/m800log"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// Exporter names of [metric.export]
+const (
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+
+	// ProtocolHTTPJSON is OTLP/HTTP with JSON encoding and ProtocolGRPC is OTLP/gRPC with protobuf encoding
+	ProtocolHTTPJSON = "http/json"
+	ProtocolGRPC     = "grpc"
+
+	defaultEndpoint     = "http://localhost:4318/v1/metrics"
+	defaultGRPCEndpoint = "http://localhost:4317"
+)
+
+// ExportConfig is the [metric.export] section
+type ExportConfig struct {
+	// Exporter is otlp, stdout or file, empty disables exporting
+	Exporter string            `mapstructure:"exporter"`
+	Protocol string            `mapstructure:"protocol"`
+	Endpoint string            `mapstructure:"endpoint"`
+	Headers  map[string]string `mapstructure:"headers"`
+	Interval time.Duration     `mapstructure:"interval"`
+	Timeout  time.Duration     `mapstructure:"timeout"`
+	// Path of the file exporter, one OTLP JSON request per line
+	Path string `mapstructure:"path"`
+}
+
+// LoadExportConfig reads [metric.export]
+func LoadExportConfig() (conf ExportConfig, err error) {
+	err = viper.UnmarshalKey("metric.export", &conf)
+	return
+}
+
+// exporter sends one encoded request
+type exporter interface {
+	export(ctx context.Context, req *otlpRequest) error
+	close() error
+}
+
+// PeriodicExporter gathers the prometheus registries on every interval and pushes them as OTLP metrics
+type PeriodicExporter struct {
+	exp      exporter
+	gatherer prometheus.Gatherer
+	res      *resource.Resource
+	interval time.Duration
+	timeout  time.Duration
+	start    time.Time
+
+	ctx    goctx.Context
+	stop   chan struct{}
+	wg     sync.WaitGroup
+	closed sync.Once
+}
+
+// NewResource returns the same resource attributes as m800trace.InitTracer
+func NewResource() *resource.Resource {
+	return otlp.NewResource(gopkg.GetAppName(), gpt.GetNamespace())
+}
+
+// Gatherer returns both the global and the default registries, the same as Handler serves
+func Gatherer() prometheus.Gatherer {
+	return prometheus.Gatherers{
+		prometheus.DefaultGatherer,
+		prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return Default().Gatherer().Gather() }),
+	}
+}
+
+// StartExporter starts exporting gatherer, nil exporter is returned when conf.Exporter is empty
+func StartExporter(conf ExportConfig, gatherer prometheus.Gatherer, res *resource.Resource) (*PeriodicExporter, error) {
+	var exp exporter
+	switch conf.Exporter {
+	case "":
+		return nil, nil
+	case ExporterOTLP:
+		switch conf.Protocol {
+		case "", ProtocolHTTPJSON:
+			endpoint := conf.Endpoint
+			if endpoint == "" {
+				endpoint = defaultEndpoint
+			}
+			exp = &httpExporter{&otlp.HTTPClient{Endpoint: endpoint, Headers: conf.Headers, Client: &http.Client{}}}
+		case ProtocolGRPC:
+			endpoint := conf.Endpoint
+			if endpoint == "" {
+				endpoint = defaultGRPCEndpoint
+			}
+			client, err := otlp.NewGRPCClient(endpoint, conf.Headers, false, 0)
+			if err != nil {
+				return nil, err
+			}
+			exp = &grpcExporter{client}
+		default:
+			return nil, fmt.Errorf("unknown otlp protocol: %s", conf.Protocol)
+		}
+	case ExporterStdout:
+		exp = &writerExporter{w: os.Stdout}
+	case ExporterFile:
+		if conf.Path == "" {
+			return nil, fmt.Errorf("path of metric file exporter is required")
+		}
+		f, err := os.OpenFile(conf.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		exp = &writerExporter{w: f, closer: f}
+	default:
+		return nil, fmt.Errorf("unknown metric exporter: %s", conf.Exporter)
+	}
+
+	p := &PeriodicExporter{
+		exp:      exp,
+		gatherer: gatherer,
+		res:      res,
+		interval: conf.Interval,
+		timeout:  conf.Timeout,
+		start:    time.Now(),
+		ctx:      goctx.Background(),
+		stop:     make(chan struct{}),
+	}
+	if p.interval <= 0 {
+		p.interval = time.Minute
+	}
+	if p.timeout <= 0 || p.timeout > p.interval {
+		p.timeout = p.interval
+	}
+	p.ctx.Set(goctx.LogKeyService, "metric-exporter")
+
+	p.wg.Add(1)
+	go p.loop()
+	return p, nil
+}
+
+func (p *PeriodicExporter) loop() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			if err := p.Export(context.Background()); err != nil {
+				m800log.Errorf(p.ctx, "[metric export] export failed: %v", err)
+			}
+		}
+	}
+}
+
+// Export gathers and exports once
+func (p *PeriodicExporter) Export(ctx context.Context) error {
+	mfs, err := p.gatherer.Gather()
+	if err != nil && len(mfs) == 0 {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	return p.exp.export(ctx, convert(mfs, p.res, p.start, time.Now()))
+}
+
+// Shutdown stops the loop and exports the last values
+func (p *PeriodicExporter) Shutdown(ctx context.Context) (err error) {
+	p.closed.Do(func() {
+		close(p.stop)
+		p.wg.Wait()
+		err = p.Export(ctx)
+		if errClose := p.exp.close(); err == nil {
+			err = errClose
+		}
+	})
+	return
+}
+
+type httpExporter struct {
+	client *otlp.HTTPClient
+}
+
+func (e *httpExporter) export(ctx context.Context, req *otlpRequest) error {
+	return e.client.Post(ctx, req)
+}
+
+func (e *httpExporter) close() error {
+	e.client.Close()
+	return nil
+}
+
+type grpcExporter struct {
+	client *otlp.GRPCClient
+}
+
+func (e *grpcExporter) export(ctx context.Context, req *otlpRequest) error {
+	return e.client.Export(ctx, otlp.MetricsExportMethod, req.marshalProto())
+}
+
+func (e *grpcExporter) close() error {
+	e.client.Close()
+	return nil
+}
+
+type writerExporter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+func (e *writerExporter) export(_ context.Context, req *otlpRequest) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return json.NewEncoder(e.w).Encode(req)
+}
+
+func (e *writerExporter) close() error {
+	if e.closer != nil {
+		return e.closer.Close()
+	}
+	return nil
+}
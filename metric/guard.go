@@ -0,0 +1,221 @@
+package metric
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// OtherValue replaces label values which are not allowed or exceed the series limit
+	OtherValue = "__other__"
+
+	OverflowReasonAllowlist = "allowlist"
+	OverflowReasonMaxSeries = "max_series"
+
+	overflowName = "metric_cardinality_overflow_total"
+)
+
+// GuardOpts limits the label values of a vector, zero value means unlimited
+type GuardOpts struct {
+	// MaxSeries is the max distinct label combinations, the rest fold into one all OtherValue series
+	MaxSeries int
+	// Allow maps the label name to the allowed values, other values fold into OtherValue
+	Allow map[string][]string
+}
+
+// Guard sanitizes the label values before they reach the vector
+type Guard struct {
+	metric   string
+	labels   []string
+	max      int
+	allow    []map[string]bool
+	overflow *prometheus.CounterVec
+
+	mu   sync.Mutex
+	seen map[string]struct{}
+}
+
+// newOverflowCounter is prefixed by the registry namespace like the collectors of Registry.Namespaced
+func newOverflowCounter(namespace string) *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      overflowName,
+		Help:      "Label values folded into " + OtherValue + " by the cardinality guard.",
+	}, []string{"metric", "reason"})
+}
+
+// NewGuard validates the opts against the labels, overflow counts the folded values and can be nil
+func NewGuard(metric string, labels []string, opts GuardOpts, overflow *prometheus.CounterVec) (*Guard, error) {
+	g := &Guard{
+		metric:   metric,
+		labels:   labels,
+		max:      opts.MaxSeries,
+		allow:    make([]map[string]bool, len(labels)),
+		overflow: overflow,
+		seen:     map[string]struct{}{},
+	}
+	for name, values := range opts.Allow {
+		idx := -1
+		for i, l := range labels {
+			if l == name {
+				idx = i
+			}
+		}
+		if idx < 0 {
+			return nil, fmt.Errorf("metric %s: allowlist of unknown label %s", metric, name)
+		}
+		g.allow[idx] = map[string]bool{OtherValue: true}
+		for _, v := range values {
+			g.allow[idx][v] = true
+		}
+	}
+	return g, nil
+}
+
+// Values returns the label values to use, values of wrong length are returned as is and the vector panics as usual
+func (g *Guard) Values(values ...string) []string {
+	if len(values) != len(g.labels) {
+		return values
+	}
+
+	// copy before folding, the caller still owns values
+	ret := values
+	copied := false
+	for i, allow := range g.allow {
+		if allow == nil || allow[values[i]] {
+			continue
+		}
+		if !copied {
+			ret, copied = append([]string(nil), values...), true
+		}
+		ret[i] = OtherValue
+		g.inc(OverflowReasonAllowlist)
+	}
+
+	if g.max <= 0 {
+		return ret
+	}
+	key := strings.Join(ret, "\xff")
+	g.mu.Lock()
+	_, ok := g.seen[key]
+	if !ok && len(g.seen) < g.max {
+		g.seen[key], ok = struct{}{}, true
+	}
+	g.mu.Unlock()
+	if ok {
+		return ret
+	}
+
+	g.inc(OverflowReasonMaxSeries)
+	ret = make([]string, len(values))
+	for i := range ret {
+		ret[i] = OtherValue
+	}
+	return ret
+}
+
+// Series returns the distinct label combinations admitted so far, only tracked with MaxSeries
+func (g *Guard) Series() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.seen)
+}
+
+func (g *Guard) inc(reason string) {
+	if g.overflow != nil {
+		g.overflow.WithLabelValues(g.metric, reason).Inc()
+	}
+}
+
+// product returns all combinations of the allowlists, nil if any label has no allowlist
+func (g *Guard) product() [][]string {
+	ret := [][]string{{}}
+	for _, allow := range g.allow {
+		if allow == nil {
+			return nil
+		}
+		next := [][]string{}
+		for _, combo := range ret {
+			for v := range allow {
+				if v == OtherValue {
+					continue
+				}
+				next = append(next, append(append([]string(nil), combo...), v))
+			}
+		}
+		ret = next
+	}
+	return ret
+}
+
+type guardedVec struct {
+	guard  *Guard
+	create func(values ...string)
+}
+
+// Preregister creates the series up front so they are exported as zero before the first observation
+func (v guardedVec) Preregister(combos ...[]string) {
+	for _, combo := range combos {
+		v.create(v.guard.Values(combo...)...)
+	}
+}
+
+// Guard returns the guard of the vector
+func (v guardedVec) Guard() *Guard {
+	return v.guard
+}
+
+type GuardedCounterVec struct {
+	guardedVec
+	vec *prometheus.CounterVec
+}
+
+func NewGuardedCounterVec(vec *prometheus.CounterVec, guard *Guard) *GuardedCounterVec {
+	return &GuardedCounterVec{guardedVec{guard, func(v ...string) { vec.WithLabelValues(v...) }}, vec}
+}
+
+func (v *GuardedCounterVec) WithLabelValues(values ...string) prometheus.Counter {
+	return v.vec.WithLabelValues(v.guard.Values(values...)...)
+}
+
+type GuardedGaugeVec struct {
+	guardedVec
+	vec *prometheus.GaugeVec
+}
+
+func NewGuardedGaugeVec(vec *prometheus.GaugeVec, guard *Guard) *GuardedGaugeVec {
+	return &GuardedGaugeVec{guardedVec{guard, func(v ...string) { vec.WithLabelValues(v...) }}, vec}
+}
+
+func (v *GuardedGaugeVec) WithLabelValues(values ...string) prometheus.Gauge {
+	return v.vec.WithLabelValues(v.guard.Values(values...)...)
+}
+
+type GuardedHistogramVec struct {
+	guardedVec
+	vec *prometheus.HistogramVec
+}
+
+func NewGuardedHistogramVec(vec *prometheus.HistogramVec, guard *Guard) *GuardedHistogramVec {
+	return &GuardedHistogramVec{guardedVec{guard, func(v ...string) { vec.WithLabelValues(v...) }}, vec}
+}
+
+func (v *GuardedHistogramVec) WithLabelValues(values ...string) prometheus.Observer {
+	return v.vec.WithLabelValues(v.guard.Values(values...)...)
+}
+
+type GuardedSummaryVec struct {
+	guardedVec
+	vec *prometheus.SummaryVec
+}
+
+func NewGuardedSummaryVec(vec *prometheus.SummaryVec, guard *Guard) *GuardedSummaryVec {
+	return &GuardedSummaryVec{guardedVec{guard, func(v ...string) { vec.WithLabelValues(v...) }}, vec}
+}
+
+func (v *GuardedSummaryVec) WithLabelValues(values ...string) prometheus.Observer {
+	return v.vec.WithLabelValues(v.guard.Values(values...)...)
+}
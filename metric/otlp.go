@@ -0,0 +1,281 @@
+package metric
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"gitlab.com/cake/go-project-template/internal/otlp"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// OTLP JSON encoding of ExportMetricsServiceRequest, also encoded as protobuf for gRPC,
+// see https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/metrics/v1/metrics.proto
+
+const (
+	scopeName = "gitlab.com/cake/go-project-template/metric"
+
+	temporalityCumulative = 2
+)
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlp.Resource      `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+	SchemaURL    string             `json:"schemaUrl,omitempty"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlp.Scope   `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpMetric struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Sum         *otlpSum       `json:"sum,omitempty"`
+	Gauge       *otlpGauge     `json:"gauge,omitempty"`
+	Histogram   *otlpHistogram `json:"histogram,omitempty"`
+	Summary     *otlpSummary   `json:"summary,omitempty"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpNumberPoint `json:"dataPoints"`
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberPoint `json:"dataPoints"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []otlpHistogramPoint `json:"dataPoints"`
+	AggregationTemporality int                  `json:"aggregationTemporality"`
+}
+
+type otlpSummary struct {
+	DataPoints []otlpSummaryPoint `json:"dataPoints"`
+}
+
+type otlpNumberPoint struct {
+	Attributes        []otlp.KeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsDouble          float64         `json:"asDouble"`
+}
+
+type otlpHistogramPoint struct {
+	Attributes        []otlp.KeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	Count             string          `json:"count"`
+	Sum               float64         `json:"sum"`
+	BucketCounts      []string        `json:"bucketCounts"`
+	ExplicitBounds    []float64       `json:"explicitBounds"`
+}
+
+type otlpSummaryPoint struct {
+	Attributes        []otlp.KeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	Count             string          `json:"count"`
+	Sum               float64         `json:"sum"`
+	QuantileValues    []otlpQuantile  `json:"quantileValues"`
+}
+
+type otlpQuantile struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+func labelAttributes(labels []*dto.LabelPair) []otlp.KeyValue {
+	ret := make([]otlp.KeyValue, 0, len(labels))
+	for _, l := range labels {
+		ret = append(ret, otlp.StringKeyValue(l.GetName(), l.GetValue()))
+	}
+	return ret
+}
+
+// convert maps the gathered prometheus families to OTLP with cumulative temporality since start,
+// NaN values are dropped because JSON can't encode them
+func convert(mfs []*dto.MetricFamily, res *resource.Resource, start, now time.Time) *otlpRequest {
+	startNano, nowNano := otlp.UnixNano(start), otlp.UnixNano(now)
+	metrics := []otlpMetric{}
+	for _, mf := range mfs {
+		m := otlpMetric{Name: mf.GetName(), Description: mf.GetHelp()}
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			m.Sum = &otlpSum{AggregationTemporality: temporalityCumulative, IsMonotonic: true}
+			for _, p := range mf.GetMetric() {
+				if v := p.GetCounter().GetValue(); !math.IsNaN(v) {
+					m.Sum.DataPoints = append(m.Sum.DataPoints, otlpNumberPoint{labelAttributes(p.GetLabel()), startNano, nowNano, v})
+				}
+			}
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			m.Gauge = &otlpGauge{}
+			for _, p := range mf.GetMetric() {
+				v := p.GetGauge().GetValue()
+				if mf.GetType() == dto.MetricType_UNTYPED {
+					v = p.GetUntyped().GetValue()
+				}
+				if !math.IsNaN(v) {
+					m.Gauge.DataPoints = append(m.Gauge.DataPoints, otlpNumberPoint{labelAttributes(p.GetLabel()), "", nowNano, v})
+				}
+			}
+		case dto.MetricType_HISTOGRAM:
+			m.Histogram = &otlpHistogram{AggregationTemporality: temporalityCumulative}
+			for _, p := range mf.GetMetric() {
+				m.Histogram.DataPoints = append(m.Histogram.DataPoints, histogramPoint(p, startNano, nowNano))
+			}
+		case dto.MetricType_SUMMARY:
+			m.Summary = &otlpSummary{}
+			for _, p := range mf.GetMetric() {
+				s := p.GetSummary()
+				point := otlpSummaryPoint{
+					Attributes:        labelAttributes(p.GetLabel()),
+					StartTimeUnixNano: startNano,
+					TimeUnixNano:      nowNano,
+					Count:             strconv.FormatUint(s.GetSampleCount(), 10),
+					Sum:               s.GetSampleSum(),
+					QuantileValues:    []otlpQuantile{},
+				}
+				for _, q := range s.GetQuantile() {
+					if !math.IsNaN(q.GetValue()) {
+						point.QuantileValues = append(point.QuantileValues, otlpQuantile{q.GetQuantile(), q.GetValue()})
+					}
+				}
+				m.Summary.DataPoints = append(m.Summary.DataPoints, point)
+			}
+		default:
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+
+	return &otlpRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource:     otlp.Resource{Attributes: otlp.Attributes(res.Attributes())},
+		ScopeMetrics: []otlpScopeMetrics{{Scope: otlp.Scope{Name: scopeName}, Metrics: metrics}},
+		SchemaURL:    res.SchemaURL(),
+	}}}
+}
+
+// histogramPoint turns the cumulative prometheus buckets into OTLP per bucket counts, the last one is +Inf
+func histogramPoint(p *dto.Metric, startNano, nowNano string) otlpHistogramPoint {
+	h := p.GetHistogram()
+	point := otlpHistogramPoint{
+		Attributes:        labelAttributes(p.GetLabel()),
+		StartTimeUnixNano: startNano,
+		TimeUnixNano:      nowNano,
+		Count:             strconv.FormatUint(h.GetSampleCount(), 10),
+		Sum:               h.GetSampleSum(),
+		BucketCounts:      []string{},
+		ExplicitBounds:    []float64{},
+	}
+	var prev uint64
+	for _, b := range h.GetBucket() {
+		if math.IsInf(b.GetUpperBound(), 1) {
+			continue
+		}
+		point.ExplicitBounds = append(point.ExplicitBounds, b.GetUpperBound())
+		point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(b.GetCumulativeCount()-prev, 10))
+		prev = b.GetCumulativeCount()
+	}
+	point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(h.GetSampleCount()-prev, 10))
+	return point
+}
+
+// marshalProto encodes the request as the protobuf ExportMetricsServiceRequest
+func (r *otlpRequest) marshalProto() []byte {
+	var b []byte
+	for _, rm := range r.ResourceMetrics {
+		rm := rm
+		b = otlp.AppendMessage(b, 1, func(b []byte) []byte {
+			b = otlp.AppendResource(b, 1, rm.Resource)
+			for _, sm := range rm.ScopeMetrics {
+				sm := sm
+				b = otlp.AppendMessage(b, 2, func(b []byte) []byte {
+					b = otlp.AppendScope(b, 1, sm.Scope)
+					for i := range sm.Metrics {
+						b = otlp.AppendMessage(b, 2, sm.Metrics[i].appendProto)
+					}
+					return b
+				})
+			}
+			return otlp.AppendString(b, 3, rm.SchemaURL)
+		})
+	}
+	return b
+}
+
+func (m *otlpMetric) appendProto(b []byte) []byte {
+	b = otlp.AppendString(b, 1, m.Name)
+	b = otlp.AppendString(b, 2, m.Description)
+	switch {
+	case m.Gauge != nil:
+		b = otlp.AppendMessage(b, 5, func(b []byte) []byte {
+			return appendNumberPoints(b, m.Gauge.DataPoints)
+		})
+	case m.Sum != nil:
+		b = otlp.AppendMessage(b, 7, func(b []byte) []byte {
+			b = appendNumberPoints(b, m.Sum.DataPoints)
+			b = otlp.AppendVarint(b, 2, uint64(m.Sum.AggregationTemporality))
+			return otlp.AppendBool(b, 3, m.Sum.IsMonotonic)
+		})
+	case m.Histogram != nil:
+		b = otlp.AppendMessage(b, 9, func(b []byte) []byte {
+			for _, p := range m.Histogram.DataPoints {
+				p := p
+				b = otlp.AppendMessage(b, 1, func(b []byte) []byte {
+					b = otlp.AppendFixed64(b, 2, p.StartTimeUnixNano)
+					b = otlp.AppendFixed64(b, 3, p.TimeUnixNano)
+					b = otlp.AppendFixed64(b, 4, p.Count)
+					b = otlp.AppendDouble(b, 5, p.Sum)
+					b = otlp.AppendPackedFixed64(b, 6, p.BucketCounts)
+					b = otlp.AppendPackedDouble(b, 7, p.ExplicitBounds)
+					return otlp.AppendKeyValues(b, 9, p.Attributes)
+				})
+			}
+			return otlp.AppendVarint(b, 2, uint64(m.Histogram.AggregationTemporality))
+		})
+	case m.Summary != nil:
+		b = otlp.AppendMessage(b, 11, func(b []byte) []byte {
+			for _, p := range m.Summary.DataPoints {
+				p := p
+				b = otlp.AppendMessage(b, 1, func(b []byte) []byte {
+					b = otlp.AppendFixed64(b, 2, p.StartTimeUnixNano)
+					b = otlp.AppendFixed64(b, 3, p.TimeUnixNano)
+					b = otlp.AppendFixed64(b, 4, p.Count)
+					b = otlp.AppendDouble(b, 5, p.Sum)
+					for _, q := range p.QuantileValues {
+						q := q
+						b = otlp.AppendMessage(b, 6, func(b []byte) []byte {
+							b = otlp.AppendDouble(b, 1, q.Quantile)
+							return otlp.AppendDouble(b, 2, q.Value)
+						})
+					}
+					return otlp.AppendKeyValues(b, 7, p.Attributes)
+				})
+			}
+			return b
+		})
+	}
+	return b
+}
+
+func appendNumberPoints(b []byte, points []otlpNumberPoint) []byte {
+	for _, p := range points {
+		p := p
+		b = otlp.AppendMessage(b, 1, func(b []byte) []byte {
+			b = otlp.AppendFixed64(b, 2, p.StartTimeUnixNano)
+			b = otlp.AppendFixed64(b, 3, p.TimeUnixNano)
+			b = otlp.AppendDouble(b, 4, p.AsDouble)
+			return otlp.AppendKeyValues(b, 7, p.Attributes)
+		})
+	}
+	return b
+}
@@ -0,0 +1,226 @@
+package metric
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	"github.com/spf13/viper"
+	"gitlab.com/cake/go-project-template/gpt"
+	"gitlab.com/cake/goctx"
+	"gitlab.com/cake/gopkg"
+	"gitlab.com/cake/m800log"
+)
+
+// Grouping labels of the pushed metrics
+const (
+	LabelJob      = "job"
+	LabelInstance = "instance"
+)
+
+// PushConfig is the [metric.push] section, a Pushgateway compatible endpoint for jobs exiting before being scraped
+type PushConfig struct {
+	// URL of the gateway, e.g. http://localhost:9091, empty disables pushing
+	URL string `mapstructure:"url"`
+	// Job defaults to the app name, Instance defaults to the pod name
+	Job      string            `mapstructure:"job"`
+	Instance string            `mapstructure:"instance"`
+	Grouping map[string]string `mapstructure:"grouping"`
+	// Interval pushes periodically, 0 only pushes on Shutdown
+	Interval time.Duration `mapstructure:"interval"`
+	Timeout  time.Duration `mapstructure:"timeout"`
+	// Replace uses PUT to replace the whole group, otherwise POST only replaces the metrics with the same names
+	Replace bool `mapstructure:"replace"`
+}
+
+// LoadPushConfig reads [metric.push]
+func LoadPushConfig() (conf PushConfig, err error) {
+	err = viper.UnmarshalKey("metric.push", &conf)
+	return
+}
+
+// GroupingKey returns the job and instance with the extra grouping labels
+func (c PushConfig) GroupingKey() map[string]string {
+	ret := map[string]string{
+		LabelJob:      c.Job,
+		LabelInstance: c.Instance,
+	}
+	if ret[LabelJob] == "" {
+		ret[LabelJob] = gopkg.GetAppName()
+	}
+	if ret[LabelInstance] == "" {
+		ret[LabelInstance] = gpt.GetPodName()
+	}
+	for k, v := range c.Grouping {
+		ret[k] = v
+	}
+	return ret
+}
+
+// Pusher pushes the gathered metrics to a Pushgateway on every interval and on Shutdown
+type Pusher struct {
+	url      string
+	method   string
+	gatherer prometheus.Gatherer
+	client   *http.Client
+	interval time.Duration
+	timeout  time.Duration
+
+	ctx    goctx.Context
+	stop   chan struct{}
+	wg     sync.WaitGroup
+	closed sync.Once
+}
+
+// StartPusher starts pushing gatherer, nil pusher is returned when conf.URL is empty
+func StartPusher(conf PushConfig, gatherer prometheus.Gatherer) (*Pusher, error) {
+	if conf.URL == "" {
+		return nil, nil
+	}
+	endpoint, err := groupURL(conf.URL, conf.GroupingKey())
+	if err != nil {
+		return nil, err
+	}
+	p := &Pusher{
+		url:      endpoint,
+		method:   http.MethodPost,
+		gatherer: gatherer,
+		client:   &http.Client{},
+		interval: conf.Interval,
+		timeout:  conf.Timeout,
+		ctx:      goctx.Background(),
+		stop:     make(chan struct{}),
+	}
+	if conf.Replace {
+		p.method = http.MethodPut
+	}
+	if p.timeout <= 0 {
+		p.timeout = 10 * time.Second
+	}
+	p.ctx.Set(goctx.LogKeyService, "metric-pusher")
+
+	if p.interval > 0 {
+		p.wg.Add(1)
+		go p.loop()
+	}
+	return p, nil
+}
+
+// groupURL encodes the grouping key in the path, the job first and the others in name order
+func groupURL(base string, grouping map[string]string) (string, error) {
+	if _, err := url.Parse(base); err != nil {
+		return "", err
+	}
+	if grouping[LabelJob] == "" {
+		return "", fmt.Errorf("job of metric push is required")
+	}
+	names := make([]string, 0, len(grouping))
+	for k := range grouping {
+		if k != LabelJob {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	path := strings.TrimSuffix(base, "/") + "/metrics/" + groupSegment(LabelJob, grouping[LabelJob])
+	for _, k := range names {
+		if !model.LabelName(k).IsValid() {
+			return "", fmt.Errorf("invalid grouping label name: %s", k)
+		}
+		path += "/" + groupSegment(k, grouping[k])
+	}
+	return path, nil
+}
+
+// groupSegment uses the base64 form for values which can't be a path segment
+func groupSegment(name, value string) string {
+	if value == "" {
+		return name + "@base64/="
+	}
+	if strings.Contains(value, "/") {
+		return name + "@base64/" + base64.RawURLEncoding.EncodeToString([]byte(value))
+	}
+	return name + "/" + url.PathEscape(value)
+}
+
+func (p *Pusher) loop() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			if err := p.Push(context.Background()); err != nil {
+				m800log.Errorf(p.ctx, "[metric push] push failed: %v", err)
+			}
+		}
+	}
+}
+
+// Push gathers and pushes once
+func (p *Pusher) Push(ctx context.Context) error {
+	mfs, err := p.gatherer.Gather()
+	if err != nil && len(mfs) == 0 {
+		return err
+	}
+	body := &bytes.Buffer{}
+	enc := expfmt.NewEncoder(body, expfmt.FmtProtoDelim)
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			return err
+		}
+	}
+	return p.do(ctx, p.method, body, string(expfmt.FmtProtoDelim))
+}
+
+// Delete removes the group from the gateway, e.g. when a job is retired
+func (p *Pusher) Delete(ctx context.Context) error {
+	return p.do(ctx, http.MethodDelete, nil, "")
+}
+
+func (p *Pusher) do(ctx context.Context, method string, body io.Reader, contentType string) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, p.url, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("push gateway responded %d: %s", resp.StatusCode, msg)
+	}
+	return nil
+}
+
+// Shutdown stops the loop and pushes the final values
+func (p *Pusher) Shutdown(ctx context.Context) (err error) {
+	p.closed.Do(func() {
+		close(p.stop)
+		p.wg.Wait()
+		err = p.Push(ctx)
+		p.client.CloseIdleConnections()
+	})
+	return
+}
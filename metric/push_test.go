@@ -0,0 +1,78 @@
+package metric
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPush(t *testing.T) {
+	rc := NewReceiver()
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	r := testExportRegistry(t)
+	conf := PushConfig{URL: srv.URL, Job: "migrate", Instance: "pod-1", Grouping: map[string]string{"path": "/a/b", "empty": ""}}
+	p, err := StartPusher(conf, r.Gatherer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	groups := rc.Groups()
+	if len(groups) != 1 || groups[0]["job"] != "migrate" || groups[0]["instance"] != "pod-1" ||
+		groups[0]["path"] != "/a/b" || groups[0]["empty"] != "" {
+		t.Fatalf("unexpected groups: %v", groups)
+	}
+	mfs := rc.Group(conf.GroupingKey())
+	if c := mfs["test_jobs_total"]; c == nil || c.GetMetric()[0].GetCounter().GetValue() != 3 {
+		t.Errorf("unexpected counter: %v", c)
+	}
+	if h := mfs["test_latency_seconds"]; h == nil || h.GetMetric()[0].GetHistogram().GetSampleCount() != 4 {
+		t.Errorf("unexpected histogram: %v", h)
+	}
+
+	if err := p.Delete(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(rc.Groups()) != 0 {
+		t.Errorf("group should be deleted")
+	}
+}
+
+func TestParseGroupingPath(t *testing.T) {
+	for _, grouping := range []map[string]string{
+		{LabelJob: "batch/migrate", LabelInstance: "pod 1"},
+		{LabelJob: "migrate", "path": "/a/b", "name": "x%2Fy"},
+	} {
+		u, err := groupURL("http://localhost", grouping)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := parseGroupingPath(strings.TrimPrefix(u, "http://localhost"))
+		if err != nil || len(got) != len(grouping) {
+			t.Errorf("%s = %v %v, want %v", u, got, err, grouping)
+			continue
+		}
+		for k, v := range grouping {
+			if got[k] != v {
+				t.Errorf("%s = %v, want %v", u, got, grouping)
+			}
+		}
+	}
+	if _, err := parseGroupingPath("/metrics/instance/a"); err == nil {
+		t.Error("grouping without job should be rejected")
+	}
+}
+
+func TestPushDisabled(t *testing.T) {
+	if p, err := StartPusher(PushConfig{}, nil); p != nil || err != nil {
+		t.Errorf("empty url should disable pushing, got %v %v", p, err)
+	}
+	if _, err := StartPusher(PushConfig{URL: "http://localhost", Grouping: map[string]string{"bad-name": "x"}}, nil); err == nil {
+		t.Errorf("invalid grouping label should be rejected")
+	}
+}
@@ -0,0 +1,157 @@
+package metric
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Receiver is a stand-in of the Pushgateway push API for tests and local runs,
+// serve it by httptest.NewServer or http.ListenAndServe and read the pushed families by Group
+type Receiver struct {
+	mu     sync.Mutex
+	groups map[string]*receivedGroup
+}
+
+type receivedGroup struct {
+	grouping map[string]string
+	families map[string]*dto.MetricFamily
+}
+
+func NewReceiver() *Receiver {
+	return &Receiver{groups: map[string]*receivedGroup{}}
+}
+
+// ServeHTTP handles PUT, POST and DELETE of /metrics/job/<job>{/<label>/<value>}
+func (rc *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	grouping, err := parseGroupingPath(req.URL.EscapedPath())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key := groupingString(grouping)
+
+	switch req.Method {
+	case http.MethodDelete:
+		rc.mu.Lock()
+		delete(rc.groups, key)
+		rc.mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+		return
+	case http.MethodPut, http.MethodPost:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	families := map[string]*dto.MetricFamily{}
+	dec := expfmt.NewDecoder(req.Body, expfmt.ResponseFormat(req.Header))
+	for {
+		mf := &dto.MetricFamily{}
+		if err := dec.Decode(mf); err != nil {
+			if err == io.EOF {
+				break
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		families[mf.GetName()] = mf
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	g, ok := rc.groups[key]
+	if !ok || req.Method == http.MethodPut {
+		g = &receivedGroup{grouping: grouping, families: map[string]*dto.MetricFamily{}}
+		rc.groups[key] = g
+	}
+	for name, mf := range families {
+		g.families[name] = mf
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Groups returns the grouping keys pushed so far
+func (rc *Receiver) Groups() []map[string]string {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	keys := make([]string, 0, len(rc.groups))
+	for k := range rc.groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	ret := make([]map[string]string, 0, len(keys))
+	for _, k := range keys {
+		ret = append(ret, rc.groups[k].grouping)
+	}
+	return ret
+}
+
+// Group returns the families of the grouping key by name, nil if nothing was pushed
+func (rc *Receiver) Group(grouping map[string]string) map[string]*dto.MetricFamily {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	g, ok := rc.groups[groupingString(grouping)]
+	if !ok {
+		return nil
+	}
+	ret := make(map[string]*dto.MetricFamily, len(g.families))
+	for k, v := range g.families {
+		ret[k] = v
+	}
+	return ret
+}
+
+// parseGroupingPath decodes the escaped path built by groupURL, the segments are split before
+// being unescaped and the @base64 suffix is removed from the names, the job label included
+func parseGroupingPath(path string) (map[string]string, error) {
+	i := strings.Index(path, "/metrics/")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid push path: %s", path)
+	}
+	parts := strings.Split(strings.Trim(path[i+len("/metrics/"):], "/"), "/")
+	if len(parts)%2 != 0 {
+		return nil, fmt.Errorf("invalid grouping key: %s", path)
+	}
+	grouping := map[string]string{}
+	for j := 0; j < len(parts); j += 2 {
+		name, err := url.PathUnescape(parts[j])
+		if err != nil {
+			return nil, fmt.Errorf("invalid grouping label name: %v", err)
+		}
+		value, err := url.PathUnescape(parts[j+1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of %s: %v", name, err)
+		}
+		if strings.HasSuffix(name, "@base64") {
+			name = strings.TrimSuffix(name, "@base64")
+			b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+			if err != nil {
+				return nil, fmt.Errorf("invalid base64 value of %s: %v", name, err)
+			}
+			value = string(b)
+		}
+		if j == 0 && name != LabelJob {
+			return nil, fmt.Errorf("invalid grouping key: %s", path)
+		}
+		grouping[name] = value
+	}
+	return grouping, nil
+}
+
+func groupingString(grouping map[string]string) string {
+	pairs := make([]string, 0, len(grouping))
+	for k, v := range grouping {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
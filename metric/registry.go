@@ -0,0 +1,295 @@
+package metric
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	ErrNotFound     = errors.New("metric not found")
+	ErrTypeMismatch = errors.New("metric type mismatch")
+
+	defaultRegistry   = NewRegistry(AppNamespace(), "")
+	defaultRegistryMu sync.RWMutex
+)
+
+// Registry owns a dedicated prometheus.Registry and looks up the declared metrics by name
+type Registry struct {
+	namespace string
+	subsystem string
+	reg       *prometheus.Registry
+	overflow  *prometheus.CounterVec
+
+	mu      sync.RWMutex
+	metrics map[string]entry
+}
+
+type entry struct {
+	def       Definition
+	collector prometheus.Collector
+	guard     *Guard
+}
+
+func NewRegistry(namespace, subsystem string) *Registry {
+	r := &Registry{
+		namespace: namespace,
+		subsystem: subsystem,
+		reg:       prometheus.NewRegistry(),
+		overflow:  newOverflowCounter(namespace),
+		metrics:   map[string]entry{},
+	}
+	r.reg.MustRegister(r.overflow)
+	return r
+}
+
+// Init replaces the default registry with the one built from conf
+func Init(conf Config) error {
+	ns := conf.Namespace
+	if ns == "" {
+		ns = AppNamespace()
+	}
+	r := NewRegistry(ns, conf.Subsystem)
+	if err := r.RegisterAll(conf.Definitions); err != nil {
+		return err
+	}
+	if err := r.registerCollectors(conf.Collectors); err != nil {
+		return err
+	}
+	if conf.ExemplarSampleRate != nil {
+		SetExemplarSampleRate(*conf.ExemplarSampleRate)
+	}
+	defaultRegistryMu.Lock()
+	defer defaultRegistryMu.Unlock()
+	defaultRegistry = r
+	return nil
+}
+
+// Default returns the registry built by Init
+func Default() *Registry {
+	defaultRegistryMu.RLock()
+	defer defaultRegistryMu.RUnlock()
+	return defaultRegistry
+}
+
+// Handler serves both the global registry, which golibs and gopkg collectors use, and the default registry
+func Handler() gin.HandlerFunc {
+	h := promhttp.HandlerFor(Gatherer(), promhttp.HandlerOpts{EnableOpenMetrics: true})
+	return func(c *gin.Context) {
+		h.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// Register creates and registers the metric, registering the same name twice returns error
+func (r *Registry) Register(d Definition) error {
+	c, err := d.collector(r.namespace, r.subsystem)
+	if err != nil {
+		return err
+	}
+	e := entry{def: d, collector: c}
+	if len(d.Labels) > 0 {
+		if e.guard, err = NewGuard(d.Name, d.Labels, GuardOpts{MaxSeries: d.MaxSeries, Allow: d.Allow}, r.overflow); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.metrics[d.Name]; ok {
+		return fmt.Errorf("metric %s already registered", d.Name)
+	}
+	if err := r.reg.Register(c); err != nil {
+		return fmt.Errorf("metric %s: %w", d.Name, err)
+	}
+	r.metrics[d.Name] = e
+	if d.Preregister {
+		combos := e.guard.product()
+		for _, combo := range combos {
+			preregister(c, e.guard.Values(combo...))
+		}
+	}
+	return nil
+}
+
+func preregister(c prometheus.Collector, values []string) {
+	switch vec := c.(type) {
+	case *prometheus.CounterVec:
+		vec.WithLabelValues(values...)
+	case *prometheus.GaugeVec:
+		vec.WithLabelValues(values...)
+	case *prometheus.HistogramVec:
+		vec.WithLabelValues(values...)
+	case *prometheus.SummaryVec:
+		vec.WithLabelValues(values...)
+	}
+}
+
+// RegisterAll stops at the first error
+func (r *Registry) RegisterAll(defs []Definition) error {
+	for _, d := range defs {
+		if err := r.Register(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Registerer is for collectors which are not declared by definition
+func (r *Registry) Registerer() prometheus.Registerer {
+	return r.reg
+}
+
+// Namespaced is the Registerer of the collectors built by the app's components, e.g. the watcher,
+// their metric names are prefixed by the registry namespace the same as {namespace}_build_info
+func (r *Registry) Namespaced() prometheus.Registerer {
+	if r.namespace == "" {
+		return r.reg
+	}
+	return prometheus.WrapRegistererWithPrefix(r.namespace+"_", r.reg)
+}
+
+// FQName returns the name of the registered metric with namespace and subsystem
+func (r *Registry) FQName(name string) string {
+	return prometheus.BuildFQName(r.namespace, r.subsystem, name)
+}
+
+func (r *Registry) Gatherer() prometheus.Gatherer {
+	return r.reg
+}
+
+// Definitions returns the registered definitions
+func (r *Registry) Definitions() []Definition {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ret := make([]Definition, 0, len(r.metrics))
+	for _, e := range r.metrics {
+		ret = append(ret, e.def)
+	}
+	return ret
+}
+
+// Definition returns the registered definition of name
+func (r *Registry) Definition(name string) (Definition, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, ok := r.metrics[name]
+	if !ok {
+		return Definition{}, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return e.def, nil
+}
+
+func (r *Registry) lookup(name, typ string, vec bool) (prometheus.Collector, error) {
+	e, err := r.lookupEntry(name, typ, vec)
+	return e.collector, err
+}
+
+func (r *Registry) lookupEntry(name, typ string, vec bool) (entry, error) {
+	r.mu.RLock()
+	e, ok := r.metrics[name]
+	r.mu.RUnlock()
+	if !ok {
+		return e, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if e.def.Type != typ || (len(e.def.Labels) > 0) != vec {
+		return e, fmt.Errorf("%w: %s is %s with labels %v", ErrTypeMismatch, name, e.def.Type, e.def.Labels)
+	}
+	return e, nil
+}
+
+func (r *Registry) Counter(name string) (prometheus.Counter, error) {
+	c, err := r.lookup(name, TypeCounter, false)
+	if err != nil {
+		return nil, err
+	}
+	return c.(prometheus.Counter), nil
+}
+
+func (r *Registry) Gauge(name string) (prometheus.Gauge, error) {
+	c, err := r.lookup(name, TypeGauge, false)
+	if err != nil {
+		return nil, err
+	}
+	return c.(prometheus.Gauge), nil
+}
+
+func (r *Registry) Histogram(name string) (prometheus.Histogram, error) {
+	c, err := r.lookup(name, TypeHistogram, false)
+	if err != nil {
+		return nil, err
+	}
+	return c.(prometheus.Histogram), nil
+}
+
+func (r *Registry) Summary(name string) (prometheus.Summary, error) {
+	c, err := r.lookup(name, TypeSummary, false)
+	if err != nil {
+		return nil, err
+	}
+	return c.(prometheus.Summary), nil
+}
+
+// CounterVec returns the counter vector behind its cardinality guard, the raw vector
+// isn't exposed so that every label value goes through the max_series and allowlist limits
+func (r *Registry) CounterVec(name string) (*GuardedCounterVec, error) {
+	e, err := r.lookupEntry(name, TypeCounter, true)
+	if err != nil {
+		return nil, err
+	}
+	return NewGuardedCounterVec(e.collector.(*prometheus.CounterVec), e.guard), nil
+}
+
+// GaugeVec returns the gauge vector behind its cardinality guard
+func (r *Registry) GaugeVec(name string) (*GuardedGaugeVec, error) {
+	e, err := r.lookupEntry(name, TypeGauge, true)
+	if err != nil {
+		return nil, err
+	}
+	return NewGuardedGaugeVec(e.collector.(*prometheus.GaugeVec), e.guard), nil
+}
+
+// HistogramVec returns the histogram vector behind its cardinality guard
+func (r *Registry) HistogramVec(name string) (*GuardedHistogramVec, error) {
+	e, err := r.lookupEntry(name, TypeHistogram, true)
+	if err != nil {
+		return nil, err
+	}
+	return NewGuardedHistogramVec(e.collector.(*prometheus.HistogramVec), e.guard), nil
+}
+
+// SummaryVec returns the summary vector behind its cardinality guard
+func (r *Registry) SummaryVec(name string) (*GuardedSummaryVec, error) {
+	e, err := r.lookupEntry(name, TypeSummary, true)
+	if err != nil {
+		return nil, err
+	}
+	return NewGuardedSummaryVec(e.collector.(*prometheus.SummaryVec), e.guard), nil
+}
+
+// Counter returns the counter of the default registry
+func Counter(name string) (prometheus.Counter, error) { return Default().Counter(name) }
+
+// CounterVec returns the counter vector of the default registry
+func CounterVec(name string) (*GuardedCounterVec, error) { return Default().CounterVec(name) }
+
+// Gauge returns the gauge of the default registry
+func Gauge(name string) (prometheus.Gauge, error) { return Default().Gauge(name) }
+
+// GaugeVec returns the gauge vector of the default registry
+func GaugeVec(name string) (*GuardedGaugeVec, error) { return Default().GaugeVec(name) }
+
+// Histogram returns the histogram of the default registry
+func Histogram(name string) (prometheus.Histogram, error) { return Default().Histogram(name) }
+
+// HistogramVec returns the histogram vector of the default registry
+func HistogramVec(name string) (*GuardedHistogramVec, error) { return Default().HistogramVec(name) }
+
+// Summary returns the summary of the default registry
+func Summary(name string) (prometheus.Summary, error) { return Default().Summary(name) }
+
+// SummaryVec returns the summary vector of the default registry
+func SummaryVec(name string) (*GuardedSummaryVec, error) { return Default().SummaryVec(name) }
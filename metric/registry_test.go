@@ -0,0 +1,248 @@
+package metric
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry("test", "unit")
+	defs := []Definition{
+		{Type: TypeCounter, Name: "requests_total"},
+		{Type: TypeHistogram, Name: "latency_seconds", Labels: []string{"route"}, Buckets: []float64{0.1, 1}},
+		{Type: TypeSummary, Name: "size_bytes", Objectives: map[string]float64{"0.9": 0.01}},
+	}
+	if err := r.RegisterAll(defs); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register(defs[0]); err == nil {
+		t.Error("duplicated registration should fail")
+	}
+
+	c, err := r.Counter("requests_total")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Inc()
+	if _, err := r.HistogramVec("latency_seconds"); err != nil {
+		t.Error(err)
+	}
+	if _, err := r.Histogram("latency_seconds"); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("histogram vector looked up as histogram: %v", err)
+	}
+	if _, err := r.Gauge("requests_total"); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("counter looked up as gauge: %v", err)
+	}
+	if _, err := r.Summary("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing metric: %v", err)
+	}
+
+	mfs, err := r.Gatherer().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mfs) != 2 || mfs[0].GetName() != "test_unit_requests_total" {
+		t.Errorf("unexpected gathered families: %v", mfs)
+	}
+
+	component := prometheus.NewCounter(prometheus.CounterOpts{Name: "component_total"})
+	r.Namespaced().MustRegister(component)
+	component.Inc()
+	if mfs, _ = r.Gatherer().Gather(); len(mfs) != 3 || mfs[0].GetName() != "test_component_total" {
+		t.Errorf("component metrics should be prefixed by the namespace: %v", mfs)
+	}
+}
+
+func TestVecMaxSeries(t *testing.T) {
+	r := NewRegistry("test", "")
+	err := r.RegisterAll([]Definition{
+		{Type: TypeCounter, Name: "hits_total", Labels: []string{"user"}, MaxSeries: 2},
+		{Type: TypeHistogram, Name: "wait_seconds", Labels: []string{"user"}, MaxSeries: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter, err := r.CounterVec("hits_total")
+	if err != nil {
+		t.Fatal(err)
+	}
+	histogram, err := r.HistogramVec("wait_seconds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []string{"u1", "u2", "u3", "u4"} {
+		counter.WithLabelValues(user).Inc()
+		histogram.WithLabelValues(user).Observe(1)
+	}
+
+	mfs, _ := r.Gatherer().Gather()
+	series := map[string][]string{}
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "user" {
+					series[mf.GetName()] = append(series[mf.GetName()], l.GetValue())
+				}
+			}
+		}
+	}
+	if got := strings.Join(series["test_hits_total"], ","); got != OtherValue+",u1,u2" {
+		t.Errorf("counter series = %s, want u1, u2 and %s", got, OtherValue)
+	}
+	if got := strings.Join(series["test_wait_seconds"], ","); got != OtherValue+",u1" {
+		t.Errorf("histogram series = %s, want u1 and %s", got, OtherValue)
+	}
+}
+
+func TestInvalidDefinition(t *testing.T) {
+	r := NewRegistry("test", "")
+	for _, d := range []Definition{
+		{Type: "meter", Name: "a"},
+		{Type: TypeCounter, Name: "b", Buckets: []float64{1}},
+		{Type: TypeHistogram, Name: "c", Buckets: []float64{2, 1}},
+		{Type: TypeHistogram, Name: "d", Labels: []string{"le"}},
+		{Type: TypeSummary, Name: "e", Objectives: map[string]float64{"1.5": 0.1}},
+		{Type: TypeGauge, Name: "invalid-name"},
+	} {
+		if err := r.Register(d); err == nil {
+			t.Errorf("%+v should be rejected", d)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.yaml")
+	content := `
+definitions:
+  - type: gauge
+    name: queue_depth
+    labels: [queue]
+  - type: summary
+    name: job_seconds
+    objectives:
+      "0.99": 0.001
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	defs, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(defs) != 2 || defs[0].Labels[0] != "queue" || defs[1].Objectives["0.99"] != 0.001 {
+		t.Errorf("unexpected definitions: %+v", defs)
+	}
+	if err := NewRegistry("test", "").RegisterAll(defs); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGuard(t *testing.T) {
+	r := NewRegistry("test", "")
+	err := r.Register(Definition{
+		Type:      TypeCounter,
+		Name:      "hits_total",
+		Labels:    []string{"route", "user"},
+		Allow:     map[string][]string{"route": {"/a", "/b"}},
+		MaxSeries: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vec, err := r.CounterVec("hits_total")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := vec.Guard()
+	cases := []struct {
+		in, want []string
+	}{
+		{[]string{"/a", "u1"}, []string{"/a", "u1"}},
+		{[]string{"/c", "u1"}, []string{OtherValue, "u1"}},
+		{[]string{"/a", "u2"}, []string{OtherValue, OtherValue}},
+		{[]string{"/a", "u1"}, []string{"/a", "u1"}},
+	}
+	for _, c := range cases {
+		if got := g.Values(c.in...); strings.Join(got, ",") != strings.Join(c.want, ",") {
+			t.Errorf("Values(%v) = %v, want %v", c.in, got, c.want)
+		}
+	}
+	vec.WithLabelValues("/b", "u3").Inc()
+	if g.Series() != 2 {
+		t.Errorf("series = %d, want 2", g.Series())
+	}
+
+	mfs, _ := r.Gatherer().Gather()
+	overflow := map[string]float64{}
+	for _, mf := range mfs {
+		if mf.GetName() != "test_"+overflowName {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "reason" {
+					overflow[l.GetValue()] = m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	if overflow[OverflowReasonAllowlist] != 1 || overflow[OverflowReasonMaxSeries] != 2 {
+		t.Errorf("unexpected overflow: %v", overflow)
+	}
+
+	if err := r.Register(Definition{Type: TypeGauge, Name: "g", Labels: []string{"a"}, Allow: map[string][]string{"b": {"x"}}}); err == nil {
+		t.Error("allowlist of unknown label should fail")
+	}
+}
+
+func TestPreregister(t *testing.T) {
+	r := NewRegistry("test", "")
+	err := r.Register(Definition{
+		Type:        TypeGauge,
+		Name:        "jobs",
+		Labels:      []string{"queue", "state"},
+		Allow:       map[string][]string{"queue": {"a", "b"}, "state": {"running", "done"}},
+		Preregister: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfs, _ := r.Gatherer().Gather()
+	for _, mf := range mfs {
+		if mf.GetName() == "test_jobs" && len(mf.GetMetric()) != 4 {
+			t.Errorf("expected 4 preregistered series, got %d", len(mf.GetMetric()))
+		}
+	}
+}
+
+func TestCollectors(t *testing.T) {
+	off := false
+	conf := Config{Namespace: "test", Collectors: CollectorConfig{GoRuntime: &off, ModFile: &off}}
+	if err := Init(conf); err != nil {
+		t.Fatal(err)
+	}
+	// Init again must not conflict with the moved global collectors
+	if err := Init(conf); err != nil {
+		t.Fatal(err)
+	}
+	mfs, err := Gatherer().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, mf := range mfs {
+		names[mf.GetName()] = true
+	}
+	if !names["test_build_info"] || !names["go_mod_info"] {
+		t.Errorf("enabled collectors missing: %v", names)
+	}
+	if names["go_goroutines"] || names["go_mod_file_info"] || names["go_build_info"] {
+		t.Errorf("disabled collectors gathered: %v", names)
+	}
+}
@@ -0,0 +1,209 @@
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gitlab.com/cake/go-project-template/gpt"
+	"gitlab.com/cake/golibs/intercom"
+)
+
+const (
+	defaultFaultMessage = "mock fault"
+)
+
+// Fixture is one canned response, a fixture file is a JSON array of fixtures
+type Fixture struct {
+	// Name shows in the records, defaults to "<file>#<index>"
+	Name string `json:"name"`
+	// Method matches any method when empty
+	Method string `json:"method"`
+	// Path pattern, :name matches one segment and a trailing *name the rest of the path,
+	// {{name}} in Result and Body is replaced by the matched value
+	Path string `json:"path"`
+	// Status defaults to the intercom http status of Code, 200 when Code is 0
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	// Code, Message and Result are sent in the intercom response envelope
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Result  json.RawMessage `json:"result"`
+	// Body is sent as is instead of the envelope when set
+	Body string `json:"body"`
+	// Latency before the response plus a random duration up to Jitter
+	Latency Duration `json:"latency"`
+	Jitter  Duration `json:"jitter"`
+	Fault   Fault    `json:"fault"`
+
+	segments []string
+}
+
+// Fault fails a ratio of the requests of the fixture
+type Fault struct {
+	// Rate of the failed requests between 0 and 1, 0 disables the fault
+	Rate float64 `json:"rate"`
+	// Code defaults to gpt.CodeInternalServerError, Status to its intercom http status
+	Status  int    `json:"status"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	// Abort closes the connection without response instead, the client gets a transport error
+	Abort bool `json:"abort"`
+}
+
+// Duration is a time.Duration written as "150ms" in the fixture files
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"150ms\": %s", b)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Load reads the *.json fixture files of dir sorted by file name, the first matching fixture serves a request
+func Load(dir string) ([]*Fixture, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	ret := []*Fixture{}
+	for _, file := range files {
+		raw, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		fixtures := []*Fixture{}
+		if err := json.Unmarshal(raw, &fixtures); err != nil {
+			return nil, fmt.Errorf("fixture file %s: %w", file, err)
+		}
+		for i, f := range fixtures {
+			if f.Name == "" {
+				f.Name = fmt.Sprintf("%s#%d", filepath.Base(file), i)
+			}
+			f.setDefault()
+			if err := f.validate(); err != nil {
+				return nil, fmt.Errorf("fixture %s: %w", f.Name, err)
+			}
+		}
+		ret = append(ret, fixtures...)
+	}
+	return ret, nil
+}
+
+func (f *Fixture) setDefault() {
+	f.Method = strings.ToUpper(f.Method)
+	f.segments = split(f.Path)
+	if f.Status == 0 {
+		f.Status = statusOf(f.Code)
+	}
+	if f.Fault.Code == 0 {
+		f.Fault.Code = gpt.CodeInternalServerError
+	}
+	if f.Fault.Status == 0 {
+		f.Fault.Status = statusOf(f.Fault.Code)
+	}
+	if f.Fault.Message == "" {
+		f.Fault.Message = defaultFaultMessage
+	}
+}
+
+func (f *Fixture) validate() error {
+	if !strings.HasPrefix(f.Path, "/") {
+		return fmt.Errorf("path must start with /: %q", f.Path)
+	}
+	for i, s := range f.segments {
+		if strings.HasPrefix(s, "*") && i != len(f.segments)-1 {
+			return fmt.Errorf("wildcard must be the last segment: %q", f.Path)
+		}
+	}
+	if f.Status < 100 || f.Status > 599 || f.Fault.Status < 100 || f.Fault.Status > 599 {
+		return fmt.Errorf("invalid status %d", f.Status)
+	}
+	if f.Fault.Rate < 0 || f.Fault.Rate > 1 {
+		return fmt.Errorf("fault rate must be between 0 and 1")
+	}
+	if f.Latency < 0 || f.Jitter < 0 {
+		return fmt.Errorf("latency must not be negative")
+	}
+	if len(f.Result) > 0 && f.Body != "" {
+		return fmt.Errorf("result and body are exclusive")
+	}
+	return nil
+}
+
+// match returns the path parameters when the fixture serves the request
+func (f *Fixture) match(method, path string) (params map[string]string, ok bool) {
+	if f.Method != "" && f.Method != method {
+		return nil, false
+	}
+	segments := split(path)
+	params = map[string]string{}
+	for i, s := range f.segments {
+		if strings.HasPrefix(s, "*") {
+			if i > len(segments) {
+				return nil, false
+			}
+			params[s[1:]] = strings.Join(segments[i:], "/")
+			return params, true
+		}
+		if i >= len(segments) {
+			return nil, false
+		}
+		if strings.HasPrefix(s, ":") {
+			params[s[1:]] = segments[i]
+			continue
+		}
+		if s != segments[i] {
+			return nil, false
+		}
+	}
+	return params, len(segments) == len(f.segments)
+}
+
+func split(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// statusOf maps the error code as intercom.GinError does
+func statusOf(code int) int {
+	if code == 0 {
+		return http.StatusOK
+	}
+	if status, ok := intercom.ErrorHttpStatusMapping.Get(code); ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// render replaces {{name}} by the path parameters, escaped as a JSON string content when escape is set
+func render(s string, params map[string]string, escape bool) string {
+	for k, v := range params {
+		if escape {
+			b, _ := json.Marshal(v)
+			v = string(b[1 : len(b)-1])
+		}
+		s = strings.ReplaceAll(s, "{{"+k+"}}", v)
+	}
+	return s
+}
@@ -0,0 +1,183 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/cake/go-project-template/gpt"
+	"gitlab.com/cake/golibs/intercom"
+)
+
+const testFixtures = `[
+  {"name": "user", "method": "get", "path": "/v1/users/:id", "result": {"id": "{{id}}"}, "headers": {"X-Mock": "1"}},
+  {"name": "missing", "path": "/v1/users", "code": 9990005, "message": "not found"},
+  {"name": "files", "method": "GET", "path": "/v1/files/*path", "body": "{{path}}", "headers": {"Content-Type": "text/plain"}},
+  {"name": "slow", "method": "POST", "path": "/v1/slow", "latency": "20ms", "result": {}},
+  {"name": "broken", "method": "GET", "path": "/v1/broken", "fault": {"rate": 1}},
+  {"name": "abort", "method": "GET", "path": "/v1/abort", "fault": {"rate": 1, "abort": true}}
+]`
+
+func newTestServer(t *testing.T) (*Server, *httptest.Server, *bytes.Buffer) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "test.json"), []byte(testFixtures), 0644); err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	s, err := NewServer(dir, NewRecorder(3, buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(s.Router())
+	t.Cleanup(srv.Close)
+	return s, srv, buf
+}
+
+func do(t *testing.T, method, url string, body string) (*http.Response, *intercom.JsonResponse) {
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	raw, _ := ioutil.ReadAll(resp.Body)
+	result := &intercom.JsonResponse{}
+	_ = json.Unmarshal(raw, result)
+	if result.Result == nil && result.Code == 0 {
+		result.Message = string(raw)
+	}
+	return resp, result
+}
+
+func TestServer(t *testing.T) {
+	s, srv, buf := newTestServer(t)
+
+	resp, result := do(t, http.MethodGet, srv.URL+"/v1/users/u1", "")
+	if resp.StatusCode != http.StatusOK || string(result.Result) != `{"id":"u1"}` || resp.Header.Get("X-Mock") != "1" {
+		t.Errorf("user = %d %s, want 200 with the id", resp.StatusCode, result.Result)
+	}
+	resp, result = do(t, http.MethodDelete, srv.URL+"/v1/users", "")
+	if resp.StatusCode != http.StatusNotFound || result.Code != gpt.CodeNotFound || result.Message != "not found" {
+		t.Errorf("missing = %d %d %s, want 404 of the code", resp.StatusCode, result.Code, result.Message)
+	}
+	resp, result = do(t, http.MethodGet, srv.URL+"/v1/files/a/b.txt", "")
+	if resp.Header.Get("Content-Type") != "text/plain" || result.Message != "a/b.txt" {
+		t.Errorf("files = %s %q, want the raw body", resp.Header.Get("Content-Type"), result.Message)
+	}
+	start := time.Now()
+	if do(t, http.MethodPost, srv.URL+"/v1/slow", `{"a":1}`); time.Since(start) < 20*time.Millisecond {
+		t.Errorf("slow fixture answered in %s", time.Since(start))
+	}
+	resp, result = do(t, http.MethodGet, srv.URL+"/v1/broken", "")
+	if resp.StatusCode != http.StatusInternalServerError || result.Code != gpt.CodeInternalServerError {
+		t.Errorf("fault = %d %d, want 500", resp.StatusCode, result.Code)
+	}
+	// a fresh connection, the transport resends an idempotent request aborted on a reused one
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	if _, err := client.Get(srv.URL + "/v1/abort"); err == nil {
+		t.Error("aborted fixture should fail the transport")
+	}
+	resp, result = do(t, http.MethodGet, srv.URL+"/v1/unknown", "")
+	if resp.StatusCode != http.StatusNotFound || result.Code != gpt.CodeRouteNotFound {
+		t.Errorf("unknown = %d %d, want 404", resp.StatusCode, result.Code)
+	}
+
+	records := s.recorder.Records()
+	if len(records) != 3 || records[0].Fixture != "broken" || records[1].Status != 0 || records[2].Fixture != "" {
+		t.Errorf("kept records = %+v, want the last 3", records)
+	}
+	if n := strings.Count(buf.String(), "\n"); n != 7 {
+		t.Errorf("written records = %d, want 7", n)
+	}
+	if !strings.Contains(buf.String(), `"body":"{\"a\":1}"`) {
+		t.Errorf("request body isn't recorded: %s", buf.String())
+	}
+
+	_, result = do(t, http.MethodGet, srv.URL+AdminPath+"/requests?path=/v1/unknown", "")
+	list := []Record{}
+	if err := json.Unmarshal(result.Result, &list); err != nil || len(list) != 1 {
+		t.Errorf("filtered records = %s, want 1", result.Result)
+	}
+	do(t, http.MethodDelete, srv.URL+AdminPath+"/requests", "")
+	if len(s.recorder.Records()) != 0 {
+		t.Error("records should be reset")
+	}
+}
+
+func TestRedactHeader(t *testing.T) {
+	_, srv, buf := newTestServer(t)
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/v1/users/u1", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Cookie", "session=secret")
+	req.Header.Set("X-Api-Key", "secret")
+	req.Header.Set("X-Mock", "visible")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	rec := Record{}
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "secret") || rec.Header.Get("Authorization") != redacted || rec.Header.Get("Cookie") != redacted {
+		t.Errorf("credentials should be redacted: %s", buf.String())
+	}
+	if rec.Header.Get("X-Mock") != "visible" {
+		t.Errorf("other headers should be recorded: %v", rec.Header)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	fixtures, err := Load("fixtures")
+	if err != nil || len(fixtures) == 0 {
+		t.Fatalf("example fixtures = %d, %v", len(fixtures), err)
+	}
+	for _, f := range fixtures {
+		if f.Name == "" || f.Status == 0 {
+			t.Errorf("fixture %+v isn't defaulted", f)
+		}
+	}
+
+	for _, bad := range []string{
+		`[{"path": "v1"}]`,
+		`[{"path": "/v1/*a/b"}]`,
+		`[{"path": "/v1", "latency": 10}]`,
+		`[{"path": "/v1", "fault": {"rate": 2}}]`,
+		`[{"path": "/v1", "result": {}, "body": "x"}]`,
+	} {
+		dir := t.TempDir()
+		_ = ioutil.WriteFile(filepath.Join(dir, "bad.json"), []byte(bad), 0644)
+		if _, err := Load(dir); err == nil {
+			t.Errorf("%s should be invalid", bad)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	f := &Fixture{Path: "/v1/:a/*rest"}
+	f.setDefault()
+	for path, want := range map[string]bool{
+		"/v1/x":     true,
+		"/v1/x/y/z": true,
+		"/v1":       false,
+		"/v2/x":     false,
+	} {
+		if _, ok := f.match(http.MethodGet, path); ok != want {
+			t.Errorf("match %s = %v, want %v", path, ok, want)
+		}
+	}
+	params, _ := f.match(http.MethodGet, "/v1/x/y/z")
+	if params["a"] != "x" || params["rest"] != "y/z" {
+		t.Errorf("params = %v", params)
+	}
+}
@@ -0,0 +1,111 @@
+package mock
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRecordBody truncates the recorded request bodies
+	maxRecordBody = 64 * 1024
+
+	redacted = "[REDACTED]"
+)
+
+// sensitiveHeaders are recorded as redacted, the keys are canonical
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Api-Key":           true,
+	"X-Auth-Token":        true,
+}
+
+// redactHeader returns a copy of h with the values of the sensitive headers redacted
+func redactHeader(h http.Header) http.Header {
+	ret := h.Clone()
+	for k, v := range ret {
+		if sensitiveHeaders[http.CanonicalHeaderKey(k)] {
+			for i := range v {
+				v[i] = redacted
+			}
+		}
+	}
+	return ret
+}
+
+// Record is one request served by the mock server, the credentials of Header are redacted
+type Record struct {
+	Time   time.Time   `json:"time"`
+	Method string      `json:"method"`
+	Path   string      `json:"path"`
+	Query  string      `json:"query,omitempty"`
+	Header http.Header `json:"header"`
+	Body   string      `json:"body,omitempty"`
+	// Fixture is empty when no fixture matches
+	Fixture string `json:"fixture,omitempty"`
+	// Status is 0 when the connection is aborted
+	Status   int      `json:"status"`
+	Fault    bool     `json:"fault,omitempty"`
+	Duration Duration `json:"duration"`
+}
+
+// Recorder keeps the last records in memory and writes every record as a JSON line to w if any
+type Recorder struct {
+	mu      sync.Mutex
+	max     int
+	records []Record
+	next    int
+	enc     *json.Encoder
+}
+
+// NewRecorder returns the recorder of the last max records, w is optional
+func NewRecorder(max int, w io.Writer) *Recorder {
+	r := &Recorder{max: max}
+	if w != nil {
+		r.enc = json.NewEncoder(w)
+	}
+	return r
+}
+
+// Add records rec, the oldest record is dropped beyond max
+func (r *Recorder) Add(rec Record) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.max > 0 {
+		if len(r.records) < r.max {
+			r.records = append(r.records, rec)
+		} else {
+			r.records[r.next] = rec
+		}
+		r.next = (r.next + 1) % r.max
+	}
+	if r.enc != nil {
+		return r.enc.Encode(rec)
+	}
+	return nil
+}
+
+// Records returns the kept records from the oldest
+func (r *Recorder) Records() []Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ret := make([]Record, 0, len(r.records))
+	if len(r.records) == r.max {
+		ret = append(ret, r.records[r.next:]...)
+		return append(ret, r.records[:r.next]...)
+	}
+	return append(ret, r.records...)
+}
+
+// Reset drops the kept records
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = nil
+	r.next = 0
+}
@@ -0,0 +1,218 @@
+package mock
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/cake/go-project-template/gpt"
+	"gitlab.com/cake/goctx"
+	"gitlab.com/cake/golibs/intercom"
+	"gitlab.com/cake/gopkg"
+	"gitlab.com/cake/m800log"
+)
+
+const (
+	// AdminPath serves the fixtures and the records, its endpoints take precedence over the fixtures
+	AdminPath = "/_mock"
+)
+
+// Server serves the fixtures of a directory and records the requests
+type Server struct {
+	dir      string
+	recorder *Recorder
+
+	mu       sync.RWMutex
+	fixtures []*Fixture
+}
+
+// NewServer loads the fixtures of dir
+func NewServer(dir string, recorder *Recorder) (*Server, error) {
+	s := &Server{dir: dir, recorder: recorder}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads the fixtures of the directory again, the loaded fixtures are kept on error
+func (s *Server) Reload() error {
+	fixtures, err := Load(s.dir)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fixtures = fixtures
+	return nil
+}
+
+// Fixtures returns the loaded fixtures in match order
+func (s *Server) Fixtures() []*Fixture {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.fixtures
+}
+
+// Router returns the gin router of the admin endpoints and the fixtures
+func (s *Server) Router() *gin.Engine {
+	router := gin.New()
+	router.Use(intercom.M800Recovery(gpt.CodeInternalServerError))
+
+	admin := router.Group(AdminPath)
+	{
+		admin.GET("/fixtures", s.listFixtures)
+		admin.POST("/reload", s.reload)
+		admin.GET("/requests", s.listRequests)
+		admin.DELETE("/requests", s.resetRequests)
+	}
+	router.NoRoute(s.serve)
+	return router
+}
+
+func (s *Server) match(method, path string) (*Fixture, map[string]string) {
+	for _, f := range s.Fixtures() {
+		if params, ok := f.match(method, path); ok {
+			return f, params
+		}
+	}
+	return nil, nil
+}
+
+func (s *Server) serve(c *gin.Context) {
+	ctx := intercom.GetContextFromGin(c)
+	start := time.Now()
+	body, _ := ioutil.ReadAll(io.LimitReader(c.Request.Body, maxRecordBody))
+	rec := Record{
+		Time:   start,
+		Method: c.Request.Method,
+		Path:   c.Request.URL.Path,
+		Query:  c.Request.URL.RawQuery,
+		Header: redactHeader(c.Request.Header),
+		Body:   string(body),
+	}
+	defer func() {
+		rec.Duration = Duration(time.Since(start))
+		if err := s.recorder.Add(rec); err != nil {
+			m800log.Errorf(ctx, "[mock] record error: %v", err)
+		}
+	}()
+
+	f, params := s.match(c.Request.Method, c.Request.URL.Path)
+	if f == nil {
+		rec.Status = http.StatusNotFound
+		intercom.GinErrorStatus(c, rec.Status, gopkg.NewCodeError(gpt.CodeRouteNotFound, "no fixture matches "+rec.Method+" "+rec.Path))
+		return
+	}
+	rec.Fixture = f.Name
+
+	if !wait(c.Request.Context(), f) {
+		return
+	}
+	for k, v := range f.Headers {
+		c.Header(k, v)
+	}
+
+	if f.Fault.Rate > 0 && rand.Float64() < f.Fault.Rate {
+		rec.Fault = true
+		if f.Fault.Abort {
+			abort(ctx, c)
+			return
+		}
+		rec.Status = f.Fault.Status
+		intercom.GinErrorStatus(c, rec.Status, gopkg.NewCodeError(f.Fault.Code, f.Fault.Message))
+		return
+	}
+
+	rec.Status = f.Status
+	if f.Body != "" {
+		contentType := f.Headers["Content-Type"]
+		if contentType == "" {
+			contentType = intercom.HeaderJSON
+		}
+		c.Data(rec.Status, contentType, []byte(render(f.Body, params, false)))
+		return
+	}
+	response := intercom.Response{
+		Code:    f.Code,
+		Message: f.Message,
+		CID:     c.GetHeader(goctx.HTTPHeaderCID),
+	}
+	if len(f.Result) > 0 {
+		response.Result = rawJSON(render(string(f.Result), params, true))
+	}
+	c.JSON(rec.Status, response)
+}
+
+// wait sleeps the latency of the fixture, false if the client is gone
+func wait(ctx context.Context, f *Fixture) bool {
+	d := time.Duration(f.Latency)
+	if f.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(f.Jitter) + 1))
+	}
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// abort closes the connection without writing a response
+func abort(ctx goctx.Context, c *gin.Context) {
+	conn, _, err := c.Writer.Hijack()
+	if err != nil {
+		m800log.Errorf(ctx, "[mock] hijack error: %v", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	_ = conn.Close()
+}
+
+// rawJSON is a rendered result marshaled as is
+type rawJSON string
+
+func (r rawJSON) MarshalJSON() ([]byte, error) {
+	return []byte(r), nil
+}
+
+func (s *Server) listFixtures(c *gin.Context) {
+	intercom.GinOKResponse(c, s.Fixtures())
+}
+
+func (s *Server) reload(c *gin.Context) {
+	if err := s.Reload(); err != nil {
+		intercom.GinError(c, gopkg.NewCodeError(gpt.CodeBadRequest, err.Error()))
+		return
+	}
+	intercom.GinOKResponse(c, s.Fixtures())
+}
+
+func (s *Server) listRequests(c *gin.Context) {
+	records := s.recorder.Records()
+	if path := c.Query("path"); path != "" {
+		filtered := []Record{}
+		for _, r := range records {
+			if r.Path == path {
+				filtered = append(filtered, r)
+			}
+		}
+		records = filtered
+	}
+	intercom.GinOKResponse(c, records)
+}
+
+func (s *Server) resetRequests(c *gin.Context) {
+	s.recorder.Reset()
+	intercom.GinOKResponse(c, nil)
+}
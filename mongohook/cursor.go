@@ -0,0 +1,92 @@
+package mongohook
+
+import (
+	"context"
+	"sync"
+
+	"gitlab.com/cake/goctx"
+	"gitlab.com/cake/gopkg"
+	"gitlab.com/cake/mgopool/v3"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// cursor ends the GetCursor operation when it's closed, the hooks see the whole iteration
+// and the context they return stays usable until then
+type cursor struct {
+	mgopool.MongoCursor
+	done     func(res Result)
+	once     sync.Once
+	returned int64
+}
+
+func (c *cursor) Next(ctx context.Context) bool {
+	ok := c.MongoCursor.Next(ctx)
+	if ok {
+		c.returned++
+	}
+	return ok
+}
+
+func (c *cursor) TryNext(ctx context.Context) bool {
+	ok := c.MongoCursor.TryNext(ctx)
+	if ok {
+		c.returned++
+	}
+	return ok
+}
+
+func (c *cursor) Close(ctx context.Context) (err gopkg.CodeError) {
+	err = c.MongoCursor.Close(ctx)
+	c.once.Do(func() { c.done(Result{Err: err, Counts: count(CountReturned, c.returned)}) })
+	return
+}
+
+// AggregateCursor ends the Aggregate operation when it's closed, like the cursor of GetCursor
+type AggregateCursor struct {
+	*mongo.Cursor
+	done     func(res Result)
+	once     sync.Once
+	returned int64
+}
+
+// Aggregate opens an aggregation cursor of client with the hooks of p, mgopool only returns
+// whole aggregation results. The operation ends when the returned cursor is closed, which the caller must do
+func (p *Pool) Aggregate(ctx goctx.Context, client *mongo.Client, dbName, collection string, pipeline interface{}, opts ...*options.AggregateOptions) (*AggregateCursor, gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "Aggregate", DB: dbName, Collection: collection, Filter: pipeline})
+	c, errDB := client.Database(dbName).Collection(collection).Aggregate(ctx.NativeContext(), pipeline, opts...)
+	if errDB != nil {
+		err := gopkg.NewCodeError(mgopool.UnknownError, errDB.Error())
+		done(Result{Err: err})
+		return nil, err
+	}
+	return &AggregateCursor{Cursor: c, done: done}, nil
+}
+
+func (c *AggregateCursor) Next(ctx context.Context) bool {
+	ok := c.Cursor.Next(ctx)
+	if ok {
+		c.returned++
+	}
+	return ok
+}
+
+func (c *AggregateCursor) TryNext(ctx context.Context) bool {
+	ok := c.Cursor.TryNext(ctx)
+	if ok {
+		c.returned++
+	}
+	return ok
+}
+
+func (c *AggregateCursor) Close(ctx context.Context) error {
+	errDB := c.Cursor.Close(ctx)
+	c.once.Do(func() {
+		var err gopkg.CodeError
+		if errDB != nil {
+			err = gopkg.NewCodeError(mgopool.UnknownError, errDB.Error())
+		}
+		c.done(Result{Err: err, Counts: count(CountReturned, c.returned)})
+	})
+	return errDB
+}
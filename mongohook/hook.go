@@ -0,0 +1,70 @@
+package mongohook
+
+import (
+	"gitlab.com/cake/goctx"
+	"gitlab.com/cake/gopkg"
+	"gitlab.com/cake/mgopool/v3"
+)
+
+// Operation describes one call of mgopool.MongoPool, Name is the method name, e.g. QueryAll
+type Operation struct {
+	Name       string
+	DB         string
+	Collection string
+	// Filter is the selector, pipeline or command of the operation as passed by the caller, nil if none
+	Filter interface{}
+	// Documents is the number of documents written by Insert and the bulk operations
+	Documents int
+}
+
+// Result of an operation, Counts holds the Count* keys known from the result of the method.
+// New details of the result are added as fields, the Hook signature stays the same
+type Result struct {
+	Err    gopkg.CodeError
+	Counts map[string]int64
+}
+
+// Hook is called before the operation, the returned context is passed to the pool
+// and done is called with the result
+type Hook func(ctx goctx.Context, op Operation) (next goctx.Context, done func(res Result))
+
+var _ mgopool.MongoPool = (*Pool)(nil)
+
+// Pool calls the hooks around every context aware operation of the wrapped pool,
+// the other methods and GetBulk are passed through
+type Pool struct {
+	mgopool.MongoPool
+	hooks []Hook
+}
+
+// Wrap returns p with the hooks, it's usually set by mgopool.SetExportedPool
+func Wrap(p mgopool.MongoPool, hooks ...Hook) *Pool {
+	if wrapped, ok := p.(*Pool); ok {
+		return &Pool{MongoPool: wrapped.MongoPool, hooks: append(append([]Hook{}, wrapped.hooks...), hooks...)}
+	}
+	return &Pool{MongoPool: p, hooks: hooks}
+}
+
+// Unwrap returns the wrapped pool
+func (p *Pool) Unwrap() mgopool.MongoPool {
+	return p.MongoPool
+}
+
+// start runs the hooks in order and returns the done funcs in reverse order
+func (p *Pool) start(ctx goctx.Context, op Operation) (goctx.Context, func(res Result)) {
+	dones := make([]func(res Result), 0, len(p.hooks))
+	for _, h := range p.hooks {
+		next, done := h(ctx, op)
+		if next != nil {
+			ctx = next
+		}
+		if done != nil {
+			dones = append(dones, done)
+		}
+	}
+	return ctx, func(res Result) {
+		for i := len(dones) - 1; i >= 0; i-- {
+			dones[i](res)
+		}
+	}
+}
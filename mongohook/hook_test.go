@@ -0,0 +1,82 @@
+package mongohook
+
+import (
+	"testing"
+
+	"gitlab.com/cake/goctx"
+	"gitlab.com/cake/gopkg"
+	"gitlab.com/cake/mgopool/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type fakePool struct {
+	mgopool.MongoPool
+	ctx goctx.Context
+	err gopkg.CodeError
+}
+
+func (p *fakePool) RemoveAll(ctx goctx.Context, dbName, collection string, selector interface{}) (int, gopkg.CodeError) {
+	p.ctx = ctx
+	return 2, p.err
+}
+
+func TestHooks(t *testing.T) {
+	var calls []string
+	hook := func(name string) Hook {
+		return func(ctx goctx.Context, op Operation) (goctx.Context, func(res Result)) {
+			calls = append(calls, name+" "+op.Name+" "+op.DB+"."+op.Collection)
+			next := goctx.CopyContext(ctx)
+			next.Set(name, true)
+			return next, func(res Result) {
+				calls = append(calls, name+" done")
+				if res.Counts[CountDeleted] != 2 || res.Err == nil {
+					t.Errorf("%s got result %+v", name, res)
+				}
+			}
+		}
+	}
+	fake := &fakePool{err: gopkg.NewCodeError(1, "failed")}
+	p := Wrap(Wrap(fake, hook("a")), hook("b"))
+	if p.Unwrap() != fake {
+		t.Error("wrapping twice should keep the hooks on the same pool")
+	}
+
+	if _, err := p.RemoveAll(goctx.Background(), "db", "c", nil); err == nil {
+		t.Error("the pool error should be returned")
+	}
+	want := []string{"a RemoveAll db.c", "b RemoveAll db.c", "b done", "a done"}
+	if len(calls) != len(want) {
+		t.Fatalf("calls = %v, want %v", calls, want)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Errorf("calls = %v, want %v", calls, want)
+			break
+		}
+	}
+	if fake.ctx.Get("a") == nil || fake.ctx.Get("b") == nil {
+		t.Error("the contexts returned by the hooks should be passed to the pool")
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	var ops []string
+	var res Result
+	p := Wrap(&fakePool{}, func(ctx goctx.Context, op Operation) (goctx.Context, func(r Result)) {
+		ops = append(ops, op.Name+" "+op.DB+"."+op.Collection)
+		return ctx, func(r Result) { res = r }
+	})
+	// the client is never connected, so the aggregation fails without a server
+	client, err := mongo.NewClient(options.Client().ApplyURI("mongodb://localhost:27017"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err := p.Aggregate(goctx.Background(), client, "db", "c", []bson.M{}); c != nil || err == nil {
+		t.Fatalf("aggregate on a disconnected client should fail, got %v %v", c, err)
+	}
+	if len(ops) != 1 || ops[0] != "Aggregate db.c" || res.Err == nil {
+		t.Errorf("the hooks should see the failed aggregation, got %v %+v", ops, res)
+	}
+}
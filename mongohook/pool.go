@@ -0,0 +1,283 @@
+package mongohook
+
+import (
+	"gitlab.com/cake/goctx"
+	"gitlab.com/cake/gopkg"
+	"gitlab.com/cake/mgopool/v3"
+	"gitlab.com/cake/mgopool/v3/compat"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Methods of mgopool.MongoPool with context, keep in sync with the interface
+
+func (p *Pool) Ping(ctx goctx.Context) (err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "Ping"})
+	defer func() { done(Result{Err: err}) }()
+	return p.MongoPool.Ping(ctx)
+}
+
+func (p *Pool) PingPref(ctx goctx.Context, pref *readpref.ReadPref) (err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "PingPref"})
+	defer func() { done(Result{Err: err}) }()
+	return p.MongoPool.PingPref(ctx, pref)
+}
+
+func (p *Pool) GetCollectionNames(ctx goctx.Context, dbName string) (names []string, err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "GetCollectionNames", DB: dbName})
+	defer func() { done(Result{Err: err}) }()
+	return p.MongoPool.GetCollectionNames(ctx, dbName)
+}
+
+func (p *Pool) CollectionCount(ctx goctx.Context, dbName, collection string) (n int, err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "CollectionCount", DB: dbName, Collection: collection})
+	defer func() { done(Result{Err: err, Counts: count(CountReturned, int64(n))}) }()
+	return p.MongoPool.CollectionCount(ctx, dbName, collection)
+}
+
+func (p *Pool) Run(ctx goctx.Context, cmd interface{}, result interface{}) (err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "Run", Filter: cmd})
+	defer func() { done(Result{Err: err}) }()
+	return p.MongoPool.Run(ctx, cmd, result)
+}
+
+func (p *Pool) DBRun(ctx goctx.Context, dbName string, cmd, result interface{}) (err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "DBRun", DB: dbName, Filter: cmd})
+	defer func() { done(Result{Err: err}) }()
+	return p.MongoPool.DBRun(ctx, dbName, cmd, result)
+}
+
+func (p *Pool) Insert(ctx goctx.Context, dbName, collection string, doc interface{}) (err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "Insert", DB: dbName, Collection: collection, Documents: 1})
+	defer func() { done(Result{Err: err}) }()
+	return p.MongoPool.Insert(ctx, dbName, collection, doc)
+}
+
+func (p *Pool) Remove(ctx goctx.Context, dbName, collection string, selector interface{}) (err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "Remove", DB: dbName, Collection: collection, Filter: selector})
+	defer func() { done(Result{Err: err}) }()
+	return p.MongoPool.Remove(ctx, dbName, collection, selector)
+}
+
+func (p *Pool) RemoveAll(ctx goctx.Context, dbName, collection string, selector interface{}) (removedCount int, err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "RemoveAll", DB: dbName, Collection: collection, Filter: selector})
+	defer func() { done(Result{Err: err, Counts: count(CountDeleted, int64(removedCount))}) }()
+	return p.MongoPool.RemoveAll(ctx, dbName, collection, selector)
+}
+
+func (p *Pool) Update(ctx goctx.Context, dbName, collection string, selector interface{}, update interface{}) (err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "Update", DB: dbName, Collection: collection, Filter: selector})
+	defer func() { done(Result{Err: err}) }()
+	return p.MongoPool.Update(ctx, dbName, collection, selector, update)
+}
+
+func (p *Pool) ReplaceOne(ctx goctx.Context, dbName, collection string, selector interface{}, update interface{}, upsert bool) (err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "ReplaceOne", DB: dbName, Collection: collection, Filter: selector})
+	defer func() { done(Result{Err: err}) }()
+	return p.MongoPool.ReplaceOne(ctx, dbName, collection, selector, update, upsert)
+}
+
+func (p *Pool) UpdateAll(ctx goctx.Context, dbName, collection string, selector interface{}, update interface{}) (result *mongo.UpdateResult, err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "UpdateAll", DB: dbName, Collection: collection, Filter: selector})
+	defer func() { done(Result{Err: err, Counts: updateCounts(result)}) }()
+	return p.MongoPool.UpdateAll(ctx, dbName, collection, selector, update)
+}
+
+func (p *Pool) UpdateId(ctx goctx.Context, dbName, collection string, id interface{}, update interface{}) (err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "UpdateId", DB: dbName, Collection: collection, Filter: bson.M{"_id": id}})
+	defer func() { done(Result{Err: err}) }()
+	return p.MongoPool.UpdateId(ctx, dbName, collection, id, update)
+}
+
+func (p *Pool) UpdateWithArrayFilters(ctx goctx.Context, dbName, collection string, selector interface{}, update interface{}, arrayFilters interface{}, multi bool) (result *mongo.UpdateResult, err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "UpdateWithArrayFilters", DB: dbName, Collection: collection, Filter: selector})
+	defer func() { done(Result{Err: err, Counts: updateCounts(result)}) }()
+	return p.MongoPool.UpdateWithArrayFilters(ctx, dbName, collection, selector, update, arrayFilters, multi)
+}
+
+func (p *Pool) Upsert(ctx goctx.Context, dbName, collection string, selector interface{}, update interface{}) (result *mongo.UpdateResult, err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "Upsert", DB: dbName, Collection: collection, Filter: selector})
+	defer func() { done(Result{Err: err, Counts: updateCounts(result)}) }()
+	return p.MongoPool.Upsert(ctx, dbName, collection, selector, update)
+}
+
+func (p *Pool) BulkInsert(ctx goctx.Context, dbName, collection string, documents []bson.M) (result *mgopool.BulkResult, err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "BulkInsert", DB: dbName, Collection: collection, Documents: len(documents)})
+	defer func() { done(Result{Err: err, Counts: bulkCounts(result)}) }()
+	return p.MongoPool.BulkInsert(ctx, dbName, collection, documents)
+}
+
+func (p *Pool) BulkUpsert(ctx goctx.Context, dbName, collection string, selectors []bson.M, documents []bson.M) (result *mgopool.BulkResult, err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "BulkUpsert", DB: dbName, Collection: collection, Filter: selectors, Documents: len(documents)})
+	defer func() { done(Result{Err: err, Counts: bulkCounts(result)}) }()
+	return p.MongoPool.BulkUpsert(ctx, dbName, collection, selectors, documents)
+}
+
+func (p *Pool) BulkUpdate(ctx goctx.Context, dbName, collection string, selectors []bson.M, documents []bson.M) (result *mgopool.BulkResult, err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "BulkUpdate", DB: dbName, Collection: collection, Filter: selectors, Documents: len(documents)})
+	defer func() { done(Result{Err: err, Counts: bulkCounts(result)}) }()
+	return p.MongoPool.BulkUpdate(ctx, dbName, collection, selectors, documents)
+}
+
+func (p *Pool) BulkInsertInterfaces(ctx goctx.Context, dbName, collection string, documents []interface{}) (result *mgopool.BulkResult, err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "BulkInsertInterfaces", DB: dbName, Collection: collection, Documents: len(documents)})
+	defer func() { done(Result{Err: err, Counts: bulkCounts(result)}) }()
+	return p.MongoPool.BulkInsertInterfaces(ctx, dbName, collection, documents)
+}
+
+func (p *Pool) BulkUpsertInterfaces(ctx goctx.Context, dbName, collection string, selectors []bson.M, documents []interface{}) (result *mgopool.BulkResult, err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "BulkUpsertInterfaces", DB: dbName, Collection: collection, Filter: selectors, Documents: len(documents)})
+	defer func() { done(Result{Err: err, Counts: bulkCounts(result)}) }()
+	return p.MongoPool.BulkUpsertInterfaces(ctx, dbName, collection, selectors, documents)
+}
+
+func (p *Pool) BulkUpdateInterfaces(ctx goctx.Context, dbName, collection string, selectors []bson.M, documents []interface{}) (result *mgopool.BulkResult, err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "BulkUpdateInterfaces", DB: dbName, Collection: collection, Filter: selectors, Documents: len(documents)})
+	defer func() { done(Result{Err: err, Counts: bulkCounts(result)}) }()
+	return p.MongoPool.BulkUpdateInterfaces(ctx, dbName, collection, selectors, documents)
+}
+
+func (p *Pool) BulkDelete(ctx goctx.Context, dbName, collection string, documents []bson.M) (result *mgopool.BulkResult, err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "BulkDelete", DB: dbName, Collection: collection, Documents: len(documents)})
+	defer func() { done(Result{Err: err, Counts: bulkCounts(result)}) }()
+	return p.MongoPool.BulkDelete(ctx, dbName, collection, documents)
+}
+
+func (p *Pool) QueryCount(ctx goctx.Context, dbName, collection string, selector interface{}) (n int, err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "QueryCount", DB: dbName, Collection: collection, Filter: selector})
+	defer func() { done(Result{Err: err, Counts: count(CountReturned, int64(n))}) }()
+	return p.MongoPool.QueryCount(ctx, dbName, collection, selector)
+}
+
+func (p *Pool) QueryCountWithOptions(ctx goctx.Context, dbName, collection string, selector interface{}, skip, limit int) (n int, err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "QueryCountWithOptions", DB: dbName, Collection: collection, Filter: selector})
+	defer func() { done(Result{Err: err, Counts: count(CountReturned, int64(n))}) }()
+	return p.MongoPool.QueryCountWithOptions(ctx, dbName, collection, selector, skip, limit)
+}
+
+func (p *Pool) QueryAll(ctx goctx.Context, dbName, collection string, result, selector, fields interface{}, skip, limit int, sort ...string) (err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "QueryAll", DB: dbName, Collection: collection, Filter: selector})
+	defer func() { done(Result{Err: err, Counts: returnedCounts(result, err)}) }()
+	return p.MongoPool.QueryAll(ctx, dbName, collection, result, selector, fields, skip, limit, sort...)
+}
+
+func (p *Pool) QueryAllWithCollation(ctx goctx.Context, dbName, collection string, result, selector, fields interface{}, collation *options.Collation, skip, limit int, sort ...string) (err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "QueryAllWithCollation", DB: dbName, Collection: collection, Filter: selector})
+	defer func() { done(Result{Err: err, Counts: returnedCounts(result, err)}) }()
+	return p.MongoPool.QueryAllWithCollation(ctx, dbName, collection, result, selector, fields, collation, skip, limit, sort...)
+}
+
+func (p *Pool) QueryOne(ctx goctx.Context, dbName, collection string, result, selector, fields interface{}, skip int, sort ...string) (err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "QueryOne", DB: dbName, Collection: collection, Filter: selector})
+	defer func() { done(Result{Err: err, Counts: foundCounts(err)}) }()
+	return p.MongoPool.QueryOne(ctx, dbName, collection, result, selector, fields, skip, sort...)
+}
+
+func (p *Pool) FindAndModify(ctx goctx.Context, dbName, collection string, result, selector, update, fields interface{}, upsert, returnNew bool, sort ...string) (err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "FindAndModify", DB: dbName, Collection: collection, Filter: selector})
+	defer func() { done(Result{Err: err, Counts: foundCounts(err)}) }()
+	return p.MongoPool.FindAndModify(ctx, dbName, collection, result, selector, update, fields, upsert, returnNew, sort...)
+}
+
+func (p *Pool) FindAndReplace(ctx goctx.Context, dbName, collection string, result, selector, replacement, fields interface{}, upsert, returnNew bool, sort ...string) (err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "FindAndReplace", DB: dbName, Collection: collection, Filter: selector})
+	defer func() { done(Result{Err: err, Counts: foundCounts(err)}) }()
+	return p.MongoPool.FindAndReplace(ctx, dbName, collection, result, selector, replacement, fields, upsert, returnNew, sort...)
+}
+
+func (p *Pool) FindAndRemove(ctx goctx.Context, dbName, collection string, result, selector, fields interface{}, sort ...string) (err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "FindAndRemove", DB: dbName, Collection: collection, Filter: selector})
+	defer func() { done(Result{Err: err, Counts: foundCounts(err)}) }()
+	return p.MongoPool.FindAndRemove(ctx, dbName, collection, result, selector, fields, sort...)
+}
+
+func (p *Pool) FindAndModifyWithArrayFilters(ctx goctx.Context, dbName, collection string, result, selector, update, fields interface{}, upsert, returnNew bool, arrayFilters interface{}, sort ...string) (err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "FindAndModifyWithArrayFilters", DB: dbName, Collection: collection, Filter: selector})
+	defer func() { done(Result{Err: err, Counts: foundCounts(err)}) }()
+	return p.MongoPool.FindAndModifyWithArrayFilters(ctx, dbName, collection, result, selector, update, fields, upsert, returnNew, arrayFilters, sort...)
+}
+
+func (p *Pool) Indexes(ctx goctx.Context, dbName, collection string) (result []map[string]interface{}, err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "Indexes", DB: dbName, Collection: collection})
+	defer func() { done(Result{Err: err, Counts: count(CountReturned, int64(len(result)))}) }()
+	return p.MongoPool.Indexes(ctx, dbName, collection)
+}
+
+func (p *Pool) CreateIndex(ctx goctx.Context, dbName, collection string, key []string, sparse, unique bool, name string) (err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "CreateIndex", DB: dbName, Collection: collection})
+	defer func() { done(Result{Err: err}) }()
+	return p.MongoPool.CreateIndex(ctx, dbName, collection, key, sparse, unique, name)
+}
+
+func (p *Pool) CreateTTLIndex(ctx goctx.Context, dbName, collection string, key string, ttlSec int) (err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "CreateTTLIndex", DB: dbName, Collection: collection})
+	defer func() { done(Result{Err: err}) }()
+	return p.MongoPool.CreateTTLIndex(ctx, dbName, collection, key, ttlSec)
+}
+
+func (p *Pool) EnsureIndex(ctx goctx.Context, dbName, collection string, index mongo.IndexModel) (err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "EnsureIndex", DB: dbName, Collection: collection})
+	defer func() { done(Result{Err: err}) }()
+	return p.MongoPool.EnsureIndex(ctx, dbName, collection, index)
+}
+
+func (p *Pool) EnsureIndexCompat(ctx goctx.Context, dbName, collection string, index compat.Index) (err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "EnsureIndexCompat", DB: dbName, Collection: collection})
+	defer func() { done(Result{Err: err}) }()
+	return p.MongoPool.EnsureIndexCompat(ctx, dbName, collection, index)
+}
+
+func (p *Pool) DropIndex(ctx goctx.Context, dbName, collection string, keys []string) (err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "DropIndex", DB: dbName, Collection: collection})
+	defer func() { done(Result{Err: err}) }()
+	return p.MongoPool.DropIndex(ctx, dbName, collection, keys)
+}
+
+func (p *Pool) DropIndexName(ctx goctx.Context, dbName, collection, name string) (err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "DropIndexName", DB: dbName, Collection: collection})
+	defer func() { done(Result{Err: err}) }()
+	return p.MongoPool.DropIndexName(ctx, dbName, collection, name)
+}
+
+func (p *Pool) CreateCollection(ctx goctx.Context, dbName, collection string, opts *options.CreateCollectionOptions) (err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "CreateCollection", DB: dbName, Collection: collection})
+	defer func() { done(Result{Err: err}) }()
+	return p.MongoPool.CreateCollection(ctx, dbName, collection, opts)
+}
+
+func (p *Pool) DropCollection(ctx goctx.Context, dbName, collection string) (err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "DropCollection", DB: dbName, Collection: collection})
+	defer func() { done(Result{Err: err}) }()
+	return p.MongoPool.DropCollection(ctx, dbName, collection)
+}
+
+func (p *Pool) RenameCollection(ctx goctx.Context, dbName, oldName, newName string) (err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "RenameCollection", DB: dbName, Collection: oldName})
+	defer func() { done(Result{Err: err}) }()
+	return p.MongoPool.RenameCollection(ctx, dbName, oldName, newName)
+}
+
+func (p *Pool) Pipe(ctx goctx.Context, dbName, collection string, pipeline, result interface{}) (err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "Pipe", DB: dbName, Collection: collection, Filter: pipeline})
+	defer func() { done(Result{Err: err, Counts: returnedCounts(result, err)}) }()
+	return p.MongoPool.Pipe(ctx, dbName, collection, pipeline, result)
+}
+
+// GetCursor ends the operation when the returned cursor is closed, which the caller must do
+func (p *Pool) GetCursor(ctx goctx.Context, dbName, collection string, selector interface{}, option ...*options.FindOptions) (mgopool.MongoCursor, gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "GetCursor", DB: dbName, Collection: collection, Filter: selector})
+	c, err := p.MongoPool.GetCursor(ctx, dbName, collection, selector, option...)
+	if err != nil {
+		done(Result{Err: err})
+		return nil, err
+	}
+	return &cursor{MongoCursor: c, done: done}, nil
+}
+
+func (p *Pool) Distinct(ctx goctx.Context, dbName, collection string, selector bson.M, field string, result interface{}) (err gopkg.CodeError) {
+	ctx, done := p.start(ctx, Operation{Name: "Distinct", DB: dbName, Collection: collection, Filter: selector})
+	defer func() { done(Result{Err: err, Counts: returnedCounts(result, err)}) }()
+	return p.MongoPool.Distinct(ctx, dbName, collection, selector, field, result)
+}
@@ -0,0 +1,76 @@
+package mongohook
+
+import (
+	"reflect"
+
+	"gitlab.com/cake/gopkg"
+	"gitlab.com/cake/mgopool/v3"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Keys of Result.Counts
+const (
+	CountMatched  = "matched"
+	CountModified = "modified"
+	CountUpserted = "upserted"
+	CountDeleted  = "deleted"
+	CountInserted = "inserted"
+	// CountReturned is the number of returned documents, or the result of the count operations
+	CountReturned = "returned"
+)
+
+func count(key string, n int64) map[string]int64 {
+	return map[string]int64{key: n}
+}
+
+func updateCounts(result *mongo.UpdateResult) map[string]int64 {
+	if result == nil {
+		return nil
+	}
+	return map[string]int64{
+		CountMatched:  result.MatchedCount,
+		CountModified: result.ModifiedCount,
+		CountUpserted: result.UpsertedCount,
+	}
+}
+
+// bulkCounts reads the counts of the bulk result, the partial result of a failed bulk write is counted too
+func bulkCounts(result *mgopool.BulkResult) map[string]int64 {
+	if result == nil {
+		return nil
+	}
+	ret := map[string]int64{
+		CountMatched:  result.MatchedCount,
+		CountModified: result.ModifiedCount,
+		CountUpserted: result.UpsertedCount,
+		CountDeleted:  result.DeletedCount,
+		CountInserted: result.InsertedCount,
+	}
+	if n := int64(len(result.InsertedIDs)); n > ret[CountInserted] {
+		ret[CountInserted] = n
+	}
+	return ret
+}
+
+// returnedCounts counts the decoded documents of the result slice pointer
+func returnedCounts(result interface{}, err gopkg.CodeError) map[string]int64 {
+	if err != nil {
+		return nil
+	}
+	v := reflect.ValueOf(result)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil
+	}
+	return count(CountReturned, int64(v.Len()))
+}
+
+// foundCounts counts the document of the single document operations, which fail if nothing is found
+func foundCounts(err gopkg.CodeError) map[string]int64 {
+	if err != nil {
+		return nil
+	}
+	return count(CountReturned, 1)
+}
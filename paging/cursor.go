@@ -0,0 +1,116 @@
+package paging
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+
+	secret   []byte
+	secretMu sync.RWMutex
+)
+
+func init() {
+	// random secret only works in a single replica, SetSecret should be called with the shared one
+	secret = make([]byte, 32)
+	_, _ = rand.Read(secret)
+}
+
+// SetSecret sets the HMAC key signing the cursors, all replicas must share the same secret
+func SetSecret(s string) {
+	if s == "" {
+		return
+	}
+	secretMu.Lock()
+	defer secretMu.Unlock()
+	secret = []byte(s)
+}
+
+// cursorPayload is the position after the last returned document
+type cursorPayload struct {
+	// Sort is the resolved sort of document fields the cursor was issued for
+	Sort []string `bson:"s"`
+	// Filter is the digest of the filter the cursor was issued for
+	Filter string `bson:"f"`
+	// Values are the sort field values of the last document
+	Values bson.A `bson:"v"`
+}
+
+func sign(data []byte) []byte {
+	secretMu.RLock()
+	defer secretMu.RUnlock()
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func encodeCursor(p cursorPayload) (string, error) {
+	data, err := bson.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data) + "." + base64.RawURLEncoding.EncodeToString(sign(data)), nil
+}
+
+func decodeCursor(s string) (p cursorPayload, err error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 2 {
+		return p, ErrInvalidCursor
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return p, ErrInvalidCursor
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(mac, sign(data)) {
+		return p, ErrInvalidCursor
+	}
+	if err = bson.Unmarshal(data, &p); err != nil {
+		return p, ErrInvalidCursor
+	}
+	return p, nil
+}
+
+// keysetSelector returns the condition of documents after the cursor position for the given sort:
+// {$or: [{f1 > v1}, {f1 = v1, f2 > v2}, ...]}, where ">" is "$lt" for descending fields.
+// Null and missing values sort before the others, so nothing is after null in descending order,
+// every value is after null in ascending order and null is after every value in descending order
+func keysetSelector(sort []string, values bson.A) bson.M {
+	or := []bson.M{}
+	for i := range sort {
+		cond := bson.M{}
+		for j := 0; j < i; j++ {
+			field, _ := sortField(sort[j])
+			cond[field] = values[j]
+		}
+		field, desc := sortField(sort[i])
+		switch {
+		case values[i] == nil && desc:
+			continue
+		case values[i] == nil:
+			cond[field] = bson.M{"$ne": nil}
+		case desc:
+			cond["$or"] = []bson.M{{field: bson.M{"$lt": values[i]}}, {field: nil}}
+		default:
+			cond[field] = bson.M{"$gt": values[i]}
+		}
+		or = append(or, cond)
+	}
+	return bson.M{"$or": or}
+}
+
+func sortField(s string) (field string, desc bool) {
+	if strings.HasPrefix(s, "-") {
+		return s[1:], true
+	}
+	return strings.TrimPrefix(s, "+"), false
+}
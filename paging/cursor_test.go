@@ -0,0 +1,115 @@
+package paging
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestCursorSign(t *testing.T) {
+	SetSecret("test-secret")
+	p := cursorPayload{Sort: []string{"-createdAt", "_id"}, Filter: "abc", Values: bson.A{int64(3), "id"}}
+	s, err := encodeCursor(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeCursor(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Sort, p.Sort) || got.Filter != p.Filter || len(got.Values) != 2 {
+		t.Errorf("decoded cursor = %+v", got)
+	}
+
+	parts := strings.Split(s, ".")
+	if _, err := decodeCursor(parts[0] + "x." + parts[1]); err != ErrInvalidCursor {
+		t.Errorf("tampered cursor should be rejected, got %v", err)
+	}
+	SetSecret("other-secret")
+	if _, err := decodeCursor(s); err != ErrInvalidCursor {
+		t.Errorf("cursor of other secret should be rejected, got %v", err)
+	}
+}
+
+func TestKeysetSelector(t *testing.T) {
+	got := keysetSelector([]string{"-a", "_id"}, bson.A{1, 2})
+	want := bson.M{"$or": []bson.M{
+		{"$or": []bson.M{{"a": bson.M{"$lt": 1}}, {"a": nil}}},
+		{"a": 1, "_id": bson.M{"$gt": 2}},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("keysetSelector = %v, want %v", got, want)
+	}
+
+	// null sorts first
+	got = keysetSelector([]string{"-a", "b", "_id"}, bson.A{nil, nil, 2})
+	want = bson.M{"$or": []bson.M{
+		{"a": nil, "b": bson.M{"$ne": nil}},
+		{"a": nil, "b": nil, "_id": bson.M{"$gt": 2}},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("keysetSelector of null = %v, want %v", got, want)
+	}
+}
+
+func TestParse(t *testing.T) {
+	spec := &Spec{
+		MaxLimit:    50,
+		Sorts:       map[string]string{"created": "createdAt"},
+		DefaultSort: []string{"-created"},
+		Filters: map[string]Filter{
+			"age":  {Type: TypeInt, Ops: []string{OpGte, OpLt}},
+			"name": {},
+		},
+	}
+	parse := func(query string) (*Query, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/?"+query, nil)
+		q, err := Parse(c, spec)
+		if err != nil {
+			return nil, err
+		}
+		return q, nil
+	}
+
+	q, err := parse("limit=100&age[gte]=3&age[lt]=9&name=a&unknown=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Limit != 50 || !reflect.DeepEqual(q.Sort, []string{"-createdAt", "_id"}) {
+		t.Errorf("unexpected limit or sort: %d %v", q.Limit, q.Sort)
+	}
+	want := bson.M{"age": bson.M{"$gte": int64(3), "$lt": int64(9)}, "name": "a"}
+	if !reflect.DeepEqual(q.Filter, want) {
+		t.Errorf("filter = %v, want %v", q.Filter, want)
+	}
+
+	for _, bad := range []string{"sort=secret", "age=3", "age[gte]=x", "limit=0", "offset=1&cursor=x", "cursor=x"} {
+		if _, err := parse(bad); err == nil {
+			t.Errorf("%s should be rejected", bad)
+		}
+	}
+
+	next, err := q.NextCursor(bson.M{"_id": "id", "createdAt": int64(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parse("age[gte]=3&age[lt]=9&name=a&cursor=" + next); err != nil {
+		t.Errorf("cursor of same query rejected: %v", err)
+	}
+	if _, err := parse("age[gte]=4&age[lt]=9&name=a&cursor=" + next); err == nil {
+		t.Error("cursor of other filter should be rejected")
+	}
+	// the missing sort field is positioned as null
+	next, err = q.NextCursor(bson.M{"_id": "id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q, err = parse("age[gte]=3&age[lt]=9&name=a&cursor=" + next); err != nil || q.cursor.Values[0] != nil {
+		t.Errorf("cursor of missing field = %v %v, want null", q, err)
+	}
+}
@@ -0,0 +1,281 @@
+package paging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/cake/go-project-template/gpt"
+	"gitlab.com/cake/goctx"
+	"gitlab.com/cake/gopkg"
+	"gitlab.com/cake/mgopool/v3"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Query is the validated list request
+type Query struct {
+	spec *Spec
+
+	Limit  int
+	Offset int
+	// Sort is the resolved sort of document fields, always ends with _id as tie-breaker
+	Sort []string
+	// Filter only contains whitelisted fields with typed values
+	Filter bson.M
+
+	cursor *cursorPayload
+	digest string
+}
+
+// Page describes the returned page
+type Page struct {
+	Total      int
+	Offset     int
+	Count      int
+	HasMore    bool
+	NextCursor string
+}
+
+// Parse validates the limit, offset/cursor, sort and filter parameters of the request against spec
+func Parse(c *gin.Context, spec *Spec) (*Query, gopkg.CodeError) {
+	params := c.Request.URL.Query()
+	q := &Query{spec: spec, Filter: bson.M{}}
+
+	defLimit, maxLimit := spec.limits()
+	q.Limit = defLimit
+	if s := params.Get(ParamLimit); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v <= 0 {
+			return nil, badRequest("invalid %s: %s", ParamLimit, s)
+		}
+		if v > maxLimit {
+			v = maxLimit
+		}
+		q.Limit = v
+	}
+
+	if err := q.parseSort(params.Get(ParamSort)); err != nil {
+		return nil, err
+	}
+	if err := q.parseFilter(params); err != nil {
+		return nil, err
+	}
+
+	cursor, offset := params.Get(ParamCursor), params.Get(ParamOffset)
+	switch {
+	case cursor != "" && offset != "":
+		return nil, badRequest("%s and %s cannot be used together", ParamCursor, ParamOffset)
+	case cursor != "":
+		p, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, badRequest("%s", err.Error())
+		}
+		if p.Filter != q.digest || strings.Join(p.Sort, ",") != strings.Join(q.Sort, ",") || len(p.Values) != len(q.Sort) {
+			return nil, badRequest("cursor doesn't match the sort or filter of the request")
+		}
+		q.cursor = &p
+	case offset != "":
+		v, err := strconv.Atoi(offset)
+		if err != nil || v < 0 {
+			return nil, badRequest("invalid %s: %s", ParamOffset, offset)
+		}
+		q.Offset = v
+	}
+	return q, nil
+}
+
+// Where adds a server side condition, e.g. tenant scope, which is not part of the cursor digest
+func (q *Query) Where(cond bson.M) *Query {
+	for k, v := range cond {
+		q.Filter[k] = v
+	}
+	return q
+}
+
+// Selector returns the filter combined with the cursor position
+func (q *Query) Selector() bson.M {
+	if q.cursor == nil {
+		return q.Filter
+	}
+	return bson.M{mgopool.OpAnd: []bson.M{q.Filter, keysetSelector(q.Sort, q.cursor.Values)}}
+}
+
+// Find queries one page into result, which must be a pointer to slice
+func (q *Query) Find(ctx goctx.Context, dbName, collection string, result interface{}) (page *Page, err gopkg.CodeError) {
+	rv := reflect.ValueOf(result)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return nil, gopkg.NewCodeError(gpt.CodeInternalServerError, "paging result must be a pointer to slice")
+	}
+
+	page = &Page{Offset: q.Offset}
+	if !q.spec.SkipTotal {
+		if page.Total, err = mgopool.QueryCount(ctx, dbName, collection, q.Filter); err != nil {
+			return nil, err
+		}
+	}
+
+	// query one more document to know whether there is a next page
+	if err = mgopool.QueryAll(ctx, dbName, collection, result, q.Selector(), nil, q.Offset, q.Limit+1, q.Sort...); err != nil {
+		return nil, err
+	}
+
+	slice := rv.Elem()
+	if slice.Len() > q.Limit {
+		page.HasMore = true
+		slice.Set(slice.Slice(0, q.Limit))
+		cursor, errCursor := q.NextCursor(slice.Index(q.Limit - 1).Interface())
+		if errCursor != nil {
+			return nil, gopkg.NewCodeError(gpt.CodeInternalServerError, errCursor.Error())
+		}
+		page.NextCursor = cursor
+	}
+	page.Count = slice.Len()
+	return page, nil
+}
+
+// NextCursor issues the cursor positioned after the given document, a sort field missing from the document,
+// e.g. optional or not projected, is positioned as null like Mongo sorts it
+func (q *Query) NextCursor(last interface{}) (string, error) {
+	data, err := mgopool.Marshal(last)
+	if err != nil {
+		return "", err
+	}
+	raw := bson.Raw(data)
+	values := bson.A{}
+	for _, s := range q.Sort {
+		field, _ := sortField(s)
+		rv, errLookup := raw.LookupErr(strings.Split(field, ".")...)
+		if errLookup != nil {
+			values = append(values, nil)
+			continue
+		}
+		var v interface{}
+		if errDecode := rv.Unmarshal(&v); errDecode != nil {
+			return "", errDecode
+		}
+		values = append(values, v)
+	}
+	return encodeCursor(cursorPayload{Sort: q.Sort, Filter: q.digest, Values: values})
+}
+
+func (q *Query) parseSort(s string) gopkg.CodeError {
+	names := q.spec.DefaultSort
+	if s != "" {
+		names = strings.Split(s, ",")
+	}
+
+	hasID := false
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		param, desc := sortField(name)
+		field, ok := q.spec.Sorts[param]
+		if !ok && param != mgopool.ObjId {
+			return badRequest("sort by %s is not allowed", param)
+		}
+		if param == mgopool.ObjId {
+			field = mgopool.ObjId
+		}
+		if seen[field] {
+			return badRequest("duplicated sort field %s", param)
+		}
+		seen[field] = true
+		if field == mgopool.ObjId {
+			hasID = true
+		}
+		if desc {
+			field = "-" + field
+		}
+		q.Sort = append(q.Sort, field)
+	}
+	if !hasID {
+		q.Sort = append(q.Sort, mgopool.ObjId)
+	}
+	return nil
+}
+
+func (q *Query) parseFilter(params url.Values) gopkg.CodeError {
+	filter, digest, err := BuildFilter(params, q.spec.Filters)
+	if err != nil {
+		return err
+	}
+	q.Filter, q.digest = filter, digest
+	return nil
+}
+
+// BuildFilter converts the whitelisted "name=value" and "name[op]=value" parameters into a selector,
+// the digest identifies the accepted parameters regardless of their order
+func BuildFilter(params url.Values, filters map[string]Filter) (selector bson.M, digest string, err gopkg.CodeError) {
+	selector = bson.M{}
+	accepted := []string{}
+	for key, values := range params {
+		name, op := key, OpEq
+		if i := strings.Index(key, "["); i > 0 && strings.HasSuffix(key, "]") {
+			name, op = key[:i], key[i+1:len(key)-1]
+		}
+		f, ok := filters[name]
+		if !ok {
+			continue
+		}
+		if !f.allow(op) {
+			return nil, "", badRequest("operator %s is not allowed on %s", op, name)
+		}
+		field := f.Field
+		if field == "" {
+			field = name
+		}
+		value := values[len(values)-1]
+
+		var cond interface{}
+		var errConvert error
+		switch op {
+		case OpIn, OpNin:
+			cond, errConvert = f.convertList(value)
+		case OpExists:
+			cond, errConvert = strconv.ParseBool(value)
+		default:
+			cond, errConvert = f.convert(value)
+		}
+		if errConvert != nil {
+			return nil, "", badRequest("invalid value of %s: %s", key, value)
+		}
+		if err = addCondition(selector, field, op, cond); err != nil {
+			return nil, "", err
+		}
+		accepted = append(accepted, key+"="+value)
+	}
+
+	sort.Strings(accepted)
+	sum := sha256.Sum256([]byte(strings.Join(accepted, "&")))
+	return selector, hex.EncodeToString(sum[:8]), nil
+}
+
+func addCondition(selector bson.M, field, op string, value interface{}) gopkg.CodeError {
+	exist, ok := selector[field]
+	if op == OpEq {
+		if ok {
+			return badRequest("conflict conditions on %s", field)
+		}
+		selector[field] = value
+		return nil
+	}
+	cond := bson.M{}
+	if ok {
+		if cond, ok = exist.(bson.M); !ok {
+			return badRequest("conflict conditions on %s", field)
+		}
+	}
+	cond["$"+op] = value
+	selector[field] = cond
+	return nil
+}
+
+func badRequest(format string, args ...interface{}) gopkg.CodeError {
+	return gopkg.NewCodeError(gpt.CodeBadRequest, fmt.Sprintf(format, args...))
+}
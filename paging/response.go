@@ -0,0 +1,35 @@
+package paging
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/cake/goctx"
+)
+
+// ListResponse extends intercom.ListResponse with the cursor of the next page
+type ListResponse struct {
+	Code       int         `json:"code"`
+	Result     interface{} `json:"result"`
+	Message    string      `json:"message,omitempty"`
+	Total      int         `json:"total"`
+	Offset     int         `json:"offset"`
+	Count      int         `json:"count"`
+	HasMore    bool        `json:"hasMore"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+	CID        string      `json:"cid,omitempty"`
+}
+
+// GinListResponse writes the page in the same envelope for both offset and cursor paging
+func GinListResponse(c *gin.Context, result interface{}, page *Page) {
+	response := ListResponse{
+		Result:     result,
+		Total:      page.Total,
+		Offset:     page.Offset,
+		Count:      page.Count,
+		HasMore:    page.HasMore,
+		NextCursor: page.NextCursor,
+		CID:        c.GetHeader(goctx.HTTPHeaderCID),
+	}
+	c.AbortWithStatusJSON(http.StatusOK, response)
+}
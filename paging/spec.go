@@ -0,0 +1,122 @@
+package paging
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	ParamLimit  = "limit"
+	ParamOffset = "offset"
+	ParamCursor = "cursor"
+	ParamSort   = "sort"
+
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+// FilterType decides how the query string value is converted
+type FilterType int
+
+const (
+	TypeString FilterType = iota
+	TypeInt
+	TypeFloat
+	TypeBool
+	TypeTime
+	TypeObjectID
+)
+
+// Filter operators, used as "field=value" for OpEq and "field[op]=value" for the others
+const (
+	OpEq     = "eq"
+	OpNe     = "ne"
+	OpIn     = "in"
+	OpNin    = "nin"
+	OpGt     = "gt"
+	OpGte    = "gte"
+	OpLt     = "lt"
+	OpLte    = "lte"
+	OpExists = "exists"
+)
+
+// Filter whitelists one query parameter
+type Filter struct {
+	// Field is the document field, defaults to the parameter name
+	Field string
+	Type  FilterType
+	// Ops are the allowed operators, defaults to OpEq only
+	Ops []string
+}
+
+// Spec declares what a list endpoint accepts
+type Spec struct {
+	DefaultLimit int
+	MaxLimit     int
+	// Sorts maps the sort parameter name to the document field
+	Sorts map[string]string
+	// DefaultSort uses the parameter names, prefix "-" for descending
+	DefaultSort []string
+	Filters     map[string]Filter
+	// SkipTotal skips the count query when total is not needed
+	SkipTotal bool
+}
+
+func (s *Spec) limits() (def, max int) {
+	def, max = s.DefaultLimit, s.MaxLimit
+	if max <= 0 {
+		max = maxLimit
+	}
+	if def <= 0 || def > max {
+		def = defaultLimit
+		if def > max {
+			def = max
+		}
+	}
+	return
+}
+
+func (f Filter) allow(op string) bool {
+	if len(f.Ops) == 0 {
+		return op == OpEq
+	}
+	for _, o := range f.Ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (f Filter) convert(s string) (interface{}, error) {
+	switch f.Type {
+	case TypeInt:
+		return strconv.ParseInt(s, 10, 64)
+	case TypeFloat:
+		return strconv.ParseFloat(s, 64)
+	case TypeBool:
+		return strconv.ParseBool(s)
+	case TypeTime:
+		return time.Parse(time.RFC3339, s)
+	case TypeObjectID:
+		return primitive.ObjectIDFromHex(s)
+	default:
+		return s, nil
+	}
+}
+
+func (f Filter) convertList(s string) ([]interface{}, error) {
+	parts := strings.Split(s, ",")
+	ret := make([]interface{}, 0, len(parts))
+	for _, p := range parts {
+		v, err := f.convert(strings.TrimSpace(p))
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, v)
+	}
+	return ret, nil
+}
@@ -0,0 +1,195 @@
+package redistrace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+	"gitlab.com/cake/goctx"
+	"gitlab.com/cake/redispool"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+)
+
+const (
+	instrumentationName = "gitlab.com/cake/go-project-template/redistrace"
+
+	// CommandCountKey is the number of commands of a pipeline span
+	CommandCountKey = attribute.Key("db.redis.command_count")
+	// KeyPatternKey are the keys of the command with the variable segments replaced, see KeyPattern
+	KeyPatternKey = attribute.Key("db.redis.key_pattern")
+
+	opMulti    = "MULTI"
+	opPipeline = "PIPELINE"
+	opPublish  = "PUBLISH"
+
+	maxStatementLength = 2048
+)
+
+// Client runs the commands on the redigo pool of redispool.Pool with a client span per command,
+// the metrics of dbmetric.InstrumentRedis are still observed by the connections of the pool
+type Client struct {
+	pool *redis.Pool
+}
+
+// New returns the client of p
+func New(p *redispool.Pool) *Client {
+	return NewFromPool(p.GetPool())
+}
+
+// NewFromPool returns the client of the redigo pool
+func NewFromPool(pool *redis.Pool) *Client {
+	return &Client{pool: pool}
+}
+
+func (c *Client) tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// start starts the client span of the commands under the span of ctx
+func (c *Client) start(ctx goctx.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, semconv.DBSystemRedis, semconv.DBOperationKey.String(name))
+	return c.tracer().Start(ctx.NativeContext(), name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// end records err on the span, redis.ErrNil is a missing key rather than an error
+func end(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, redis.ErrNil) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Do sends the command and returns the reply
+func (c *Client) Do(ctx goctx.Context, cmd string, args ...interface{}) (reply interface{}, err error) {
+	cmd = strings.ToUpper(cmd)
+	native, span := c.start(ctx, cmd, commandAttributes(cmd, args)...)
+	defer func() { end(span, err) }()
+
+	conn, err := c.pool.GetContext(native)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.Do(cmd, args...)
+}
+
+// Get returns the value of key, redis.ErrNil if the key doesn't exist
+func (c *Client) Get(ctx goctx.Context, key string) ([]byte, error) {
+	return redis.Bytes(c.Do(ctx, redispool.RedisGet, key))
+}
+
+// Set sets key to value
+func (c *Client) Set(ctx goctx.Context, key string, value interface{}) error {
+	str, err := redis.String(c.Do(ctx, redispool.RedisSet, key, value))
+	if err != nil {
+		return err
+	}
+	if str != redispool.RedisOk {
+		return fmt.Errorf("unknown resp:%s", str)
+	}
+	return nil
+}
+
+// HSetJSON sets field of the hash key to value in JSON, the number of added fields is returned
+func (c *Client) HSetJSON(ctx goctx.Context, key, field string, value interface{}) (int, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return 0, err
+	}
+	return redis.Int(c.Do(ctx, redispool.RedisHSet, key, field, b))
+}
+
+// HGetJSON decodes field of the hash key into result, redis.ErrNil if the field doesn't exist
+func (c *Client) HGetJSON(ctx goctx.Context, key, field string, result interface{}) error {
+	b, err := redis.Bytes(c.Do(ctx, redispool.RedisHGet, key, field))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, result)
+}
+
+// MultiExec runs the commands in a transaction as one span, see redispool.Pool.MultiExec
+func (c *Client) MultiExec(ctx goctx.Context, commands []string, args [][]interface{}) ([]interface{}, error) {
+	if len(commands) == 0 {
+		return nil, nil
+	}
+	return c.pipeline(ctx, opMulti, commands, args)
+}
+
+// Pipeline sends the commands in one round trip without a transaction as one span,
+// the replies are returned in order and the first error reply is returned as error
+func (c *Client) Pipeline(ctx goctx.Context, commands []string, args [][]interface{}) ([]interface{}, error) {
+	if len(commands) == 0 {
+		return nil, nil
+	}
+	return c.pipeline(ctx, opPipeline, commands, args)
+}
+
+func (c *Client) pipeline(ctx goctx.Context, op string, commands []string, args [][]interface{}) (replies []interface{}, err error) {
+	if len(commands) != len(args) {
+		return nil, errors.New("unmatch number of commands and args")
+	}
+	statements := make([]string, len(commands))
+	for i, cmd := range commands {
+		statements[i] = statement(strings.ToUpper(cmd), args[i])
+	}
+	native, span := c.start(ctx, op,
+		CommandCountKey.Int(len(commands)),
+		semconv.DBStatementKey.String(truncate(strings.Join(statements, "\n"))),
+	)
+	defer func() { end(span, err) }()
+
+	conn, err := c.pool.GetContext(native)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if op == opMulti {
+		if err = conn.Send(redispool.RedisMulti); err != nil {
+			return nil, err
+		}
+	}
+	for i, cmd := range commands {
+		if err = conn.Send(cmd, args[i]...); err != nil {
+			return nil, err
+		}
+	}
+	if op == opMulti {
+		return redis.Values(conn.Do(redispool.RedisExec))
+	}
+
+	if err = conn.Flush(); err != nil {
+		return nil, err
+	}
+	replies = make([]interface{}, len(commands))
+	for i := range commands {
+		reply, rerr := conn.Receive()
+		if rerr != nil {
+			if _, ok := rerr.(redis.Error); !ok {
+				return nil, rerr
+			}
+			if err == nil {
+				err = rerr
+			}
+		}
+		replies[i] = reply
+	}
+	return replies, err
+}
+
+func truncate(s string) string {
+	if len(s) > maxStatementLength {
+		return s[:maxStatementLength] + "..."
+	}
+	return s
+}
@@ -0,0 +1,86 @@
+package redistrace
+
+import (
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+)
+
+const (
+	keySeparator = ":"
+	// maxSegmentLength is the longest key segment kept, the longer ones are usually tokens or encoded values
+	maxSegmentLength = 32
+)
+
+// keylessCommands have no key argument
+var keylessCommands = map[string]bool{
+	"PING": true, "ECHO": true, "AUTH": true, "SELECT": true, "INFO": true, "CONFIG": true, "CLIENT": true,
+	"FLUSHDB": true, "FLUSHALL": true, "DBSIZE": true, "TIME": true, "SCAN": true, "KEYS": true,
+	"MULTI": true, "EXEC": true, "DISCARD": true, "UNWATCH": true, "SCRIPT": true,
+	"PUBLISH": true, "SUBSCRIBE": true, "PSUBSCRIBE": true, "UNSUBSCRIBE": true, "PUNSUBSCRIBE": true,
+}
+
+// multiKeyCommands take only keys as arguments
+var multiKeyCommands = map[string]bool{
+	"MGET": true, "DEL": true, "UNLINK": true, "EXISTS": true, "TOUCH": true, "WATCH": true,
+}
+
+// KeyPattern replaces the segments of key separated by ":" which contain digits or are longer than 32 bytes with "*",
+// e.g. user:42:profile is user:*:profile, so the attribute has a bounded set of values
+func KeyPattern(key string) string {
+	segments := strings.Split(key, keySeparator)
+	for i, s := range segments {
+		if len(s) > maxSegmentLength || strings.ContainsAny(s, "0123456789") {
+			segments[i] = "*"
+		}
+	}
+	return strings.Join(segments, keySeparator)
+}
+
+// keyPatterns returns the distinct patterns of the keys of the command
+func keyPatterns(cmd string, args []interface{}) []string {
+	if keylessCommands[cmd] || len(args) == 0 {
+		return nil
+	}
+	keys := args[:1]
+	if multiKeyCommands[cmd] {
+		keys = args
+	}
+	ret := []string{}
+	seen := map[string]bool{}
+	for _, k := range keys {
+		var key string
+		switch v := k.(type) {
+		case string:
+			key = v
+		case []byte:
+			key = string(v)
+		default:
+			continue
+		}
+		if p := KeyPattern(key); !seen[p] {
+			seen[p] = true
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+// statement is the command with the key patterns, the values are never included
+func statement(cmd string, args []interface{}) string {
+	patterns := keyPatterns(cmd, args)
+	if len(patterns) == 0 {
+		return cmd
+	}
+	return cmd + " " + strings.Join(patterns, " ")
+}
+
+func commandAttributes(cmd string, args []interface{}) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{semconv.DBStatementKey.String(truncate(statement(cmd, args)))}
+	if patterns := keyPatterns(cmd, args); len(patterns) > 0 {
+		attrs = append(attrs, KeyPatternKey.StringSlice(patterns))
+	}
+	return attrs
+}
@@ -0,0 +1,138 @@
+package redistrace
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gomodule/redigo/redis"
+	"gitlab.com/cake/go-project-template/tracing"
+	"gitlab.com/cake/goctx"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+)
+
+// Envelope is the published message carrying the trace context, the payload is base64 in JSON
+type Envelope struct {
+	Headers map[string]string `json:"headers"`
+	Payload []byte            `json:"payload"`
+}
+
+// Message is a received message, Payload is unwrapped from the envelope
+type Message struct {
+	Channel string
+	// Pattern is the pattern matched by the channel of PSubscribe
+	Pattern string
+	Payload []byte
+
+	span trace.Span
+}
+
+// Done ends the consumer span of the message with the result of its handling,
+// the subscriber must call it once the message is processed
+func (m *Message) Done(err error) {
+	end(m.span, err)
+}
+
+func messagingAttributes(channel string, size int) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.MessagingSystemKey.String("redis"),
+		semconv.MessagingDestinationKindTopic,
+		semconv.MessagingDestinationKey.String(KeyPattern(channel)),
+		semconv.MessagingMessagePayloadSizeBytesKey.Int(size),
+	}
+}
+
+// Publish wraps payload in an Envelope with the context of the producer span and publishes it to channel,
+// the number of the subscribers received the message is returned
+func (c *Client) Publish(ctx goctx.Context, channel string, payload []byte) (n int, err error) {
+	native, span := c.tracer().Start(ctx.NativeContext(), KeyPattern(channel)+" send",
+		trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(messagingAttributes(channel, len(payload))...))
+	defer func() { end(span, err) }()
+
+	env := Envelope{Headers: map[string]string{}, Payload: payload}
+	otel.GetTextMapPropagator().Inject(native, propagation.MapCarrier(env.Headers))
+	b, err := json.Marshal(env)
+	if err != nil {
+		return 0, err
+	}
+
+	conn, err := c.pool.GetContext(native)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	return redis.Int(conn.Do(opPublish, channel, b))
+}
+
+// Subscription receives the messages of the patterns on a dedicated connection
+type Subscription struct {
+	psc      redis.PubSubConn
+	patterns []interface{}
+}
+
+// PSubscribe subscribes the channel patterns, close the subscription to release the connection
+func (c *Client) PSubscribe(ctx goctx.Context, patterns ...string) (*Subscription, error) {
+	conn, err := c.pool.GetContext(ctx.NativeContext())
+	if err != nil {
+		return nil, err
+	}
+	s := &Subscription{psc: redis.PubSubConn{Conn: conn}}
+	for _, p := range patterns {
+		s.patterns = append(s.patterns, p)
+	}
+	if err := s.psc.PSubscribe(s.patterns...); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Receive blocks until the next message, the returned context carries the consumer span of the message,
+// which is a child of the publisher span if the message is an Envelope. The span covers the handling
+// of the message and lasts until Message.Done is called.
+func (s *Subscription) Receive() (goctx.Context, *Message, error) {
+	for {
+		switch v := s.psc.Receive().(type) {
+		case error:
+			return nil, nil, v
+		case redis.Message:
+			ctx, msg := receive(v)
+			return ctx, msg, nil
+		}
+	}
+}
+
+// Close unsubscribes the patterns and releases the connection
+func (s *Subscription) Close() error {
+	if err := s.psc.PUnsubscribe(s.patterns...); err != nil {
+		s.psc.Close()
+		return err
+	}
+	return s.psc.Close()
+}
+
+// receive unwraps the envelope and starts the consumer span ended by Message.Done, the messages not published by Publish,
+// e.g. keyspace notifications, are passed as is with a new trace
+func receive(m redis.Message) (goctx.Context, *Message) {
+	msg := &Message{Channel: m.Channel, Pattern: m.Pattern, Payload: m.Data}
+	native := context.Background()
+	var env Envelope
+	if err := json.Unmarshal(m.Data, &env); err == nil && env.Headers != nil {
+		msg.Payload = env.Payload
+		native = otel.GetTextMapPropagator().Extract(native, propagation.MapCarrier(env.Headers))
+	}
+
+	attrs := append(messagingAttributes(m.Channel, len(msg.Payload)), semconv.MessagingOperationReceive)
+	_, msg.span = otel.Tracer(instrumentationName).Start(native, KeyPattern(m.Channel)+" receive",
+		trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(attrs...))
+
+	ctx := goctx.Background()
+	ctx.SetBaggage(baggage.FromContext(native))
+	tracing.SetSpan(ctx, msg.span)
+	return ctx, msg
+}
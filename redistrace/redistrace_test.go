@@ -0,0 +1,191 @@
+package redistrace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"gitlab.com/cake/goctx"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+)
+
+type memoryExporter struct {
+	spans []tracesdk.ReadOnlySpan
+}
+
+func (e *memoryExporter) ExportSpans(_ context.Context, spans []tracesdk.ReadOnlySpan) error {
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *memoryExporter) Shutdown(context.Context) error { return nil }
+
+// fakeConn replies OK to every command, WRONGTYPE to HGET and records the published messages
+type fakeConn struct {
+	redis.Conn
+	pending   []string
+	published [][]byte
+}
+
+func (c *fakeConn) reply(cmd string, args []interface{}) (interface{}, error) {
+	switch cmd {
+	case "HGET":
+		return nil, redis.Error("WRONGTYPE")
+	case "PUBLISH":
+		c.published = append(c.published, args[1].([]byte))
+		return int64(1), nil
+	case "GET":
+		return nil, nil
+	}
+	return "OK", nil
+}
+
+func (c *fakeConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd == "EXEC" {
+		replies := make([]interface{}, 0, len(c.pending))
+		for range c.pending[1:] {
+			replies = append(replies, "OK")
+		}
+		c.pending = nil
+		return replies, nil
+	}
+	return c.reply(cmd, args)
+}
+
+func (c *fakeConn) Send(cmd string, args ...interface{}) error {
+	c.pending = append(c.pending, cmd)
+	return nil
+}
+
+func (c *fakeConn) Flush() error { return nil }
+
+func (c *fakeConn) Receive() (interface{}, error) {
+	cmd := c.pending[0]
+	c.pending = c.pending[1:]
+	return c.reply(cmd, nil)
+}
+
+func (c *fakeConn) Err() error   { return nil }
+func (c *fakeConn) Close() error { return nil }
+
+func setup(t *testing.T) (*Client, *fakeConn, *memoryExporter) {
+	exp := &memoryExporter{}
+	otel.SetTracerProvider(tracesdk.NewTracerProvider(tracesdk.WithSyncer(exp)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(trace.NewNoopTracerProvider()) })
+	conn := &fakeConn{}
+	return NewFromPool(&redis.Pool{Dial: func() (redis.Conn, error) { return conn, nil }}), conn, exp
+}
+
+func attributes(s tracesdk.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	ret := map[attribute.Key]attribute.Value{}
+	for _, kv := range s.Attributes() {
+		ret[kv.Key] = kv.Value
+	}
+	return ret
+}
+
+func TestCommands(t *testing.T) {
+	c, _, exp := setup(t)
+	ctx := goctx.Background()
+
+	if err := c.Set(ctx, "user:42:profile", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "user:42:profile"); err != redis.ErrNil {
+		t.Fatalf("expected ErrNil, got %v", err)
+	}
+	var v interface{}
+	if err := c.HGetJSON(ctx, "session:abc", "token", &v); err == nil {
+		t.Fatal("error reply should be returned")
+	}
+	if _, err := c.MultiExec(ctx, []string{"SET", "DEL"}, [][]interface{}{{"a:1", "x"}, {"b:2", "c:3"}}); err != nil {
+		t.Fatal(err)
+	}
+	replies, err := c.Pipeline(ctx, []string{"SET", "HGET"}, [][]interface{}{{"a:1", "x"}, {"h", "f"}})
+	if err == nil || len(replies) != 2 || replies[0] != "OK" {
+		t.Fatalf("error reply of the pipeline should be returned with the replies: %v %v", replies, err)
+	}
+
+	if len(exp.spans) != 5 {
+		t.Fatalf("expected 5 spans, got %d", len(exp.spans))
+	}
+	set, get, hget, multi, pipeline := exp.spans[0], exp.spans[1], exp.spans[2], exp.spans[3], exp.spans[4]
+	attrs := attributes(set)
+	if set.Name() != "SET" || attrs["db.statement"].AsString() != "SET user:*:profile" || attrs["db.system"].AsString() != "redis" {
+		t.Errorf("unexpected span %s %v", set.Name(), set.Attributes())
+	}
+	if get.Status().Code == codes.Error {
+		t.Error("missing key should not be an error")
+	}
+	if hget.Status().Code != codes.Error {
+		t.Error("error reply should be recorded")
+	}
+	attrs = attributes(multi)
+	if multi.Name() != "MULTI" || attrs[CommandCountKey].AsInt64() != 2 || attrs["db.statement"].AsString() != "SET a:*\nDEL b:* c:*" {
+		t.Errorf("unexpected pipeline span %s %v", multi.Name(), multi.Attributes())
+	}
+	if pipeline.Name() != "PIPELINE" || pipeline.Status().Code != codes.Error {
+		t.Errorf("unexpected pipeline span %s %v", pipeline.Name(), pipeline.Status())
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	c, conn, exp := setup(t)
+	ctx := goctx.Background()
+	parent := ctx.StartSpanFromContext("parent")
+
+	if _, err := c.Publish(ctx, "events:1", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+	var env Envelope
+	if err := json.Unmarshal(conn.published[0], &env); err != nil || string(env.Payload) != "hello" || env.Headers["traceparent"] == "" {
+		t.Fatalf("unexpected envelope %s: %v", conn.published[0], err)
+	}
+
+	rctx, msg := receive(redis.Message{Channel: "events:1", Pattern: "events:*", Data: conn.published[0]})
+	if string(msg.Payload) != "hello" || msg.Pattern != "events:*" {
+		t.Errorf("unexpected message %+v", msg)
+	}
+	if rctx.GetSpan().SpanContext().TraceID() != parent.SpanContext().TraceID() {
+		t.Error("receive span should continue the trace of the publisher")
+	}
+	if exp.spans[0].Name() != "events:* send" || exp.spans[0].SpanKind() != trace.SpanKindProducer {
+		t.Errorf("unexpected publish span %s", exp.spans[0].Name())
+	}
+	if !rctx.GetSpan().IsRecording() || len(exp.spans) != 1 {
+		t.Fatal("receive span should stay open while the message is handled")
+	}
+	msg.Done(errors.New("handler failed"))
+	if len(exp.spans) != 2 || exp.spans[1].SpanKind() != trace.SpanKindConsumer || exp.spans[1].Status().Code != codes.Error {
+		t.Errorf("Done should end the receive span with the handling error: %+v", exp.spans)
+	}
+
+	_, raw := receive(redis.Message{Channel: "__keyspace@0__:a", Data: []byte("set")})
+	if string(raw.Payload) != "set" {
+		t.Errorf("plain message should be passed as is: %s", raw.Payload)
+	}
+	raw.Done(nil)
+}
+
+func TestKeyPattern(t *testing.T) {
+	for key, want := range map[string]string{
+		"user:42:profile":                           "user:*:profile",
+		"session:0a1b2c":                            "session:*",
+		"config":                                    "config",
+		"token:abcdefghijklmnopqrstuvwxyzabcdefghij": "token:*",
+	} {
+		if got := KeyPattern(key); got != want {
+			t.Errorf("KeyPattern(%s) = %s, want %s", key, got, want)
+		}
+	}
+}
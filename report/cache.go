@@ -0,0 +1,63 @@
+package report
+
+import (
+	"sync"
+	"time"
+)
+
+// cache keeps report results in memory, when full the expired entries are dropped
+// and then the entry expiring first is evicted
+type cache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	result  *Result
+	expires time.Time
+}
+
+func newCache(size int) *cache {
+	return &cache{size: size, entries: map[string]cacheEntry{}}
+}
+
+func (c *cache) get(key string) (*Result, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(e.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return e.result, true
+}
+
+func (c *cache) set(key string, result *Result, ttl time.Duration) {
+	if c.size <= 0 || ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.size {
+		first := ""
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+				continue
+			}
+			if first == "" || e.expires.Before(c.entries[first].expires) {
+				first = k
+			}
+		}
+		if len(c.entries) >= c.size {
+			delete(c.entries, first)
+		}
+	}
+	c.entries[key] = cacheEntry{result: result, expires: now.Add(ttl)}
+}
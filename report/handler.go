@@ -0,0 +1,397 @@
+package report
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"gitlab.com/cake/go-project-template/gpt"
+	"gitlab.com/cake/go-project-template/mongohook"
+	"gitlab.com/cake/go-project-template/tracing"
+	"gitlab.com/cake/goctx"
+	"gitlab.com/cake/golibs/intercom"
+	"gitlab.com/cake/gopkg"
+	"gitlab.com/cake/m800log"
+	"gitlab.com/cake/mgopool/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/sync/singleflight"
+)
+
+// Output formats, json results are cached and csv and ndjson are streamed row by row
+// from an aggregation cursor, the truncation of a stream is sent as a trailer
+const (
+	FormatJSON   = "json"
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+
+	HeaderTruncated = "X-Report-Truncated"
+
+	flushRows = 100
+)
+
+var (
+	settings = struct {
+		timeout  time.Duration
+		cacheTTL time.Duration
+		maxRows  int
+	}{timeout: 10 * time.Second, cacheTTL: time.Minute, maxRows: 1000}
+
+	results = newCache(256)
+	flight  singleflight.Group
+
+	// aggregate opens the cursor of the streamed formats, set by SetMongoClient
+	aggregate   func(ctx goctx.Context, db, collection string, pipeline interface{}) (cursor, error)
+	aggregateMu sync.RWMutex
+)
+
+// cursor iterates the aggregated rows, implemented by *mongohook.AggregateCursor
+type cursor interface {
+	Next(ctx context.Context) bool
+	Decode(val interface{}) error
+	Err() error
+	Close(ctx context.Context) error
+}
+
+// SetMongoClient sets the client of the streamed csv and ndjson reports, mgopool only returns
+// whole aggregation results. The cursors are opened through the hooks of pool, the same as the json reports
+func SetMongoClient(client *mongo.Client, pool *mongohook.Pool) {
+	aggregateMu.Lock()
+	defer aggregateMu.Unlock()
+	aggregate = func(ctx goctx.Context, db, collection string, pipeline interface{}) (cursor, error) {
+		c, err := pool.Aggregate(ctx, client, db, collection, pipeline)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+}
+
+// Result is the aggregated report
+type Result struct {
+	Report      string    `json:"report"`
+	Columns     []string  `json:"columns"`
+	Rows        []bson.M  `json:"rows"`
+	Truncated   bool      `json:"truncated"`
+	GeneratedAt time.Time `json:"generatedAt"`
+}
+
+// Info describes a registered report to clients
+type Info struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Dimensions  []string `json:"dimensions"`
+	Measures    []string `json:"measures"`
+	Filters     []string `json:"filters"`
+	Buckets     []string `json:"buckets,omitempty"`
+}
+
+func AddReportEndpoint(rootGroup *gin.RouterGroup) {
+	// settings
+	apiTimeout := viper.GetDuration("http.api_timeout")
+	if d := viper.GetDuration("report.timeout"); d > 0 {
+		settings.timeout = d
+	}
+	if viper.IsSet("report.cache_ttl") {
+		settings.cacheTTL = viper.GetDuration("report.cache_ttl")
+	}
+	if n := viper.GetInt("report.max_rows"); n > 0 {
+		settings.maxRows = n
+	}
+	if viper.IsSet("report.cache_size") {
+		results = newCache(viper.GetInt("report.cache_size"))
+	}
+
+	reportGroup := rootGroup.Group(gpt.APIReportPath,
+		intercom.AccessMiddleware(apiTimeout, gpt.GetNamespace()),
+	)
+	{
+		reportGroup.GET("", listHandler)
+		reportGroup.GET("/:name", reportHandler)
+	}
+}
+
+func listHandler(c *gin.Context) {
+	ret := []Info{}
+	for _, r := range List() {
+		ret = append(ret, r.info())
+	}
+	intercom.GinOKResponse(c, ret)
+}
+
+func reportHandler(c *gin.Context) {
+	ctx := intercom.GetContextFromGin(c)
+	r, ok := Get(c.Param("name"))
+	if !ok {
+		intercom.GinError(c, gopkg.NewCodeError(gpt.CodeNotFound, "report not found: "+c.Param("name")))
+		return
+	}
+
+	params := c.Request.URL.Query()
+	format := params.Get(ParamFormat)
+	switch format {
+	case "":
+		format = FormatJSON
+	case FormatJSON, FormatCSV, FormatNDJSON:
+	default:
+		intercom.GinError(c, badRequest("invalid %s: %s", ParamFormat, format))
+		return
+	}
+	params.Del(ParamFormat)
+
+	req, err := parseRequest(r, params, settings.maxRows)
+	if err != nil {
+		intercom.GinError(c, err)
+		return
+	}
+
+	if format != FormatJSON {
+		stream(c, ctx, req, format)
+		return
+	}
+
+	result, err := run(ctx, req, r.Name+"?"+params.Encode())
+	if err != nil {
+		m800log.Errorf(ctx, "[report] %s failed: %v", r.Name, err)
+		intercom.GinError(c, err)
+		return
+	}
+	intercom.GinOKResponse(c, result)
+}
+
+// run returns the cached result, concurrent identical requests share one aggregation.
+// The shared aggregation is detached from the caller which started it, so its cancellation
+// only stops its own wait and the aggregation is bounded by the report timeout alone.
+func run(ctx goctx.Context, req *request, key string) (*Result, gopkg.CodeError) {
+	if result, ok := results.get(key); ok {
+		return result, nil
+	}
+
+	ttl := req.report.CacheTTL
+	if ttl == 0 {
+		ttl = settings.cacheTTL
+	}
+	ch := flight.DoChan(key, func() (interface{}, error) {
+		result, errExec := execute(tracing.CopyContext(ctx), req)
+		if errExec != nil {
+			return nil, errExec
+		}
+		results.set(key, result, ttl)
+		return result, nil
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err.(gopkg.CodeError)
+		}
+		return res.Val.(*Result), nil
+	case <-ctx.Done():
+		return nil, gopkg.NewCodeError(gpt.CodeTimeout, fmt.Sprintf("report %s: %v", req.report.Name, ctx.Err()))
+	}
+}
+
+func execute(ctx goctx.Context, req *request) (*Result, gopkg.CodeError) {
+	r := req.report
+	timeout := req.timeout()
+	pipeline := req.pipeline()
+	m800log.Debugf(ctx, "[report] %s pipeline: %s", r.Name, pipeline.String())
+
+	tctx, cancel := ctx.WithDeadline(time.Now().Add(timeout))
+	defer cancel()
+	rows := []bson.M{}
+	if err := mgopool.Pipe(tctx, req.db(), r.Collection, pipeline.Done(), &rows); err != nil {
+		if tctx.Err() == context.DeadlineExceeded {
+			return nil, timeoutError(r, timeout)
+		}
+		return nil, err
+	}
+
+	result := &Result{Report: r.Name, Columns: req.columns(), Rows: rows, GeneratedAt: time.Now()}
+	if len(rows) > req.limit {
+		result.Rows, result.Truncated = rows[:req.limit], true
+	}
+	return result, nil
+}
+
+// stream writes the rows as they are read from the cursor, the status is sent before
+// the first row so a later cursor error only ends the body early and is logged
+func stream(c *gin.Context, ctx goctx.Context, req *request, format string) {
+	r := req.report
+	aggregateMu.RLock()
+	open := aggregate
+	aggregateMu.RUnlock()
+	if open == nil {
+		intercom.GinError(c, gopkg.NewCodeError(gpt.CodeInternalServerError, "report streaming has no mongo client"))
+		return
+	}
+
+	timeout := req.timeout()
+	pipeline := req.pipeline()
+	m800log.Debugf(ctx, "[report] %s pipeline: %s", r.Name, pipeline.String())
+
+	tctx, cancel := ctx.WithDeadline(time.Now().Add(timeout))
+	defer cancel()
+	cur, err := open(tctx, req.db(), r.Collection, pipeline.Done())
+	if err != nil {
+		m800log.Errorf(ctx, "[report] %s failed: %v", r.Name, err)
+		if tctx.Err() == context.DeadlineExceeded {
+			intercom.GinError(c, timeoutError(r, timeout))
+			return
+		}
+		intercom.GinError(c, gopkg.NewCodeError(gpt.CodeInternalServerError, err.Error()))
+		return
+	}
+	defer cur.Close(context.Background())
+
+	var w rowWriter
+	if format == FormatCSV {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, r.Name))
+		w = newCSVWriter(c.Writer, req.columns())
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+		w = &ndjsonWriter{enc: json.NewEncoder(c.Writer)}
+	}
+	c.Header("Trailer", HeaderTruncated)
+	c.Status(http.StatusOK)
+
+	n, truncated := 0, false
+	for cur.Next(tctx) {
+		if n == req.limit {
+			truncated = true
+			break
+		}
+		row := bson.M{}
+		if err := cur.Decode(&row); err != nil {
+			m800log.Errorf(ctx, "[report] %s decode error: %v", r.Name, err)
+			break
+		}
+		if err := w.write(row); err != nil {
+			return
+		}
+		if n++; n%flushRows == 0 {
+			w.flush()
+			c.Writer.Flush()
+		}
+	}
+	if err := cur.Err(); err != nil {
+		m800log.Errorf(ctx, "[report] %s cursor error after %d rows: %v", r.Name, n, err)
+	}
+	w.flush()
+	c.Writer.Header().Set(HeaderTruncated, strconv.FormatBool(truncated))
+}
+
+type rowWriter interface {
+	write(row bson.M) error
+	flush()
+}
+
+type csvWriter struct {
+	w       *csv.Writer
+	columns []string
+	record  []string
+}
+
+func newCSVWriter(w io.Writer, columns []string) *csvWriter {
+	cw := &csvWriter{w: csv.NewWriter(w), columns: columns, record: make([]string, len(columns))}
+	_ = cw.w.Write(columns)
+	return cw
+}
+
+func (cw *csvWriter) write(row bson.M) error {
+	for i, column := range cw.columns {
+		cw.record[i] = formatValue(row[column])
+	}
+	return cw.w.Write(cw.record)
+}
+
+func (cw *csvWriter) flush() {
+	cw.w.Flush()
+}
+
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (nw *ndjsonWriter) write(row bson.M) error {
+	return nw.enc.Encode(row)
+}
+
+func (nw *ndjsonWriter) flush() {}
+
+func (req *request) timeout() time.Duration {
+	if req.report.Timeout > 0 {
+		return req.report.Timeout
+	}
+	return settings.timeout
+}
+
+func (req *request) db() string {
+	if req.report.DB != "" {
+		return req.report.DB
+	}
+	return viper.GetString("database.mgo.name")
+}
+
+func timeoutError(r *Report, timeout time.Duration) gopkg.CodeError {
+	return gopkg.NewCodeError(gpt.CodeTimeout, fmt.Sprintf("report %s timeout after %s", r.Name, timeout))
+}
+
+func formatValue(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case primitive.DateTime:
+		return t.Time().UTC().Format(time.RFC3339)
+	case time.Time:
+		return t.UTC().Format(time.RFC3339)
+	case primitive.ObjectID:
+		return t.Hex()
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+func (r *Report) info() Info {
+	info := Info{
+		Name:        r.Name,
+		Description: r.Description,
+		Dimensions:  []string{},
+		Measures:    []string{},
+		Filters:     []string{},
+		Buckets:     append([]string{}, r.Buckets...),
+	}
+	for name := range r.Dimensions {
+		info.Dimensions = append(info.Dimensions, name)
+	}
+	for name := range r.Measures {
+		info.Measures = append(info.Measures, name)
+	}
+	for name := range r.Filters {
+		info.Filters = append(info.Filters, name)
+	}
+	if r.TimeField != "" && len(info.Buckets) == 0 {
+		for b := range bucketFormats {
+			info.Buckets = append(info.Buckets, b)
+		}
+	}
+	sort.Strings(info.Dimensions)
+	sort.Strings(info.Measures)
+	sort.Strings(info.Filters)
+	sort.Strings(info.Buckets)
+	return info
+}
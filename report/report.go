@@ -0,0 +1,179 @@
+package report
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"gitlab.com/cake/go-project-template/paging"
+)
+
+// Aggregation operators of measures
+const (
+	AggSum   = "sum"
+	AggAvg   = "avg"
+	AggMin   = "min"
+	AggMax   = "max"
+	AggCount = "count"
+)
+
+// Time buckets, formatted by $dateToString in the requested timezone
+const (
+	BucketHour  = "hour"
+	BucketDay   = "day"
+	BucketWeek  = "week"
+	BucketMonth = "month"
+	BucketYear  = "year"
+
+	// ColumnBucket is the output column of the time bucket
+	ColumnBucket = "bucket"
+)
+
+var (
+	bucketFormats = map[string]string{
+		BucketHour:  "%Y-%m-%dT%H:00",
+		BucketDay:   "%Y-%m-%d",
+		BucketWeek:  "%G-W%V",
+		BucketMonth: "%Y-%m",
+		BucketYear:  "%Y",
+	}
+
+	// column names are used as output field names of the pipeline
+	columnName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+	reports   = map[string]*Report{}
+	reportsMu sync.RWMutex
+)
+
+// Measure is an aggregated value of the report
+type Measure struct {
+	Op string
+	// Field is the document field, not used by AggCount
+	Field string
+}
+
+// Report declares what can be aggregated from a collection
+type Report struct {
+	Name        string
+	Description string
+	// DB defaults to database.mgo.name
+	DB         string
+	Collection string
+	// Dimensions maps the dimension name to the document field
+	Dimensions map[string]string
+	Measures   map[string]Measure
+	// DefaultMeasures are used when the request doesn't specify measures, defaults to all
+	DefaultMeasures []string
+	Filters         map[string]paging.Filter
+
+	// TimeField enables from/to range and bucketing, empty means the report has no time axis
+	TimeField string
+	// Buckets are the allowed time buckets, defaults to all
+	Buckets []string
+	// MaxRange limits to - from, 0 means unlimited
+	MaxRange time.Duration
+
+	// MaxRows limits the returned rows, defaults to the endpoint setting
+	MaxRows int
+	// Timeout of the aggregation, defaults to the endpoint setting
+	Timeout time.Duration
+	// CacheTTL defaults to the endpoint setting, negative disables caching
+	CacheTTL time.Duration
+}
+
+// Register validates and adds the report, the name must be unique
+func Register(r *Report) error {
+	if err := r.validate(); err != nil {
+		return err
+	}
+	reportsMu.Lock()
+	defer reportsMu.Unlock()
+	if _, ok := reports[r.Name]; ok {
+		return fmt.Errorf("report %s already registered", r.Name)
+	}
+	reports[r.Name] = r
+	return nil
+}
+
+// Get returns the registered report
+func Get(name string) (r *Report, ok bool) {
+	reportsMu.RLock()
+	defer reportsMu.RUnlock()
+	r, ok = reports[name]
+	return
+}
+
+// List returns the registered reports ordered by name
+func List() []*Report {
+	reportsMu.RLock()
+	defer reportsMu.RUnlock()
+	ret := make([]*Report, 0, len(reports))
+	for _, r := range reports {
+		ret = append(ret, r)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+func (r *Report) validate() error {
+	if r.Name == "" || r.Collection == "" {
+		return fmt.Errorf("report name and collection are required")
+	}
+	if len(r.Measures) == 0 {
+		return fmt.Errorf("report %s has no measure", r.Name)
+	}
+
+	names := map[string]bool{ColumnBucket: true}
+	for name := range r.Dimensions {
+		if !columnName.MatchString(name) || names[name] {
+			return fmt.Errorf("report %s: invalid or duplicated dimension %s", r.Name, name)
+		}
+		names[name] = true
+	}
+	for name, m := range r.Measures {
+		if !columnName.MatchString(name) || names[name] {
+			return fmt.Errorf("report %s: invalid or duplicated measure %s", r.Name, name)
+		}
+		names[name] = true
+		switch m.Op {
+		case AggCount:
+		case AggSum, AggAvg, AggMin, AggMax:
+			if m.Field == "" {
+				return fmt.Errorf("report %s: measure %s requires field", r.Name, name)
+			}
+		default:
+			return fmt.Errorf("report %s: unknown operator %s of measure %s", r.Name, m.Op, name)
+		}
+	}
+	for _, name := range r.DefaultMeasures {
+		if _, ok := r.Measures[name]; !ok {
+			return fmt.Errorf("report %s: unknown default measure %s", r.Name, name)
+		}
+	}
+	for _, b := range r.Buckets {
+		if _, ok := bucketFormats[b]; !ok {
+			return fmt.Errorf("report %s: unknown bucket %s", r.Name, b)
+		}
+	}
+	if len(r.Buckets) > 0 && r.TimeField == "" {
+		return fmt.Errorf("report %s: buckets require time field", r.Name)
+	}
+	return nil
+}
+
+func (r *Report) allowBucket(b string) bool {
+	if _, ok := bucketFormats[b]; !ok || r.TimeField == "" {
+		return false
+	}
+	if len(r.Buckets) == 0 {
+		return true
+	}
+	for _, allowed := range r.Buckets {
+		if allowed == b {
+			return true
+		}
+	}
+	return false
+}
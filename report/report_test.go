@@ -0,0 +1,196 @@
+package report
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/cake/go-project-template/paging"
+	"gitlab.com/cake/goctx"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func testReport() *Report {
+	return &Report{
+		Name:       "orders",
+		Collection: "orders",
+		Dimensions: map[string]string{"country": "address.country"},
+		Measures: map[string]Measure{
+			"orders":  {Op: AggCount},
+			"revenue": {Op: AggSum, Field: "amount"},
+		},
+		Filters:   map[string]paging.Filter{"status": {}},
+		TimeField: "createdAt",
+		Buckets:   []string{BucketDay, BucketMonth},
+		MaxRange:  31 * 24 * time.Hour,
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := testReport().validate(); err != nil {
+		t.Fatal(err)
+	}
+	bad := []func(r *Report){
+		func(r *Report) { r.Measures["bucket"] = Measure{Op: AggCount} },
+		func(r *Report) { r.Measures["x"] = Measure{Op: AggAvg} },
+		func(r *Report) { r.Measures["y"] = Measure{Op: "median", Field: "a"} },
+		func(r *Report) { r.Dimensions["a.b"] = "a.b" },
+		func(r *Report) { r.Buckets = []string{"minute"} },
+	}
+	for i, f := range bad {
+		r := testReport()
+		f(r)
+		if err := r.validate(); err == nil {
+			t.Errorf("case %d should be invalid", i)
+		}
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	params, _ := url.ParseQuery("dimensions=country&measures=revenue&bucket=day&tz=Asia/Taipei" +
+		"&from=2021-01-01T00:00:00Z&to=2021-01-08T00:00:00Z&status=paid&limit=10")
+	req, err := parseRequest(testReport(), params, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(req.columns(), []string{ColumnBucket, "country", "revenue"}) {
+		t.Errorf("columns = %v", req.columns())
+	}
+
+	stages := req.pipeline().Done()
+	if len(stages) != 5 {
+		t.Fatalf("expected match, group, project, sort and limit, got %v", stages)
+	}
+	match := stages[0]["$match"].(bson.M)
+	if match["status"] != "paid" || match["createdAt"] == nil {
+		t.Errorf("unexpected match: %v", match)
+	}
+	group := stages[1]["$group"].(bson.M)
+	id := group["_id"].(bson.M)
+	if id["country"] != "$address.country" || group["revenue"].(bson.M)["$sum"] != "$amount" {
+		t.Errorf("unexpected group: %v", group)
+	}
+	if !reflect.DeepEqual(req.sort, []string{"+" + ColumnBucket}) {
+		t.Errorf("default sort = %v", req.sort)
+	}
+	if stages[4]["$limit"] != 11 {
+		t.Errorf("unexpected limit: %v", stages[4])
+	}
+
+	for _, query := range []string{
+		"dimensions=city",
+		"measures=profit",
+		"bucket=hour&from=2021-01-01T00:00:00Z&to=2021-01-02T00:00:00Z",
+		"from=2021-01-01T00:00:00Z&to=2021-03-01T00:00:00Z",
+		"from=2021-01-01T00:00:00Z&to=2021-01-02T00:00:00Z&sort=amount",
+		"from=2021-01-01T00:00:00Z&to=2021-01-02T00:00:00Z&sort=--orders",
+		"from=2021-01-01T00:00:00Z&to=2021-01-02T00:00:00Z&sort=orders,-orders",
+		"from=2021-01-01T00:00:00Z&to=2021-01-02T00:00:00Z&tz=Mars/Base",
+	} {
+		params, _ := url.ParseQuery(query)
+		if _, err := parseRequest(testReport(), params, 1000); err == nil {
+			t.Errorf("%s should be rejected", query)
+		}
+	}
+}
+
+func TestSort(t *testing.T) {
+	params, _ := url.ParseQuery("dimensions=country&sort=-orders,country&from=2021-01-01T00:00:00Z&to=2021-01-02T00:00:00Z")
+	req, err := parseRequest(testReport(), params, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(req.sort, []string{"-orders", "+country"}) {
+		t.Errorf("sort = %v, want explicit directions", req.sort)
+	}
+}
+
+func TestCache(t *testing.T) {
+	c := newCache(2)
+	c.set("a", &Result{Report: "a"}, time.Minute)
+	c.set("b", &Result{Report: "b"}, 2*time.Minute)
+	c.set("c", &Result{Report: "c"}, 3*time.Minute)
+	if _, ok := c.get("a"); ok {
+		t.Error("entry expiring first should be evicted")
+	}
+	if r, ok := c.get("c"); !ok || r.Report != "c" {
+		t.Error("latest entry should be cached")
+	}
+	c.set("a", &Result{Report: "a"}, 5*time.Minute)
+	if _, ok := c.get("b"); ok {
+		t.Error("b expires before c and should be evicted")
+	}
+	c.set("d", &Result{}, -1)
+	if _, ok := c.get("d"); ok {
+		t.Error("negative ttl should not be cached")
+	}
+}
+
+type sliceCursor struct {
+	rows []bson.M
+	i    int
+}
+
+func (c *sliceCursor) Next(ctx context.Context) bool {
+	c.i++
+	return c.i <= len(c.rows)
+}
+
+func (c *sliceCursor) Decode(val interface{}) error {
+	row, ok := val.(*bson.M)
+	if !ok {
+		return errors.New("unexpected value")
+	}
+	*row = c.rows[c.i-1]
+	return nil
+}
+
+func (c *sliceCursor) Err() error                      { return nil }
+func (c *sliceCursor) Close(ctx context.Context) error { return nil }
+
+func TestStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rows := []bson.M{{"country": "TW", "orders": int32(3)}, {"country": "JP", "orders": int32(2)}, {"country": "US", "orders": int32(1)}}
+	aggregate = func(ctx goctx.Context, db, collection string, pipeline interface{}) (cursor, error) {
+		return &sliceCursor{rows: rows}, nil
+	}
+	defer func() { aggregate = nil }()
+
+	router := gin.New()
+	router.GET("/", func(c *gin.Context) {
+		params, _ := url.ParseQuery("dimensions=country&measures=orders&limit=2&from=2021-01-01T00:00:00Z&to=2021-01-02T00:00:00Z")
+		req, err := parseRequest(testReport(), params, 1000)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		stream(c, goctx.Background(), req, c.Query(ParamFormat))
+	})
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	for format, want := range map[string]string{
+		FormatCSV:    "country,orders\nTW,3\nJP,2\n",
+		FormatNDJSON: `{"country":"TW","orders":3}` + "\n" + `{"country":"JP","orders":2}` + "\n",
+	} {
+		resp, err := http.Get(srv.URL + "/?format=" + format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != want {
+			t.Errorf("%s body = %q, want %q", format, body, want)
+		}
+		if resp.Trailer.Get(HeaderTruncated) != "true" {
+			t.Errorf("%s trailer = %v, want truncated", format, resp.Trailer)
+		}
+	}
+}
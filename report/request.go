@@ -0,0 +1,255 @@
+package report
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/cake/go-project-template/gpt"
+	"gitlab.com/cake/go-project-template/paging"
+	"gitlab.com/cake/gopkg"
+	"gitlab.com/cake/mgopool/v3"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Request parameters
+const (
+	ParamDimensions = "dimensions"
+	ParamMeasures   = "measures"
+	ParamBucket     = "bucket"
+	ParamFrom       = "from"
+	ParamTo         = "to"
+	ParamTimezone   = "tz"
+	ParamSort       = "sort"
+	ParamLimit      = "limit"
+	ParamFormat     = "format"
+)
+
+// request is the validated report request
+type request struct {
+	report     *Report
+	dimensions []string
+	measures   []string
+	bucket     string
+	timezone   string
+	from, to   time.Time
+	filter     bson.M
+	sort       []string
+	limit      int
+}
+
+func parseRequest(r *Report, params url.Values, maxRows int) (*request, gopkg.CodeError) {
+	req := &request{report: r, timezone: "UTC", limit: maxRows}
+	if r.MaxRows > 0 {
+		req.limit = r.MaxRows
+	}
+
+	seen := map[string]bool{}
+	for _, d := range splitList(params.Get(ParamDimensions)) {
+		if _, ok := r.Dimensions[d]; !ok || seen[d] {
+			return nil, badRequest("unknown or duplicated dimension %s", d)
+		}
+		seen[d] = true
+		req.dimensions = append(req.dimensions, d)
+	}
+
+	req.measures = r.DefaultMeasures
+	if s := params.Get(ParamMeasures); s != "" {
+		req.measures = splitList(s)
+	}
+	for _, m := range req.measures {
+		if _, ok := r.Measures[m]; !ok || seen[m] {
+			return nil, badRequest("unknown or duplicated measure %s", m)
+		}
+		seen[m] = true
+	}
+	if len(req.measures) == 0 {
+		for name := range r.Measures {
+			req.measures = append(req.measures, name)
+		}
+		sort.Strings(req.measures)
+	}
+
+	if err := req.parseTime(params); err != nil {
+		return nil, err
+	}
+
+	filter, _, err := paging.BuildFilter(params, r.Filters)
+	if err != nil {
+		return nil, err
+	}
+	req.filter = filter
+
+	if err := req.parseSort(params.Get(ParamSort)); err != nil {
+		return nil, err
+	}
+
+	if s := params.Get(ParamLimit); s != "" {
+		v, errConv := strconv.Atoi(s)
+		if errConv != nil || v <= 0 {
+			return nil, badRequest("invalid %s: %s", ParamLimit, s)
+		}
+		if v < req.limit {
+			req.limit = v
+		}
+	}
+	return req, nil
+}
+
+func (req *request) parseTime(params url.Values) gopkg.CodeError {
+	r := req.report
+	from, to := params.Get(ParamFrom), params.Get(ParamTo)
+	req.bucket = params.Get(ParamBucket)
+	if r.TimeField == "" {
+		if from != "" || to != "" || req.bucket != "" {
+			return badRequest("report %s has no time field", r.Name)
+		}
+		return nil
+	}
+
+	if req.bucket != "" && !r.allowBucket(req.bucket) {
+		return badRequest("bucket %s is not allowed", req.bucket)
+	}
+	if tz := params.Get(ParamTimezone); tz != "" {
+		if _, err := time.LoadLocation(tz); err != nil {
+			return badRequest("invalid %s: %s", ParamTimezone, tz)
+		}
+		req.timezone = tz
+	}
+
+	var err error
+	if from != "" {
+		if req.from, err = time.Parse(time.RFC3339, from); err != nil {
+			return badRequest("invalid %s: %s", ParamFrom, from)
+		}
+	}
+	if to != "" {
+		if req.to, err = time.Parse(time.RFC3339, to); err != nil {
+			return badRequest("invalid %s: %s", ParamTo, to)
+		}
+	}
+	if r.MaxRange > 0 {
+		if req.from.IsZero() || req.to.IsZero() {
+			return badRequest("%s and %s are required", ParamFrom, ParamTo)
+		}
+		if req.to.Sub(req.from) > r.MaxRange {
+			return badRequest("time range exceeds %s", r.MaxRange)
+		}
+	}
+	if !req.from.IsZero() && !req.to.IsZero() && !req.from.Before(req.to) {
+		return badRequest("%s must be before %s", ParamFrom, ParamTo)
+	}
+	return nil
+}
+
+func (req *request) parseSort(s string) gopkg.CodeError {
+	allowed := map[string]bool{}
+	for _, c := range req.columns() {
+		allowed[c] = true
+	}
+	seen := map[string]bool{}
+	for _, name := range splitList(s) {
+		direction := "+"
+		if name[0] == '+' || name[0] == '-' {
+			direction, name = name[:1], name[1:]
+		}
+		if !allowed[name] || seen[name] {
+			return badRequest("sort by %s is not allowed", name)
+		}
+		seen[name] = true
+		req.sort = append(req.sort, direction+name)
+	}
+	if len(req.sort) == 0 && req.bucket != "" {
+		req.sort = []string{"+" + ColumnBucket}
+	}
+	return nil
+}
+
+// columns are the output columns: bucket, dimensions then measures
+func (req *request) columns() []string {
+	ret := []string{}
+	if req.bucket != "" {
+		ret = append(ret, ColumnBucket)
+	}
+	ret = append(ret, req.dimensions...)
+	return append(ret, req.measures...)
+}
+
+// pipeline builds match, group, project, sort and limit stages,
+// one more row than the limit is queried to know whether the result is truncated
+func (req *request) pipeline() *mgopool.Pipeline {
+	r := req.report
+	p := mgopool.NewPipeline()
+
+	match := bson.M{}
+	for k, v := range req.filter {
+		match[k] = v
+	}
+	if !req.from.IsZero() || !req.to.IsZero() {
+		cond := bson.M{}
+		if !req.from.IsZero() {
+			cond[mgopool.OpGte] = req.from
+		}
+		if !req.to.IsZero() {
+			cond[mgopool.OpLt] = req.to
+		}
+		match[r.TimeField] = cond
+	}
+	p.Match(match)
+
+	id := bson.M{}
+	project := bson.M{mgopool.ObjId: 0}
+	if req.bucket != "" {
+		id[ColumnBucket] = bson.M{"$dateToString": bson.M{
+			"format":   bucketFormats[req.bucket],
+			"date":     "$" + r.TimeField,
+			"timezone": req.timezone,
+		}}
+	}
+	for _, d := range req.dimensions {
+		id[d] = "$" + r.Dimensions[d]
+	}
+	for name := range id {
+		project[name] = "$_id." + name
+	}
+
+	var groupID interface{}
+	if len(id) > 0 {
+		groupID = id
+	}
+	group := bson.M{mgopool.ObjId: groupID}
+	for _, name := range req.measures {
+		m := r.Measures[name]
+		if m.Op == AggCount {
+			group[name] = bson.M{mgopool.OpSum: 1}
+		} else {
+			group[name] = bson.M{"$" + m.Op: "$" + m.Field}
+		}
+		project[name] = 1
+	}
+	p.Append(bson.M{mgopool.OpGroup: group})
+	p.Append(bson.M{mgopool.OpProject: project})
+
+	if len(req.sort) > 0 {
+		p.Sort(req.sort...)
+	}
+	p.Limit(req.limit + 1)
+	return p
+}
+
+func splitList(s string) []string {
+	ret := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+func badRequest(format string, args ...interface{}) gopkg.CodeError {
+	return gopkg.NewCodeError(gpt.CodeBadRequest, fmt.Sprintf(format, args...))
+}
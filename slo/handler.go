@@ -0,0 +1,47 @@
+package slo
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/cake/goctx"
+	"gitlab.com/cake/golibs/intercom"
+)
+
+// Middleware records the requests of the routes covered by the default tracker,
+// the error code is the one set by intercom.GinError and the other intercom responses.
+// It runs inside intercom.M800Recovery, so a panic is recorded as 5xx before it is passed on
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		t := Default()
+		if t == nil {
+			c.Next()
+			return
+		}
+		start := time.Now()
+		defer func() {
+			r := recover()
+			status := c.Writer.Status()
+			if r != nil {
+				status = http.StatusInternalServerError
+			}
+			if route := c.FullPath(); route != "" {
+				t.Record(route, status, c.GetInt(goctx.LogKeyErrorCode), time.Since(start))
+			}
+			if r != nil {
+				panic(r)
+			}
+		}()
+		c.Next()
+	}
+}
+
+// StatusHandler serves the status of the objectives
+func StatusHandler(c *gin.Context) {
+	ret := []Status{}
+	if t := Default(); t != nil {
+		ret = t.Status()
+	}
+	intercom.GinOKResponse(c, ret)
+}
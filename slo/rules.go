@@ -0,0 +1,90 @@
+package slo
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"gopkg.in/yaml.v2"
+)
+
+// RuleFile is the prometheus rule file format
+type RuleFile struct {
+	Groups []RuleGroup `yaml:"groups"`
+}
+
+type RuleGroup struct {
+	Name  string `yaml:"name"`
+	Rules []Rule `yaml:"rules"`
+}
+
+type Rule struct {
+	Record      string            `yaml:"record,omitempty"`
+	Alert       string            `yaml:"alert,omitempty"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// AlertName of the generated burn rate alerts
+const AlertName = "SLOErrorBudgetBurn"
+
+// ErrorRatioRecord is the recording rule name of the error ratio over window
+func ErrorRatioRecord(window string) string {
+	return "slo:error_ratio:rate" + window
+}
+
+// Rules generates one group per objective with the error ratio of every alert window
+// and the multi-window burn rate alerts
+func Rules(conf Config) RuleFile {
+	conf.setDefault()
+	ret := RuleFile{Groups: []RuleGroup{}}
+	for _, o := range conf.Objectives {
+		g := RuleGroup{Name: "slo-" + o.Name}
+		selector := fmt.Sprintf(`{%s=%q}`, LabelSLO, o.Name)
+		for _, w := range conf.Windows() {
+			window := FormatWindow(w)
+			g.Rules = append(g.Rules, Rule{
+				Record: ErrorRatioRecord(window),
+				Expr: fmt.Sprintf("1 - (sum by (%s) (rate(%s%s[%s])) / sum by (%s) (rate(%s%s[%s])))",
+					LabelSLO, MetricGoodEvents, selector, window, LabelSLO, MetricEvents, selector, window),
+			})
+		}
+		budget := strconv.FormatFloat(1-o.Target, 'g', 6, 64)
+		for _, a := range conf.Alerts {
+			long, short := FormatWindow(a.LongWindow), FormatWindow(a.ShortWindow)
+			threshold := fmt.Sprintf("(%s * %s)", strconv.FormatFloat(a.BurnRate, 'g', -1, 64), budget)
+			rule := Rule{
+				Alert: AlertName,
+				Expr: fmt.Sprintf("%s%s > %s and %s%s > %s",
+					ErrorRatioRecord(long), selector, threshold, ErrorRatioRecord(short), selector, threshold),
+				Labels: map[string]string{
+					"severity":  a.Severity,
+					LabelSLO:    o.Name,
+					LabelWindow: long,
+				},
+				Annotations: map[string]string{
+					"summary":     fmt.Sprintf("SLO %s is burning its error budget %vx faster than allowed", o.Name, a.BurnRate),
+					"description": fmt.Sprintf("Error ratio of %s over %s and %s exceeds %s, target %v over %s.", o.Name, long, short, threshold, o.Target, FormatWindow(o.Window)),
+				},
+			}
+			if a.For > 0 {
+				rule.For = FormatWindow(a.For)
+			}
+			g.Rules = append(g.Rules, rule)
+		}
+		ret.Groups = append(ret.Groups, g)
+	}
+	return ret
+}
+
+// WriteRules writes the rules of conf as YAML
+func WriteRules(w io.Writer, conf Config) error {
+	out, err := yaml.Marshal(Rules(conf))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
@@ -0,0 +1,164 @@
+package slo
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Objective types
+const (
+	// TypeAvailability counts 5xx responses and the configured error codes as bad events
+	TypeAvailability = "availability"
+	// TypeLatency counts responses slower than the threshold as bad events, failed requests are ignored
+	TypeLatency = "latency"
+
+	defaultWindow     = 30 * 24 * time.Hour
+	defaultResolution = time.Minute
+)
+
+var (
+	validName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+	// DefaultAlerts are the multi-window burn rate alerts of the SRE workbook for a 30 days window
+	DefaultAlerts = []Alert{
+		{Severity: "page", LongWindow: time.Hour, ShortWindow: 5 * time.Minute, BurnRate: 14.4, For: 2 * time.Minute},
+		{Severity: "page", LongWindow: 6 * time.Hour, ShortWindow: 30 * time.Minute, BurnRate: 6, For: 15 * time.Minute},
+		{Severity: "ticket", LongWindow: 24 * time.Hour, ShortWindow: 2 * time.Hour, BurnRate: 3, For: time.Hour},
+		{Severity: "ticket", LongWindow: 3 * 24 * time.Hour, ShortWindow: 6 * time.Hour, BurnRate: 1, For: 3 * time.Hour},
+	}
+)
+
+// Objective is one [[slo.objectives]] entry
+type Objective struct {
+	Name        string `mapstructure:"name"`
+	Description string `mapstructure:"description"`
+	// Routes are prefixes of the gin route path on segment boundaries, e.g. /v1/report matches /v1/report/:name
+	// but not /v1/reports
+	Routes []string `mapstructure:"routes"`
+	Type   string   `mapstructure:"type"`
+	// Target is the ratio of good events, e.g. 0.999
+	Target float64 `mapstructure:"target"`
+	// Window of the error budget, defaults to 30 days
+	Window time.Duration `mapstructure:"window"`
+	// Threshold of latency objective
+	Threshold time.Duration `mapstructure:"threshold"`
+	// ErrorCodes are intercom error codes counted as bad events besides 5xx responses
+	ErrorCodes []int `mapstructure:"error_codes"`
+}
+
+// Alert fires when the burn rate of both windows exceeds BurnRate
+type Alert struct {
+	Severity    string        `mapstructure:"severity"`
+	LongWindow  time.Duration `mapstructure:"long_window"`
+	ShortWindow time.Duration `mapstructure:"short_window"`
+	BurnRate    float64       `mapstructure:"burn_rate"`
+	For         time.Duration `mapstructure:"for"`
+}
+
+// Config is the [slo] section
+type Config struct {
+	// Resolution of the in-process sliding windows, defaults to 1m
+	Resolution time.Duration `mapstructure:"resolution"`
+	Objectives []Objective   `mapstructure:"objectives"`
+	// Alerts defaults to DefaultAlerts, their windows are published as burn rate gauges
+	Alerts []Alert `mapstructure:"alerts"`
+}
+
+// LoadConfig reads and validates the [slo] section
+func LoadConfig() (conf Config, err error) {
+	if err = viper.UnmarshalKey("slo", &conf); err != nil {
+		return
+	}
+	conf.setDefault()
+	err = conf.validate()
+	return
+}
+
+func (c *Config) setDefault() {
+	if c.Resolution <= 0 {
+		c.Resolution = defaultResolution
+	}
+	if len(c.Alerts) == 0 {
+		c.Alerts = DefaultAlerts
+	}
+	for i := range c.Objectives {
+		if c.Objectives[i].Window <= 0 {
+			c.Objectives[i].Window = defaultWindow
+		}
+	}
+}
+
+func (c Config) validate() error {
+	names := map[string]bool{}
+	for _, o := range c.Objectives {
+		if !validName.MatchString(o.Name) {
+			return fmt.Errorf("invalid slo name: %q", o.Name)
+		}
+		if names[o.Name] {
+			return fmt.Errorf("slo %s: duplicated name", o.Name)
+		}
+		names[o.Name] = true
+		switch o.Type {
+		case TypeAvailability:
+		case TypeLatency:
+			if o.Threshold <= 0 {
+				return fmt.Errorf("slo %s: threshold is required by latency objective", o.Name)
+			}
+		default:
+			return fmt.Errorf("slo %s: unknown type %q", o.Name, o.Type)
+		}
+		if o.Target <= 0 || o.Target >= 1 {
+			return fmt.Errorf("slo %s: target must be between 0 and 1", o.Name)
+		}
+		if len(o.Routes) == 0 {
+			return fmt.Errorf("slo %s: routes are required", o.Name)
+		}
+		if o.Window < c.Resolution {
+			return fmt.Errorf("slo %s: window is shorter than resolution", o.Name)
+		}
+	}
+	for _, a := range c.Alerts {
+		if a.ShortWindow < c.Resolution || a.ShortWindow >= a.LongWindow {
+			return fmt.Errorf("slo alert %s: short window must be between resolution and long window", a.Severity)
+		}
+		if a.BurnRate <= 0 {
+			return fmt.Errorf("slo alert %s: burn rate must be positive", a.Severity)
+		}
+	}
+	return nil
+}
+
+// Windows returns the sorted windows of the alerts
+func (c Config) Windows() []time.Duration {
+	set := map[time.Duration]bool{}
+	for _, a := range c.Alerts {
+		set[a.LongWindow] = true
+		set[a.ShortWindow] = true
+	}
+	ret := make([]time.Duration, 0, len(set))
+	for d := range set {
+		ret = append(ret, d)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return ret
+}
+
+// FormatWindow formats d as prometheus duration, e.g. 30d, 6h, 5m
+func FormatWindow(d time.Duration) string {
+	day := 24 * time.Hour
+	switch {
+	case d%day == 0:
+		return strconv.FormatInt(int64(d/day), 10) + "d"
+	case d%time.Hour == 0:
+		return strconv.FormatInt(int64(d/time.Hour), 10) + "h"
+	case d%time.Minute == 0:
+		return strconv.FormatInt(int64(d/time.Minute), 10) + "m"
+	default:
+		return strconv.FormatInt(int64(d/time.Second), 10) + "s"
+	}
+}
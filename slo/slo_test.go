@@ -0,0 +1,152 @@
+package slo
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"gitlab.com/cake/golibs/intercom"
+)
+
+func testConfig() Config {
+	return Config{
+		Objectives: []Objective{
+			{Name: "api", Routes: []string{"/v1/report"}, Type: TypeAvailability, Target: 0.9, Window: time.Hour, ErrorCodes: []int{42}},
+			{Name: "fast", Routes: []string{"/v1/report"}, Type: TypeLatency, Target: 0.5, Threshold: time.Second},
+		},
+		Alerts: []Alert{{Severity: "page", LongWindow: time.Hour, ShortWindow: 5 * time.Minute, BurnRate: 2}},
+	}
+}
+
+func TestWindow(t *testing.T) {
+	w := newWindow(10*time.Minute, time.Minute)
+	now := time.Unix(0, 0).Add(time.Hour)
+	w.add(now, true)
+	w.add(now.Add(5*time.Minute), false)
+	w.add(now.Add(5*time.Minute), true)
+
+	if good, total := w.sum(now.Add(5*time.Minute), 10*time.Minute); good != 2 || total != 3 {
+		t.Errorf("sum = %d/%d, want 2/3", good, total)
+	}
+	if good, total := w.sum(now.Add(5*time.Minute), time.Minute); good != 1 || total != 2 {
+		t.Errorf("sum of the last minute = %d/%d, want 1/2", good, total)
+	}
+	if _, total := w.sum(now.Add(12*time.Minute), 10*time.Minute); total != 2 {
+		t.Errorf("expired bucket should be dropped, total = %d", total)
+	}
+	if _, total := w.sum(now.Add(time.Hour), 10*time.Minute); total != 0 {
+		t.Errorf("all buckets should be dropped, total = %d", total)
+	}
+}
+
+func TestTracker(t *testing.T) {
+	conf := testConfig()
+	if err := conf.validate(); err != nil {
+		t.Fatal(err)
+	}
+	tr := NewTracker(conf)
+	now := time.Unix(1000000, 0)
+	tr.now = func() time.Time { return now }
+
+	tr.Record("/v1/report/:name", 200, 0, 10*time.Millisecond)
+	tr.Record("/v1/report/:name", 200, 0, 2*time.Second)
+	tr.Record("/v1/report/:name", 404, 0, time.Millisecond)
+	tr.Record("/v1/report/:name", 504, 0, time.Millisecond)
+	tr.Record("/v1/report", 200, 42, time.Millisecond)
+	tr.Record("/health", 500, 0, time.Millisecond)
+	tr.Record("/v1/reports", 500, 0, time.Millisecond)
+
+	status := tr.Status()
+	api, fast := status[0], status[1]
+	if api.Total != 5 || api.Good != 3 {
+		t.Errorf("availability = %d/%d, want 3/5", api.Good, api.Total)
+	}
+	// 40% errors against 10% budget
+	if math.Abs(api.BurnRates["1h"]-4) > 1e-9 || math.Abs(api.ErrorBudgetRemaining+3) > 1e-9 {
+		t.Errorf("unexpected burn rate %v and budget %v", api.BurnRates, api.ErrorBudgetRemaining)
+	}
+	// the 504 is ignored by latency objective, error code 42 is only bad for the availability one
+	if fast.Total != 4 || fast.Good != 3 || fast.Window != "30d" {
+		t.Errorf("latency = %+v", fast)
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(tr)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]int{}
+	for _, mf := range mfs {
+		names[mf.GetName()] = len(mf.GetMetric())
+	}
+	if names[MetricEvents] != 2 || names[MetricBurnRate] != 4 || names[MetricBudget] != 2 {
+		t.Errorf("unexpected metrics: %v", names)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defer func(t *Tracker) {
+		defaultTrackerMu.Lock()
+		defaultTracker = t
+		defaultTrackerMu.Unlock()
+	}(Default())
+	if err := Init(testConfig(), prometheus.NewRegistry()); err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.Use(intercom.M800Recovery(500), Middleware())
+	router.GET("/v1/report/:name", func(c *gin.Context) {
+		if c.Param("name") == "panic" {
+			panic("boom")
+		}
+	})
+	for _, path := range []string{"/v1/report/a", "/v1/report/panic"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	if api := Default().Status()[0]; api.Total != 2 || api.Good != 1 {
+		t.Errorf("availability = %d/%d, want the panic as the bad event of 2", api.Good, api.Total)
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	for _, o := range []Objective{
+		{Name: "bad name", Routes: []string{"/"}, Type: TypeAvailability, Target: 0.9},
+		{Name: "a", Routes: []string{"/"}, Type: TypeAvailability, Target: 1},
+		{Name: "a", Routes: []string{"/"}, Type: TypeLatency, Target: 0.9},
+		{Name: "a", Type: TypeAvailability, Target: 0.9},
+		{Name: "a", Routes: []string{"/"}, Type: "error", Target: 0.9},
+	} {
+		conf := Config{Objectives: []Objective{o}}
+		conf.setDefault()
+		if err := conf.validate(); err == nil {
+			t.Errorf("%+v should be rejected", o)
+		}
+	}
+}
+
+func TestRules(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteRules(buf, testConfig()); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"name: slo-api",
+		"record: slo:error_ratio:rate5m",
+		`slo:error_ratio:rate1h{slo="api"} > (2 * 0.1) and slo:error_ratio:rate5m{slo="api"}`,
+		`rate(slo_good_events_total{slo="fast"}[1h])`,
+	} {
+		if !strings.Contains(strings.Join(strings.Fields(out), " "), want) {
+			t.Errorf("rules should contain %q:\n%s", want, out)
+		}
+	}
+}
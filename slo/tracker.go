@@ -0,0 +1,225 @@
+package slo
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metric names, the alert rules are generated against them
+const (
+	MetricEvents     = "slo_events_total"
+	MetricGoodEvents = "slo_good_events_total"
+	MetricBudget     = "slo_error_budget_remaining"
+	MetricBurnRate   = "slo_burn_rate"
+	MetricTarget     = "slo_target"
+
+	LabelSLO    = "slo"
+	LabelWindow = "window"
+)
+
+var (
+	defaultTracker   *Tracker
+	defaultTrackerMu sync.RWMutex
+)
+
+// Tracker counts the good and total events of the objectives and publishes the budget and burn rate gauges,
+// the gauges are computed per process while the generated alert rules aggregate the counters of all replicas
+type Tracker struct {
+	objectives []*objective
+	windows    []time.Duration
+
+	events *prometheus.CounterVec
+	good   *prometheus.CounterVec
+	budget *prometheus.Desc
+	burn   *prometheus.Desc
+	target *prometheus.Desc
+
+	now func() time.Time
+}
+
+type objective struct {
+	Objective
+	errorCodes map[int]bool
+	window     *window
+}
+
+// Status is the current state of one objective
+type Status struct {
+	Name                 string             `json:"name"`
+	Description          string             `json:"description,omitempty"`
+	Type                 string             `json:"type"`
+	Target               float64            `json:"target"`
+	Window               string             `json:"window"`
+	Threshold            string             `json:"threshold,omitempty"`
+	Total                uint64             `json:"total"`
+	Good                 uint64             `json:"good"`
+	SLI                  float64            `json:"sli"`
+	ErrorBudgetRemaining float64            `json:"errorBudgetRemaining"`
+	BurnRates            map[string]float64 `json:"burnRates"`
+}
+
+func NewTracker(conf Config) *Tracker {
+	conf.setDefault()
+	t := &Tracker{
+		windows: conf.Windows(),
+		events: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: MetricEvents,
+			Help: "Total events of the service level objective.",
+		}, []string{LabelSLO}),
+		good: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: MetricGoodEvents,
+			Help: "Good events of the service level objective.",
+		}, []string{LabelSLO}),
+		budget: prometheus.NewDesc(MetricBudget, "Ratio of the error budget left in the objective window, negative when exhausted.", []string{LabelSLO}, nil),
+		burn:   prometheus.NewDesc(MetricBurnRate, "Error rate of the window divided by the error budget rate.", []string{LabelSLO, LabelWindow}, nil),
+		target: prometheus.NewDesc(MetricTarget, "Target ratio of good events.", []string{LabelSLO}, nil),
+		now:    time.Now,
+	}
+	longest := time.Duration(0)
+	if len(t.windows) > 0 {
+		longest = t.windows[len(t.windows)-1]
+	}
+	for _, o := range conf.Objectives {
+		size := o.Window
+		if longest > size {
+			size = longest
+		}
+		obj := &objective{Objective: o, errorCodes: map[int]bool{}, window: newWindow(size, conf.Resolution)}
+		for _, code := range o.ErrorCodes {
+			obj.errorCodes[code] = true
+		}
+		t.objectives = append(t.objectives, obj)
+		// export zero counters so that the rates exist before the first request
+		t.events.WithLabelValues(o.Name)
+		t.good.WithLabelValues(o.Name)
+	}
+	return t
+}
+
+// Init replaces the default tracker used by Middleware and StatusHandler, and registers it to reg
+func Init(conf Config, reg prometheus.Registerer) error {
+	t := NewTracker(conf)
+	if err := reg.Register(t); err != nil {
+		return err
+	}
+	defaultTrackerMu.Lock()
+	defer defaultTrackerMu.Unlock()
+	defaultTracker = t
+	return nil
+}
+
+// Default returns the tracker built by Init, nil before Init
+func Default() *Tracker {
+	defaultTrackerMu.RLock()
+	defer defaultTrackerMu.RUnlock()
+	return defaultTracker
+}
+
+// Record classifies one request for every objective whose routes match the gin route path
+func (t *Tracker) Record(route string, status, code int, elapsed time.Duration) {
+	now := t.now()
+	for _, o := range t.objectives {
+		if !o.match(route) {
+			continue
+		}
+		good, ok := o.classify(status, code, elapsed)
+		if !ok {
+			continue
+		}
+		t.events.WithLabelValues(o.Name).Inc()
+		if good {
+			t.good.WithLabelValues(o.Name).Inc()
+		}
+		o.window.add(now, good)
+	}
+}
+
+// match compares whole path segments, /v1/report matches /v1/report/:name but not /v1/reports
+func (o *objective) match(route string) bool {
+	for _, prefix := range o.Routes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if route == prefix || strings.HasPrefix(route, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// classify returns whether the request is a good event, ok is false when the objective ignores it
+func (o *objective) classify(status, code int, elapsed time.Duration) (good, ok bool) {
+	failed := status >= http.StatusInternalServerError || o.errorCodes[code]
+	switch o.Type {
+	case TypeLatency:
+		if failed {
+			return false, false
+		}
+		return elapsed <= o.Threshold, true
+	default:
+		return !failed, true
+	}
+}
+
+// burnRate is the bad ratio divided by the budget ratio, 0 without events
+func (o *objective) burnRate(good, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(total-good) / float64(total) / (1 - o.Target)
+}
+
+// Status returns the objectives in config order
+func (t *Tracker) Status() []Status {
+	now := t.now()
+	ret := make([]Status, 0, len(t.objectives))
+	for _, o := range t.objectives {
+		good, total := o.window.sum(now, o.Window)
+		s := Status{
+			Name:                 o.Name,
+			Description:          o.Description,
+			Type:                 o.Type,
+			Target:               o.Target,
+			Window:               FormatWindow(o.Window),
+			Total:                total,
+			Good:                 good,
+			SLI:                  1,
+			ErrorBudgetRemaining: 1 - o.burnRate(good, total),
+			BurnRates:            map[string]float64{},
+		}
+		if o.Type == TypeLatency {
+			s.Threshold = o.Threshold.String()
+		}
+		if total > 0 {
+			s.SLI = float64(good) / float64(total)
+		}
+		for _, w := range t.windows {
+			g, n := o.window.sum(now, w)
+			s.BurnRates[FormatWindow(w)] = o.burnRate(g, n)
+		}
+		ret = append(ret, s)
+	}
+	return ret
+}
+
+func (t *Tracker) Describe(ch chan<- *prometheus.Desc) {
+	t.events.Describe(ch)
+	t.good.Describe(ch)
+	ch <- t.budget
+	ch <- t.burn
+	ch <- t.target
+}
+
+func (t *Tracker) Collect(ch chan<- prometheus.Metric) {
+	t.events.Collect(ch)
+	t.good.Collect(ch)
+	for _, s := range t.Status() {
+		ch <- prometheus.MustNewConstMetric(t.budget, prometheus.GaugeValue, s.ErrorBudgetRemaining, s.Name)
+		ch <- prometheus.MustNewConstMetric(t.target, prometheus.GaugeValue, s.Target, s.Name)
+		for w, v := range s.BurnRates {
+			ch <- prometheus.MustNewConstMetric(t.burn, prometheus.GaugeValue, v, s.Name, w)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package slo
+
+import (
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	good, total uint64
+}
+
+// window is a ring of event counts per resolution, the oldest bucket is overwritten when time moves on
+type window struct {
+	mu         sync.Mutex
+	resolution time.Duration
+	buckets    []bucket
+	// last is the latest bucket index in resolution units since epoch
+	last int64
+}
+
+func newWindow(size, resolution time.Duration) *window {
+	return &window{
+		resolution: resolution,
+		buckets:    make([]bucket, int(size/resolution)+1),
+	}
+}
+
+// advance clears the buckets between the last and the current index, must hold mu
+func (w *window) advance(now time.Time) int64 {
+	idx := now.UnixNano() / int64(w.resolution)
+	if idx <= w.last {
+		return w.last
+	}
+	n := int64(len(w.buckets))
+	from := w.last + 1
+	if idx-from >= n {
+		from = idx - n + 1
+	}
+	for i := from; i <= idx; i++ {
+		w.buckets[mod(i, n)] = bucket{}
+	}
+	w.last = idx
+	return idx
+}
+
+func (w *window) add(now time.Time, good bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	b := &w.buckets[mod(w.advance(now), int64(len(w.buckets)))]
+	b.total++
+	if good {
+		b.good++
+	}
+}
+
+// sum counts the events of the last d including the current partial bucket
+func (w *window) sum(now time.Time, d time.Duration) (good, total uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	idx := w.advance(now)
+	n := int64(len(w.buckets))
+	k := int64((d + w.resolution - 1) / w.resolution)
+	if k > n {
+		k = n
+	}
+	for i := int64(0); i < k; i++ {
+		b := w.buckets[mod(idx-i, n)]
+		good += b.good
+		total += b.total
+	}
+	return
+}
+
+func mod(i, n int64) int64 {
+	return (i%n + n) % n
+}
@@ -0,0 +1,222 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/spf13/viper"
+	"gitlab.com/cake/goctx"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+const (
+	baggageHeader = "baggage"
+
+	// limits of the W3C baggage specification
+	defaultBaggageMembers = 180
+	defaultBaggageBytes   = 8192
+)
+
+var (
+	baggagePolicy   *BaggagePolicy
+	baggagePolicyMu sync.RWMutex
+)
+
+// BaggageConfig is the [otel.baggage] section, the baggage is propagated as is without the section
+type BaggageConfig struct {
+	// AllowedKeys are the keys accepted from the inbound requests, empty accepts all
+	AllowedKeys []string `mapstructure:"allowed_keys"`
+	// MaxMembers and MaxBytes limit the accepted and the propagated baggage, default to 180 and 8192 of W3C
+	MaxMembers int `mapstructure:"max_members"`
+	MaxBytes   int `mapstructure:"max_bytes"`
+	// Propagate sends the baggage with the outbound intercom calls, defaults to true
+	Propagate *bool `mapstructure:"propagate"`
+	// OutboundKeys are the keys sent with the outbound calls, empty sends all
+	OutboundKeys []string `mapstructure:"outbound_keys"`
+	// Promote copies the baggage members to the goctx fields, so they are written in the logs,
+	// the fields of goctx and m800log, e.g. cid, are reserved
+	Promote []BaggagePromotion `mapstructure:"promote"`
+}
+
+// BaggagePromotion copies the baggage member Key to the goctx field Field
+type BaggagePromotion struct {
+	Key   string `mapstructure:"key"`
+	Field string `mapstructure:"field"`
+}
+
+// LoadBaggageConfig reads [otel.baggage]
+func LoadBaggageConfig() (conf BaggageConfig, err error) {
+	if err = viper.UnmarshalKey("otel.baggage", &conf); err != nil {
+		return
+	}
+	conf.setDefault()
+	err = conf.validate()
+	return
+}
+
+func (c *BaggageConfig) setDefault() {
+	if c.MaxMembers == 0 {
+		c.MaxMembers = defaultBaggageMembers
+	}
+	if c.MaxBytes == 0 {
+		c.MaxBytes = defaultBaggageBytes
+	}
+	if c.Propagate == nil {
+		propagate := true
+		c.Propagate = &propagate
+	}
+}
+
+func (c *BaggageConfig) validate() error {
+	if c.MaxMembers < 0 || c.MaxBytes < 0 {
+		return fmt.Errorf("max_members and max_bytes must not be negative")
+	}
+	for i, p := range c.Promote {
+		if p.Key == "" || p.Field == "" {
+			return fmt.Errorf("baggage promotion %d: key and field are required", i)
+		}
+		if reservedField(p.Field) {
+			return fmt.Errorf("baggage promotion %d: field %s is reserved", i, p.Field)
+		}
+	}
+	return nil
+}
+
+// BaggagePolicy filters the baggage at the trust boundary, see BaggagePropagator
+type BaggagePolicy struct {
+	conf     BaggageConfig
+	allowed  map[string]bool
+	outbound map[string]bool
+}
+
+func NewBaggagePolicy(conf BaggageConfig) *BaggagePolicy {
+	conf.setDefault()
+	return &BaggagePolicy{
+		conf:     conf,
+		allowed:  set(conf.AllowedKeys),
+		outbound: set(conf.OutboundKeys),
+	}
+}
+
+func set(keys []string) map[string]bool {
+	if len(keys) == 0 {
+		return nil
+	}
+	ret := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		ret[k] = true
+	}
+	return ret
+}
+
+// SetBaggagePolicy replaces the policy of BaggagePropagator, nil propagates the baggage as is
+func SetBaggagePolicy(p *BaggagePolicy) {
+	baggagePolicyMu.Lock()
+	defer baggagePolicyMu.Unlock()
+	baggagePolicy = p
+}
+
+// DefaultBaggagePolicy returns the policy set by SetBaggagePolicy
+func DefaultBaggagePolicy() *BaggagePolicy {
+	baggagePolicyMu.RLock()
+	defer baggagePolicyMu.RUnlock()
+	return baggagePolicy
+}
+
+// Inbound strips the members not allowed and the ones beyond the limits
+func (p *BaggagePolicy) Inbound(b baggage.Baggage) baggage.Baggage {
+	return p.limit(b, p.allowed)
+}
+
+// Outbound returns the members sent with the outbound calls, empty if propagation is disabled
+func (p *BaggagePolicy) Outbound(b baggage.Baggage) baggage.Baggage {
+	if !*p.conf.Propagate {
+		return baggage.Baggage{}
+	}
+	return p.limit(b, p.outbound)
+}
+
+// limit keeps the members of the keys in order of key within the limits, nil keys keep all
+func (p *BaggagePolicy) limit(b baggage.Baggage, keys map[string]bool) baggage.Baggage {
+	members := b.Members()
+	sort.Slice(members, func(i, j int) bool { return members[i].Key() < members[j].Key() })
+	kept, size := 0, 0
+	for _, m := range members {
+		n := len(m.String())
+		if kept > 0 {
+			// the separator
+			n++
+		}
+		if (keys != nil && !keys[m.Key()]) || kept >= p.conf.MaxMembers || size+n > p.conf.MaxBytes {
+			// the members returned by Members can't be passed to baggage.New, delete the dropped ones instead
+			b = b.DeleteMember(m.Key())
+			continue
+		}
+		kept++
+		size += n
+	}
+	return b
+}
+
+// Promote sets the promoted members of the baggage of ctx to the goctx fields, the reserved fields are skipped
+func (p *BaggagePolicy) Promote(ctx goctx.Context) {
+	if len(p.conf.Promote) == 0 {
+		return
+	}
+	b := ctx.GetBaggage()
+	for _, pr := range p.conf.Promote {
+		if reservedField(pr.Field) {
+			continue
+		}
+		if v := b.Member(pr.Key).Value(); v != "" {
+			ctx.Set(pr.Field, v)
+		}
+	}
+}
+
+// reservedField reports the goctx fields propagated by intercom and the fields written by m800log and SetLogCorrelation,
+// which the baggage of the callers must not override
+func reservedField(field string) bool {
+	if _, ok := goctx.IFieldLogKeyKeyMap()[field]; ok {
+		return true
+	}
+	switch field {
+	case goctx.LogKeyUserRole, goctx.LogKeyErrorCode, goctx.LogKeyErrorMessage, goctx.LogKeyErrorType,
+		goctx.LogKeyWrapErrorCode, goctx.LogKeyWrapErrorMessage, goctx.LogKeyTimestamp, goctx.LogKeyLevel,
+		goctx.LogKeyMessage, goctx.LogKeyLogType, goctx.LogKeyApp,
+		LogKeyTraceID, LogKeySpanID, LogKeyTraceSampled, logKeySpan:
+		return true
+	}
+	return false
+}
+
+// BaggagePropagator is the W3C baggage propagator applying the default baggage policy,
+// Extract is used by the trace middleware for the inbound requests and Inject by intercom for the outbound calls
+type BaggagePropagator struct{}
+
+var _ propagation.TextMapPropagator = BaggagePropagator{}
+
+func (BaggagePropagator) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	if p := DefaultBaggagePolicy(); p != nil {
+		ctx = baggage.ContextWithBaggage(ctx, p.Outbound(baggage.FromContext(ctx)))
+	}
+	propagation.Baggage{}.Inject(ctx, carrier)
+}
+
+func (BaggagePropagator) Extract(parent context.Context, carrier propagation.TextMapCarrier) context.Context {
+	if carrier.Get(baggageHeader) == "" {
+		return parent
+	}
+	ctx := propagation.Baggage{}.Extract(parent, carrier)
+	if p := DefaultBaggagePolicy(); p != nil {
+		ctx = baggage.ContextWithBaggage(ctx, p.Inbound(baggage.FromContext(ctx)))
+	}
+	return ctx
+}
+
+func (BaggagePropagator) Fields() []string {
+	return []string{baggageHeader}
+}
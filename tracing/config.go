@@ -0,0 +1,174 @@
+package tracing
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Exporter names of [otel.traces]
+const (
+	ExporterOTLP   = "otlp"
+	ExporterJaeger = "jaeger"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+	// ExporterNone disables tracing, the incoming trace context is still propagated
+	ExporterNone = "none"
+
+	// ProtocolHTTPJSON is OTLP/HTTP with JSON encoding and ProtocolGRPC is OTLP/gRPC with protobuf encoding
+	ProtocolHTTPJSON = "http/json"
+	ProtocolGRPC     = "grpc"
+
+	CompressionGzip = "gzip"
+	CompressionNone = "none"
+
+	defaultOTLPEndpoint     = "http://localhost:4318/v1/traces"
+	defaultOTLPGRPCEndpoint = "http://localhost:4317"
+)
+
+// Config is the [otel.traces] section
+type Config struct {
+	// Exporter is otlp, jaeger, stdout, file or none, defaults to jaeger
+	Exporter string `mapstructure:"exporter"`
+	// SamplerArg is the ratio of the parent based trace ID ratio sampler
+	SamplerArg float64 `mapstructure:"sampler_arg"`
+
+	Protocol string `mapstructure:"protocol"`
+	// Endpoint is the OTLP URL or the jaeger collector URL, jaeger uses the agent of AgentHost and AgentPort if empty
+	Endpoint    string            `mapstructure:"endpoint"`
+	Headers     map[string]string `mapstructure:"headers"`
+	Compression string            `mapstructure:"compression"`
+	Timeout     time.Duration     `mapstructure:"timeout"`
+	// AgentHost and AgentPort of jaeger, the OTEL_EXPORTER_JAEGER_AGENT_* env vars are used if empty
+	AgentHost string `mapstructure:"agent_host"`
+	AgentPort string `mapstructure:"agent_port"`
+	// Path of the file exporter, one OTLP JSON request per line
+	Path string `mapstructure:"path"`
+
+	Batch    BatchConfig    `mapstructure:"batch"`
+	Sampling SamplingConfig `mapstructure:"sampling"`
+}
+
+// BatchConfig is the [otel.traces.batch] section, zero values keep the SDK defaults
+type BatchConfig struct {
+	MaxQueueSize       int           `mapstructure:"max_queue_size"`
+	MaxExportBatchSize int           `mapstructure:"max_export_batch_size"`
+	BatchTimeout       time.Duration `mapstructure:"batch_timeout"`
+	ExportTimeout      time.Duration `mapstructure:"export_timeout"`
+}
+
+// SamplingConfig is the [otel.traces.sampling] section, requests not matched by any rule are sampled by SamplerArg
+type SamplingConfig struct {
+	// Exclude are the routes or paths never traced, defaults to /health, /ready and /metrics
+	Exclude []string `mapstructure:"exclude"`
+	// SampleErrors records the spans which are not sampled and exports the whole trace once one of its spans
+	// ends with error status
+	SampleErrors bool `mapstructure:"sample_errors"`
+	// ErrorTimeout and ErrorMaxSpans bound the spans of SampleErrors buffered until their trace fails,
+	// default to 30s and 10000
+	ErrorTimeout  time.Duration `mapstructure:"error_timeout"`
+	ErrorMaxSpans int           `mapstructure:"error_max_spans"`
+	// RateLimit caps the traces per second sampled by SamplerArg, 0 is unlimited
+	RateLimit float64 `mapstructure:"rate_limit"`
+	// Rules are matched in order, the first matching rule decides
+	Rules []SamplingRule `mapstructure:"rules"`
+	// Tail retains the whole traces by their result, it supersedes SampleErrors
+	Tail TailConfig `mapstructure:"tail"`
+}
+
+// SamplingRule matches a request when all of the set conditions match
+type SamplingRule struct {
+	// Route is a path.Match pattern of the gin route, e.g. /v1/report/*, or of the path of unknown routes
+	Route  string `mapstructure:"route"`
+	Method string `mapstructure:"method"`
+	// Headers must all match, "*" matches any present value
+	Headers map[string]string `mapstructure:"headers"`
+	// Ratio of the matched traces, 1 force-samples and 0 drops them
+	Ratio float64 `mapstructure:"ratio"`
+	// RateLimit caps the traces per second of the rule, 0 is unlimited
+	RateLimit float64 `mapstructure:"rate_limit"`
+}
+
+// LoadConfig reads [otel.traces]
+func LoadConfig() (conf Config, err error) {
+	if err = viper.UnmarshalKey("otel.traces", &conf); err != nil {
+		return
+	}
+	conf.setDefault()
+	err = conf.validate()
+	return
+}
+
+func (c *Config) setDefault() {
+	if c.Exporter == "" {
+		c.Exporter = ExporterJaeger
+	}
+	if c.Exporter == ExporterOTLP && c.Protocol == "" {
+		c.Protocol = ProtocolHTTPJSON
+	}
+	if c.Exporter == ExporterOTLP && c.Endpoint == "" {
+		c.Endpoint = defaultOTLPEndpoint
+		if c.Protocol == ProtocolGRPC {
+			c.Endpoint = defaultOTLPGRPCEndpoint
+		}
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 10 * time.Second
+	}
+	if c.Sampling.Exclude == nil {
+		c.Sampling.Exclude = []string{"/health", "/ready", "/metrics"}
+	}
+	if c.Sampling.ErrorTimeout <= 0 {
+		c.Sampling.ErrorTimeout = defaultTailTimeout
+	}
+	if c.Sampling.ErrorMaxSpans <= 0 {
+		c.Sampling.ErrorMaxSpans = defaultTailMaxSpans
+	}
+	c.Sampling.Tail.setDefault()
+}
+
+func (c *Config) validate() error {
+	switch c.Exporter {
+	case ExporterOTLP:
+		switch c.Protocol {
+		case ProtocolHTTPJSON, ProtocolGRPC:
+		default:
+			return fmt.Errorf("unknown otlp protocol: %s", c.Protocol)
+		}
+	case ExporterFile:
+		if c.Path == "" {
+			return fmt.Errorf("path of trace file exporter is required")
+		}
+	case ExporterJaeger, ExporterStdout, ExporterNone:
+	default:
+		return fmt.Errorf("unknown trace exporter: %s", c.Exporter)
+	}
+	switch c.Compression {
+	case "", CompressionNone, CompressionGzip:
+	default:
+		return fmt.Errorf("unknown compression: %s", c.Compression)
+	}
+	if c.SamplerArg < 0 || c.SamplerArg > 1 {
+		return fmt.Errorf("sampler_arg must be between 0 and 1: %v", c.SamplerArg)
+	}
+	if c.Sampling.RateLimit < 0 {
+		return fmt.Errorf("rate_limit must not be negative: %v", c.Sampling.RateLimit)
+	}
+	if t := c.Sampling.Tail; t.Ratio < 0 || t.Ratio > 1 || t.Latency < 0 {
+		return fmt.Errorf("tail ratio must be between 0 and 1 and latency must not be negative")
+	}
+	for i, r := range c.Sampling.Rules {
+		if r.Route == "" && r.Method == "" && len(r.Headers) == 0 {
+			return fmt.Errorf("sampling rule %d: route, method or headers is required", i)
+		}
+		if _, err := path.Match(r.Route, "/"); err != nil {
+			return fmt.Errorf("sampling rule %d: invalid route pattern %s", i, r.Route)
+		}
+		if r.Ratio < 0 || r.Ratio > 1 || r.RateLimit < 0 {
+			return fmt.Errorf("sampling rule %d: ratio must be between 0 and 1 and rate_limit must not be negative", i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+
+	"gitlab.com/cake/go-project-template/internal/otlp"
+	oteljaeger "go.opentelemetry.io/otel/exporters/jaeger"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// newExporter returns the span exporter of conf, nil for ExporterNone
+func newExporter(conf Config) (tracesdk.SpanExporter, error) {
+	switch conf.Exporter {
+	case ExporterNone:
+		return nil, nil
+	case ExporterJaeger:
+		if conf.Endpoint != "" {
+			return oteljaeger.New(oteljaeger.WithCollectorEndpoint(
+				oteljaeger.WithEndpoint(conf.Endpoint),
+				oteljaeger.WithHTTPClient(&http.Client{Timeout: conf.Timeout, Transport: headerTransport(conf.Headers)}),
+			))
+		}
+		opts := []oteljaeger.AgentEndpointOption{}
+		if conf.AgentHost != "" {
+			opts = append(opts, oteljaeger.WithAgentHost(conf.AgentHost))
+		}
+		if conf.AgentPort != "" {
+			opts = append(opts, oteljaeger.WithAgentPort(conf.AgentPort))
+		}
+		return oteljaeger.New(oteljaeger.WithAgentEndpoint(opts...))
+	case ExporterOTLP:
+		if conf.Protocol == ProtocolGRPC {
+			client, err := otlp.NewGRPCClient(conf.Endpoint, conf.Headers, conf.Compression == CompressionGzip, conf.Timeout)
+			if err != nil {
+				return nil, err
+			}
+			return &grpcExporter{client}, nil
+		}
+		return &httpExporter{&otlp.HTTPClient{
+			Endpoint: conf.Endpoint,
+			Headers:  conf.Headers,
+			Gzip:     conf.Compression == CompressionGzip,
+			Client:   &http.Client{Timeout: conf.Timeout},
+		}}, nil
+	case ExporterStdout:
+		return &writerExporter{w: os.Stdout}, nil
+	case ExporterFile:
+		f, err := os.OpenFile(conf.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		return &writerExporter{w: f, closer: f}, nil
+	}
+	return nil, fmt.Errorf("unknown trace exporter: %s", conf.Exporter)
+}
+
+// headerTransport adds the headers to every request of the jaeger collector
+func headerTransport(headers map[string]string) http.RoundTripper {
+	if len(headers) == 0 {
+		return http.DefaultTransport
+	}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		req = req.Clone(req.Context())
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		return http.DefaultTransport.RoundTrip(req)
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// httpExporter posts OTLP JSON
+type httpExporter struct {
+	client *otlp.HTTPClient
+}
+
+func (e *httpExporter) ExportSpans(ctx context.Context, spans []tracesdk.ReadOnlySpan) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	return e.client.Post(ctx, convert(spans))
+}
+
+func (e *httpExporter) Shutdown(ctx context.Context) error {
+	e.client.Close()
+	return nil
+}
+
+// grpcExporter calls the Export method of the OTLP/gRPC trace service
+type grpcExporter struct {
+	client *otlp.GRPCClient
+}
+
+func (e *grpcExporter) ExportSpans(ctx context.Context, spans []tracesdk.ReadOnlySpan) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	return e.client.Export(ctx, otlp.TraceExportMethod, convert(spans).marshalProto())
+}
+
+func (e *grpcExporter) Shutdown(ctx context.Context) error {
+	e.client.Close()
+	return nil
+}
+
+// writerExporter writes one OTLP JSON request per line, the file format of the collector otlpjsonfile receiver
+type writerExporter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+func (e *writerExporter) ExportSpans(_ context.Context, spans []tracesdk.ReadOnlySpan) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return json.NewEncoder(e.w).Encode(convert(spans))
+}
+
+func (e *writerExporter) Shutdown(ctx context.Context) error {
+	if e.closer != nil {
+		return e.closer.Close()
+	}
+	return nil
+}
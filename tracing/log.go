@@ -0,0 +1,149 @@
+package tracing
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/cake/goctx"
+	"gitlab.com/cake/golibs/intercom"
+	"gitlab.com/cake/m800log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Log fields of the span of the context, see SetLogCorrelation
+const (
+	LogKeyTraceID      = "trace_id"
+	LogKeySpanID       = "span_id"
+	LogKeyTraceSampled = "trace_sampled"
+
+	// logKeySpan holds the spanSource in the map of goctx, which is the only part of the context m800log reads
+	logKeySpan = "otelSpan"
+
+	logEventName = "log"
+)
+
+// spanSource reads the current span of ctx when the entry is written, so spans started on ctx after BindLog are logged too
+type spanSource struct {
+	ctx goctx.Context
+}
+
+func (s spanSource) spanContext() trace.SpanContext {
+	return s.ctx.GetSpan().SpanContext()
+}
+
+// MarshalJSON keeps the output readable when the formatter isn't wrapped by SetLogCorrelation
+func (s spanSource) MarshalJSON() ([]byte, error) {
+	sc := s.spanContext()
+	if !sc.IsValid() {
+		return []byte("null"), nil
+	}
+	return []byte(`{"` + LogKeyTraceID + `":"` + sc.TraceID().String() + `","` + LogKeySpanID + `":"` + sc.SpanID().String() + `"}`), nil
+}
+
+// BindLog makes the logs written with ctx carry the IDs of the span of ctx, the child contexts share the binding
+// until BindLog is called on them, copy a bound context by CopyContext
+func BindLog(ctx goctx.Context) {
+	ctx.Set(logKeySpan, spanSource{ctx: ctx})
+}
+
+// CopyContext copies ctx like goctx.CopyContext and binds the copy when ctx is bound, the copied binding
+// would still read the span of ctx, which moves on while the detached copy runs
+func CopyContext(ctx goctx.Context) goctx.Context {
+	copied := goctx.CopyContext(ctx)
+	if _, ok := copied.Get(logKeySpan).(spanSource); ok {
+		BindLog(copied)
+	}
+	return copied
+}
+
+// StartSpan starts a span on ctx like ctx.StartSpanFromContext and binds ctx to the logs, use it in place of the
+// method of goctx so that the logs written with ctx carry the IDs of the span
+func StartSpan(ctx goctx.Context, name string, opts ...trace.SpanStartOption) trace.Span {
+	span := ctx.StartSpanFromContext(name, opts...)
+	BindLog(ctx)
+	return span
+}
+
+// SetSpan sets span as the span of ctx and binds ctx to the logs, use it in place of ctx.SetSpan
+func SetSpan(ctx goctx.Context, span trace.Span) {
+	ctx.SetSpan(span)
+	BindLog(ctx)
+}
+
+// LogMiddleware binds the request context to the logs and promotes the baggage by the default baggage policy,
+// it must be used after the trace middleware
+func LogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := intercom.GetContextFromGin(c)
+		BindLog(ctx)
+		if p := DefaultBaggagePolicy(); p != nil {
+			p.Promote(ctx)
+		}
+		c.Next()
+	}
+}
+
+// SetLogCorrelation wraps the formatter of m800log to write the trace_id, span_id and trace_sampled fields,
+// it must be called after m800log.SetM800JSONFormatter, spanEvents mirrors the error logs as events of the span
+func SetLogCorrelation(spanEvents bool) {
+	logger := m800log.GetLogger()
+	if _, ok := logger.Formatter.(*logFormatter); !ok {
+		logger.Formatter = &logFormatter{Formatter: logger.Formatter}
+	}
+	if spanEvents {
+		logger.AddHook(spanEventHook{})
+	}
+}
+
+// logFormatter replaces the span binding with the log fields of the span
+type logFormatter struct {
+	logrus.Formatter
+}
+
+func (f *logFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	sc := trace.SpanContextFromContext(entry.Context)
+	src, bound := entry.Data[logKeySpan].(spanSource)
+	if bound && !sc.IsValid() {
+		sc = src.spanContext()
+	}
+	if !bound && !sc.IsValid() {
+		return f.Formatter.Format(entry)
+	}
+
+	// entry.Data is shared with the hooks, format a copy
+	data := make(logrus.Fields, len(entry.Data)+3)
+	for k, v := range entry.Data {
+		data[k] = v
+	}
+	delete(data, logKeySpan)
+	if sc.IsValid() {
+		data[LogKeyTraceID] = sc.TraceID().String()
+		data[LogKeySpanID] = sc.SpanID().String()
+		data[LogKeyTraceSampled] = sc.IsSampled()
+	}
+	copied := *entry
+	copied.Data = data
+	return f.Formatter.Format(&copied)
+}
+
+// spanEventHook adds the error logs as events of the recording span
+type spanEventHook struct{}
+
+func (spanEventHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel}
+}
+
+func (spanEventHook) Fire(entry *logrus.Entry) error {
+	span := trace.SpanFromContext(entry.Context)
+	if src, ok := entry.Data[logKeySpan].(spanSource); ok && !span.IsRecording() {
+		span = src.ctx.GetSpan()
+	}
+	if !span.IsRecording() {
+		return nil
+	}
+	span.AddEvent(logEventName, trace.WithAttributes(
+		attribute.String("log.severity", entry.Level.String()),
+		attribute.String("log.message", entry.Message),
+	))
+	return nil
+}
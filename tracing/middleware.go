@@ -0,0 +1,15 @@
+package tracing
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// SamplingMiddleware evaluates the sampling rules of the request, it must be used before the trace middleware
+func SamplingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s := DefaultSampler(); s != nil {
+			c.Request = c.Request.WithContext(s.WithRequest(c.Request.Context(), c.FullPath(), c.Request))
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,113 @@
+package tracing
+
+import (
+	"strconv"
+	"strings"
+
+	"gitlab.com/cake/go-project-template/mongohook"
+	"gitlab.com/cake/goctx"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+)
+
+const (
+	instrumentationName = "gitlab.com/cake/go-project-template/tracing"
+
+	// maxStatementLength truncates db.statement of large pipelines and bulk selectors
+	maxStatementLength = 2048
+	sanitizedValue     = `"?"`
+)
+
+// MongoHook starts a client span of every operation following the OTel database conventions,
+// db.statement is the filter with all the values replaced by "?"
+// Reference: https://github.com/open-telemetry/opentelemetry-specification/blob/v1.7.0/specification/trace/semantic_conventions/database.md
+func MongoHook() mongohook.Hook {
+	return func(ctx goctx.Context, op mongohook.Operation) (goctx.Context, func(res mongohook.Result)) {
+		name := op.Name
+		if op.DB != "" {
+			name += " " + op.DB
+			if op.Collection != "" {
+				name += "." + op.Collection
+			}
+		}
+		attrs := []attribute.KeyValue{semconv.DBSystemMongoDB, semconv.DBOperationKey.String(op.Name)}
+		if op.DB != "" {
+			attrs = append(attrs, semconv.DBNameKey.String(op.DB))
+		}
+		if op.Collection != "" {
+			attrs = append(attrs, semconv.DBMongoDBCollectionKey.String(op.Collection))
+		}
+		if op.Filter != nil {
+			attrs = append(attrs, semconv.DBStatementKey.String(SanitizeFilter(op.Filter)))
+		}
+		if op.Documents > 0 {
+			attrs = append(attrs, attribute.Int("db.mongodb.documents", op.Documents))
+		}
+
+		_, span := otel.Tracer(instrumentationName).Start(ctx.NativeContext(), name,
+			trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+		next, cancel := ctx.WithCancel()
+		SetSpan(next, span)
+		return next, func(res mongohook.Result) {
+			for k, n := range res.Counts {
+				span.SetAttributes(attribute.Int64("db.mongodb."+k+"_count", n))
+			}
+			if res.Err != nil {
+				span.RecordError(res.Err)
+				span.SetStatus(codes.Error, res.Err.Error())
+			}
+			span.End()
+			// mongohook ends GetCursor when the cursor is closed
+			cancel()
+		}
+	}
+}
+
+// SanitizeFilter returns the JSON shape of the filter, pipeline or command with the keys kept and the values replaced
+func SanitizeFilter(filter interface{}) string {
+	raw, err := bson.Marshal(bson.D{{Key: "f", Value: filter}})
+	if err != nil {
+		return sanitizedValue
+	}
+	sb := &strings.Builder{}
+	writeSanitized(sb, bson.Raw(raw).Lookup("f"))
+	if sb.Len() > maxStatementLength {
+		return sb.String()[:maxStatementLength] + "..."
+	}
+	return sb.String()
+}
+
+func writeSanitized(sb *strings.Builder, v bson.RawValue) {
+	switch v.Type {
+	case bsontype.EmbeddedDocument:
+		elems, _ := v.Document().Elements()
+		sb.WriteByte('{')
+		for i, e := range elems {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteString(strconv.Quote(e.Key()))
+			sb.WriteByte(':')
+			writeSanitized(sb, e.Value())
+		}
+		sb.WriteByte('}')
+	case bsontype.Array:
+		values, _ := v.Array().Values()
+		sb.WriteByte('[')
+		for i, e := range values {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			writeSanitized(sb, e)
+		}
+		sb.WriteByte(']')
+	default:
+		sb.WriteString(sanitizedValue)
+	}
+}
@@ -0,0 +1,210 @@
+package tracing
+
+import (
+	"gitlab.com/cake/go-project-template/internal/otlp"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// OTLP JSON encoding of ExportTraceServiceRequest, trace and span IDs are hex encoded,
+// marshalProto encodes the same model as protobuf for gRPC,
+// see https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/trace/v1/trace.proto
+
+// OTLP status codes, which differ from the codes of the API
+const (
+	statusUnset = 0
+	statusOK    = 1
+	statusError = 2
+)
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlp.Resource    `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	SchemaURL  string           `json:"schemaUrl,omitempty"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlp.Scope `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpSpan struct {
+	TraceID                string          `json:"traceId"`
+	SpanID                 string          `json:"spanId"`
+	TraceState             string          `json:"traceState,omitempty"`
+	ParentSpanID           string          `json:"parentSpanId,omitempty"`
+	Name                   string          `json:"name"`
+	Kind                   int             `json:"kind"`
+	StartTimeUnixNano      string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano        string          `json:"endTimeUnixNano"`
+	Attributes             []otlp.KeyValue `json:"attributes,omitempty"`
+	DroppedAttributesCount int             `json:"droppedAttributesCount,omitempty"`
+	Events                 []otlpEvent     `json:"events,omitempty"`
+	DroppedEventsCount     int             `json:"droppedEventsCount,omitempty"`
+	Links                  []otlpLink      `json:"links,omitempty"`
+	DroppedLinksCount      int             `json:"droppedLinksCount,omitempty"`
+	Status                 otlpStatus      `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string          `json:"timeUnixNano"`
+	Name         string          `json:"name"`
+	Attributes   []otlp.KeyValue `json:"attributes,omitempty"`
+}
+
+type otlpLink struct {
+	TraceID    string          `json:"traceId"`
+	SpanID     string          `json:"spanId"`
+	TraceState string          `json:"traceState,omitempty"`
+	Attributes []otlp.KeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// convert groups the spans by resource and instrumentation library in the order they come
+func convert(spans []tracesdk.ReadOnlySpan) *otlpRequest {
+	req := &otlpRequest{ResourceSpans: []otlpResourceSpans{}}
+	resources := map[*resource.Resource]int{}
+	type scopeKey struct {
+		res           int
+		name, version string
+	}
+	scopes := map[scopeKey]int{}
+
+	for _, s := range spans {
+		ri, ok := resources[s.Resource()]
+		if !ok {
+			rs := otlpResourceSpans{Resource: otlp.Resource{Attributes: []otlp.KeyValue{}}, ScopeSpans: []otlpScopeSpans{}}
+			if res := s.Resource(); res != nil {
+				rs.Resource.Attributes = otlp.Attributes(res.Attributes())
+				rs.SchemaURL = res.SchemaURL()
+			}
+			ri = len(req.ResourceSpans)
+			resources[s.Resource()] = ri
+			req.ResourceSpans = append(req.ResourceSpans, rs)
+		}
+		lib := s.InstrumentationLibrary()
+		key := scopeKey{ri, lib.Name, lib.Version}
+		si, ok := scopes[key]
+		if !ok {
+			si = len(req.ResourceSpans[ri].ScopeSpans)
+			scopes[key] = si
+			req.ResourceSpans[ri].ScopeSpans = append(req.ResourceSpans[ri].ScopeSpans,
+				otlpScopeSpans{Scope: otlp.Scope{Name: lib.Name, Version: lib.Version}, Spans: []otlpSpan{}})
+		}
+		scope := &req.ResourceSpans[ri].ScopeSpans[si]
+		scope.Spans = append(scope.Spans, span(s))
+	}
+	return req
+}
+
+func span(s tracesdk.ReadOnlySpan) otlpSpan {
+	sc := s.SpanContext()
+	ret := otlpSpan{
+		TraceID:                sc.TraceID().String(),
+		SpanID:                 sc.SpanID().String(),
+		TraceState:             sc.TraceState().String(),
+		Name:                   s.Name(),
+		Kind:                   int(s.SpanKind()),
+		StartTimeUnixNano:      otlp.UnixNano(s.StartTime()),
+		EndTimeUnixNano:        otlp.UnixNano(s.EndTime()),
+		Attributes:             otlp.Attributes(s.Attributes()),
+		DroppedAttributesCount: s.DroppedAttributes(),
+		DroppedEventsCount:     s.DroppedEvents(),
+		DroppedLinksCount:      s.DroppedLinks(),
+		Status:                 otlpStatus{Code: statusCode(s.Status().Code), Message: s.Status().Description},
+	}
+	if p := s.Parent(); p.SpanID().IsValid() {
+		ret.ParentSpanID = p.SpanID().String()
+	}
+	for _, e := range s.Events() {
+		ret.Events = append(ret.Events, otlpEvent{TimeUnixNano: otlp.UnixNano(e.Time), Name: e.Name, Attributes: otlp.Attributes(e.Attributes)})
+	}
+	for _, l := range s.Links() {
+		ret.Links = append(ret.Links, otlpLink{
+			TraceID:    l.SpanContext.TraceID().String(),
+			SpanID:     l.SpanContext.SpanID().String(),
+			TraceState: l.SpanContext.TraceState().String(),
+			Attributes: otlp.Attributes(l.Attributes),
+		})
+	}
+	return ret
+}
+
+func statusCode(c codes.Code) int {
+	switch c {
+	case codes.Ok:
+		return statusOK
+	case codes.Error:
+		return statusError
+	default:
+		return statusUnset
+	}
+}
+
+// marshalProto encodes the request as the protobuf ExportTraceServiceRequest
+func (r *otlpRequest) marshalProto() []byte {
+	var b []byte
+	for _, rs := range r.ResourceSpans {
+		rs := rs
+		b = otlp.AppendMessage(b, 1, func(b []byte) []byte {
+			b = otlp.AppendResource(b, 1, rs.Resource)
+			for _, ss := range rs.ScopeSpans {
+				ss := ss
+				b = otlp.AppendMessage(b, 2, func(b []byte) []byte {
+					b = otlp.AppendScope(b, 1, ss.Scope)
+					for i := range ss.Spans {
+						b = otlp.AppendMessage(b, 2, ss.Spans[i].appendProto)
+					}
+					return b
+				})
+			}
+			return otlp.AppendString(b, 3, rs.SchemaURL)
+		})
+	}
+	return b
+}
+
+func (s *otlpSpan) appendProto(b []byte) []byte {
+	b = otlp.AppendID(b, 1, s.TraceID)
+	b = otlp.AppendID(b, 2, s.SpanID)
+	b = otlp.AppendString(b, 3, s.TraceState)
+	b = otlp.AppendID(b, 4, s.ParentSpanID)
+	b = otlp.AppendString(b, 5, s.Name)
+	b = otlp.AppendVarint(b, 6, uint64(s.Kind))
+	b = otlp.AppendFixed64(b, 7, s.StartTimeUnixNano)
+	b = otlp.AppendFixed64(b, 8, s.EndTimeUnixNano)
+	b = otlp.AppendKeyValues(b, 9, s.Attributes)
+	b = otlp.AppendVarint(b, 10, uint64(s.DroppedAttributesCount))
+	for _, e := range s.Events {
+		e := e
+		b = otlp.AppendMessage(b, 11, func(b []byte) []byte {
+			b = otlp.AppendFixed64(b, 1, e.TimeUnixNano)
+			b = otlp.AppendString(b, 2, e.Name)
+			return otlp.AppendKeyValues(b, 3, e.Attributes)
+		})
+	}
+	b = otlp.AppendVarint(b, 12, uint64(s.DroppedEventsCount))
+	for _, l := range s.Links {
+		l := l
+		b = otlp.AppendMessage(b, 13, func(b []byte) []byte {
+			b = otlp.AppendID(b, 1, l.TraceID)
+			b = otlp.AppendID(b, 2, l.SpanID)
+			b = otlp.AppendString(b, 3, l.TraceState)
+			return otlp.AppendKeyValues(b, 4, l.Attributes)
+		})
+	}
+	b = otlp.AppendVarint(b, 14, uint64(s.DroppedLinksCount))
+	return otlp.AppendMessage(b, 15, func(b []byte) []byte {
+		b = otlp.AppendString(b, 2, s.Status.Message)
+		return otlp.AppendVarint(b, 3, uint64(s.Status.Code))
+	})
+}
@@ -0,0 +1,361 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+)
+
+type decisionKey struct{}
+
+// decision is evaluated by SamplingMiddleware from the request and read by the sampler from the context
+type decision struct {
+	excluded bool
+	rule     *rule
+}
+
+type rule struct {
+	SamplingRule
+	sampler tracesdk.Sampler
+	limiter *rateLimiter
+}
+
+// Sampler decides the root spans and the spans of remote parents by the rules of the request,
+// the local child spans follow their parent
+type Sampler struct {
+	rules        []*rule
+	exclude      []string
+	sampleErrors bool
+	tail         bool
+	fallback     tracesdk.Sampler
+	limiter      *rateLimiter
+}
+
+var (
+	defaultSampler   *Sampler
+	defaultSamplerMu sync.RWMutex
+)
+
+// NewSampler samples the requests not matched by any rule by ratio
+func NewSampler(ratio float64, conf SamplingConfig) *Sampler {
+	s := &Sampler{
+		exclude:      conf.Exclude,
+		sampleErrors: conf.SampleErrors,
+		tail:         conf.Tail.Enabled,
+		fallback:     tracesdk.TraceIDRatioBased(ratio),
+		limiter:      newRateLimiter(conf.RateLimit),
+	}
+	for _, r := range conf.Rules {
+		s.rules = append(s.rules, &rule{
+			SamplingRule: r,
+			sampler:      tracesdk.TraceIDRatioBased(r.Ratio),
+			limiter:      newRateLimiter(r.RateLimit),
+		})
+	}
+	return s
+}
+
+// DefaultSampler returns the sampler of the provider built by Init, nil when tracing is disabled
+func DefaultSampler() *Sampler {
+	defaultSamplerMu.RLock()
+	defer defaultSamplerMu.RUnlock()
+	return defaultSampler
+}
+
+func setDefaultSampler(s *Sampler) {
+	defaultSamplerMu.Lock()
+	defer defaultSamplerMu.Unlock()
+	defaultSampler = s
+}
+
+// WithRequest evaluates the rules against the request of route, empty route is for unknown routes
+func (s *Sampler) WithRequest(ctx context.Context, route string, req *http.Request) context.Context {
+	target := route
+	if target == "" {
+		target = req.URL.Path
+	}
+	for _, e := range s.exclude {
+		if e == target || e == req.URL.Path {
+			return context.WithValue(ctx, decisionKey{}, decision{excluded: true})
+		}
+	}
+	for _, r := range s.rules {
+		if r.match(target, req) {
+			return context.WithValue(ctx, decisionKey{}, decision{rule: r})
+		}
+	}
+	return ctx
+}
+
+func (r *rule) match(target string, req *http.Request) bool {
+	if r.Route != "" {
+		if ok, _ := path.Match(r.Route, target); !ok {
+			return false
+		}
+	}
+	if r.Method != "" && !strings.EqualFold(r.Method, req.Method) {
+		return false
+	}
+	for k, v := range r.Headers {
+		got := req.Header.Get(k)
+		if got == "" || (v != "*" && got != v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Sampler) ShouldSample(p tracesdk.SamplingParameters) tracesdk.SamplingResult {
+	psc := trace.SpanContextFromContext(p.ParentContext)
+	ret := tracesdk.SamplingResult{Tracestate: psc.TraceState()}
+
+	if psc.IsValid() && !psc.IsRemote() {
+		switch {
+		case psc.IsSampled():
+			ret.Decision = tracesdk.RecordAndSample
+		case trace.SpanFromContext(p.ParentContext).IsRecording():
+			ret.Decision = tracesdk.RecordOnly
+		default:
+			ret.Decision = tracesdk.Drop
+		}
+		return ret
+	}
+
+	d, _ := p.ParentContext.Value(decisionKey{}).(decision)
+	switch {
+	case d.excluded:
+		ret.Decision = tracesdk.Drop
+		return ret
+	case d.rule != nil:
+		ret.Decision = d.rule.sampler.ShouldSample(p).Decision
+		if ret.Decision == tracesdk.RecordAndSample && !d.rule.limiter.allow() {
+			ret.Decision = tracesdk.Drop
+		}
+	case psc.IsValid():
+		ret.Decision = tracesdk.Drop
+		if psc.IsSampled() {
+			ret.Decision = tracesdk.RecordAndSample
+		}
+	default:
+		ret.Decision = s.fallback.ShouldSample(p).Decision
+		if ret.Decision == tracesdk.RecordAndSample && !s.limiter.allow() {
+			ret.Decision = tracesdk.Drop
+		}
+	}
+
+	// defer the decision to the end of the span or the trace, see deferredProcessor and tailProcessor
+	if ret.Decision == tracesdk.Drop && (s.sampleErrors || s.tail) {
+		ret.Decision = tracesdk.RecordOnly
+	}
+	return ret
+}
+
+func (s *Sampler) Description() string {
+	return fmt.Sprintf("RuleBased{rules:%d,fallback:%s,sampleErrors:%t,tail:%t}", len(s.rules), s.fallback.Description(), s.sampleErrors, s.tail)
+}
+
+// rateLimiter is a token bucket of rate tokens per second with burst of one second, nil allows all
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	l := &rateLimiter{rate: rate, now: time.Now}
+	l.tokens = l.burst()
+	return l
+}
+
+func (l *rateLimiter) burst() float64 {
+	if l.rate < 1 {
+		return 1
+	}
+	return l.rate
+}
+
+func (l *rateLimiter) allow() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+	}
+	if burst := l.burst(); l.tokens > burst {
+		l.tokens = burst
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// deferredProcessor buffers the recorded but not sampled spans by trace until one of them ends with error status,
+// then passes the buffered spans of the trace and its later ones to the next processor as sampled.
+// A trace is forgotten when its local root ends without error or when it's older than the timeout
+type deferredProcessor struct {
+	next     tracesdk.SpanProcessor
+	timeout  time.Duration
+	maxSpans int
+	now      func() time.Time
+
+	mu       sync.Mutex
+	traces   map[trace.TraceID]*deferredTrace
+	buffered int
+	// failed keeps the failed traces for their spans ending after the error
+	failed map[trace.TraceID]time.Time
+
+	stop   chan struct{}
+	wg     sync.WaitGroup
+	closed sync.Once
+}
+
+type deferredTrace struct {
+	spans   []tracesdk.ReadOnlySpan
+	started time.Time
+}
+
+var _ tracesdk.SpanProcessor = (*deferredProcessor)(nil)
+
+func newDeferredProcessor(next tracesdk.SpanProcessor, conf SamplingConfig) *deferredProcessor {
+	p := &deferredProcessor{
+		next:     next,
+		timeout:  conf.ErrorTimeout,
+		maxSpans: conf.ErrorMaxSpans,
+		now:      time.Now,
+		traces:   map[trace.TraceID]*deferredTrace{},
+		failed:   map[trace.TraceID]time.Time{},
+		stop:     make(chan struct{}),
+	}
+	p.wg.Add(1)
+	go p.loop()
+	return p
+}
+
+func (p *deferredProcessor) OnStart(parent context.Context, s tracesdk.ReadWriteSpan) {
+	p.next.OnStart(parent, s)
+}
+
+func (p *deferredProcessor) OnEnd(s tracesdk.ReadOnlySpan) {
+	if s.SpanContext().IsSampled() {
+		p.next.OnEnd(s)
+		return
+	}
+	id := s.SpanContext().TraceID()
+	var export []tracesdk.ReadOnlySpan
+
+	p.mu.Lock()
+	_, failed := p.failed[id]
+	switch {
+	case failed:
+		export = append(export, s)
+	case s.Status().Code == codes.Error:
+		if t := p.traces[id]; t != nil {
+			export = t.spans
+			p.forget(id)
+		}
+		export = append(export, s)
+		if len(p.failed) < p.maxSpans {
+			p.failed[id] = p.now()
+		}
+	case isLocalRoot(s):
+		// the trace ended here without error
+		p.forget(id)
+	case p.buffered >= p.maxSpans:
+	default:
+		t := p.traces[id]
+		if t == nil {
+			t = &deferredTrace{started: p.now()}
+			p.traces[id] = t
+		}
+		t.spans = append(t.spans, s)
+		p.buffered++
+	}
+	p.mu.Unlock()
+
+	for _, s := range export {
+		p.next.OnEnd(sampledSpan{s})
+	}
+}
+
+func (p *deferredProcessor) forget(id trace.TraceID) {
+	if t := p.traces[id]; t != nil {
+		p.buffered -= len(t.spans)
+		delete(p.traces, id)
+	}
+}
+
+func (p *deferredProcessor) loop() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.sweep(p.now())
+		}
+	}
+}
+
+// sweep forgets the traces and the failures older than the timeout
+func (p *deferredProcessor) sweep(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, t := range p.traces {
+		if now.Sub(t.started) >= p.timeout {
+			p.forget(id)
+		}
+	}
+	for id, at := range p.failed {
+		if now.Sub(at) >= p.timeout {
+			delete(p.failed, id)
+		}
+	}
+}
+
+// Shutdown drops the buffered spans, none of their traces failed
+func (p *deferredProcessor) Shutdown(ctx context.Context) error {
+	p.closed.Do(func() {
+		close(p.stop)
+		p.wg.Wait()
+	})
+	p.mu.Lock()
+	p.traces = map[trace.TraceID]*deferredTrace{}
+	p.buffered = 0
+	p.mu.Unlock()
+	return p.next.Shutdown(ctx)
+}
+
+func (p *deferredProcessor) ForceFlush(ctx context.Context) error {
+	return p.next.ForceFlush(ctx)
+}
+
+type sampledSpan struct {
+	tracesdk.ReadOnlySpan
+}
+
+func (s sampledSpan) SpanContext() trace.SpanContext {
+	sc := s.ReadOnlySpan.SpanContext()
+	return sc.WithTraceFlags(sc.TraceFlags().WithSampled(true))
+}
@@ -0,0 +1,350 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gitlab.com/cake/golibs/intercom"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	m800schema "gitlab.com/cake/golibs/intercom/schemas/v1.0.0"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+)
+
+// Decisions of the tail processor, the label values of trace_tail_decisions_total
+const (
+	TailDecisionError   = "error"
+	TailDecisionStatus  = "status"
+	TailDecisionLatency = "latency"
+	TailDecisionSampled = "sampled"
+	TailDecisionRatio   = "ratio"
+	TailDecisionDropped = "dropped"
+
+	// reasons of trace_tail_dropped_spans_total
+	tailDropBufferFull = "buffer_full"
+	tailDropTraceLimit = "trace_limit"
+	tailDropLate       = "late"
+
+	defaultTailTimeout       = 30 * time.Second
+	defaultTailMaxSpans      = 10000
+	defaultTailMaxTraceSpans = 1000
+)
+
+var (
+	tailCollector   prometheus.Collector
+	tailCollectorMu sync.RWMutex
+
+	ginErrorCodeKey = attribute.Key(intercom.TraceTagGinErrorCode)
+)
+
+// TailConfig is the [otel.traces.sampling.tail] section, the spans not sampled by the head sampler are recorded
+// and buffered until the local root span ends, then the whole trace is exported or dropped
+type TailConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Ratio of the traces retained besides the failed, slow and head sampled ones
+	Ratio float64 `mapstructure:"ratio"`
+	// Latency retains the traces whose local root lasts at least the duration, 0 disables
+	Latency time.Duration `mapstructure:"latency"`
+	// Timeout decides the traces whose local root hasn't ended and forgets the decisions, defaults to 30s
+	Timeout time.Duration `mapstructure:"timeout"`
+	// MaxSpans buffered of all traces and MaxTraceSpans of one trace, the spans beyond them are dropped,
+	// default to 10000 and 1000. MaxSpans decisions are kept too, the oldest ones are forgotten first
+	MaxSpans      int `mapstructure:"max_spans"`
+	MaxTraceSpans int `mapstructure:"max_trace_spans"`
+}
+
+func (c *TailConfig) setDefault() {
+	if c.Timeout <= 0 {
+		c.Timeout = defaultTailTimeout
+	}
+	if c.MaxSpans <= 0 {
+		c.MaxSpans = defaultTailMaxSpans
+	}
+	if c.MaxTraceSpans <= 0 {
+		c.MaxTraceSpans = defaultTailMaxTraceSpans
+	}
+}
+
+// TailCollector returns the metrics of the tail processor of the provider built by Init, nil if tail sampling is disabled
+// The metrics are named trace_tail_*, the app prefixes them with the metric namespace by metric.Registry.Namespaced
+func TailCollector() prometheus.Collector {
+	tailCollectorMu.RLock()
+	defer tailCollectorMu.RUnlock()
+	return tailCollector
+}
+
+func setTailCollector(c prometheus.Collector) {
+	tailCollectorMu.Lock()
+	defer tailCollectorMu.Unlock()
+	tailCollector = c
+}
+
+type tailTrace struct {
+	spans   []tracesdk.ReadOnlySpan
+	started time.Time
+}
+
+// tailProcessor buffers the spans by trace and passes the retained traces to the next processor as sampled
+type tailProcessor struct {
+	next  tracesdk.SpanProcessor
+	conf  TailConfig
+	ratio tracesdk.Sampler
+	now   func() time.Time
+
+	mu       sync.Mutex
+	traces   map[trace.TraceID]*tailTrace
+	buffered int
+	// decided keeps the decision of the traces for the spans ending after the local root,
+	// decidedOrder is the order of the decisions to forget the oldest ones
+	decided      map[trace.TraceID]decidedTrace
+	decidedOrder []decidedEntry
+
+	stop   chan struct{}
+	wg     sync.WaitGroup
+	closed sync.Once
+
+	decisions    *prometheus.CounterVec
+	dropped      *prometheus.CounterVec
+	bufferedDesc *prometheus.Desc
+}
+
+type decidedTrace struct {
+	keep bool
+	at   time.Time
+}
+
+type decidedEntry struct {
+	id trace.TraceID
+	at time.Time
+}
+
+var _ tracesdk.SpanProcessor = (*tailProcessor)(nil)
+
+func newTailProcessor(next tracesdk.SpanProcessor, conf TailConfig) *tailProcessor {
+	conf.setDefault()
+	p := &tailProcessor{
+		next:    next,
+		conf:    conf,
+		ratio:   tracesdk.TraceIDRatioBased(conf.Ratio),
+		now:     time.Now,
+		traces:  map[trace.TraceID]*tailTrace{},
+		decided: map[trace.TraceID]decidedTrace{},
+		decisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "trace_tail_decisions_total",
+			Help: "Traces decided by the tail processor by decision, dropped traces are not exported.",
+		}, []string{"decision"}),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "trace_tail_dropped_spans_total",
+			Help: "Spans dropped by the buffer limits of the tail processor or ended after a dropped trace was decided.",
+		}, []string{"reason"}),
+		bufferedDesc: prometheus.NewDesc("trace_tail_buffered_spans", "Spans buffered by the tail processor.", nil, nil),
+		stop:         make(chan struct{}),
+	}
+	p.wg.Add(1)
+	go p.loop()
+	return p
+}
+
+func (p *tailProcessor) OnStart(parent context.Context, s tracesdk.ReadWriteSpan) {
+	p.next.OnStart(parent, s)
+}
+
+func (p *tailProcessor) OnEnd(s tracesdk.ReadOnlySpan) {
+	id := s.SpanContext().TraceID()
+	now := p.now()
+	var export []tracesdk.ReadOnlySpan
+
+	p.mu.Lock()
+	if d, ok := p.decided[id]; ok && p.traces[id] == nil {
+		p.mu.Unlock()
+		if d.keep {
+			p.forward([]tracesdk.ReadOnlySpan{s})
+		} else {
+			p.dropped.WithLabelValues(tailDropLate).Inc()
+		}
+		return
+	}
+	t := p.traces[id]
+	root := isLocalRoot(s)
+	admit := true
+	switch {
+	case root:
+		// the local root carries the route, status and duration of the trace and is decided right away,
+		// so it's admitted beyond the limits
+	case t != nil && len(t.spans) >= p.conf.MaxTraceSpans:
+		p.dropped.WithLabelValues(tailDropTraceLimit).Inc()
+		admit = false
+	case p.buffered >= p.conf.MaxSpans:
+		p.dropped.WithLabelValues(tailDropBufferFull).Inc()
+		admit = false
+	}
+	if admit {
+		if t == nil {
+			t = &tailTrace{started: now}
+			p.traces[id] = t
+		}
+		t.spans = append(t.spans, s)
+		p.buffered++
+	}
+	if root {
+		export = append(export, p.decide(id, s, now)...)
+	}
+	p.mu.Unlock()
+	p.forward(export)
+}
+
+func isLocalRoot(s tracesdk.ReadOnlySpan) bool {
+	return !s.Parent().IsValid() || s.Parent().IsRemote()
+}
+
+// decide removes the trace from the buffer and returns its spans if retained, root is nil for the timeout traces
+func (p *tailProcessor) decide(id trace.TraceID, root tracesdk.ReadOnlySpan, now time.Time) []tracesdk.ReadOnlySpan {
+	t := p.traces[id]
+	delete(p.traces, id)
+	p.buffered -= len(t.spans)
+
+	decision := p.decision(id, t.spans, root)
+	p.decisions.WithLabelValues(decision).Inc()
+	keep := decision != TailDecisionDropped
+	if len(p.decided) >= p.conf.MaxSpans {
+		p.forgetOldest()
+	}
+	p.decided[id] = decidedTrace{keep: keep, at: now}
+	p.decidedOrder = append(p.decidedOrder, decidedEntry{id: id, at: now})
+	if !keep {
+		return nil
+	}
+	return t.spans
+}
+
+func (p *tailProcessor) decision(id trace.TraceID, spans []tracesdk.ReadOnlySpan, root tracesdk.ReadOnlySpan) string {
+	for _, s := range spans {
+		if s.Status().Code == codes.Error {
+			return TailDecisionError
+		}
+	}
+	if root != nil {
+		for _, kv := range root.Attributes() {
+			switch kv.Key {
+			case semconv.HTTPStatusCodeKey:
+				if code := kv.Value.AsInt64(); code < 200 || code > 299 {
+					return TailDecisionStatus
+				}
+			case ginErrorCodeKey, m800schema.M800ErrorCodeKey:
+				if kv.Value.AsInt64() != 0 {
+					return TailDecisionStatus
+				}
+			}
+		}
+		if p.conf.Latency > 0 && root.EndTime().Sub(root.StartTime()) >= p.conf.Latency {
+			return TailDecisionLatency
+		}
+	}
+	for _, s := range spans {
+		if s.SpanContext().IsSampled() {
+			return TailDecisionSampled
+		}
+	}
+	if p.ratio.ShouldSample(tracesdk.SamplingParameters{TraceID: id}).Decision == tracesdk.RecordAndSample {
+		return TailDecisionRatio
+	}
+	return TailDecisionDropped
+}
+
+// forgetOldest removes the oldest decision, the entries of the decisions replaced by a later one are skipped
+func (p *tailProcessor) forgetOldest() {
+	for len(p.decidedOrder) > 0 {
+		e := p.decidedOrder[0]
+		p.decidedOrder = p.decidedOrder[1:]
+		if d, ok := p.decided[e.id]; ok && d.at.Equal(e.at) {
+			delete(p.decided, e.id)
+			return
+		}
+	}
+}
+
+func (p *tailProcessor) loop() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.conf.Timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.forward(p.sweep(p.now()))
+		}
+	}
+}
+
+// sweep decides the traces buffered longer than the timeout and forgets the old decisions
+func (p *tailProcessor) sweep(now time.Time) []tracesdk.ReadOnlySpan {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var export []tracesdk.ReadOnlySpan
+	for id, t := range p.traces {
+		if now.Sub(t.started) >= p.conf.Timeout {
+			export = append(export, p.decide(id, nil, now)...)
+		}
+	}
+	for len(p.decidedOrder) > 0 && now.Sub(p.decidedOrder[0].at) >= p.conf.Timeout {
+		e := p.decidedOrder[0]
+		p.decidedOrder = p.decidedOrder[1:]
+		if d, ok := p.decided[e.id]; ok && d.at.Equal(e.at) {
+			delete(p.decided, e.id)
+		}
+	}
+	return export
+}
+
+// forward passes the spans as sampled since the next processor drops the unsampled ones
+func (p *tailProcessor) forward(spans []tracesdk.ReadOnlySpan) {
+	for _, s := range spans {
+		if !s.SpanContext().IsSampled() {
+			s = sampledSpan{s}
+		}
+		p.next.OnEnd(s)
+	}
+}
+
+// Shutdown decides all the buffered traces as timeout before shutting down the next processor
+func (p *tailProcessor) Shutdown(ctx context.Context) error {
+	p.closed.Do(func() {
+		close(p.stop)
+		p.wg.Wait()
+	})
+	now := p.now()
+	var export []tracesdk.ReadOnlySpan
+	p.mu.Lock()
+	for id := range p.traces {
+		export = append(export, p.decide(id, nil, now)...)
+	}
+	p.mu.Unlock()
+	p.forward(export)
+	return p.next.Shutdown(ctx)
+}
+
+// ForceFlush flushes the next processor, the traces in progress are kept in the buffer
+func (p *tailProcessor) ForceFlush(ctx context.Context) error {
+	return p.next.ForceFlush(ctx)
+}
+
+func (p *tailProcessor) Describe(ch chan<- *prometheus.Desc) {
+	p.decisions.Describe(ch)
+	p.dropped.Describe(ch)
+	ch <- p.bufferedDesc
+}
+
+func (p *tailProcessor) Collect(ch chan<- prometheus.Metric) {
+	p.decisions.Collect(ch)
+	p.dropped.Collect(ch)
+	p.mu.Lock()
+	buffered := p.buffered
+	p.mu.Unlock()
+	ch <- prometheus.MustNewConstMetric(p.bufferedDesc, prometheus.GaugeValue, float64(buffered))
+}
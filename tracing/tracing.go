@@ -0,0 +1,77 @@
+package tracing
+
+import (
+	"gitlab.com/cake/go-project-template/internal/otlp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/trace"
+
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// NewResource returns the same resource attributes as m800trace.InitTracer
+func NewResource(componentName, localNamespace string) *resource.Resource {
+	return otlp.NewResource(componentName, localNamespace)
+}
+
+// Init replaces m800trace.InitTracer with the exporter of conf and sets the global tracer provider and propagator,
+// ExporterNone keeps the span processors of opts, nil provider is returned and the global provider is the no-op one
+// when there are none of them
+func Init(conf Config, componentName, localNamespace string, opts ...tracesdk.TracerProviderOption) (*tracesdk.TracerProvider, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, BaggagePropagator{}))
+
+	exporter, err := newExporter(conf)
+	if err != nil {
+		return nil, err
+	}
+	setTailCollector(nil)
+	if exporter == nil && len(opts) == 0 {
+		setDefaultSampler(nil)
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+		return nil, nil
+	}
+
+	// the trace ID ratio of the built-in sampler is the fallback of the rules
+	// Reference: https://github.com/open-telemetry/opentelemetry-specification/blob/main/specification/trace/sdk.md#built-in-samplers
+	sampler := NewSampler(conf.SamplerArg, conf.Sampling)
+	setDefaultSampler(sampler)
+	allOpts := []tracesdk.TracerProviderOption{
+		tracesdk.WithSampler(sampler),
+		tracesdk.WithResource(NewResource(componentName, localNamespace)),
+	}
+	allOpts = append(allOpts, opts...)
+	if exporter != nil {
+		var processor tracesdk.SpanProcessor = tracesdk.NewBatchSpanProcessor(exporter, conf.Batch.options()...)
+		switch {
+		case conf.Sampling.Tail.Enabled:
+			tail := newTailProcessor(processor, conf.Sampling.Tail)
+			setTailCollector(tail)
+			processor = tail
+		case conf.Sampling.SampleErrors:
+			processor = newDeferredProcessor(processor, conf.Sampling)
+		}
+		allOpts = append(allOpts, tracesdk.WithSpanProcessor(processor))
+	}
+
+	tp := tracesdk.NewTracerProvider(allOpts...)
+	otel.SetTracerProvider(tp)
+	return tp, nil
+}
+
+func (c BatchConfig) options() []tracesdk.BatchSpanProcessorOption {
+	ret := []tracesdk.BatchSpanProcessorOption{}
+	if c.MaxQueueSize > 0 {
+		ret = append(ret, tracesdk.WithMaxQueueSize(c.MaxQueueSize))
+	}
+	if c.MaxExportBatchSize > 0 {
+		ret = append(ret, tracesdk.WithMaxExportBatchSize(c.MaxExportBatchSize))
+	}
+	if c.BatchTimeout > 0 {
+		ret = append(ret, tracesdk.WithBatchTimeout(c.BatchTimeout))
+	}
+	if c.ExportTimeout > 0 {
+		ret = append(ret, tracesdk.WithExportTimeout(c.ExportTimeout))
+	}
+	return ret
+}
@@ -0,0 +1,705 @@
+package tracing
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/cake/go-project-template/internal/otlp"
+	"gitlab.com/cake/go-project-template/internal/otlp/otlptest"
+	"gitlab.com/cake/go-project-template/mongohook"
+	"gitlab.com/cake/goctx"
+	"gitlab.com/cake/gopkg"
+	"gitlab.com/cake/m800log"
+	"gitlab.com/cake/mgopool/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
+
+	dto "github.com/prometheus/client_model/go"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+func testSpans(t *testing.T, conf Config) {
+	tp, err := Init(conf, "test", "ns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, parent := otel.Tracer("tracing-test").Start(context.Background(), "parent")
+	_, child := otel.Tracer("tracing-test").Start(ctx, "child", trace.WithSpanKind(trace.SpanKindClient))
+	child.SetAttributes(attribute.Int("count", 3))
+	child.RecordError(errors.New("boom"))
+	child.SetStatus(codes.Error, "boom")
+	child.End()
+	parent.End()
+	if err := tp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkRequest(t *testing.T, req otlpRequest) {
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected request: %+v", req)
+	}
+	if attrs := req.ResourceSpans[0].Resource.Attributes; len(attrs) == 0 {
+		t.Errorf("resource attributes missing")
+	}
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	child, parent := spans[0], spans[1]
+	if child.ParentSpanID != parent.SpanID || child.TraceID != parent.TraceID || len(child.TraceID) != 32 {
+		t.Errorf("child %+v should be under parent %+v", child, parent)
+	}
+	if child.Kind != int(trace.SpanKindClient) || child.Status.Code != statusError || len(child.Events) != 1 {
+		t.Errorf("unexpected child: %+v", child)
+	}
+	if v := child.Attributes[0].Value.IntValue; v == nil || *v != "3" {
+		t.Errorf("unexpected attributes: %+v", child.Attributes)
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	testSpans(t, Config{Exporter: ExporterFile, Path: path, SamplerArg: 1})
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected one batch per line, got %d lines", len(lines))
+	}
+	var req otlpRequest
+	if err := json.Unmarshal([]byte(lines[0]), &req); err != nil {
+		t.Fatal(err)
+	}
+	checkRequest(t, req)
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var req otlpRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "gzip" || r.Header.Get("Authorization") != "token" {
+			t.Errorf("unexpected headers: %v", r.Header)
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(zr)
+		if err := json.Unmarshal(b, &req); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	conf := Config{Exporter: ExporterOTLP, Endpoint: srv.URL, Compression: CompressionGzip, SamplerArg: 1,
+		Headers: map[string]string{"authorization": "token"}}
+	conf.setDefault()
+	if err := conf.validate(); err != nil {
+		t.Fatal(err)
+	}
+	testSpans(t, conf)
+	checkRequest(t, req)
+}
+
+func TestOTLPGRPCExporter(t *testing.T) {
+	srv := otlptest.NewGRPCServer(t)
+	conf := Config{Exporter: ExporterOTLP, Protocol: ProtocolGRPC, Endpoint: srv.Endpoint, Compression: CompressionGzip, SamplerArg: 1,
+		Headers: map[string]string{"authorization": "token"}}
+	conf.setDefault()
+	if err := conf.validate(); err != nil {
+		t.Fatal(err)
+	}
+	testSpans(t, conf)
+
+	calls := srv.Requests()
+	if len(calls) != 1 || calls[0].Method != otlp.TraceExportMethod || calls[0].Header.Get("Grpc-Encoding") != "gzip" || calls[0].Header.Get("Authorization") != "token" {
+		t.Fatalf("unexpected grpc calls: %+v", calls)
+	}
+	// the request is decoded with the official OTLP types to catch drift of the hand-written encoding
+	resourceSpans, _ := otlptest.Fields(calls[0].Message, 1)
+	if len(resourceSpans) != 1 {
+		t.Fatalf("expected 1 resource spans, got %d", len(resourceSpans))
+	}
+	rs := &tracepb.ResourceSpans{}
+	if err := proto.Unmarshal(resourceSpans[0], rs); err != nil {
+		t.Fatal(err)
+	}
+	if len(rs.GetResource().GetAttributes()) == 0 || len(rs.GetInstrumentationLibrarySpans()) != 1 {
+		t.Fatalf("unexpected resource spans: %v", rs)
+	}
+	spans := rs.GetInstrumentationLibrarySpans()[0].GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	child, parent := spans[0], spans[1]
+	if len(child.GetTraceId()) != 16 || !bytes.Equal(child.GetTraceId(), parent.GetTraceId()) || !bytes.Equal(child.GetParentSpanId(), parent.GetSpanId()) {
+		t.Errorf("child %v should be under parent %v", child, parent)
+	}
+	if child.GetName() != "child" || child.GetKind() != tracepb.Span_SPAN_KIND_CLIENT || child.GetStatus().GetCode() != tracepb.Status_STATUS_CODE_ERROR ||
+		child.GetStatus().GetMessage() != "boom" || len(child.GetEvents()) != 1 || child.GetEndTimeUnixNano() < child.GetStartTimeUnixNano() {
+		t.Errorf("unexpected child span: %v", child)
+	}
+	if attrs := child.GetAttributes(); len(attrs) != 1 || attrs[0].GetKey() != "count" || attrs[0].GetValue().GetIntValue() != 3 {
+		t.Errorf("unexpected child attributes: %v", attrs)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	tp, err := Init(Config{Exporter: ExporterNone}, "test", "ns")
+	if tp != nil || err != nil {
+		t.Errorf("none exporter should disable tracing, got %v %v", tp, err)
+	}
+	exp := &memoryExporter{}
+	tp, err = Init(Config{Exporter: ExporterNone, SamplerArg: 1}, "test", "ns", tracesdk.WithSyncer(exp))
+	if tp == nil || err != nil {
+		t.Fatalf("none exporter should keep the span processors of the options, got %v %v", tp, err)
+	}
+	_, span := tp.Tracer("test").Start(context.Background(), "kept")
+	span.End()
+	_ = tp.Shutdown(context.Background())
+	otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	if len(exp.spans) != 1 {
+		t.Errorf("expected the span in the processor of the options, got %d", len(exp.spans))
+	}
+	for _, c := range []Config{
+		{Exporter: ExporterOTLP, Protocol: "http/protobuf"},
+		{Exporter: ExporterFile},
+		{Exporter: "zipkin"},
+		{Exporter: ExporterStdout, Compression: "zstd"},
+		{Exporter: ExporterStdout, SamplerArg: 2},
+	} {
+		c.setDefault()
+		if err := c.validate(); err == nil {
+			t.Errorf("%+v should be rejected", c)
+		}
+	}
+}
+
+type memoryExporter struct {
+	spans []tracesdk.ReadOnlySpan
+}
+
+func (e *memoryExporter) ExportSpans(_ context.Context, spans []tracesdk.ReadOnlySpan) error {
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *memoryExporter) Shutdown(context.Context) error { return nil }
+
+func TestSampler(t *testing.T) {
+	conf := Config{Sampling: SamplingConfig{
+		SampleErrors: true,
+		Rules: []SamplingRule{
+			{Headers: map[string]string{"x-debug-trace": "1"}, Ratio: 1},
+			{Route: "/v1/report/*", Method: "GET", Ratio: 1, RateLimit: 1},
+		},
+	}}
+	conf.setDefault()
+	if err := conf.validate(); err != nil {
+		t.Fatal(err)
+	}
+	s := NewSampler(0, conf.Sampling)
+	exp := &memoryExporter{}
+	tp := tracesdk.NewTracerProvider(tracesdk.WithSampler(s), tracesdk.WithSpanProcessor(newDeferredProcessor(tracesdk.NewSimpleSpanProcessor(exp), conf.Sampling)))
+	defer func() { _ = tp.Shutdown(context.Background()) }()
+	tracer := tp.Tracer("sampler-test")
+
+	start := func(route string, req *http.Request) trace.Span {
+		_, span := tracer.Start(s.WithRequest(context.Background(), route, req), req.Method+" "+route)
+		return span
+	}
+	get := func(path string, headers ...string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		return req
+	}
+
+	if span := start("/health", get("/health", "X-Debug-Trace", "1")); span.IsRecording() {
+		t.Error("excluded route should not be recorded")
+	}
+	if span := start("/v1/trace", get("/v1/trace", "X-Debug-Trace", "1")); !span.SpanContext().IsSampled() {
+		t.Error("debug header should force sampling")
+	}
+	if span := start("/v1/report/:name", get("/v1/report/a")); !span.SpanContext().IsSampled() {
+		t.Error("first report request should be sampled")
+	}
+	if span := start("/v1/report/:name", get("/v1/report/a")); span.SpanContext().IsSampled() {
+		t.Error("rate limit of the rule should drop the second report request")
+	}
+
+	// the dropped spans are recorded and exported only when their trace fails
+	ok := start("/v1/trace", get("/v1/trace"))
+	failed := start("/v1/trace", get("/v1/trace"))
+	if ok.SpanContext().IsSampled() || !ok.IsRecording() {
+		t.Fatal("span should be recorded but not sampled")
+	}
+	_, child := tracer.Start(trace.ContextWithSpan(context.Background(), failed), "child")
+	if !child.IsRecording() || child.SpanContext().IsSampled() {
+		t.Error("child should follow the deferred parent")
+	}
+	child.End()
+	ok.End()
+	failed.SetStatus(codes.Error, "boom")
+	failed.End()
+	if len(exp.spans) != 2 || exp.spans[1].SpanContext().SpanID() != failed.SpanContext().SpanID() || !exp.spans[0].SpanContext().IsSampled() {
+		t.Errorf("only the failed trace should be exported, got %d spans", len(exp.spans))
+	}
+}
+
+func TestDeferredProcessor(t *testing.T) {
+	conf := Config{Sampling: SamplingConfig{SampleErrors: true, ErrorMaxSpans: 2}}
+	conf.setDefault()
+	exp := &memoryExporter{}
+	p := newDeferredProcessor(tracesdk.NewSimpleSpanProcessor(exp), conf.Sampling)
+	tp := tracesdk.NewTracerProvider(tracesdk.WithSampler(NewSampler(0, conf.Sampling)), tracesdk.WithSpanProcessor(p))
+	defer func() { _ = tp.Shutdown(context.Background()) }()
+	tracer := tp.Tracer("deferred-test")
+
+	// only the second child fails, the whole trace is exported
+	ctx, parent := tracer.Start(context.Background(), "parent")
+	_, ok := tracer.Start(ctx, "ok")
+	_, failed := tracer.Start(ctx, "failed")
+	ok.End()
+	failed.SetStatus(codes.Error, "boom")
+	failed.End()
+	parent.End()
+	if len(exp.spans) != 3 {
+		t.Fatalf("expected the 3 spans of the failed trace, got %d", len(exp.spans))
+	}
+	for i, name := range []string{"ok", "failed", "parent"} {
+		if s := exp.spans[i]; s.Name() != name || !s.SpanContext().IsSampled() {
+			t.Errorf("span %d = %s, want sampled %s", i, s.Name(), name)
+		}
+	}
+
+	// the spans beyond the buffer are dropped and the old traces are forgotten
+	ctx, parent = tracer.Start(context.Background(), "parent")
+	for i := 0; i < 3; i++ {
+		_, child := tracer.Start(ctx, "child")
+		child.End()
+	}
+	p.mu.Lock()
+	buffered := p.buffered
+	p.mu.Unlock()
+	if buffered != 2 {
+		t.Errorf("buffered = %d, want max 2", buffered)
+	}
+	p.sweep(time.Now().Add(conf.Sampling.ErrorTimeout))
+	if len(p.traces) != 0 || len(p.failed) != 0 || p.buffered != 0 {
+		t.Errorf("sweep should forget the old traces, got %d traces and %d failures", len(p.traces), len(p.failed))
+	}
+	parent.End()
+	if len(exp.spans) != 3 {
+		t.Errorf("the trace without error should not be exported, got %d spans", len(exp.spans))
+	}
+}
+
+type fakeMongoPool struct {
+	mgopool.MongoPool
+	err    gopkg.CodeError
+	cursor *fakeCursor
+}
+
+func (p *fakeMongoPool) UpdateAll(ctx goctx.Context, dbName, collection string, selector, update interface{}) (*mongo.UpdateResult, gopkg.CodeError) {
+	if !ctx.GetSpan().SpanContext().IsValid() {
+		return nil, gopkg.NewCodeError(1, "span of the operation is missing")
+	}
+	return &mongo.UpdateResult{MatchedCount: 3, ModifiedCount: 2}, p.err
+}
+
+func (p *fakeMongoPool) Insert(ctx goctx.Context, dbName, collection string, doc interface{}) gopkg.CodeError {
+	return p.err
+}
+
+func (p *fakeMongoPool) GetCursor(ctx goctx.Context, dbName, collection string, selector interface{}, option ...*options.FindOptions) (mgopool.MongoCursor, gopkg.CodeError) {
+	p.cursor = &fakeCursor{ctx: ctx, n: 2}
+	return p.cursor, p.err
+}
+
+type fakeCursor struct {
+	mgopool.MongoCursor
+	ctx goctx.Context
+	n   int
+}
+
+func (c *fakeCursor) Next(context.Context) bool {
+	c.n--
+	return c.n >= 0 && c.ctx.Err() == nil
+}
+
+func (c *fakeCursor) Close(context.Context) gopkg.CodeError { return nil }
+
+func TestMongoHook(t *testing.T) {
+	exp := &memoryExporter{}
+	otel.SetTracerProvider(tracesdk.NewTracerProvider(tracesdk.WithSyncer(exp)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	pool := mongohook.Wrap(&fakeMongoPool{}, MongoHook())
+	selector := bson.M{"name": "alice", "age": bson.M{"$gt": 20}}
+	if _, err := pool.UpdateAll(goctx.Background(), "db", "users", selector, bson.M{"$set": bson.M{"x": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	pool = mongohook.Wrap(&fakeMongoPool{err: gopkg.NewCodeError(2, "duplicate key")}, MongoHook())
+	if err := pool.Insert(goctx.Background(), "db", "users", bson.M{"_id": 1}); err == nil {
+		t.Fatal("error should be returned")
+	}
+
+	fake := &fakeMongoPool{}
+	pool = mongohook.Wrap(fake, MongoHook())
+	cursor, err := pool.GetCursor(goctx.Background(), "db", "users", bson.M{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for cursor.Next(context.Background()) {
+	}
+	if len(exp.spans) != 2 {
+		t.Errorf("the cursor span should end on close, got %d spans", len(exp.spans))
+	}
+	_ = cursor.Close(context.Background())
+	if fake.cursor.ctx.Err() == nil {
+		t.Error("the context of the cursor should be canceled on close")
+	}
+
+	if len(exp.spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(exp.spans))
+	}
+	update, insert, find := exp.spans[0], exp.spans[1], exp.spans[2]
+	if returned := find.Attributes()[len(find.Attributes())-1]; returned.Key != "db.mongodb.returned_count" || returned.Value.AsInt64() != 2 {
+		t.Errorf("unexpected cursor attributes: %v", find.Attributes())
+	}
+	if update.Name() != "UpdateAll db.users" || update.SpanKind() != trace.SpanKindClient {
+		t.Errorf("unexpected span %s %v", update.Name(), update.SpanKind())
+	}
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range update.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	if s := attrs["db.statement"].AsString(); s != `{"age":{"$gt":"?"},"name":"?"}` && s != `{"name":"?","age":{"$gt":"?"}}` {
+		t.Errorf("filter should be sanitized: %s", s)
+	}
+	if attrs["db.system"].AsString() != "mongodb" || attrs["db.mongodb.collection"].AsString() != "users" ||
+		attrs["db.mongodb.matched_count"].AsInt64() != 3 || attrs["db.mongodb.modified_count"].AsInt64() != 2 {
+		t.Errorf("unexpected attributes: %v", update.Attributes())
+	}
+	if insert.Status().Code != codes.Error || len(insert.Events()) != 1 {
+		t.Errorf("error should be recorded: %+v %v", insert.Status(), insert.Events())
+	}
+}
+
+func TestSanitizeFilter(t *testing.T) {
+	pipeline := []bson.M{{"$match": bson.M{"tags": bson.A{"a", "b"}}}, {"$limit": 10}}
+	if s := SanitizeFilter(pipeline); s != `[{"$match":{"tags":["?","?"]}},{"$limit":"?"}]` {
+		t.Errorf("unexpected pipeline: %s", s)
+	}
+	if s := SanitizeFilter("id"); s != `"?"` {
+		t.Errorf("unexpected scalar: %s", s)
+	}
+}
+
+func TestLogCorrelation(t *testing.T) {
+	exp := &memoryExporter{}
+	otel.SetTracerProvider(tracesdk.NewTracerProvider(tracesdk.WithSyncer(exp)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	std := m800log.GetLogger()
+	defer m800log.SetLogger(std)
+	buf := &bytes.Buffer{}
+	logger := m800log.GetDiscardLogger()
+	logger.Out, logger.Level = buf, logrus.DebugLevel
+	m800log.SetLogger(logger)
+	m800log.SetM800JSONFormatter("", "test", "v1", "dev", "ns")
+	SetLogCorrelation(true)
+
+	ctx := goctx.Background()
+	BindLog(ctx)
+	m800log.Info(ctx, "no span")
+	// goctx binds its tracer to the first global provider, start the span on the provider of the test
+	_, span := otel.Tracer("log-test").Start(ctx.NativeContext(), "request")
+	ctx.SetSpan(span)
+	m800log.Error(ctx, "failed")
+	span.End()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", buf.String())
+	}
+	var first, second map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := first[LogKeyTraceID]; ok || first[logKeySpan] != nil {
+		t.Errorf("log without span should not have trace fields: %s", lines[0])
+	}
+	sc := span.SpanContext()
+	if second[LogKeyTraceID] != sc.TraceID().String() || second[LogKeySpanID] != sc.SpanID().String() || second[LogKeyTraceSampled] != true {
+		t.Errorf("log should carry the span started after binding: %s", lines[1])
+	}
+	if events := exp.spans[0].Events(); len(events) != 1 || events[0].Name != logEventName {
+		t.Errorf("error log should be a span event: %+v", events)
+	}
+
+	// a context derived from a bound one gets the span set on it, not the one of its parent
+	buf.Reset()
+	child, cancel := ctx.WithCancel()
+	defer cancel()
+	_, childSpan := otel.Tracer("log-test").Start(ctx.NativeContext(), "child")
+	SetSpan(child, childSpan)
+	m800log.Info(child, "child")
+	childSpan.End()
+	var third map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &third); err != nil {
+		t.Fatal(err)
+	}
+	if third[LogKeySpanID] != childSpan.SpanContext().SpanID().String() {
+		t.Errorf("log of the child context should carry the child span: %s", buf.String())
+	}
+
+	// a detached copy keeps its span when the original context moves on to another one
+	buf.Reset()
+	copied := CopyContext(ctx)
+	_, next := otel.Tracer("log-test").Start(context.Background(), "next")
+	ctx.SetSpan(next)
+	m800log.Info(copied, "copied")
+	next.End()
+	var fourth map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &fourth); err != nil {
+		t.Fatal(err)
+	}
+	if fourth[LogKeySpanID] != sc.SpanID().String() {
+		t.Errorf("log of the copied context should carry the span it was copied with: %s", buf.String())
+	}
+}
+
+func TestBaggagePolicy(t *testing.T) {
+	propagate := true
+	conf := BaggageConfig{
+		AllowedKeys:  []string{"tenant", "platform"},
+		MaxBytes:     40,
+		Propagate:    &propagate,
+		OutboundKeys: []string{"tenant"},
+		Promote:      []BaggagePromotion{{Key: "tenant", Field: "tenant"}},
+	}
+	SetBaggagePolicy(NewBaggagePolicy(conf))
+	defer SetBaggagePolicy(nil)
+	p := BaggagePropagator{}
+
+	inbound := func(caller string) baggage.Baggage {
+		h := http.Header{}
+		h.Set("Baggage", "tenant=acme,platform=ios,user=alice,"+"zz="+strings.Repeat("x", 20))
+		if caller != "" {
+			h.Set(goctx.HTTPHeaderInternalCaller, caller)
+		}
+		return baggage.FromContext(p.Extract(context.Background(), propagation.HeaderCarrier(h)))
+	}
+	if b := inbound(""); b.Len() != 2 || b.Member("user").Key() != "" {
+		t.Errorf("only the allowed keys should be accepted: %s", b)
+	}
+	// the internal caller header is set by any client, it doesn't skip the allowlist
+	if b := inbound("billing"); b.Len() != 2 || b.Member("user").Key() != "" {
+		t.Errorf("the internal caller should not skip the allowlist: %s", b)
+	}
+
+	ctx := goctx.Background()
+	ctx.Set(goctx.LogKeyCID, "cid")
+	ctx.SetBaggage(inbound(""))
+	// the reserved fields are skipped by the policies not validated by LoadBaggageConfig
+	reserved := conf
+	reserved.Promote = append(reserved.Promote, BaggagePromotion{Key: "platform", Field: goctx.LogKeyCID})
+	NewBaggagePolicy(reserved).Promote(ctx)
+	if v, _ := ctx.GetString("tenant"); v != "acme" {
+		t.Errorf("tenant should be promoted, got %q", v)
+	}
+	if v, _ := ctx.GetString(goctx.LogKeyCID); v != "cid" {
+		t.Errorf("the reserved field should not be promoted, got %q", v)
+	}
+	if err := reserved.validate(); err == nil {
+		t.Error("the promotion to a reserved field should be rejected")
+	}
+	h := http.Header{}
+	p.Inject(ctx.NativeContext(), propagation.HeaderCarrier(h))
+	if got := h.Get("Baggage"); got != "tenant=acme" {
+		t.Errorf("only the outbound keys should be sent, got %q", got)
+	}
+
+	propagate = false
+	h = http.Header{}
+	p.Inject(ctx.NativeContext(), propagation.HeaderCarrier(h))
+	if got := h.Get("Baggage"); got != "" {
+		t.Errorf("propagation should be disabled, got %q", got)
+	}
+}
+
+func TestTailProcessor(t *testing.T) {
+	conf := Config{Sampling: SamplingConfig{Tail: TailConfig{Enabled: true, Latency: time.Hour, MaxTraceSpans: 3}}}
+	conf.setDefault()
+	if err := conf.validate(); err != nil {
+		t.Fatal(err)
+	}
+	exp := &memoryExporter{}
+	tail := newTailProcessor(tracesdk.NewSimpleSpanProcessor(exp), conf.Sampling.Tail)
+	tp := tracesdk.NewTracerProvider(tracesdk.WithSampler(NewSampler(0, conf.Sampling)), tracesdk.WithSpanProcessor(tail))
+	tracer := tp.Tracer("tail-test")
+
+	run := func(status int, fail bool, children int) trace.Span {
+		ctx, root := tracer.Start(context.Background(), "GET /v1/trace")
+		for i := 0; i < children; i++ {
+			_, child := tracer.Start(ctx, "child")
+			if fail && i == 0 {
+				child.SetStatus(codes.Error, "boom")
+			}
+			child.End()
+		}
+		root.SetAttributes(semconv.HTTPStatusCodeKey.Int(status))
+		root.End()
+		return root
+	}
+	run(200, false, 1)
+	failed := run(200, true, 2)
+	notFound := run(404, false, 0)
+	// the 4th child of the trace is beyond max_trace_spans, the root is kept
+	serverError := run(500, true, 4)
+	if len(exp.spans) != 3+1+4 {
+		t.Fatalf("expected the spans of the failed traces, got %d", len(exp.spans))
+	}
+	if got := exp.spans[len(exp.spans)-1]; got.SpanContext().SpanID() != serverError.SpanContext().SpanID() {
+		t.Error("the root of the trace beyond max_trace_spans should be exported")
+	}
+	if got := exp.spans[2]; got.SpanContext().SpanID() != failed.SpanContext().SpanID() || !got.SpanContext().IsSampled() {
+		t.Error("the root of the failed trace should be exported as sampled")
+	}
+	if exp.spans[3].SpanContext().SpanID() != notFound.SpanContext().SpanID() {
+		t.Error("non-2xx trace should be retained")
+	}
+
+	mfs := map[string]*dto.MetricFamily{}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(tail)
+	gathered, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range gathered {
+		mfs[mf.GetName()] = mf
+	}
+	decisions := map[string]float64{}
+	for _, m := range mfs["trace_tail_decisions_total"].GetMetric() {
+		decisions[m.GetLabel()[0].GetValue()] = m.GetCounter().GetValue()
+	}
+	if decisions[TailDecisionError] != 2 || decisions[TailDecisionStatus] != 1 || decisions[TailDecisionDropped] != 1 {
+		t.Errorf("unexpected decisions: %v", decisions)
+	}
+	if m := mfs["trace_tail_dropped_spans_total"].GetMetric(); len(m) != 1 || m[0].GetCounter().GetValue() != 1 {
+		t.Errorf("one span should be dropped by the trace limit: %v", m)
+	}
+
+	// the trace without a local root end is decided on shutdown
+	remote := trace.NewSpanContext(trace.SpanContextConfig{TraceID: trace.TraceID{1}, SpanID: trace.SpanID{1}, Remote: true})
+	_, orphan := tracer.Start(trace.ContextWithRemoteSpanContext(context.Background(), remote), "orphan")
+	_, child := tracer.Start(trace.ContextWithSpan(context.Background(), orphan), "child")
+	child.SetStatus(codes.Error, "boom")
+	child.End()
+	if err := tp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := exp.spans[len(exp.spans)-1]; got.Name() != "child" || got.SpanContext().TraceID() != remote.TraceID() {
+		t.Errorf("the failed span of the open trace should be exported on shutdown, got %s", got.Name())
+	}
+}
+
+func TestTailRootBufferFull(t *testing.T) {
+	conf := SamplingConfig{Tail: TailConfig{Enabled: true, MaxSpans: 1}}
+	exp := &memoryExporter{}
+	tail := newTailProcessor(tracesdk.NewSimpleSpanProcessor(exp), conf.Tail)
+	tp := tracesdk.NewTracerProvider(tracesdk.WithSampler(NewSampler(0, conf)), tracesdk.WithSpanProcessor(tail))
+	defer func() { _ = tp.Shutdown(context.Background()) }()
+	tracer := tp.Tracer("tail-test")
+
+	// an open trace fills the buffer
+	ctx, open := tracer.Start(context.Background(), "open")
+	_, child := tracer.Start(ctx, "child")
+	child.End()
+	// the failing request of a single span is still decided
+	_, root := tracer.Start(context.Background(), "GET /v1/trace")
+	root.SetStatus(codes.Error, "boom")
+	root.End()
+	if len(exp.spans) != 1 || exp.spans[0].SpanContext().SpanID() != root.SpanContext().SpanID() {
+		t.Errorf("the failed root should be exported with a full buffer, got %d spans", len(exp.spans))
+	}
+	open.End()
+}
+
+func TestTailSweep(t *testing.T) {
+	conf := SamplingConfig{Tail: TailConfig{Enabled: true, Timeout: 20 * time.Millisecond, MaxSpans: 2}}
+	exp := &memoryExporter{}
+	tail := newTailProcessor(tracesdk.NewSimpleSpanProcessor(exp), conf.Tail)
+	tp := tracesdk.NewTracerProvider(tracesdk.WithSampler(NewSampler(0, conf)), tracesdk.WithSpanProcessor(tail))
+	tracer := tp.Tracer("tail-test")
+
+	// the decisions beyond max_spans forget the oldest ones
+	for i := 0; i < 3; i++ {
+		_, root := tracer.Start(context.Background(), "root")
+		root.End()
+	}
+	tail.mu.Lock()
+	decided := len(tail.decided)
+	tail.mu.Unlock()
+	if decided != 2 {
+		t.Errorf("expected 2 decisions kept, got %d", decided)
+	}
+
+	// the open trace is decided by the ticker without another span ending
+	remote := trace.NewSpanContext(trace.SpanContextConfig{TraceID: trace.TraceID{2}, SpanID: trace.SpanID{2}, Remote: true})
+	_, child := tracer.Start(trace.ContextWithRemoteSpanContext(context.Background(), remote), "child")
+	child.SetStatus(codes.Error, "boom")
+	child.End()
+	deadline := time.Now().Add(time.Second)
+	for {
+		tail.mu.Lock()
+		buffered, decided := tail.buffered, len(tail.decided)
+		tail.mu.Unlock()
+		if buffered == 0 && decided == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the open trace and the old decisions should be swept, %d spans and %d decisions left", buffered, decided)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := tp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(exp.spans) != 1 || exp.spans[0].SpanContext().TraceID() != remote.TraceID() {
+		t.Errorf("the failed span of the open trace should be exported by the sweep, got %d spans", len(exp.spans))
+	}
+}
@@ -0,0 +1,139 @@
+package upstream
+
+import (
+	"sync"
+	"time"
+)
+
+// State of the circuit breaker, the values are exported by upstream_circuit_state
+type State int
+
+const (
+	StateClosed State = iota
+	StateHalfOpen
+	StateOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateHalfOpen:
+		return "half-open"
+	case StateOpen:
+		return "open"
+	}
+	return "unknown"
+}
+
+// outcome of an allowed request reported to the breaker
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeFailure
+	// outcomeNeutral releases the probe without a result, e.g. the caller gave up before the upstream answered
+	outcomeNeutral
+)
+
+// breaker opens after FailureThreshold consecutive failures, lets HalfOpenRequests probes through after OpenTimeout
+// and closes after as many successful probes, a failed probe opens it again
+type breaker struct {
+	conf BreakerConfig
+	now  func() time.Time
+
+	mu        sync.Mutex
+	state     State
+	failures  int
+	openedAt  time.Time
+	probes    int
+	successes int
+	// generation drops the results of the requests allowed before the last transition
+	generation uint64
+}
+
+func newBreaker(conf BreakerConfig) *breaker {
+	return &breaker{conf: conf, now: time.Now}
+}
+
+// allow reports whether a request may be sent, done must be called with the outcome of an allowed request
+func (b *breaker) allow() (done func(o outcome), ok bool) {
+	if b.conf.Disabled {
+		return func(outcome) {}, true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == StateOpen {
+		if b.now().Sub(b.openedAt) < b.conf.OpenTimeout {
+			return nil, false
+		}
+		b.transit(StateHalfOpen)
+	}
+	if b.state == StateHalfOpen {
+		if b.probes >= b.conf.HalfOpenRequests {
+			return nil, false
+		}
+		b.probes++
+	}
+	generation := b.generation
+	return func(o outcome) { b.done(generation, o) }, true
+}
+
+func (b *breaker) done(generation uint64, o outcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if generation != b.generation {
+		return
+	}
+	if o == outcomeNeutral {
+		if b.state == StateHalfOpen {
+			b.probes--
+		}
+		return
+	}
+	failed := o == outcomeFailure
+	switch b.state {
+	case StateClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.conf.FailureThreshold {
+			b.transit(StateOpen)
+		}
+	case StateHalfOpen:
+		if failed {
+			b.transit(StateOpen)
+			return
+		}
+		b.successes++
+		if b.successes >= b.conf.HalfOpenRequests {
+			b.transit(StateClosed)
+		}
+	}
+}
+
+func (b *breaker) transit(s State) {
+	b.state = s
+	b.failures = 0
+	b.probes = 0
+	b.successes = 0
+	b.generation++
+	if s == StateOpen {
+		b.openedAt = b.now()
+	}
+}
+
+// current returns the state, an open breaker past OpenTimeout is reported as half-open
+func (b *breaker) current() State {
+	if b.conf.Disabled {
+		return StateClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == StateOpen && b.now().Sub(b.openedAt) >= b.conf.OpenTimeout {
+		return StateHalfOpen
+	}
+	return b.state
+}
@@ -0,0 +1,341 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/cake/go-project-template/gpt"
+	"gitlab.com/cake/go-project-template/tracing"
+	"gitlab.com/cake/goctx"
+	"gitlab.com/cake/golibs/intercom"
+	"gitlab.com/cake/gopkg"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+)
+
+const (
+	instrumentationName = "gitlab.com/cake/go-project-template/upstream"
+
+	// NameKey is the upstream of the call span
+	NameKey = attribute.Key("upstream.name")
+	// AttemptsKey is the number of requests sent by the call
+	AttemptsKey = attribute.Key("upstream.attempts")
+	// BackoffKey is the wait of the retry event
+	BackoffKey = attribute.Key("upstream.backoff")
+	// RejectReasonKey is the reason of the rejected event
+	RejectReasonKey = attribute.Key("upstream.reject_reason")
+
+	// HeaderIdempotencyKey marks a request of any method as safe to retry
+	HeaderIdempotencyKey = "Idempotency-Key"
+
+	// reasons of upstream_rejected_total
+	rejectCircuitOpen  = "circuit_open"
+	rejectBulkheadFull = "bulkhead_full"
+)
+
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+}
+
+// Client sends the requests of one upstream through intercom, so the trace context, the baggage and
+// the internal caller header are propagated as intercom.HTTPDo does and the errors are gopkg.CodeError.
+// Every call has a span with one otelhttp client span per attempt.
+type Client struct {
+	name    string
+	conf    Config
+	ic      *intercom.IntercomClient
+	breaker *breaker
+	metrics *metrics
+	// slots is the bulkhead, nil when the concurrency is unlimited
+	slots    chan struct{}
+	inflight int64
+
+	retryStatuses map[int]bool
+}
+
+// send sends one attempt and returns the http status, 0 without a response
+type send func(ctx goctx.Context, req *http.Request) (status int, err gopkg.CodeError)
+
+// New returns the client of the upstream, its metrics are exported only by the clients of a Registry
+func New(name string, conf Config) *Client {
+	return newClient(name, conf, newMetrics())
+}
+
+func newClient(name string, conf Config, m *metrics) *Client {
+	conf.setDefault()
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.MaxIdleConnsPerHost = conf.MaxIdleConns
+	httpClient := &http.Client{Transport: otelhttp.NewTransport(tr), Timeout: conf.Timeout}
+
+	c := &Client{
+		name:          name,
+		conf:          conf,
+		ic:            intercom.NewIntercomClientWithTransport(httpClient, tr),
+		breaker:       newBreaker(conf.Breaker),
+		metrics:       m,
+		retryStatuses: map[int]bool{},
+	}
+	if conf.Bulkhead.MaxConcurrent > 0 {
+		c.slots = make(chan struct{}, conf.Bulkhead.MaxConcurrent)
+	}
+	for _, s := range conf.Retry.Statuses {
+		c.retryStatuses[s] = true
+	}
+	return c
+}
+
+// Name returns the upstream name
+func (c *Client) Name() string {
+	return c.name
+}
+
+// State returns the state of the circuit breaker
+func (c *Client) State() State {
+	return c.breaker.current()
+}
+
+// NewRequest returns the request of path under the base url
+func (c *Client) NewRequest(ctx goctx.Context, method, path string, body io.Reader) (*http.Request, gopkg.CodeError) {
+	uri := strings.TrimRight(c.conf.BaseURL, "/")
+	if path != "" {
+		uri += "/" + strings.TrimLeft(path, "/")
+	}
+	return intercom.HTTPNewRequest(ctx, method, uri, body)
+}
+
+// Do sends req as intercom.HTTPDo, the body of the returned response must be closed
+func (c *Client) Do(ctx goctx.Context, req *http.Request) (resp *http.Response, err gopkg.CodeError) {
+	cancel, err := c.call(ctx, req, func(ctx goctx.Context, req *http.Request) (int, gopkg.CodeError) {
+		// the response of the previous attempt is retried
+		discard(resp)
+		var e gopkg.CodeError
+		resp, e = c.ic.HTTPDo(ctx, req)
+		if resp == nil {
+			return 0, e
+		}
+		return resp.StatusCode, e
+	})
+	if resp == nil {
+		cancel()
+		return
+	}
+	// the body is read with the context of the call
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return
+}
+
+// M800Do sends req as intercom.M800Do
+func (c *Client) M800Do(ctx goctx.Context, req *http.Request) (result *intercom.JsonResponse, err gopkg.CodeError) {
+	cancel, err := c.call(ctx, req, func(ctx goctx.Context, req *http.Request) (int, gopkg.CodeError) {
+		var e gopkg.CodeError
+		result, e = c.ic.M800Do(ctx, req)
+		if result == nil {
+			return 0, e
+		}
+		return result.HTTPStatus, e
+	})
+	cancel()
+	return
+}
+
+// call runs the attempts of req under the call span, the returned cancel releases the context of the call
+func (c *Client) call(ctx goctx.Context, req *http.Request, fn send) (cancel context.CancelFunc, err gopkg.CodeError) {
+	start := time.Now()
+	_, span := otel.Tracer(instrumentationName).Start(ctx.NativeContext(), "upstream "+c.name,
+		trace.WithAttributes(NameKey.String(c.name), semconv.HTTPMethodKey.String(req.Method), semconv.HTTPURLKey.String(req.URL.String())))
+	next, cancel := ctx.WithCancel()
+	tracing.SetSpan(next, span)
+
+	retryable := c.retryable(req)
+	attempts, status := 0, 0
+	for {
+		s, e, reason := c.attempt(next, req, attempts+1, fn)
+		if reason != "" {
+			c.metrics.rejected.WithLabelValues(c.name, reason).Inc()
+			span.AddEvent("rejected", trace.WithAttributes(RejectReasonKey.String(reason)))
+			// a rejected retry keeps the outcome of the last attempt
+			if attempts == 0 {
+				err = gopkg.NewCodeError(gpt.CodeUpstreamUnavailable, fmt.Sprintf("upstream %s: %s", c.name, reason))
+			}
+			break
+		}
+		attempts++
+		status, err = s, e
+		if !retryable || attempts >= c.conf.Retry.Attempts || !c.retry(s, e) || next.Err() != nil {
+			break
+		}
+		backoff := c.backoff(attempts)
+		span.AddEvent("retry", trace.WithAttributes(AttemptsKey.Int(attempts), BackoffKey.String(backoff.String())))
+		c.metrics.retries.WithLabelValues(c.name).Inc()
+		if !sleep(next, backoff) {
+			break
+		}
+	}
+
+	span.SetAttributes(AttemptsKey.Int(attempts))
+	if status > 0 {
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(status))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+	c.metrics.observe(c.name, req.Method, codeLabel(attempts, status), time.Since(start))
+	return
+}
+
+// attempt sends one request when the bulkhead and the breaker let it through, otherwise returns the reject reason
+func (c *Client) attempt(ctx goctx.Context, req *http.Request, n int, fn send) (status int, err gopkg.CodeError, reason string) {
+	if !c.acquire(ctx) {
+		return 0, nil, rejectBulkheadFull
+	}
+	defer c.release()
+
+	// the body is rewound before the breaker is asked, its failure is not an outcome of the upstream
+	r := req.Clone(ctx)
+	if n > 1 && req.GetBody != nil {
+		body, errBody := req.GetBody()
+		if errBody != nil {
+			return 0, gopkg.NewCodeError(intercom.CodeNewRequest, errBody.Error()), ""
+		}
+		r.Body = body
+	}
+	done, ok := c.breaker.allow()
+	if !ok {
+		if r.Body != nil {
+			_ = r.Body.Close()
+		}
+		return 0, nil, rejectCircuitOpen
+	}
+	status, err = fn(ctx, r)
+	switch {
+	case ctx.Err() != nil:
+		// the caller canceled or timed out, the upstream may be healthy
+		done(outcomeNeutral)
+	case status == 0 || status >= http.StatusInternalServerError:
+		done(outcomeFailure)
+	default:
+		done(outcomeSuccess)
+	}
+	return
+}
+
+func (c *Client) acquire(ctx goctx.Context) bool {
+	if c.slots != nil {
+		select {
+		case c.slots <- struct{}{}:
+		default:
+			if c.conf.Bulkhead.MaxWait <= 0 {
+				return false
+			}
+			t := time.NewTimer(c.conf.Bulkhead.MaxWait)
+			defer t.Stop()
+			select {
+			case c.slots <- struct{}{}:
+			case <-t.C:
+				return false
+			case <-ctx.Done():
+				return false
+			}
+		}
+	}
+	atomic.AddInt64(&c.inflight, 1)
+	return true
+}
+
+func (c *Client) release() {
+	atomic.AddInt64(&c.inflight, -1)
+	if c.slots != nil {
+		<-c.slots
+	}
+}
+
+// retryable reports whether req is idempotent and its body can be sent again
+func (c *Client) retryable(req *http.Request) bool {
+	if c.conf.Retry.Attempts <= 1 {
+		return false
+	}
+	if !idempotentMethods[req.Method] && req.Header.Get(HeaderIdempotencyKey) == "" {
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// retry reports whether the outcome of an attempt is retried, i.e. a transport error or one of the retry statuses
+func (c *Client) retry(status int, err gopkg.CodeError) bool {
+	if status == 0 {
+		return err != nil
+	}
+	return c.retryStatuses[status]
+}
+
+// backoff returns the full jitter wait before the n-th retry
+func (c *Client) backoff(n int) time.Duration {
+	d := c.conf.Retry.MaxBackoff
+	if n <= 30 {
+		if exp := c.conf.Retry.Backoff << uint(n-1); exp > 0 && exp < d {
+			d = exp
+		}
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+func sleep(ctx goctx.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// discard drains and closes the body so that the connection is reused
+func discard(resp *http.Response) {
+	if resp == nil || resp.Body == nil {
+		return
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	_ = resp.Body.Close()
+}
+
+func codeLabel(attempts, status int) string {
+	switch {
+	case attempts == 0:
+		return "rejected"
+	case status == 0:
+		return "error"
+	}
+	return fmt.Sprintf("%dxx", status/100)
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
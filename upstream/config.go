@@ -0,0 +1,136 @@
+package upstream
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultTimeout          = 10 * time.Second
+	defaultAttempts         = 3
+	defaultBackoff          = 100 * time.Millisecond
+	defaultMaxBackoff       = 2 * time.Second
+	defaultFailureThreshold = 5
+	defaultOpenTimeout      = 30 * time.Second
+	defaultHalfOpenRequests = 1
+	defaultMaxIdleConns     = 100
+)
+
+var (
+	validName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+	// DefaultRetryStatuses are the responses retried besides the transport errors
+	DefaultRetryStatuses = []int{502, 503, 504}
+)
+
+// Config is one [upstream.<name>] section
+type Config struct {
+	BaseURL string `mapstructure:"base_url"`
+	// Timeout of one attempt, defaults to 10s
+	Timeout time.Duration `mapstructure:"timeout"`
+	// MaxIdleConns kept per host, defaults to 100
+	MaxIdleConns int `mapstructure:"max_idle_conns"`
+	// Critical upstreams fail /ready while their breaker is open
+	Critical bool           `mapstructure:"critical"`
+	Retry    RetryConfig    `mapstructure:"retry"`
+	Breaker  BreakerConfig  `mapstructure:"breaker"`
+	Bulkhead BulkheadConfig `mapstructure:"bulkhead"`
+}
+
+// RetryConfig is the [upstream.<name>.retry] section, only the idempotent requests are retried,
+// i.e. GET, HEAD, OPTIONS, TRACE, PUT, DELETE and the requests with an Idempotency-Key header
+type RetryConfig struct {
+	// Attempts including the first one, defaults to 3, 1 disables the retry
+	Attempts int `mapstructure:"attempts"`
+	// Backoff before the n-th retry is random between 0 and min(MaxBackoff, Backoff * 2^(n-1)),
+	// default to 100ms and 2s
+	Backoff    time.Duration `mapstructure:"backoff"`
+	MaxBackoff time.Duration `mapstructure:"max_backoff"`
+	// Statuses retried besides the transport errors, defaults to DefaultRetryStatuses
+	Statuses []int `mapstructure:"statuses"`
+}
+
+// BreakerConfig is the [upstream.<name>.breaker] section, transport errors and 5xx responses are failures
+type BreakerConfig struct {
+	Disabled bool `mapstructure:"disabled"`
+	// FailureThreshold of consecutive failures opening the breaker, defaults to 5
+	FailureThreshold int `mapstructure:"failure_threshold"`
+	// OpenTimeout before the breaker lets probes through, defaults to 30s
+	OpenTimeout time.Duration `mapstructure:"open_timeout"`
+	// HalfOpenRequests are the concurrent probes, the breaker closes after as many successes, defaults to 1
+	HalfOpenRequests int `mapstructure:"half_open_requests"`
+}
+
+// BulkheadConfig is the [upstream.<name>.bulkhead] section
+type BulkheadConfig struct {
+	// MaxConcurrent requests in flight, 0 is unlimited
+	MaxConcurrent int `mapstructure:"max_concurrent"`
+	// MaxWait for a free slot before the request is rejected, 0 rejects at once
+	MaxWait time.Duration `mapstructure:"max_wait"`
+}
+
+// LoadConfig reads and validates the [upstream] section keyed by the upstream name
+func LoadConfig() (confs map[string]Config, err error) {
+	if err = viper.UnmarshalKey("upstream", &confs); err != nil {
+		return
+	}
+	for name, c := range confs {
+		c.setDefault()
+		if err = c.validate(name); err != nil {
+			return
+		}
+		confs[name] = c
+	}
+	return
+}
+
+func (c *Config) setDefault() {
+	if c.Timeout <= 0 {
+		c.Timeout = defaultTimeout
+	}
+	if c.MaxIdleConns <= 0 {
+		c.MaxIdleConns = defaultMaxIdleConns
+	}
+	if c.Retry.Attempts <= 0 {
+		c.Retry.Attempts = defaultAttempts
+	}
+	if c.Retry.Backoff <= 0 {
+		c.Retry.Backoff = defaultBackoff
+	}
+	if c.Retry.MaxBackoff <= 0 {
+		c.Retry.MaxBackoff = defaultMaxBackoff
+	}
+	if len(c.Retry.Statuses) == 0 {
+		c.Retry.Statuses = DefaultRetryStatuses
+	}
+	if c.Breaker.FailureThreshold <= 0 {
+		c.Breaker.FailureThreshold = defaultFailureThreshold
+	}
+	if c.Breaker.OpenTimeout <= 0 {
+		c.Breaker.OpenTimeout = defaultOpenTimeout
+	}
+	if c.Breaker.HalfOpenRequests <= 0 {
+		c.Breaker.HalfOpenRequests = defaultHalfOpenRequests
+	}
+}
+
+func (c Config) validate(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid upstream name: %q", name)
+	}
+	u, err := url.Parse(c.BaseURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("upstream %s: invalid base url %q", name, c.BaseURL)
+	}
+	if c.Retry.Backoff > c.Retry.MaxBackoff {
+		return fmt.Errorf("upstream %s: backoff is longer than max backoff", name)
+	}
+	if c.Bulkhead.MaxConcurrent < 0 || c.Bulkhead.MaxWait < 0 {
+		return fmt.Errorf("upstream %s: bulkhead must not be negative", name)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package upstream
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metric names, the app prefixes them with the metric namespace by metric.Registry.Namespaced
+const (
+	MetricRequests     = "upstream_requests_total"
+	MetricDuration     = "upstream_request_duration_seconds"
+	MetricRetries      = "upstream_retries_total"
+	MetricRejected     = "upstream_rejected_total"
+	MetricCircuitState = "upstream_circuit_state"
+	MetricInflight     = "upstream_inflight_requests"
+
+	LabelUpstream = "upstream"
+	LabelMethod   = "method"
+	LabelCode     = "code"
+	LabelReason   = "reason"
+)
+
+var (
+	defaultRegistry   *Registry
+	defaultRegistryMu sync.RWMutex
+)
+
+type metrics struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	retries  *prometheus.CounterVec
+	rejected *prometheus.CounterVec
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: MetricRequests,
+			Help: "Calls to the upstream by the status class of the last attempt, error without response and rejected without attempt.",
+		}, []string{LabelUpstream, LabelMethod, LabelCode}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    MetricDuration,
+			Help:    "Duration of the calls to the upstream including the retries.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{LabelUpstream}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: MetricRetries,
+			Help: "Retried attempts to the upstream.",
+		}, []string{LabelUpstream}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: MetricRejected,
+			Help: "Attempts rejected by the circuit breaker or the bulkhead without being sent.",
+		}, []string{LabelUpstream, LabelReason}),
+	}
+}
+
+func (m *metrics) observe(name, method, code string, elapsed time.Duration) {
+	m.requests.WithLabelValues(name, method, code).Inc()
+	m.duration.WithLabelValues(name).Observe(elapsed.Seconds())
+}
+
+// Registry holds the clients of the [upstream] section and exports their metrics
+type Registry struct {
+	clients map[string]*Client
+	names   []string
+	metrics *metrics
+
+	state    *prometheus.Desc
+	inflight *prometheus.Desc
+}
+
+// Status is the current state of one upstream
+type Status struct {
+	Name     string `json:"name"`
+	BaseURL  string `json:"baseURL"`
+	Critical bool   `json:"critical"`
+	Circuit  string `json:"circuit"`
+	Inflight int64  `json:"inflight"`
+}
+
+func NewRegistry(confs map[string]Config) *Registry {
+	r := &Registry{
+		clients:  map[string]*Client{},
+		metrics:  newMetrics(),
+		state:    prometheus.NewDesc(MetricCircuitState, "State of the circuit breaker, 0 closed, 1 half-open and 2 open.", []string{LabelUpstream}, nil),
+		inflight: prometheus.NewDesc(MetricInflight, "Attempts to the upstream in flight.", []string{LabelUpstream}, nil),
+	}
+	for name, conf := range confs {
+		r.clients[name] = newClient(name, conf, r.metrics)
+		r.names = append(r.names, name)
+		// export zero counters so that the rates exist before the first call
+		r.metrics.retries.WithLabelValues(name)
+	}
+	sort.Strings(r.names)
+	return r
+}
+
+// Init replaces the default registry used by Get and Ready, and registers it to reg
+func Init(confs map[string]Config, reg prometheus.Registerer) error {
+	r := NewRegistry(confs)
+	if err := reg.Register(r); err != nil {
+		return err
+	}
+	defaultRegistryMu.Lock()
+	defer defaultRegistryMu.Unlock()
+	defaultRegistry = r
+	return nil
+}
+
+// Default returns the registry built by Init, nil before Init
+func Default() *Registry {
+	defaultRegistryMu.RLock()
+	defer defaultRegistryMu.RUnlock()
+	return defaultRegistry
+}
+
+// Get returns the client of the default registry, nil if the upstream isn't configured
+func Get(name string) *Client {
+	r := Default()
+	if r == nil {
+		return nil
+	}
+	return r.Get(name)
+}
+
+// Get returns the client of the upstream, nil if the upstream isn't configured
+func (r *Registry) Get(name string) *Client {
+	return r.clients[name]
+}
+
+// Status returns the state of the upstreams sorted by name
+func (r *Registry) Status() []Status {
+	ret := make([]Status, 0, len(r.names))
+	for _, name := range r.names {
+		c := r.clients[name]
+		ret = append(ret, Status{
+			Name:     name,
+			BaseURL:  c.conf.BaseURL,
+			Critical: c.conf.Critical,
+			Circuit:  c.State().String(),
+			Inflight: atomic.LoadInt64(&c.inflight),
+		})
+	}
+	return ret
+}
+
+// Ready reports false while the breaker of a critical upstream is open
+func (r *Registry) Ready() bool {
+	for _, c := range r.clients {
+		if c.conf.Critical && c.State() == StateOpen {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Registry) Describe(ch chan<- *prometheus.Desc) {
+	r.metrics.requests.Describe(ch)
+	r.metrics.duration.Describe(ch)
+	r.metrics.retries.Describe(ch)
+	r.metrics.rejected.Describe(ch)
+	ch <- r.state
+	ch <- r.inflight
+}
+
+func (r *Registry) Collect(ch chan<- prometheus.Metric) {
+	r.metrics.requests.Collect(ch)
+	r.metrics.duration.Collect(ch)
+	r.metrics.retries.Collect(ch)
+	r.metrics.rejected.Collect(ch)
+	for _, name := range r.names {
+		c := r.clients[name]
+		ch <- prometheus.MustNewConstMetric(r.state, prometheus.GaugeValue, float64(c.State()), name)
+		ch <- prometheus.MustNewConstMetric(r.inflight, prometheus.GaugeValue, float64(atomic.LoadInt64(&c.inflight)), name)
+	}
+}
@@ -0,0 +1,329 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gitlab.com/cake/go-project-template/gpt"
+	"gitlab.com/cake/goctx"
+	"gitlab.com/cake/gopkg"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+)
+
+type memoryExporter struct {
+	spans []tracesdk.ReadOnlySpan
+}
+
+func (e *memoryExporter) ExportSpans(_ context.Context, spans []tracesdk.ReadOnlySpan) error {
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *memoryExporter) Shutdown(context.Context) error { return nil }
+
+func (e *memoryExporter) find(name string) tracesdk.ReadOnlySpan {
+	for _, s := range e.spans {
+		if s.Name() == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// flakyServer fails the first n requests with 503, counts the requests and records their traceparent
+func flakyServer(t *testing.T, n int32) (*httptest.Server, *int32, *[]string) {
+	var count int32
+	parents := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parents = append(parents, r.Header.Get("traceparent"))
+		if atomic.AddInt32(&count, 1) <= n {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"code":0,"result":"ok"}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &count, &parents
+}
+
+func testConfig(url string) Config {
+	return Config{
+		BaseURL: url,
+		Retry:   RetryConfig{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond},
+		Breaker: BreakerConfig{FailureThreshold: 2, OpenTimeout: time.Minute},
+	}
+}
+
+func TestRetry(t *testing.T) {
+	exp := &memoryExporter{}
+	otel.SetTracerProvider(tracesdk.NewTracerProvider(tracesdk.WithSyncer(exp)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(trace.NewNoopTracerProvider()) })
+
+	srv, count, parents := flakyServer(t, 1)
+	conf := testConfig(srv.URL)
+	conf.Breaker.Disabled = true
+	r := NewRegistry(map[string]Config{"flaky": conf})
+	c := r.Get("flaky")
+
+	ctx := goctx.Background()
+	req, err := c.NewRequest(ctx, http.MethodGet, "/v1/test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := c.M800Do(ctx, req)
+	if err != nil {
+		t.Fatalf("M800Do error: %v", err)
+	}
+	if string(result.Result) != `"ok"` || *count != 2 {
+		t.Errorf("result = %s after %d requests, want ok after 2", result.Result, *count)
+	}
+
+	s := exp.find("upstream flaky")
+	if s == nil {
+		t.Fatal("call span not found")
+	}
+	if s.Status().Code != codes.Unset {
+		t.Errorf("status of the retried call = %v, want unset", s.Status())
+	}
+	events := 0
+	for _, e := range s.Events() {
+		if e.Name == "retry" {
+			events++
+		}
+	}
+	if events != 1 {
+		t.Errorf("retry events = %d, want 1", events)
+	}
+	for _, p := range *parents {
+		if !strings.Contains(p, s.SpanContext().TraceID().String()) {
+			t.Errorf("traceparent %q isn't in the trace of the call", p)
+		}
+	}
+
+	// POST isn't retried without an idempotency key
+	atomic.StoreInt32(count, -1)
+	req, _ = c.NewRequest(ctx, http.MethodPost, "/v1/test", strings.NewReader("{}"))
+	resp, err := c.Do(ctx, req)
+	if err != nil {
+		t.Fatalf("Do error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || *count != 0 {
+		t.Errorf("POST = %d after %d requests, want 503 after 1", resp.StatusCode, *count+1)
+	}
+
+	// the body is sent again to the retry
+	atomic.StoreInt32(count, 0)
+	req, _ = c.NewRequest(ctx, http.MethodPost, "/v1/test", strings.NewReader("{}"))
+	req.Header.Set(HeaderIdempotencyKey, "key")
+	resp, err = c.Do(ctx, req)
+	if err != nil {
+		t.Fatalf("Do error: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "ok") {
+		t.Errorf("POST with idempotency key = %d %s, want 200", resp.StatusCode, body)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(r)
+	mfs, errGather := reg.Gather()
+	if errGather != nil {
+		t.Fatal(errGather)
+	}
+	values := map[string]float64{}
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			values[mf.GetName()] += m.GetCounter().GetValue()
+		}
+	}
+	if values[MetricRequests] != 3 || values[MetricRetries] != 2 {
+		t.Errorf("requests = %v retries = %v, want 3 and 2", values[MetricRequests], values[MetricRetries])
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	srv, count, _ := flakyServer(t, 4)
+	conf := testConfig(srv.URL)
+	conf.Critical = true
+	conf.Retry.Attempts = 1
+	r := NewRegistry(map[string]Config{"flaky": conf})
+	c := r.Get("flaky")
+	now := time.Now()
+	c.breaker.now = func() time.Time { return now }
+
+	ctx := goctx.Background()
+	get := func() (int, gopkg.CodeError) {
+		req, _ := c.NewRequest(ctx, http.MethodGet, "", nil)
+		resp, err := c.Do(ctx, req)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+	for i := 0; i < 2; i++ {
+		if status, err := get(); status != http.StatusServiceUnavailable {
+			t.Fatalf("request %d = %d %v, want 503", i, status, err)
+		}
+	}
+	if c.State() != StateOpen || r.Ready() {
+		t.Fatalf("breaker = %s ready = %v, want open and not ready", c.State(), r.Ready())
+	}
+	_, err := get()
+	if err == nil || err.ErrorCode() != gpt.CodeUpstreamUnavailable || *count != 2 {
+		t.Fatalf("open breaker = %v after %d requests, want unavailable after 2", err, *count)
+	}
+
+	// a failed probe opens the breaker again, a successful one closes it
+	now = now.Add(time.Minute)
+	if c.State() != StateHalfOpen {
+		t.Errorf("breaker = %s, want half-open after the open timeout", c.State())
+	}
+	if status, _ := get(); status != http.StatusServiceUnavailable || c.State() != StateOpen {
+		t.Errorf("failed probe = %d breaker = %s, want 503 and open", status, c.State())
+	}
+	now = now.Add(time.Minute)
+	if status, _ := get(); status != http.StatusServiceUnavailable {
+		t.Errorf("probe = %d, want 503", status)
+	}
+	now = now.Add(time.Minute)
+	if status, _ := get(); status != http.StatusOK || c.State() != StateClosed || !r.Ready() {
+		t.Errorf("probe = %d breaker = %s, want 200 and closed", status, c.State())
+	}
+}
+
+func TestBreakerBodyFailure(t *testing.T) {
+	srv, count, _ := flakyServer(t, 10)
+	r := NewRegistry(map[string]Config{"flaky": testConfig(srv.URL)})
+	c := r.Get("flaky")
+
+	// the failure to rewind the body doesn't count as a success of the upstream and reset the failures
+	ctx := goctx.Background()
+	req, _ := c.NewRequest(ctx, http.MethodPut, "", strings.NewReader("body"))
+	req.GetBody = func() (io.ReadCloser, error) { return nil, errors.New("rewind failed") }
+	if _, err := c.Do(ctx, req); err == nil || *count != 1 {
+		t.Fatalf("body failure = %v after %d requests, want an error after 1", err, *count)
+	}
+	c.breaker.mu.Lock()
+	failures := c.breaker.failures
+	c.breaker.mu.Unlock()
+	if c.State() != StateClosed || failures != 1 {
+		t.Errorf("breaker = %s with %d failures, want closed with the failure of the first attempt", c.State(), failures)
+	}
+}
+
+func TestBreakerCallerCancel(t *testing.T) {
+	received := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+	r := NewRegistry(map[string]Config{"slow": testConfig(srv.URL)})
+	c := r.Get("slow")
+	now := time.Now()
+	c.breaker.now = func() time.Time { return now }
+
+	cancelled := func() {
+		ctx, cancel := goctx.Background().WithCancel()
+		go func() {
+			<-received
+			cancel()
+		}()
+		req, _ := c.NewRequest(ctx, http.MethodGet, "", nil)
+		if resp, err := c.Do(ctx, req); err == nil {
+			resp.Body.Close()
+			t.Error("the cancelled call should fail")
+		}
+	}
+	// the cancelled calls are not failures of the upstream
+	for i := 0; i < 3; i++ {
+		cancelled()
+	}
+	if c.State() != StateClosed || !r.Ready() {
+		t.Fatalf("breaker = %s, want closed after the caller cancelled", c.State())
+	}
+
+	// a cancelled probe is released for the next one
+	c.breaker.mu.Lock()
+	c.breaker.transit(StateOpen)
+	c.breaker.mu.Unlock()
+	now = now.Add(time.Minute)
+	cancelled()
+	if _, ok := c.breaker.allow(); !ok {
+		t.Error("the probe of the cancelled call should be released")
+	}
+}
+
+func TestBulkhead(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(srv.Close)
+	conf := testConfig(srv.URL)
+	conf.Bulkhead = BulkheadConfig{MaxConcurrent: 1, MaxWait: 10 * time.Millisecond}
+	c := New("slow", conf)
+
+	ctx := goctx.Background()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req, _ := c.NewRequest(ctx, http.MethodGet, "", nil)
+		if resp, err := c.Do(ctx, req); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	for atomic.LoadInt64(&c.inflight) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	req, _ := c.NewRequest(ctx, http.MethodGet, "", nil)
+	_, err := c.Do(ctx, req)
+	if err == nil || err.ErrorCode() != gpt.CodeUpstreamUnavailable {
+		t.Errorf("full bulkhead = %v, want unavailable", err)
+	}
+	close(release)
+	<-done
+}
+
+func TestConfig(t *testing.T) {
+	for name, c := range map[string]Config{
+		"no_url":  {},
+		"bad_url": {BaseURL: "localhost"},
+		"backoff": {BaseURL: "http://localhost", Retry: RetryConfig{Backoff: time.Second, MaxBackoff: time.Millisecond}},
+	} {
+		c.setDefault()
+		if err := c.validate(name); err == nil {
+			t.Errorf("%s should be invalid", name)
+		}
+	}
+	c := Config{BaseURL: "http://localhost"}
+	c.setDefault()
+	if err := c.validate("local"); err != nil {
+		t.Errorf("default config: %v", err)
+	}
+	if code := codeLabel(1, http.StatusServiceUnavailable); code != "5xx" {
+		t.Errorf("code label = %s, want 5xx", code)
+	}
+	if s := (&Client{conf: c}).backoff(10); s < 0 || s > c.Retry.MaxBackoff {
+		t.Errorf("backoff = %s exceeds max backoff", s)
+	}
+}
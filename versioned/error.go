@@ -0,0 +1,42 @@
+package versioned
+
+import (
+	"errors"
+	"fmt"
+
+	"gitlab.com/cake/go-project-template/gpt"
+	"gitlab.com/cake/gopkg"
+)
+
+// ConflictError is returned when the stored version no longer matches the expected one
+type ConflictError struct {
+	Collection string
+	ID         interface{}
+	Expected   int64
+}
+
+func (e ConflictError) ErrorCode() int {
+	return gpt.CodeConflict
+}
+
+func (e ConflictError) ErrorMsg() string {
+	return fmt.Sprintf("version conflict on %s/%v, expected version %d", e.Collection, e.ID, e.Expected)
+}
+
+func (e ConflictError) Error() string {
+	return fmt.Sprintf("%07d %s", e.ErrorCode(), e.ErrorMsg())
+}
+
+func (e ConflictError) FullError() string {
+	return e.Error()
+}
+
+// IsConflict reports whether err is (or wraps) a ConflictError
+func IsConflict(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.As(err, &ConflictError{})
+}
+
+var _ gopkg.CodeError = ConflictError{}
@@ -0,0 +1,95 @@
+package versioned
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/cake/go-project-template/gpt"
+	"gitlab.com/cake/gopkg"
+)
+
+const (
+	HeaderETag    = "ETag"
+	HeaderIfMatch = "If-Match"
+)
+
+// ETag formats the document version as a strong entity tag
+func ETag(version int64) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// ErrWeakETag is returned by ParseETag for a weak entity tag, which never matches
+// under the strong comparison required by If-Match
+var ErrWeakETag = errors.New("weak etag")
+
+// ParseETag parses the strong entity tag generated by ETag
+func ParseETag(tag string) (version int64, err error) {
+	tag = strings.TrimSpace(tag)
+	if strings.HasPrefix(tag, "W/") {
+		return 0, ErrWeakETag
+	}
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, fmt.Errorf("malformed etag: %q", tag)
+	}
+	return strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+}
+
+// SetETag writes the ETag response header of the given version
+func SetETag(c *gin.Context, version int64) {
+	c.Header(HeaderETag, ETag(version))
+}
+
+// Precondition is the If-Match request header, Any is true when the header is absent or "*"
+type Precondition struct {
+	Any      bool
+	Versions []int64
+}
+
+// Match reports whether version satisfies the precondition
+func (p Precondition) Match(version int64) bool {
+	if p.Any {
+		return true
+	}
+	for _, v := range p.Versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns a precondition failed error when version doesn't satisfy the precondition
+func (p Precondition) Check(version int64) gopkg.CodeError {
+	if p.Match(version) {
+		return nil
+	}
+	return gopkg.NewCodeError(gpt.CodePreconditionFailed, fmt.Sprintf("If-Match doesn't match the current version %s", ETag(version)))
+}
+
+// IfMatch parses the list of entity tags of the If-Match request header. Weak tags never match
+// under the strong comparison, a header with only weak tags fails the precondition at once.
+func IfMatch(c *gin.Context) (p Precondition, err gopkg.CodeError) {
+	header := strings.TrimSpace(c.GetHeader(HeaderIfMatch))
+	if header == "" || header == "*" {
+		return Precondition{Any: true}, nil
+	}
+	weak := false
+	for _, tag := range strings.Split(header, ",") {
+		version, errParse := ParseETag(tag)
+		switch {
+		case errParse == ErrWeakETag:
+			weak = true
+		case errParse != nil:
+			return Precondition{}, gopkg.NewCodeError(gpt.CodeBadRequest, "invalid If-Match header: "+errParse.Error())
+		default:
+			p.Versions = append(p.Versions, version)
+		}
+	}
+	if len(p.Versions) == 0 && weak {
+		return Precondition{}, gopkg.NewCodeError(gpt.CodePreconditionFailed, "If-Match requires a strong etag")
+	}
+	return p, nil
+}
@@ -0,0 +1,37 @@
+package versioned
+
+import (
+	"math/rand"
+	"time"
+)
+
+var (
+	DefaultRetryPolicy = RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   10 * time.Millisecond,
+		MaxDelay:    500 * time.Millisecond,
+	}
+)
+
+// RetryPolicy controls the read-modify-write loop when a version conflict happens
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// Backoff returns the delay before the given retry attempt (starts from 1),
+// exponential with full jitter and capped by MaxDelay
+func (r RetryPolicy) Backoff(attempt int) time.Duration {
+	if attempt < 1 || r.BaseDelay <= 0 {
+		return 0
+	}
+	d := r.BaseDelay
+	for i := 1; i < attempt && (r.MaxDelay <= 0 || d < r.MaxDelay); i++ {
+		d *= 2
+	}
+	if r.MaxDelay > 0 && d > r.MaxDelay {
+		d = r.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
@@ -0,0 +1,215 @@
+package versioned
+
+import (
+	"reflect"
+	"time"
+
+	"gitlab.com/cake/go-project-template/gpt"
+	"gitlab.com/cake/go-project-template/internal/document"
+	"gitlab.com/cake/goctx"
+	"gitlab.com/cake/gopkg"
+	"gitlab.com/cake/m800log"
+	"gitlab.com/cake/mgopool/v3"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	DefaultVersionField = "version"
+)
+
+// Store performs optimistic concurrency controlled operations on one collection.
+// Every write carries the expected version in the selector and increases it by one.
+type Store struct {
+	dbName       string
+	collection   string
+	versionField string
+	retry        RetryPolicy
+}
+
+func NewStore(dbName, collection string) *Store {
+	return &Store{
+		dbName:       dbName,
+		collection:   collection,
+		versionField: DefaultVersionField,
+		retry:        DefaultRetryPolicy,
+	}
+}
+
+// WithVersionField changes the document field which holds the version
+func (s *Store) WithVersionField(field string) *Store {
+	s.versionField = field
+	return s
+}
+
+// WithRetryPolicy changes the retry policy used by ReadModifyWrite
+func (s *Store) WithRetryPolicy(r RetryPolicy) *Store {
+	s.retry = r
+	return s
+}
+
+// Insert stores a new document with version 1
+func (s *Store) Insert(ctx goctx.Context, doc interface{}) (version int64, err gopkg.CodeError) {
+	m, err := document.ToM(doc)
+	if err != nil {
+		return
+	}
+	m[s.versionField] = int64(1)
+	if err = mgopool.Insert(ctx, s.dbName, s.collection, m); err != nil {
+		return
+	}
+	return 1, nil
+}
+
+// Get loads the document into result and returns its current version
+func (s *Store) Get(ctx goctx.Context, id interface{}, result interface{}) (version int64, err gopkg.CodeError) {
+	raw := bson.Raw{}
+	if err = mgopool.QueryOne(ctx, s.dbName, s.collection, &raw, bson.M{mgopool.ObjId: id}, nil, 0); err != nil {
+		return
+	}
+	return s.decode(raw, result)
+}
+
+// Update applies update only if the stored version equals version.
+// The update may be a plain document or contain update operators, the version field is maintained by the store.
+// A ConflictError is returned when the document exists with another version.
+func (s *Store) Update(ctx goctx.Context, id interface{}, version int64, update interface{}, result interface{}) (newVersion int64, err gopkg.CodeError) {
+	op, err := s.updateOp(update)
+	if err != nil {
+		return
+	}
+
+	raw := bson.Raw{}
+	err = mgopool.FindAndModify(ctx, s.dbName, s.collection, &raw, s.selector(id, version), op, nil, false, true)
+	if err != nil {
+		return 0, s.checkConflict(ctx, id, version, err)
+	}
+	return s.decode(raw, result)
+}
+
+// Delete removes the document only if the stored version equals version
+func (s *Store) Delete(ctx goctx.Context, id interface{}, version int64) (err gopkg.CodeError) {
+	err = mgopool.FindAndRemove(ctx, s.dbName, s.collection, nil, s.selector(id, version), nil)
+	if err != nil {
+		return s.checkConflict(ctx, id, version, err)
+	}
+	return nil
+}
+
+// ReadModifyWrite loads the document into result, asks mutate for the update of the loaded version and writes it back.
+// On version conflict the whole cycle is retried with backoff until the retry policy is exhausted.
+func (s *Store) ReadModifyWrite(ctx goctx.Context, id interface{}, result interface{},
+	mutate func(version int64) (update interface{}, err gopkg.CodeError)) (newVersion int64, err gopkg.CodeError) {
+	attempts := s.retry.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		resetResult(result)
+		var version int64
+		version, err = s.Get(ctx, id, result)
+		if err != nil {
+			return
+		}
+
+		var update interface{}
+		update, err = mutate(version)
+		if err != nil {
+			return
+		}
+
+		newVersion, err = s.Update(ctx, id, version, update, result)
+		if !IsConflict(err) || attempt >= attempts {
+			return
+		}
+
+		delay := s.retry.Backoff(attempt)
+		m800log.Debugf(ctx, "[versioned] %s/%v conflict at version %d, retry %d after %s", s.collection, id, version, attempt, delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (s *Store) selector(id interface{}, version int64) bson.M {
+	return bson.M{
+		mgopool.ObjId:  id,
+		s.versionField: version,
+	}
+}
+
+func (s *Store) updateOp(update interface{}) (bson.M, gopkg.CodeError) {
+	op := bson.M{}
+	if m, ok := update.(bson.M); ok {
+		for key, val := range m {
+			op[key] = val
+		}
+	} else {
+		m, err := document.ToM(update)
+		if err != nil {
+			return nil, err
+		}
+		op = m
+	}
+	op, _ = mgopool.EnsureUpdateOp(op)
+
+	// the version field and the id are never updated by caller
+	if exist, ok := op[mgopool.OpSet].(bson.M); ok {
+		set := bson.M{}
+		for key, val := range exist {
+			set[key] = val
+		}
+		op[mgopool.OpSet] = set
+		delete(set, s.versionField)
+		delete(set, mgopool.ObjId)
+		if len(set) == 0 {
+			delete(op, mgopool.OpSet)
+		}
+	}
+
+	inc := bson.M{}
+	if exist, ok := op[mgopool.OpInc].(bson.M); ok {
+		for key, val := range exist {
+			inc[key] = val
+		}
+	}
+	inc[s.versionField] = 1
+	op[mgopool.OpInc] = inc
+	return op, nil
+}
+
+func (s *Store) checkConflict(ctx goctx.Context, id interface{}, version int64, err gopkg.CodeError) gopkg.CodeError {
+	if err.ErrorCode() != mgopool.NotFound {
+		return err
+	}
+	n, errCount := mgopool.QueryCount(ctx, s.dbName, s.collection, bson.M{mgopool.ObjId: id})
+	if errCount != nil || n == 0 {
+		return err
+	}
+	return ConflictError{Collection: s.collection, ID: id, Expected: version}
+}
+
+func (s *Store) decode(raw bson.Raw, result interface{}) (version int64, err gopkg.CodeError) {
+	if v, errLookup := raw.LookupErr(s.versionField); errLookup == nil {
+		version, _ = v.AsInt64OK()
+	}
+	if result == nil {
+		return
+	}
+	if errDecode := mgopool.Unmarshal(raw, result); errDecode != nil {
+		return version, gopkg.NewCodeError(gpt.CodeInternalServerError, errDecode.Error())
+	}
+	return
+}
+
+func resetResult(result interface{}) {
+	if result == nil {
+		return
+	}
+	v := reflect.ValueOf(result)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		v.Elem().Set(reflect.Zero(v.Elem().Type()))
+	}
+}
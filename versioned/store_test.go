@@ -0,0 +1,119 @@
+package versioned
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/cake/go-project-template/gpt"
+	"gitlab.com/cake/mgopool/v3"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestETag(t *testing.T) {
+	for _, v := range []int64{0, 1, 42} {
+		got, err := ParseETag(ETag(v))
+		if err != nil || got != v {
+			t.Errorf("ParseETag(ETag(%d)) = %d, %v", v, got, err)
+		}
+	}
+	if _, err := ParseETag(`W/"7"`); err != ErrWeakETag {
+		t.Errorf("weak etag error = %v", err)
+	}
+	for _, bad := range []string{"", "7", `"x"`, `"`} {
+		if _, err := ParseETag(bad); err == nil {
+			t.Errorf("ParseETag(%q) expected error", bad)
+		}
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	for _, tc := range []struct {
+		header  string
+		match   int64
+		noMatch int64
+		code    int
+	}{
+		{header: "", match: 5},
+		{header: "*", match: 5},
+		{header: `"3"`, match: 3, noMatch: 4},
+		{header: `"3", "4"`, match: 4, noMatch: 5},
+		{header: `W/"4", "3"`, match: 3, noMatch: 4},
+		{header: `W/"4"`, code: gpt.CodePreconditionFailed},
+		{header: `"3", 4`, code: gpt.CodeBadRequest},
+	} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPut, "/", nil)
+		c.Request.Header.Set(HeaderIfMatch, tc.header)
+		p, err := IfMatch(c)
+		if tc.code != 0 {
+			if err == nil || err.ErrorCode() != tc.code {
+				t.Errorf("If-Match %s error = %v, want code %d", tc.header, err, tc.code)
+			}
+			continue
+		}
+		if err != nil || !p.Match(tc.match) || p.Check(tc.match) != nil {
+			t.Errorf("If-Match %s should match %d: %+v %v", tc.header, tc.match, p, err)
+		}
+		if tc.noMatch != 0 {
+			if err := p.Check(tc.noMatch); err == nil || err.ErrorCode() != gpt.CodePreconditionFailed {
+				t.Errorf("If-Match %s should fail %d: %v", tc.header, tc.noMatch, err)
+			}
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	r := RetryPolicy{MaxAttempts: 5, BaseDelay: 10 * time.Millisecond, MaxDelay: 40 * time.Millisecond}
+	for attempt := 1; attempt <= 10; attempt++ {
+		d := r.Backoff(attempt)
+		if d <= 0 || d > r.MaxDelay {
+			t.Errorf("attempt %d backoff %s out of range", attempt, d)
+		}
+	}
+	if d := (RetryPolicy{}).Backoff(1); d != 0 {
+		t.Errorf("zero policy backoff = %s", d)
+	}
+}
+
+func TestUpdateOp(t *testing.T) {
+	s := NewStore("db", "col")
+	op, err := s.updateOp(bson.M{"name": "a", DefaultVersionField: 9, mgopool.ObjId: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := op[mgopool.OpSet].(bson.M)
+	if _, ok := set[DefaultVersionField]; ok {
+		t.Errorf("version field should be stripped from $set: %v", op)
+	}
+	if _, ok := set[mgopool.ObjId]; ok {
+		t.Errorf("_id should be stripped from $set: %v", op)
+	}
+	if op[mgopool.OpInc].(bson.M)[DefaultVersionField] != 1 {
+		t.Errorf("version should be increased: %v", op)
+	}
+
+	op, err = s.updateOp(bson.M{mgopool.OpInc: bson.M{"count": 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inc := op[mgopool.OpInc].(bson.M)
+	if inc["count"] != 2 || inc[DefaultVersionField] != 1 {
+		t.Errorf("unexpected $inc: %v", inc)
+	}
+}
+
+func TestConflictError(t *testing.T) {
+	var err error = ConflictError{Collection: "col", ID: 1, Expected: 3}
+	if !IsConflict(err) {
+		t.Error("IsConflict should be true")
+	}
+	if IsConflict(nil) {
+		t.Error("IsConflict(nil) should be false")
+	}
+	if err.(ConflictError).ErrorCode() != gpt.CodeConflict {
+		t.Error("unexpected error code")
+	}
+}
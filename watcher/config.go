@@ -0,0 +1,104 @@
+package watcher
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	defaultTokenCollection = "watcher_tokens"
+	defaultLeaseCollection = "watcher_leases"
+	defaultLeaseName       = "watcher"
+	defaultLeaseTTL        = 15 * time.Second
+	defaultRetryMin        = time.Second
+	defaultRetryMax        = 30 * time.Second
+	defaultHandlerRetry    = 3
+	defaultMaxAwait        = 5 * time.Second
+)
+
+// Config is loaded from the [watcher] section.
+// HandlerRetry is the max attempts of an event, then the event is saved to DeadLetterCollection,
+// or skipped when it's empty, and the stream moves on.
+type Config struct {
+	Enabled              bool           `mapstructure:"enabled"`
+	DB                   string         `mapstructure:"db"`
+	TokenCollection      string         `mapstructure:"token_collection"`
+	LeaseCollection      string         `mapstructure:"lease_collection"`
+	LeaseName            string         `mapstructure:"lease_name"`
+	LeaseTTL             time.Duration  `mapstructure:"lease_ttl"`
+	LeaderOnly           bool           `mapstructure:"leader_only"`
+	RetryMin             time.Duration  `mapstructure:"retry_min"`
+	RetryMax             time.Duration  `mapstructure:"retry_max"`
+	HandlerRetry         int            `mapstructure:"handler_retry"`
+	DeadLetterCollection string         `mapstructure:"dead_letter_collection"`
+	MaxAwait             time.Duration  `mapstructure:"max_await"`
+	Streams              []StreamConfig `mapstructure:"streams"`
+}
+
+// StreamConfig declares one change stream, Name is also the name of the registered handler
+type StreamConfig struct {
+	Name         string `mapstructure:"name"`
+	DB           string `mapstructure:"db"`
+	Collection   string `mapstructure:"collection"`
+	Pipeline     string `mapstructure:"pipeline"`
+	FullDocument bool   `mapstructure:"full_document"`
+}
+
+// LoadConfig reads the watcher config from viper
+func LoadConfig() (conf Config, err error) {
+	err = viper.UnmarshalKey("watcher", &conf)
+	if err != nil {
+		return
+	}
+	conf.setDefault()
+	return
+}
+
+func (c *Config) setDefault() {
+	if c.TokenCollection == "" {
+		c.TokenCollection = defaultTokenCollection
+	}
+	if c.LeaseCollection == "" {
+		c.LeaseCollection = defaultLeaseCollection
+	}
+	if c.LeaseName == "" {
+		c.LeaseName = defaultLeaseName
+	}
+	if c.LeaseTTL <= 0 {
+		c.LeaseTTL = defaultLeaseTTL
+	}
+	if c.RetryMin <= 0 {
+		c.RetryMin = defaultRetryMin
+	}
+	if c.RetryMax < c.RetryMin {
+		c.RetryMax = defaultRetryMax
+	}
+	if c.HandlerRetry <= 0 {
+		c.HandlerRetry = defaultHandlerRetry
+	}
+	if c.MaxAwait <= 0 {
+		c.MaxAwait = defaultMaxAwait
+	}
+	for i := range c.Streams {
+		if c.Streams[i].DB == "" {
+			c.Streams[i].DB = c.DB
+		}
+	}
+}
+
+// pipeline parses the extended JSON array of aggregation stages
+func (s StreamConfig) pipeline() ([]bson.M, error) {
+	if s.Pipeline == "" {
+		return []bson.M{}, nil
+	}
+	wrapper := struct {
+		Stages []bson.M `bson:"stages"`
+	}{}
+	if err := bson.UnmarshalExtJSON([]byte(`{"stages":`+s.Pipeline+`}`), false, &wrapper); err != nil {
+		return nil, fmt.Errorf("stream %s invalid pipeline: %w", s.Name, err)
+	}
+	return wrapper.Stages, nil
+}
@@ -0,0 +1,37 @@
+package watcher
+
+import (
+	"testing"
+)
+
+func TestStreamPipeline(t *testing.T) {
+	s := StreamConfig{Name: "s", Pipeline: `[{"$match": {"operationType": {"$in": ["insert", "update"]}}}]`}
+	p, err := s.pipeline()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p) != 1 {
+		t.Fatalf("expected 1 stage, got %v", p)
+	}
+	if _, ok := p[0]["$match"]; !ok {
+		t.Errorf("unexpected stage: %v", p[0])
+	}
+
+	if p, err := (StreamConfig{}).pipeline(); err != nil || len(p) != 0 {
+		t.Errorf("empty pipeline = %v, %v", p, err)
+	}
+	if _, err := (StreamConfig{Pipeline: `{"$match": 1}`}).pipeline(); err == nil {
+		t.Error("non array pipeline should fail")
+	}
+}
+
+func TestConfigDefault(t *testing.T) {
+	c := Config{DB: "db", Streams: []StreamConfig{{Name: "a"}, {Name: "b", DB: "other"}}}
+	c.setDefault()
+	if c.Streams[0].DB != "db" || c.Streams[1].DB != "other" {
+		t.Errorf("unexpected stream db: %+v", c.Streams)
+	}
+	if c.RetryMax < c.RetryMin || c.LeaseTTL <= 0 || c.HandlerRetry <= 0 {
+		t.Errorf("unexpected defaults: %+v", c)
+	}
+}
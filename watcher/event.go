@@ -0,0 +1,60 @@
+package watcher
+
+import (
+	"sync"
+
+	"gitlab.com/cake/goctx"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	OperationInsert     = "insert"
+	OperationUpdate     = "update"
+	OperationReplace    = "replace"
+	OperationDelete     = "delete"
+	OperationDrop       = "drop"
+	OperationRename     = "rename"
+	OperationInvalidate = "invalidate"
+)
+
+// Event is the change event document
+type Event struct {
+	ResumeToken       bson.Raw           `bson:"_id"`
+	OperationType     string             `bson:"operationType"`
+	NS                Namespace          `bson:"ns"`
+	DocumentKey       bson.M             `bson:"documentKey,omitempty"`
+	FullDocument      bson.Raw           `bson:"fullDocument,omitempty"`
+	UpdateDescription *UpdateDescription `bson:"updateDescription,omitempty"`
+}
+
+type Namespace struct {
+	DB         string `bson:"db"`
+	Collection string `bson:"coll"`
+}
+
+type UpdateDescription struct {
+	UpdatedFields bson.M   `bson:"updatedFields"`
+	RemovedFields []string `bson:"removedFields"`
+}
+
+// Handler processes one event, a returned error makes the event retried up to handler_retry attempts
+type Handler func(ctx goctx.Context, event *Event) error
+
+var (
+	handlers   = map[string]Handler{}
+	handlersMu sync.RWMutex
+)
+
+// Register binds the handler to the stream name declared in config
+func Register(stream string, h Handler) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	handlers[stream] = h
+}
+
+func getHandler(stream string) (Handler, bool) {
+	handlersMu.RLock()
+	defer handlersMu.RUnlock()
+	h, ok := handlers[stream]
+	return h, ok
+}
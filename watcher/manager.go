@@ -0,0 +1,348 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gitlab.com/cake/go-project-template/gpt"
+	"gitlab.com/cake/go-project-template/tracing"
+	"gitlab.com/cake/goctx"
+	"gitlab.com/cake/m800log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// server error codes which mean the resume token can no longer be used
+	codeChangeStreamFatal       = 280
+	codeChangeStreamHistoryLost = 286
+
+	leaderCheckInterval = time.Second
+
+	// MetricFailedEvents is prefixed by the metric namespace when registered by metric.Registry.Namespaced
+	MetricFailedEvents = "watcher_failed_events_total"
+	LabelStream        = "stream"
+	LabelAction        = "action"
+
+	// actions on an event failing every handler attempt
+	ActionDeadLetter = "dead_letter"
+	ActionSkip       = "skip"
+)
+
+// Manager runs the configured change streams and dispatches events to the registered handlers.
+// With LeaderOnly, streams only run on the replica holding the lease.
+type Manager struct {
+	conf   Config
+	client *mongo.Client
+	owner  string
+	leader int32
+
+	ctx    *goctx.MapContext
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	failed *prometheus.CounterVec
+}
+
+func NewManager(client *mongo.Client, conf Config) *Manager {
+	conf.setDefault()
+	return &Manager{
+		conf:   conf,
+		client: client,
+		owner:  gpt.GetPodName(),
+		failed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: MetricFailedEvents,
+			Help: "Events failing every handler attempt, by dead letter or skip action.",
+		}, []string{LabelStream, LabelAction}),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (m *Manager) Describe(ch chan<- *prometheus.Desc) {
+	m.failed.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (m *Manager) Collect(ch chan<- prometheus.Metric) {
+	m.failed.Collect(ch)
+}
+
+// Start validates the streams and launches the background goroutines
+func (m *Manager) Start(ctx goctx.Context) error {
+	type job struct {
+		stream   StreamConfig
+		pipeline []bson.M
+		handler  Handler
+	}
+	jobs := []job{}
+	for _, s := range m.conf.Streams {
+		if s.Name == "" || s.DB == "" || s.Collection == "" {
+			return fmt.Errorf("stream %+v requires name, db and collection", s)
+		}
+		h, ok := getHandler(s.Name)
+		if !ok {
+			return fmt.Errorf("stream %s has no registered handler", s.Name)
+		}
+		pipeline, err := s.pipeline()
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, job{s, pipeline, h})
+	}
+
+	m.ctx, m.cancel = tracing.CopyContext(ctx).WithCancel()
+	m.ctx.Set(goctx.LogKeyCID, "watcher")
+
+	if m.conf.LeaderOnly {
+		m.wg.Add(1)
+		go m.leaseLoop()
+	} else {
+		atomic.StoreInt32(&m.leader, 1)
+	}
+
+	for _, j := range jobs {
+		m.wg.Add(1)
+		go m.run(j.stream, j.pipeline, j.handler)
+	}
+	m800log.Infof(m.ctx, "[watcher] started %d streams, leader only: %t", len(jobs), m.conf.LeaderOnly)
+	return nil
+}
+
+// Close stops all streams and waits for the in-flight events
+func (m *Manager) Close() {
+	if m.cancel == nil {
+		return
+	}
+	m.cancel()
+	m.wg.Wait()
+	if m.conf.LeaderOnly && m.IsLeader() {
+		ctx := goctx.Background()
+		cancel := ctx.SetTimeout(m.conf.LeaseTTL)
+		defer cancel()
+		if err := m.releaseLease(ctx); err != nil {
+			m800log.Errorf(m.ctx, "[watcher] release lease error: %v", err)
+		}
+	}
+}
+
+func (m *Manager) IsLeader() bool {
+	return atomic.LoadInt32(&m.leader) == 1
+}
+
+func (m *Manager) leaseLoop() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(m.conf.LeaseTTL / 3)
+	defer ticker.Stop()
+	for {
+		ok, err := m.acquireLease(m.ctx)
+		if err != nil {
+			m800log.Errorf(m.ctx, "[watcher] acquire lease error: %v", err)
+		}
+		if ok != m.IsLeader() {
+			m800log.Infof(m.ctx, "[watcher] %s leadership changed: %t", m.owner, ok)
+		}
+		if ok {
+			atomic.StoreInt32(&m.leader, 1)
+		} else {
+			atomic.StoreInt32(&m.leader, 0)
+		}
+
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Manager) run(s StreamConfig, pipeline []bson.M, h Handler) {
+	defer m.wg.Done()
+	delay := m.conf.RetryMin
+	for {
+		if !m.IsLeader() {
+			if !m.sleep(leaderCheckInterval) {
+				return
+			}
+			continue
+		}
+
+		err := m.consume(s, pipeline, h)
+		if m.ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			delay = m.conf.RetryMin
+			continue
+		}
+
+		if tokenLost(err) {
+			m800log.Warnf(m.ctx, "[watcher] stream %s resume token lost, restart from now: %v", s.Name, err)
+			if errClear := m.clearToken(m.ctx, s.Name); errClear != nil {
+				err = errClear
+			}
+		}
+		m800log.Errorf(m.ctx, "[watcher] stream %s error: %v, retry after %s", s.Name, err, delay)
+		if !m.sleep(delay) {
+			return
+		}
+		if delay *= 2; delay > m.conf.RetryMax {
+			delay = m.conf.RetryMax
+		}
+	}
+}
+
+// consume opens the change stream from the persisted token and handles events until an error,
+// an invalidate event or the loss of leadership
+func (m *Manager) consume(s StreamConfig, pipeline []bson.M, h Handler) error {
+	watchCtx, cancel := m.ctx.WithCancel()
+	defer cancel()
+	go func() {
+		for watchCtx.Err() == nil {
+			if !m.IsLeader() {
+				cancel()
+				return
+			}
+			time.Sleep(leaderCheckInterval)
+		}
+	}()
+
+	token, err := m.loadToken(watchCtx, s.Name)
+	if err != nil {
+		return err
+	}
+	opts := options.ChangeStream().SetMaxAwaitTime(m.conf.MaxAwait)
+	if s.FullDocument {
+		opts.SetFullDocument(options.UpdateLookup)
+	}
+	if token != nil {
+		if token.StartAfter {
+			opts.SetStartAfter(token.Token)
+		} else {
+			opts.SetResumeAfter(token.Token)
+		}
+	}
+
+	cs, errWatch := m.client.Database(s.DB).Collection(s.Collection).Watch(watchCtx, pipeline, opts)
+	if errWatch != nil {
+		return errWatch
+	}
+	defer cs.Close(context.Background())
+
+	for cs.Next(watchCtx) {
+		event := &Event{}
+		if errDecode := cs.Decode(event); errDecode != nil {
+			return errDecode
+		}
+		if errHandle := m.dispatch(s, h, event); errHandle != nil {
+			return errHandle
+		}
+
+		invalidated := event.OperationType == OperationInvalidate
+		if err := m.saveToken(m.ctx, s.Name, cs.ResumeToken(), invalidated); err != nil {
+			return err
+		}
+		if invalidated {
+			m800log.Warnf(m.ctx, "[watcher] stream %s invalidated, reopen after the invalidate event", s.Name)
+			return nil
+		}
+	}
+	if watchCtx.Err() != nil {
+		return nil
+	}
+	return cs.Err()
+}
+
+// dispatch calls the handler with retry, each attempt has its own context and span.
+// An event failing every attempt is dead lettered or skipped so that it doesn't block the stream,
+// an error is only returned when the manager stops or the dead letter can't be saved.
+func (m *Manager) dispatch(s StreamConfig, h Handler, event *Event) error {
+	delay := m.conf.RetryMin
+	for attempt := 1; ; attempt++ {
+		err := m.handle(s, h, event, attempt)
+		if err == nil {
+			return nil
+		}
+		if attempt >= m.conf.HandlerRetry {
+			return m.fail(s, event, attempt, err)
+		}
+		m800log.Warnf(m.ctx, "[watcher] stream %s handler attempt %d error: %v", s.Name, attempt, err)
+		if !m.sleep(delay) {
+			return m.ctx.Err()
+		}
+		if delay *= 2; delay > m.conf.RetryMax {
+			delay = m.conf.RetryMax
+		}
+	}
+}
+
+func (m *Manager) fail(s StreamConfig, event *Event, attempts int, cause error) error {
+	if m.conf.DeadLetterCollection == "" {
+		m800log.Errorf(m.ctx, "[watcher] stream %s skip %s event %v after %d attempts: %v",
+			s.Name, event.OperationType, event.DocumentKey, attempts, cause)
+		m.failed.WithLabelValues(s.Name, ActionSkip).Inc()
+		return nil
+	}
+	if err := m.saveDeadLetter(m.ctx, s.Name, event, attempts, cause); err != nil {
+		return fmt.Errorf("dead letter error: %v, handler error: %w", err, cause)
+	}
+	m800log.Errorf(m.ctx, "[watcher] stream %s dead lettered %s event %v after %d attempts: %v",
+		s.Name, event.OperationType, event.DocumentKey, attempts, cause)
+	m.failed.WithLabelValues(s.Name, ActionDeadLetter).Inc()
+	return nil
+}
+
+func (m *Manager) handle(s StreamConfig, h Handler, event *Event, attempt int) (err error) {
+	ctx, cancel := m.ctx.WithCancel()
+	defer cancel()
+	ctx.Set(goctx.LogKeyCID, fmt.Sprintf("watcher-%s-%d", s.Name, time.Now().UnixNano()))
+
+	span := tracing.StartSpan(ctx, "watcher "+s.Name,
+		oteltrace.WithSpanKind(oteltrace.SpanKindConsumer),
+		oteltrace.WithAttributes(
+			attribute.String("db.system", "mongodb"),
+			attribute.String("db.name", event.NS.DB),
+			attribute.String("db.mongodb.collection", event.NS.Collection),
+			attribute.String("watcher.stream", s.Name),
+			attribute.String("watcher.operation", event.OperationType),
+			attribute.Int("watcher.attempt", attempt),
+		),
+	)
+	defer span.End()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panic: %v", r)
+		}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}()
+	return h(ctx, event)
+}
+
+func (m *Manager) sleep(d time.Duration) bool {
+	select {
+	case <-m.ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+func tokenLost(err error) bool {
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) {
+		return cmdErr.HasErrorCode(codeChangeStreamHistoryLost) || cmdErr.HasErrorCode(codeChangeStreamFatal)
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package watcher
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"gitlab.com/cake/goctx"
+)
+
+func TestDispatchSkip(t *testing.T) {
+	m := NewManager(nil, Config{HandlerRetry: 3, RetryMin: time.Millisecond, RetryMax: time.Millisecond})
+	var cancel func()
+	m.ctx, cancel = goctx.Background().WithCancel()
+	defer cancel()
+
+	attempts := 0
+	h := func(ctx goctx.Context, event *Event) error {
+		attempts++
+		return errors.New("poison")
+	}
+	s := StreamConfig{Name: "s"}
+	if err := m.dispatch(s, h, &Event{OperationType: OperationInsert}); err != nil {
+		t.Fatalf("dispatch = %v, want the event skipped", err)
+	}
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+	if n := counterValue(t, m.failed.WithLabelValues("s", ActionSkip)); n != 1 {
+		t.Errorf("skipped events = %v, want 1", n)
+	}
+
+	attempts = 0
+	cancel()
+	if err := m.dispatch(s, h, &Event{}); err == nil || attempts != 1 {
+		t.Errorf("dispatch = %v after %d attempts, want the stop error", err, attempts)
+	}
+}
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	m := &dto.Metric{}
+	if err := c.Write(m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
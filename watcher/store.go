@@ -0,0 +1,103 @@
+package watcher
+
+import (
+	"time"
+
+	"gitlab.com/cake/goctx"
+	"gitlab.com/cake/gopkg"
+	"gitlab.com/cake/mgopool/v3"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type tokenDoc struct {
+	Stream     string    `bson:"_id"`
+	Token      bson.Raw  `bson:"token"`
+	StartAfter bool      `bson:"startAfter"`
+	UpdatedAt  time.Time `bson:"updatedAt"`
+}
+
+type deadLetterDoc struct {
+	Stream   string    `bson:"stream"`
+	Event    *Event    `bson:"event"`
+	Attempts int       `bson:"attempts"`
+	Error    string    `bson:"error"`
+	Time     time.Time `bson:"time"`
+}
+
+type leaseDoc struct {
+	Name     string    `bson:"_id"`
+	Owner    string    `bson:"owner"`
+	ExpireAt time.Time `bson:"expireAt"`
+}
+
+// loadToken returns the persisted resume token of the stream, nil if the stream never ran
+func (m *Manager) loadToken(ctx goctx.Context, stream string) (doc *tokenDoc, err gopkg.CodeError) {
+	doc = &tokenDoc{}
+	err = mgopool.QueryOne(ctx, m.conf.DB, m.conf.TokenCollection, doc, bson.M{mgopool.ObjId: stream}, nil, 0)
+	if err != nil && err.ErrorCode() == mgopool.NotFound {
+		return nil, nil
+	}
+	return
+}
+
+// saveToken persists the token, startAfter is set when the token comes from an invalidate event
+func (m *Manager) saveToken(ctx goctx.Context, stream string, token bson.Raw, startAfter bool) (err gopkg.CodeError) {
+	_, err = mgopool.Upsert(ctx, m.conf.DB, m.conf.TokenCollection, bson.M{mgopool.ObjId: stream}, bson.M{
+		mgopool.OpSet: bson.M{
+			"token":      token,
+			"startAfter": startAfter,
+			"updatedAt":  time.Now().UTC(),
+		},
+	})
+	return
+}
+
+// saveDeadLetter keeps the event failing every attempt for inspection and replay
+func (m *Manager) saveDeadLetter(ctx goctx.Context, stream string, event *Event, attempts int, cause error) gopkg.CodeError {
+	return mgopool.Insert(ctx, m.conf.DB, m.conf.DeadLetterCollection, &deadLetterDoc{
+		Stream:   stream,
+		Event:    event,
+		Attempts: attempts,
+		Error:    cause.Error(),
+		Time:     time.Now().UTC(),
+	})
+}
+
+func (m *Manager) clearToken(ctx goctx.Context, stream string) (err gopkg.CodeError) {
+	err = mgopool.Remove(ctx, m.conf.DB, m.conf.TokenCollection, bson.M{mgopool.ObjId: stream})
+	if err != nil && err.ErrorCode() == mgopool.NotFound {
+		return nil
+	}
+	return
+}
+
+// acquireLease takes or renews the lease, it fails without error when another owner holds an unexpired lease
+func (m *Manager) acquireLease(ctx goctx.Context) (ok bool, err gopkg.CodeError) {
+	now := time.Now().UTC()
+	selector := bson.M{
+		mgopool.ObjId: m.conf.LeaseName,
+		mgopool.OpOr: []bson.M{
+			{"owner": m.owner},
+			{"expireAt": bson.M{mgopool.OpLt: now}},
+		},
+	}
+	update := bson.M{mgopool.OpSet: bson.M{"owner": m.owner, "expireAt": now.Add(m.conf.LeaseTTL)}}
+	lease := &leaseDoc{}
+	err = mgopool.FindAndModify(ctx, m.conf.DB, m.conf.LeaseCollection, lease, selector, update, nil, true, true)
+	if err != nil {
+		if mgopool.IsDup(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return lease.Owner == m.owner, nil
+}
+
+// releaseLease gives up the lease so another replica takes over without waiting for expiry
+func (m *Manager) releaseLease(ctx goctx.Context) (err gopkg.CodeError) {
+	err = mgopool.Remove(ctx, m.conf.DB, m.conf.LeaseCollection, bson.M{mgopool.ObjId: m.conf.LeaseName, "owner": m.owner})
+	if err != nil && err.ErrorCode() == mgopool.NotFound {
+		return nil
+	}
+	return
+}